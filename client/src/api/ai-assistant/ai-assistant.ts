@@ -1,4 +1,5 @@
 import { AppError } from "@/types/error";
+import { ResultSet, rowsToRecords } from "@/utils/table";
 
 export const GenerateQuery = async (connID: string, query: string) => {
   const res = await fetch(`/api/query/${connID}/generate`, {
@@ -29,7 +30,12 @@ export const ExecuteQuery = async (connID: string, query: string, generatedQuery
     const err: AppError = await res.json();
     throw err
   }
-  return res.json();
+  const body = await res.json();
+  // Errors after the response started streaming are reported in the body
+  if (!body.success) {
+    throw body as AppError
+  }
+  return { ...body, data: { ...body.data, result: rowsToRecords(body.data as ResultSet) } };
 }
 
 export const GetQueryHistory = async (connID: string) => {
@@ -1,4 +1,5 @@
 import { AppError } from "@/types/error"
+import { ResultSet, rowsToRecords } from "@/utils/table"
 
 export const GetTables = async (connID: string, dbName?: string) => {
   const res = await fetch(`/api/tables/${connID}?db_name=${dbName}`, {
@@ -36,5 +37,10 @@ export const GetTableRecords = async (connID: string, tableName: string, dbName?
     const err: AppError = await res.json()
     throw err
   }
-  return res.json()
+  const body = await res.json()
+  // Errors after the response started streaming are reported in the body
+  if (!body.success) {
+    throw body as AppError
+  }
+  return { ...body, data: rowsToRecords(body.data as ResultSet) }
 }
\ No newline at end of file
@@ -86,4 +86,32 @@ export function inferMongoDBSchemaType(value: unknown): string {
   }
   if (typeof value === "object" && value !== null) return "object";
   return "unknown";
-}
\ No newline at end of file
+}
+export type ResultKind =
+  | 'integer' | 'float' | 'decimal' | 'boolean' | 'string' | 'binary'
+  | 'date' | 'time' | 'timestamp' | 'timestamptz' | 'interval' | 'json'
+  | 'array' | 'uuid' | 'geometry' | 'objectid' | 'document' | 'unknown';
+
+export interface ResultColumn {
+  name: string;
+  type: string;
+  kind: ResultKind;
+  nullable?: boolean;
+  precision?: number;
+  scale?: number;
+  length?: number;
+}
+
+export interface ResultSet {
+  columns: ResultColumn[];
+  rows: unknown[][];
+  row_count: number;
+  truncated: boolean;
+}
+
+// Utility to turn the row arrays of a result set into records keyed by column name
+export function rowsToRecords<T = Record<string, string>>(resultSet: ResultSet): T[] {
+  return (resultSet.rows ?? []).map(row =>
+    Object.fromEntries(resultSet.columns.map((col, idx) => [col.name, row[idx]])) as T
+  );
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up             apply the pending migrations
+  down [steps]   revert the last applied migrations, one by default
+  to <version>   apply or revert migrations until version is the last applied, 0 reverts all
+  status         list the migrations and when they were applied`
+
+// runMigrate runs the migrate subcommand against the database of the configured store.
+func runMigrate(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+	number := -1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q\n\n%s", args[1], migrateUsage)
+		}
+		number = n
+	}
+
+	env, err := config.LoadEnv()
+	if err != nil {
+		return err
+	}
+	db, err := config.ConnectStore(env)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		if number >= 0 {
+			return errors.New(migrateUsage)
+		}
+		err = db.Migrate()
+	case "down":
+		if number < 0 {
+			number = 1
+		}
+		err = db.Rollback(number)
+	case "to":
+		if number < 0 {
+			return errors.New(migrateUsage)
+		}
+		err = db.MigrateTo(number)
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := db.Status()
+	if err != nil {
+		return err
+	}
+	for _, migration := range status {
+		applied := "pending"
+		if migration.AppliedAt != nil {
+			applied = "applied " + migration.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d  %-24s  %s\n", migration.Version, migration.Name, applied)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,11 +11,23 @@ import (
 	"time"
 
 	"github.com/cprakhar/datawhiz/config"
+	copyjobs "github.com/cprakhar/datawhiz/internal/copy_jobs"
 	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+	queryjobs "github.com/cprakhar/datawhiz/internal/query_jobs"
 	"github.com/cprakhar/datawhiz/internal/router"
+	"github.com/cprakhar/datawhiz/internal/scheduler"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize the configuration
 	config, err := config.NewConfig()
 	if err != nil {
@@ -32,7 +45,18 @@ func main() {
 	config.Env.EncryptionKey = string(encryptionKey)
 	
 
-	poolmanager.StartCleanupRoutine(config.Env.CleanupInterval, config.DBClient)
+	poolmanager.StartCleanupRoutine(config)
+	poolmanager.StartHealthChecker(config)
+	if err := queryjobs.StartWorkers(config); err != nil {
+		panic("Failed to start query job workers: " + err.Error())
+	}
+	copyjobs.StartWorkers(config)
+	if err := querycache.Init(config); err != nil {
+		panic("Failed to set up the query cache: " + err.Error())
+	}
+	if err := scheduler.Start(config); err != nil {
+		panic("Failed to start the scheduler: " + err.Error())
+	}
 	
 	server := router.NewRouter(config)
 	srv := &http.Server{
@@ -60,7 +84,10 @@ func main() {
 		panic("Server forced to shutdown: " + err.Error())
 	}
 
-	poolmanager.ShutdownAllPools(config.DBClient)
+	scheduler.Shutdown()
+	queryjobs.ShutdownJobs()
+	copyjobs.ShutdownJobs()
+	poolmanager.ShutdownAllPools(config.Connections)
 
 	println("Server gracefully stopped")
 }
@@ -19,11 +19,16 @@ type DBConfig struct {
 	MaxIdleConns       int
 	ConnMaxLifetime    time.Duration
 	ConnMaxIdleTime    time.Duration
+	QueryTimeout       time.Duration
+	MaxQueryTimeout    time.Duration
+	MaxResultRows      int
+	MaxExportRows      int
 }
 
 type Config struct {
 	Env            *Env
-	DBClient       *supabase.Client
+	DBClient       *supabase.Client // nil when Supabase is not configured
+	*Store
 	ProviderEmail  ProviderType
 	ProviderGoogle ProviderType
 	ProviderGitHub ProviderType
@@ -36,7 +41,17 @@ func NewConfig() (*Config, error) {
 		return nil, err
 	}
 
-	dbClient, err := NewClient(env.SupabaseURL, env.SupabaseKey)
+	// Saved queries, schedules and alerts are only kept in Supabase, so the client is created
+	// whenever it is configured, whichever store holds the rest.
+	var dbClient *supabase.Client
+	if env.SupabaseURL != "" {
+		dbClient, err = NewClient(env.SupabaseURL, env.SupabaseKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := NewStore(env, dbClient)
 	if err != nil {
 		return nil, err
 	}
@@ -44,6 +59,7 @@ func NewConfig() (*Config, error) {
 	return &Config{
 		Env:            env,
 		DBClient:       dbClient,
+		Store:          store,
 		ProviderEmail:  ProviderEmail,
 		ProviderGoogle: ProviderGoogle,
 		ProviderGitHub: ProviderGitHub,
@@ -52,6 +68,10 @@ func NewConfig() (*Config, error) {
 			MaxIdleConns:       env.MaxIdleConns,
 			ConnMaxLifetime:    env.ConnMaxLifetime,
 			ConnMaxIdleTime:    env.ConnMaxIdleTime,
+			QueryTimeout:       env.QueryTimeout,
+			MaxQueryTimeout:    env.MaxQueryTimeout,
+			MaxResultRows:      env.MaxResultRows,
+			MaxExportRows:      env.MaxExportRows,
 		},
 	}, nil
 }
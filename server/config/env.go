@@ -7,26 +7,60 @@ import (
 )
 
 type Env struct {
-	Port               string        `env:"PORT" envDefault:"8080"`
-	BackendBaseURL     string        `env:"BACKEND_BASE_URL" envDefault:"http://localhost:8080"`
-	FrontendBaseURL    string        `env:"FRONTEND_BASE_URL" envDefault:"http://localhost:3000"`
-	GitHubClientID     string        `env:"GITHUB_CLIENT_ID" envDefault:""`
-	GitHubClientSecret string        `env:"GITHUB_CLIENT_SECRET" envDefault:""`
-	GoogleClientID     string        `env:"GOOGLE_CLIENT_ID" envDefault:""`
-	GoogleClientSecret string        `env:"GOOGLE_CLIENT_SECRET" envDefault:""`
-	SupabaseURL        string        `env:"SUPABASE_URL" envDefault:""`
-	SupabaseKey        string        `env:"SUPABASE_KEY" envDefault:""`
-	GroqAPIKey         string        `env:"GROQ_API_KEY" envDefault:""`
-	GroqModel          string        `env:"GROQ_MODEL" envDefault:"meta-llama/llama-4-scout-17b-16e-instruct"`
-	SessionSecret      string        `env:"SESSION_SECRET" envDefault:"sessions-secret-key"`
-	SessionMaxAge      time.Duration `env:"SESSION_MAX_AGE" envDefault:"24h"`
-	SessionSecure      bool          `env:"SESSION_SECURE" envDefault:"false"`
-	MaxOpenConns       int           `env:"MAX_OPEN_CONNS" envDefault:"50"`
-	MaxIdleConns       int           `env:"MAX_IDLE_CONNS" envDefault:"10"`
-	ConnMaxLifetime    time.Duration `env:"CONN_MAX_LIFETIME" envDefault:"30m"`
-	ConnMaxIdleTime    time.Duration `env:"CONN_MAX_IDLE_TIME" envDefault:"5m"`
-	EncryptionKey      string        `env:"ENCRYPTION_KEY" envDefault:""`
-	CleanupInterval    time.Duration `env:"CLEANUP_INTERVAL" envDefault:"15m"`
+	Port                    string        `env:"PORT" envDefault:"8080"`
+	BackendBaseURL          string        `env:"BACKEND_BASE_URL" envDefault:"http://localhost:8080"`
+	FrontendBaseURL         string        `env:"FRONTEND_BASE_URL" envDefault:"http://localhost:3000"`
+	GitHubClientID          string        `env:"GITHUB_CLIENT_ID" envDefault:""`
+	GitHubClientSecret      string        `env:"GITHUB_CLIENT_SECRET" envDefault:""`
+	GoogleClientID          string        `env:"GOOGLE_CLIENT_ID" envDefault:""`
+	GoogleClientSecret      string        `env:"GOOGLE_CLIENT_SECRET" envDefault:""`
+	SupabaseURL             string        `env:"SUPABASE_URL" envDefault:""`
+	SupabaseKey             string        `env:"SUPABASE_KEY" envDefault:""`
+	StoreBackend            string        `env:"STORE_BACKEND" envDefault:"supabase"` // supabase, postgres or sqlite
+	StoreDatabaseURL        string        `env:"STORE_DATABASE_URL" envDefault:""`
+	StoreSQLitePath         string        `env:"STORE_SQLITE_PATH" envDefault:"data/datawhiz.db"`
+	StoreAutoMigrate        bool          `env:"STORE_AUTO_MIGRATE" envDefault:"true"`
+	GroqAPIKey              string        `env:"GROQ_API_KEY" envDefault:""`
+	GroqModel               string        `env:"GROQ_MODEL" envDefault:"meta-llama/llama-4-scout-17b-16e-instruct"`
+	SessionSecret           string        `env:"SESSION_SECRET" envDefault:"sessions-secret-key"`
+	SessionMaxAge           time.Duration `env:"SESSION_MAX_AGE" envDefault:"24h"`
+	SessionSecure           bool          `env:"SESSION_SECURE" envDefault:"false"`
+	MaxOpenConns            int           `env:"MAX_OPEN_CONNS" envDefault:"50"`
+	MaxIdleConns            int           `env:"MAX_IDLE_CONNS" envDefault:"10"`
+	ConnMaxLifetime         time.Duration `env:"CONN_MAX_LIFETIME" envDefault:"30m"`
+	ConnMaxIdleTime         time.Duration `env:"CONN_MAX_IDLE_TIME" envDefault:"5m"`
+	EncryptionKey           string        `env:"ENCRYPTION_KEY" envDefault:""`
+	CleanupInterval         time.Duration `env:"CLEANUP_INTERVAL" envDefault:"15m"`
+	PoolIdleTTL             time.Duration `env:"POOL_IDLE_TTL" envDefault:"1h"`
+	PoolMaxOpen             int           `env:"POOL_MAX_OPEN" envDefault:"100"`    // 0 for no limit
+	PoolMaxPerUser          int           `env:"POOL_MAX_PER_USER" envDefault:"10"` // 0 for no limit
+	PoolHealthInterval      time.Duration `env:"POOL_HEALTH_INTERVAL" envDefault:"30s"`
+	PoolHealthTimeout       time.Duration `env:"POOL_HEALTH_TIMEOUT" envDefault:"5s"`
+	PoolHealthSlowPing      time.Duration `env:"POOL_HEALTH_SLOW_PING" envDefault:"1s"` // slower pings mark a pool degraded
+	QueryTimeout            time.Duration `env:"QUERY_TIMEOUT" envDefault:"30s"`
+	MaxQueryTimeout         time.Duration `env:"MAX_QUERY_TIMEOUT" envDefault:"15m"`
+	MaxResultRows           int           `env:"MAX_RESULT_ROWS" envDefault:"100000"`
+	MaxExportRows           int           `env:"MAX_EXPORT_ROWS" envDefault:"1000000"`
+	MaxImportSize           int64         `env:"MAX_IMPORT_SIZE" envDefault:"1073741824"`
+	JobWorkers              int           `env:"JOB_WORKERS" envDefault:"4"`
+	JobQueueSize            int           `env:"JOB_QUEUE_SIZE" envDefault:"100"`
+	JobSpoolDir             string        `env:"JOB_SPOOL_DIR" envDefault:"/tmp/datawhiz/jobs"`
+	JobRetention            time.Duration `env:"JOB_RETENTION" envDefault:"24h"`
+	CopyJobWorkers          int           `env:"COPY_JOB_WORKERS" envDefault:"2"`
+	SchedulerInterval       time.Duration `env:"SCHEDULER_INTERVAL" envDefault:"30s"`
+	ScheduleWorkers         int           `env:"SCHEDULE_WORKERS" envDefault:"4"`
+	ScheduleMaxRows         int           `env:"SCHEDULE_MAX_ROWS" envDefault:"1000"`
+	ScheduleCatchUpMax      int           `env:"SCHEDULE_CATCH_UP_MAX" envDefault:"24"`
+	ScheduleDropDir         string        `env:"SCHEDULE_DROP_DIR" envDefault:"/tmp/datawhiz/drops"`
+	SMTPAddr                string        `env:"SMTP_ADDR" envDefault:"localhost:25"`
+	SMTPFrom                string        `env:"SMTP_FROM" envDefault:"datawhiz@localhost"`
+	QueryCacheBackend       string        `env:"QUERY_CACHE_BACKEND" envDefault:"memory"` // memory, disk or none
+	QueryCacheDir           string        `env:"QUERY_CACHE_DIR" envDefault:"/tmp/datawhiz/cache"`
+	QueryCacheTTL           time.Duration `env:"QUERY_CACHE_TTL" envDefault:"5m"`
+	QueryCacheMaxTTL        time.Duration `env:"QUERY_CACHE_MAX_TTL" envDefault:"1h"`
+	QueryCacheMaxBytes      int64         `env:"QUERY_CACHE_MAX_BYTES" envDefault:"268435456"`
+	QueryCacheMaxEntryBytes int64         `env:"QUERY_CACHE_MAX_ENTRY_BYTES" envDefault:"16777216"`
+	QueryCacheMaxEntries    int           `env:"QUERY_CACHE_MAX_ENTRIES" envDefault:"1000"`
 }
 
 func LoadEnv() (*Env, error) {
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cprakhar/datawhiz/internal/database/connections"
+	queryhistory "github.com/cprakhar/datawhiz/internal/database/query_history"
+	"github.com/cprakhar/datawhiz/internal/database/sqlstore"
+	"github.com/cprakhar/datawhiz/internal/database/users"
+	"github.com/supabase-community/supabase-go"
+)
+
+const (
+	StoreSupabase = "supabase"
+	StorePostgres = "postgres"
+	StoreSQLite   = "sqlite"
+)
+
+// Store holds the repositories of the users, their connections and their query history.
+type Store struct {
+	Users       users.Repository
+	Connections connections.Repository
+	History     queryhistory.Repository
+}
+
+// NewStore opens the store chosen by the configuration and, unless turned off, applies its
+// pending migrations. Supabase serves tables of its own PostgreSQL database, which is migrated
+// when STORE_DATABASE_URL points to it.
+func NewStore(env *Env, client *supabase.Client) (*Store, error) {
+	switch env.StoreBackend {
+	case StoreSupabase:
+		if client == nil {
+			return nil, errors.New("the supabase store needs SUPABASE_URL and SUPABASE_KEY")
+		}
+		if env.StoreAutoMigrate && env.StoreDatabaseURL != "" {
+			db, err := ConnectStore(env)
+			if err != nil {
+				return nil, err
+			}
+			err = db.Migrate()
+			db.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to migrate the store: %w", err)
+			}
+		}
+		return &Store{
+			Users:       users.NewSupabaseRepository(client),
+			Connections: connections.NewSupabaseRepository(client),
+			History:     queryhistory.NewSupabaseRepository(client),
+		}, nil
+	case StorePostgres, StoreSQLite:
+		db, err := ConnectStore(env)
+		if err != nil {
+			return nil, err
+		}
+		if env.StoreAutoMigrate {
+			if err := db.Migrate(); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to migrate the store: %w", err)
+			}
+		}
+		return newSQLStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", env.StoreBackend)
+	}
+}
+
+// ConnectStore connects to the database that holds the tables of the store: the SQLite file, or
+// the PostgreSQL database of the postgres store or behind Supabase.
+func ConnectStore(env *Env) (*sqlstore.DB, error) {
+	switch env.StoreBackend {
+	case StoreSQLite:
+		return sqlstore.Connect(sqlstore.DialectSQLite, env.StoreSQLitePath)
+	case StorePostgres, StoreSupabase:
+		if env.StoreDatabaseURL == "" {
+			return nil, fmt.Errorf("the %s store needs STORE_DATABASE_URL to reach its database", env.StoreBackend)
+		}
+		return sqlstore.Connect(sqlstore.DialectPostgres, env.StoreDatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", env.StoreBackend)
+	}
+}
+
+func newSQLStore(db *sqlstore.DB) *Store {
+	return &Store{
+		Users:       users.NewSQLRepository(db),
+		Connections: connections.NewSQLRepository(db),
+		History:     queryhistory.NewSQLRepository(db),
+	}
+}
@@ -0,0 +1,526 @@
+package copyjobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/dump"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+type TableStatus string
+
+const (
+	TablePending TableStatus = "pending"
+	TableCopying TableStatus = "copying"
+	TableDone    TableStatus = "done"
+	TableSkipped TableStatus = "skipped"
+	TableFailed  TableStatus = "failed"
+)
+
+var (
+	ErrNotFound     = errors.New("job not found")
+	ErrQueueFull    = errors.New("job queue is full, try again later")
+	ErrNotResumable = errors.New("only failed or cancelled jobs can be resumed")
+	ErrNoResumeKey  = errors.New("the table has no primary key to resume from")
+)
+
+// sampleDocuments is the number of documents read to find the fields of a collection.
+const sampleDocuments = 1000
+
+// maxReportedErrors bounds the row errors kept per table; the rest are only counted.
+const maxReportedErrors = 100
+
+// Options controls how the tables of a job are created and loaded.
+type Options struct {
+	BatchSize int           `json:"batch_size"`
+	IfExists  dump.IfExists `json:"if_exists"`
+	// Lossy creates columns without a matching type on the target engine as text instead of
+	// failing the table.
+	Lossy     bool                 `json:"lossy"`
+	OnError   importer.ErrorPolicy `json:"on_error"`
+	MaxErrors int64                `json:"max_errors,omitempty"`
+}
+
+// Table is a table copied by a job, with the checkpoint a resumed job continues from.
+type Table struct {
+	Source        string              `json:"source"`
+	Target        string              `json:"target"`
+	Status        TableStatus         `json:"status"`
+	Created       bool                `json:"created"`
+	Columns       []importer.Column   `json:"columns,omitempty"` // columns loaded into the target
+	Keys          []string            `json:"keys,omitempty"`
+	LastKey       []interface{}       `json:"last_key,omitempty"` // key of the last row read before the last checkpoint
+	RowsTotal     int64               `json:"rows_total"`         // counted when the table was planned
+	RowsRead      int64               `json:"rows_read"`
+	RowsCopied    int64               `json:"rows_copied"`
+	RowsFailed    int64               `json:"rows_failed"`
+	Errors        []importer.RowError `json:"errors"`
+	Percent       float64             `json:"percent"`
+	RowsPerSecond float64             `json:"rows_per_second"`
+	Error         string              `json:"error,omitempty"`
+
+	planned       bool
+	sourceColumns []importer.Column
+	keyColumns    []importer.Column
+}
+
+// Job copies tables from one connection to another in the background.
+type Job struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"-"`
+	SourceID      string     `json:"source_id"`
+	SourceDB      string     `json:"source_db,omitempty"`
+	TargetID      string     `json:"target_id"`
+	TargetDB      string     `json:"target_db,omitempty"`
+	Options       Options    `json:"options"`
+	Tables        []Table    `json:"tables"`
+	Status        Status     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	Attempts      int        `json:"attempts"`
+	RowsCopied    int64      `json:"rows_copied"`
+	RowsPerSecond float64    `json:"rows_per_second"` // over the running time of every attempt
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Duration      int64      `json:"duration"` // running time of every attempt
+
+	cancel           context.CancelFunc
+	attemptStartedAt time.Time
+}
+
+var (
+	jobMap   = make(map[string]*Job) // key: job ID
+	jobMutex sync.RWMutex            // Mutex to protect access to jobMap and the jobs in it
+	jobQueue chan *Job
+	jobCfg   *config.Config
+)
+
+// StartWorkers starts the copy workers and the routine that expires finished jobs.
+func StartWorkers(cfg *config.Config) {
+	jobCfg = cfg
+	jobQueue = make(chan *Job, cfg.Env.JobQueueSize)
+	for range cfg.Env.CopyJobWorkers {
+		go worker()
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Env.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			CleanupJobs()
+		}
+	}()
+}
+
+// Submit queues a job copying the given tables.
+func Submit(job *Job) error {
+	job.Status = StatusQueued
+	job.CreatedAt = time.Now()
+	for i := range job.Tables {
+		job.Tables[i].Status = TablePending
+		job.Tables[i].Errors = []importer.RowError{}
+	}
+
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	if _, exists := jobMap[job.ID]; exists {
+		return errors.New("job ID already in use")
+	}
+
+	select {
+	case jobQueue <- job:
+		jobMap[job.ID] = job
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// GetJob returns a snapshot of the job with the given ID owned by the given user.
+func GetJob(jobID, userID string) (*Job, error) {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	job, exists := jobMap[jobID]
+	if !exists || job.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return snapshot(job), nil
+}
+
+// ListJobs returns snapshots of the copy jobs of the given user, oldest first.
+func ListJobs(userID string) []Job {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	var result []Job
+	for _, job := range jobMap {
+		if job.UserID == userID {
+			result = append(result, *snapshot(job))
+		}
+	}
+	slices.SortFunc(result, func(a, b Job) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return result
+}
+
+// snapshot copies a job so that it can be read without holding jobMutex. The caller must hold jobMutex.
+func snapshot(job *Job) *Job {
+	copied := *job
+	copied.Tables = slices.Clone(job.Tables)
+	return &copied
+}
+
+// CancelJob cancels a queued or running job. A running job stops after the batch in flight, and
+// can be resumed from there.
+func CancelJob(jobID, userID string) error {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	job, exists := jobMap[jobID]
+	if !exists || job.UserID != userID {
+		return ErrNotFound
+	}
+	switch job.Status {
+	case StatusQueued:
+		finish(job, StatusCancelled, "")
+	case StatusRunning:
+		job.cancel()
+	}
+	return nil
+}
+
+// ResumeJob queues a failed or cancelled job again. Finished tables are left alone and the
+// others continue after their last checkpoint.
+func ResumeJob(jobID, userID string) error {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	job, exists := jobMap[jobID]
+	if !exists || job.UserID != userID {
+		return ErrNotFound
+	}
+	if job.Status != StatusFailed && job.Status != StatusCancelled {
+		return ErrNotResumable
+	}
+
+	select {
+	case jobQueue <- job:
+		job.Status = StatusQueued
+		job.Error = ""
+		job.FinishedAt = nil
+		job.ExpiresAt = nil
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// CleanupJobs removes finished jobs once their retention window has passed.
+func CleanupJobs() {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	for jobID, job := range jobMap {
+		if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+			delete(jobMap, jobID)
+		}
+	}
+}
+
+// ShutdownJobs cancels every queued and running job.
+func ShutdownJobs() {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	for _, job := range jobMap {
+		switch job.Status {
+		case StatusQueued:
+			finish(job, StatusCancelled, "server shutting down")
+		case StatusRunning:
+			job.cancel()
+		}
+	}
+}
+
+func worker() {
+	for job := range jobQueue {
+		run(job)
+	}
+}
+
+// run copies the tables of a job one after the other, detached from any HTTP request.
+func run(job *Job) {
+	jobMutex.Lock()
+	if job.Status != StatusQueued {
+		jobMutex.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startedAt := time.Now()
+	job.Status = StatusRunning
+	job.Attempts++
+	job.cancel = cancel
+	job.attemptStartedAt = startedAt
+	if job.StartedAt == nil {
+		job.StartedAt = &startedAt
+	}
+	jobMutex.Unlock()
+
+	err := execute(ctx, job)
+
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	job.Duration += time.Since(startedAt).Milliseconds()
+	job.RowsPerSecond = rate(job.RowsCopied, time.Duration(job.Duration)*time.Millisecond)
+	switch {
+	case err == nil:
+		finish(job, StatusSucceeded, "")
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		finish(job, StatusCancelled, err.Error())
+	default:
+		finish(job, StatusFailed, err.Error())
+	}
+}
+
+func execute(ctx context.Context, job *Job) error {
+	source, err := poolmanager.GetPool(job.SourceID, job.UserID)
+	if err != nil {
+		return fmt.Errorf("source connection: %w", err)
+	}
+	target, err := poolmanager.GetPool(job.TargetID, job.UserID)
+	if err != nil {
+		return fmt.Errorf("target connection: %w", err)
+	}
+	// Every committed batch changes the target, whether or not the copy finishes.
+	defer querycache.InvalidateConnection(job.TargetID)
+
+	for i := range job.Tables {
+		jobMutex.RLock()
+		table := &job.Tables[i]
+		status := table.Status
+		jobMutex.RUnlock()
+		if status == TableDone || status == TableSkipped {
+			continue
+		}
+
+		if err := copyTable(ctx, job, table, source, target); err != nil {
+			jobMutex.Lock()
+			table.Status = TableFailed
+			table.Error = err.Error()
+			jobMutex.Unlock()
+			return fmt.Errorf("copying %s: %w", table.Source, err)
+		}
+	}
+	return nil
+}
+
+// planTable finds the columns and keys of the source table and creates the target table, or
+// checks the existing one. It runs once per table; a resumed job reuses its plan.
+func planTable(ctx context.Context, job *Job, table *Table, source, target *poolmanager.PoolManager) error {
+	columns, err := dbdriver.GetDumpColumns(ctx, source.Pool, source.DBType, job.SourceDB, table.Source)
+	if err != nil {
+		return err
+	}
+	if columns == nil {
+		// Collections have no fixed columns, so they are copied with the fields of the first documents.
+		rows, err := dbdriver.GetTableBatch(ctx, source.Pool, source.DBType, job.SourceDB, table.Source, nil, nil, sampleDocuments)
+		if err != nil {
+			return err
+		}
+		columns = dump.DocumentColumns(rows.Columns())
+		rows.Close()
+	}
+	var keys []importer.Column
+	for _, col := range columns {
+		if col.PrimaryKey {
+			keys = append(keys, col)
+		}
+	}
+
+	existing, err := dump.ResolveTables(ctx, target.Pool, target.DBType, job.TargetDB, nil)
+	if err != nil {
+		return err
+	}
+	exists := slices.Contains(existing, table.Target)
+	status := TablePending
+	var targets []importer.Column
+	switch {
+	case exists && job.Options.IfExists == dump.IfExistsSkip:
+		status = TableSkipped
+	case exists && job.Options.IfExists == dump.IfExistsAppend:
+		if targets, err = dbdriver.GetImportColumns(ctx, target.Pool, target.DBType, job.TargetDB, table.Target); err != nil {
+			return err
+		}
+		if targets == nil {
+			targets = dump.DocumentTargets(columns)
+		}
+	case exists:
+		return fmt.Errorf("%w: %s", dump.ErrTableExists, table.Target)
+	case len(columns) == 0:
+		// Only an empty collection has no columns.
+		if target.DBType != "mongodb" {
+			return fmt.Errorf("%s is empty, there are no columns to create the table with", table.Source)
+		}
+	default:
+		if targets, err = dump.TargetColumns(source.DBType, target.DBType, columns, job.Options.Lossy); err != nil {
+			return err
+		}
+	}
+
+	var total int64
+	if status != TableSkipped {
+		if total, err = dbdriver.CountTableRows(ctx, source.Pool, source.DBType, job.SourceDB, table.Source); err != nil {
+			return err
+		}
+	}
+	// The table is created last, so that a failure before leaves nothing to clean up when the
+	// job is resumed and the table planned again.
+	created := !exists
+	if created {
+		if err := dbdriver.CreateImportTable(ctx, target.Pool, target.DBType, job.TargetDB, table.Target, targets); err != nil {
+			return err
+		}
+	}
+
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	table.planned = true
+	table.Status = status
+	table.Created = created
+	table.Columns = targets
+	table.RowsTotal = total
+	table.sourceColumns = columns
+	table.keyColumns = keys
+	for _, key := range keys {
+		table.Keys = append(table.Keys, key.Name)
+	}
+	return nil
+}
+
+// copyTable loads the rows of a table after its last checkpoint. The checkpoint moves after every
+// batch the target committed, so a resumed table reloads at most the batch that was in flight.
+func copyTable(ctx context.Context, job *Job, table *Table, source, target *poolmanager.PoolManager) error {
+	jobMutex.RLock()
+	planned := table.planned
+	jobMutex.RUnlock()
+	if !planned {
+		if err := planTable(ctx, job, table, source, target); err != nil {
+			return err
+		}
+	}
+
+	jobMutex.Lock()
+	if table.Status == TableSkipped {
+		jobMutex.Unlock()
+		return nil
+	}
+	if len(table.keyColumns) == 0 && table.RowsRead > 0 {
+		jobMutex.Unlock()
+		return fmt.Errorf("%w, %d rows were already copied", ErrNoResumeKey, table.RowsCopied)
+	}
+	table.Status = TableCopying
+	table.Error = ""
+	base := *table
+	jobMutex.Unlock()
+
+	if len(base.sourceColumns) == 0 {
+		tableDone(table)
+		return nil
+	}
+
+	src := newTableSource(ctx, source.Pool, source.DBType, job.SourceDB, table.Source, base.sourceColumns, base.keyColumns, base.LastKey, base.RowsRead, base.RowsTotal, job.Options.BatchSize)
+	defer src.Close()
+	plan, err := importer.NewPlan(base.sourceColumns, base.Columns, nil)
+	if err != nil {
+		return err
+	}
+	loader, err := dbdriver.NewImportLoader(target.Pool, target.DBType, job.TargetDB, table.Target, plan.Targets)
+	if err != nil {
+		return err
+	}
+	opts := importer.Options{BatchSize: job.Options.BatchSize, OnError: job.Options.OnError, MaxErrors: job.Options.MaxErrors}
+
+	startedAt := time.Now()
+	var checkpointRead int64
+	checkpoint := func(p importer.Progress) {
+		jobMutex.Lock()
+		defer jobMutex.Unlock()
+		job.RowsCopied += base.RowsCopied + p.RowsLoaded - table.RowsCopied
+		job.RowsPerSecond = rate(job.RowsCopied, time.Duration(job.Duration)*time.Millisecond+time.Since(job.attemptStartedAt))
+		table.LastKey = src.after
+		table.RowsRead = base.RowsRead + p.RowsRead
+		table.RowsCopied = base.RowsCopied + p.RowsLoaded
+		table.RowsFailed = base.RowsFailed + p.RowsFailed
+		table.Percent = p.Percent
+		table.RowsPerSecond = rate(p.RowsLoaded, time.Since(startedAt))
+		checkpointRead = p.RowsRead
+	}
+	summary, err := importer.Run(ctx, src, plan, loader, opts, checkpoint)
+
+	if err == nil {
+		// Rows that all failed after the last batch leave no checkpoint behind, so the counts are
+		// brought up to date with the summary.
+		checkpoint(importer.Progress{RowsRead: summary.RowsRead, RowsLoaded: summary.RowsLoaded, RowsFailed: summary.RowsFailed})
+	}
+	jobMutex.Lock()
+	// Only errors of rows before the checkpoint are kept; the others are read again on resume.
+	for _, rowErr := range summary.Errors {
+		if rowErr.Row <= checkpointRead && len(table.Errors) < maxReportedErrors {
+			rowErr.Row += base.RowsRead
+			table.Errors = append(table.Errors, rowErr)
+		}
+	}
+	jobMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	tableDone(table)
+	return nil
+}
+
+// tableDone marks a table as fully copied.
+func tableDone(table *Table) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	table.Status = TableDone
+	table.Percent = 100
+}
+
+// rate returns the rows per second over the given time.
+func rate(rows int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(rows) / elapsed.Seconds()
+}
+
+// finish marks the job as done and starts its retention window. The caller must hold jobMutex.
+func finish(job *Job, status Status, errMsg string) {
+	finishedAt := time.Now()
+	expiresAt := finishedAt.Add(jobCfg.Env.JobRetention)
+	job.Status = status
+	job.Error = errMsg
+	job.FinishedAt = &finishedAt
+	job.ExpiresAt = &expiresAt
+	job.cancel = nil
+}
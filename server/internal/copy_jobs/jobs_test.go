@@ -0,0 +1,106 @@
+package copyjobs
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	"github.com/cprakhar/datawhiz/internal/database/connections"
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+	"github.com/cprakhar/datawhiz/utils/secure"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef"
+
+// fakeRepository serves SQLite connections of files named after their IDs.
+type fakeRepository struct {
+	connections.Repository
+	dir string
+}
+
+func (r *fakeRepository) GetRecord(id, userID string) (*schema.Connection, error) {
+	connString, err := secure.Encrypt(filepath.Join(r.dir, id+".db"), testKey)
+	if err != nil {
+		return nil, err
+	}
+	return &schema.Connection{ID: id, UserID: userID, DBType: "sqlite", ConnString: connString}, nil
+}
+
+func (r *fakeRepository) SetActive(id, userID string, isActive bool) error {
+	return nil
+}
+
+func (r *fakeRepository) SetAllInactive() error {
+	return nil
+}
+
+func TestCopyInvalidatesTargetCache(t *testing.T) {
+	repo := &fakeRepository{dir: t.TempDir()}
+	cfg := &config.Config{
+		Env: &config.Env{
+			EncryptionKey:        testKey,
+			PoolIdleTTL:          time.Hour,
+			PoolMaxPerUser:       4,
+			PoolMaxOpen:          4,
+			QueryCacheBackend:    querycache.BackendMemory,
+			QueryCacheMaxBytes:   1 << 20,
+			QueryCacheMaxEntries: 10,
+			QueryCacheTTL:        time.Minute,
+			CleanupInterval:      time.Hour,
+		},
+		Store:    &config.Store{Connections: repo},
+		DBConfig: &config.DBConfig{MaxOpenConns: 2, MaxIdleConns: 2},
+	}
+	t.Cleanup(func() { poolmanager.ShutdownAllPools(repo) })
+	if err := querycache.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(repo.dir, "source.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("create table users (id integer primary key, name text); insert into users values (1, 'ada'), (2, 'alan')")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"source", "target"} {
+		if err := poolmanager.ActivateConnection(context.Background(), cfg, id, "sqlite", "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A result of the target read before the copy.
+	key, _ := querycache.Key("target", "", "sqlite", "select name from sqlite_master", nil)
+	rec := querycache.Capture(result.NewSliceRows([]result.Column{{Name: "name"}}, nil))
+	for rec.Next() {
+	}
+	querycache.Put(key, "target", rec, 0)
+	if _, _, ok := querycache.Get(key); !ok {
+		t.Fatal("result was not cached")
+	}
+
+	job := &Job{
+		UserID:   "user",
+		SourceID: "source",
+		TargetID: "target",
+		Options:  Options{BatchSize: 100},
+		Tables:   []Table{{Source: "users", Target: "users", Status: TablePending}},
+	}
+	if err := execute(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Tables[0].RowsCopied != 2 {
+		t.Fatalf("copied %d rows", job.Tables[0].RowsCopied)
+	}
+	if _, _, ok := querycache.Get(key); ok {
+		t.Fatal("the cached result of the target outlived the copy")
+	}
+}
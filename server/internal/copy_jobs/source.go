@@ -0,0 +1,204 @@
+package copyjobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+// tableSource reads a table in batches ordered by its key columns, starting after a checkpoint,
+// and hands the rows to the importer with the columns the copy was planned with.
+type tableSource struct {
+	ctx       context.Context
+	pool      interface{}
+	dbType    string
+	dbName    string
+	table     string
+	columns   []importer.Column
+	keys      []importer.Column
+	keyIndex  []int // column of each key
+	batchSize int64
+
+	after []interface{} // key of the last row returned
+	read  int64         // rows read, including earlier attempts
+	total int64
+
+	rows      result.Rows
+	rowCols   []result.Column
+	rowIndex  []int // column each field of the batch goes to, -1 for fields that are not copied
+	batchRows int64
+	done      bool
+}
+
+func newTableSource(ctx context.Context, pool interface{}, dbType, dbName, table string, columns, keys []importer.Column, after []interface{}, read, total int64, batchSize int) *tableSource {
+	index := make(map[string]int, len(columns))
+	for i, col := range columns {
+		index[col.Name] = i
+	}
+	keyIndex := make([]int, len(keys))
+	for i, key := range keys {
+		keyIndex[i] = index[key.Name]
+	}
+	return &tableSource{
+		ctx:       ctx,
+		pool:      pool,
+		dbType:    dbType,
+		dbName:    dbName,
+		table:     table,
+		columns:   columns,
+		keys:      keys,
+		keyIndex:  keyIndex,
+		batchSize: int64(batchSize),
+		after:     after,
+		read:      read,
+		total:     total,
+	}
+}
+
+func (s *tableSource) Columns() []importer.Column { return s.columns }
+
+func (s *tableSource) Next() ([]interface{}, error) {
+	for {
+		if s.rows == nil {
+			if s.done {
+				return nil, io.EOF
+			}
+			if err := s.fetch(); err != nil {
+				return nil, err
+			}
+		}
+		if s.rows.Next() {
+			s.batchRows++
+			return s.row()
+		}
+		err := s.rows.Err()
+		s.rows.Close()
+		s.rows = nil
+		if err != nil {
+			return nil, err
+		}
+		// A short batch is the last one, and a table without keys is read in a single pass.
+		if len(s.keys) == 0 || s.batchRows < s.batchSize {
+			s.done = true
+		}
+	}
+}
+
+// fetch starts reading the batch after the last row returned.
+func (s *tableSource) fetch() error {
+	rows, err := dbdriver.GetTableBatch(s.ctx, s.pool, s.dbType, s.dbName, s.table, s.keys, s.after, s.batchSize)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(s.columns))
+	for i, col := range s.columns {
+		index[col.Name] = i
+	}
+	s.rowCols = rows.Columns()
+	s.rowIndex = make([]int, len(s.rowCols))
+	for i, col := range s.rowCols {
+		if idx, ok := index[col.Name]; ok {
+			s.rowIndex[i] = idx
+		} else {
+			s.rowIndex[i] = -1
+		}
+	}
+	s.rows = rows
+	s.batchRows = 0
+	return nil
+}
+
+// row reads the current row. The checkpoint moves past every row read, including rows that are
+// reported as errors, since the importer counts them as done.
+func (s *tableSource) row() ([]interface{}, error) {
+	values, err := s.rows.Values()
+	if err != nil {
+		return nil, err
+	}
+	s.read++
+	row := make([]interface{}, len(s.columns))
+	var unknown string
+	for i, v := range values {
+		if s.rowIndex[i] < 0 {
+			if v != nil && unknown == "" {
+				unknown = s.rowCols[i].Name
+			}
+			continue
+		}
+		row[s.rowIndex[i]] = copyValue(s.rowCols[i], v)
+	}
+	if len(s.keys) > 0 {
+		after := make([]interface{}, len(s.keys))
+		for i, idx := range s.keyIndex {
+			after[i] = row[idx]
+		}
+		s.after = after
+	}
+	if unknown != "" {
+		// Documents are copied with the fields of the first batch; a later field would be lost.
+		return nil, &importer.RowError{Column: unknown, Message: "field is not in the documents sampled to plan the copy"}
+	}
+	return row, nil
+}
+
+func (s *tableSource) Progress() float64 {
+	if s.total <= 0 {
+		return 0
+	}
+	return min(float64(s.read)/float64(s.total), 1)
+}
+
+// Close stops reading the current batch.
+func (s *tableSource) Close() {
+	if s.rows != nil {
+		s.rows.Close()
+		s.rows = nil
+	}
+}
+
+// copyValue encodes a value read from the source the way a query result would have it, which is
+// the form the importer converts to the target kind.
+func copyValue(col result.Column, value interface{}) interface{} {
+	switch v := result.Encode(col, value).(type) {
+	case nil, string, bool, int64, uint64, float64, []byte, json.RawMessage, map[string]interface{}, []interface{}:
+		return v
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return uint64(v)
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	default:
+		// Documents and values with their own JSON form go through JSON, like in a dump.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		if len(b) > 0 && (b[0] == '{' || b[0] == '[') {
+			return json.RawMessage(b)
+		}
+		var scalar interface{}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if err := decoder.Decode(&scalar); err != nil {
+			return string(b)
+		}
+		return scalar
+	}
+}
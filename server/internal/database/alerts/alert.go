@@ -0,0 +1,255 @@
+package alerts
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+var ErrNotFound = errors.New("alert rule not found")
+
+type ConditionType string
+
+const (
+	ConditionThreshold ConditionType = "threshold"        // a value of the first row crosses a limit
+	ConditionRowCount  ConditionType = "row_count_change" // the number of rows changes
+	ConditionNewRows   ConditionType = "new_rows"         // rows with keys not seen before appear
+)
+
+// Condition is what a rule watches in the result of its query.
+type Condition struct {
+	Type     ConditionType `json:"type"`
+	Column   string        `json:"column,omitempty"`   // threshold, the first column by default
+	Operator string        `json:"operator,omitempty"` // threshold: >, >=, <, <=, = or !=
+	Value    float64       `json:"value,omitempty"`    // threshold
+	Key      string        `json:"key,omitempty"`      // new_rows, the column identifying a row, the first column by default
+}
+
+type NotifierType string
+
+const (
+	NotifierWebhook NotifierType = "webhook"
+	NotifierEmail   NotifierType = "email"
+)
+
+// Notifier is where the events of a rule are sent.
+type Notifier struct {
+	Type    NotifierType      `json:"type"`
+	URL     string            `json:"url,omitempty"`     // webhook
+	Headers map[string]string `json:"headers,omitempty"` // webhook
+	To      []string          `json:"to,omitempty"`      // email
+}
+
+type Status string
+
+const (
+	StatusPending Status = "pending" // not checked yet
+	StatusOK      Status = "ok"
+	StatusFiring  Status = "firing" // the threshold is crossed
+	StatusError   Status = "error"  // the query fails
+)
+
+// State is what a rule remembers between checks, so that it only notifies about changes.
+type State struct {
+	Status   Status   `json:"status"`
+	Value    *float64 `json:"value,omitempty"`
+	RowCount *int64   `json:"row_count,omitempty"`
+	Keys     []string `json:"keys,omitempty"` // new_rows, the keys seen so far
+	Error    string   `json:"error,omitempty"`
+}
+
+// Rule runs a query on a connection of its owner every few minutes and notifies when its
+// condition is met.
+type Rule struct {
+	ID              string     `json:"id,omitempty"`
+	UserID          string     `json:"user_id"`
+	ConnID          string     `json:"conn_id"`
+	DBName          string     `json:"db_name"`
+	Name            string     `json:"name"`
+	Query           string     `json:"query"`
+	IntervalMinutes int        `json:"interval_minutes"`
+	Condition       Condition  `json:"condition"`
+	Notifiers       []Notifier `json:"notifiers"`
+	NotifyResolved  bool       `json:"notify_resolved"`
+	Enabled         bool       `json:"enabled"`
+	State           State      `json:"state"`
+	NextCheckAt     *time.Time `json:"next_check_at"`
+	LastCheckedAt   *time.Time `json:"last_checked_at"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+type EventKind string
+
+const (
+	EventTriggered EventKind = "triggered" // the threshold was crossed
+	EventResolved  EventKind = "resolved"  // the threshold is no longer crossed
+	EventChanged   EventKind = "changed"   // the row count changed
+	EventNewRows   EventKind = "new_rows"
+	EventError     EventKind = "error" // the query started failing
+)
+
+// Event is a notification-worthy change found by a check of a rule.
+type Event struct {
+	ID          string          `json:"id,omitempty"`
+	RuleID      string          `json:"rule_id"`
+	UserID      string          `json:"user_id"`
+	Kind        EventKind       `json:"kind"`
+	Message     string          `json:"message"`
+	Value       *float64        `json:"value"`
+	RowCount    *int64          `json:"row_count"`
+	Rows        [][]interface{} `json:"rows"` // new_rows, the rows that appeared
+	Notified    bool            `json:"notified"`
+	NotifyError string          `json:"notify_error"`
+	CreatedAt   *time.Time      `json:"created_at,omitempty"`
+}
+
+// EventFilter narrows a listing of events. Empty fields match everything.
+type EventFilter struct {
+	RuleID string
+	Kind   EventKind
+	Since  *time.Time
+	Until  *time.Time
+	Limit  int
+}
+
+// InsertRule creates an alert rule.
+func InsertRule(client *supabase.Client, rule *Rule) (*Rule, error) {
+	var created Rule
+	if _, err := client.From("alert_rules").Insert(rule, false, "", "representation", "").Single().ExecuteTo(&created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetRule retrieves an alert rule of a user.
+func GetRule(client *supabase.Client, id, userID string) (*Rule, error) {
+	var rules []Rule
+	if _, err := client.From("alert_rules").Select("*", "", false).Eq("id", id).Eq("user_id", userID).ExecuteTo(&rules); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rules[0], nil
+}
+
+// ListRules retrieves the alert rules of a user by name.
+func ListRules(client *supabase.Client, userID string) ([]Rule, error) {
+	rules := []Rule{}
+	_, err := client.From("alert_rules").Select("*", "", false).
+		Eq("user_id", userID).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ListDueRules retrieves the enabled alert rules of every user whose next check is not after now.
+func ListDueRules(client *supabase.Client, now time.Time) ([]Rule, error) {
+	rules := []Rule{}
+	_, err := client.From("alert_rules").Select("*", "", false).
+		Eq("enabled", "true").
+		Lte("next_check_at", now.UTC().Format(time.RFC3339)).
+		ExecuteTo(&rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// UpdateRule stores the edited settings of an alert rule of a user. The state starts over, since
+// it no longer describes what the rule watches.
+func UpdateRule(client *supabase.Client, rule *Rule) (*Rule, error) {
+	update := map[string]interface{}{
+		"conn_id":          rule.ConnID,
+		"db_name":          rule.DBName,
+		"name":             rule.Name,
+		"query":            rule.Query,
+		"interval_minutes": rule.IntervalMinutes,
+		"condition":        rule.Condition,
+		"notifiers":        rule.Notifiers,
+		"notify_resolved":  rule.NotifyResolved,
+		"enabled":          rule.Enabled,
+		"state":            State{Status: StatusPending},
+		"next_check_at":    rule.NextCheckAt,
+		"updated_at":       time.Now().UTC(),
+	}
+	var updated []Rule
+	_, err := client.From("alert_rules").Update(update, "representation", "").
+		Eq("id", rule.ID).
+		Eq("user_id", rule.UserID).
+		ExecuteTo(&updated)
+	if err != nil {
+		return nil, err
+	}
+	if len(updated) == 0 {
+		return nil, ErrNotFound
+	}
+	return &updated[0], nil
+}
+
+// SaveState records the outcome of a check of an alert rule.
+func SaveState(client *supabase.Client, rule *Rule) error {
+	update := map[string]interface{}{
+		"state":             rule.State,
+		"next_check_at":     rule.NextCheckAt,
+		"last_checked_at":   rule.LastCheckedAt,
+		"last_triggered_at": rule.LastTriggeredAt,
+	}
+	_, _, err := client.From("alert_rules").Update(update, "minimal", "").Eq("id", rule.ID).Execute()
+	return err
+}
+
+// DeleteRule deletes an alert rule of a user with its events.
+func DeleteRule(client *supabase.Client, id, userID string) error {
+	if _, _, err := client.From("alert_events").Delete("minimal", "").Eq("rule_id", id).Eq("user_id", userID).Execute(); err != nil {
+		return err
+	}
+	_, _, err := client.From("alert_rules").Delete("minimal", "").Eq("id", id).Eq("user_id", userID).Execute()
+	return err
+}
+
+// InsertEvent records an event of an alert rule.
+func InsertEvent(client *supabase.Client, event *Event) error {
+	_, _, err := client.From("alert_events").Insert(event, false, "", "minimal", "").Execute()
+	return err
+}
+
+// ListEvents retrieves the events of the alert rules of a user, latest first.
+func ListEvents(client *supabase.Client, userID string, filter EventFilter) ([]Event, error) {
+	query := client.From("alert_events").Select("*", "", false).Eq("user_id", userID)
+	if filter.RuleID != "" {
+		query = query.Eq("rule_id", filter.RuleID)
+	}
+	if filter.Kind != "" {
+		query = query.Eq("kind", string(filter.Kind))
+	}
+	// Filters are kept per column, so both bounds of the range go into a single condition.
+	var bounds []string
+	if filter.Since != nil {
+		bounds = append(bounds, "created_at.gte."+filter.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.Until != nil {
+		bounds = append(bounds, "created_at.lt."+filter.Until.UTC().Format(time.RFC3339Nano))
+	}
+	if len(bounds) > 0 {
+		query = query.And(strings.Join(bounds, ","), "")
+	}
+
+	events := []Event{}
+	_, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(filter.Limit, "").
+		ExecuteTo(&events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -3,10 +3,10 @@ package connections
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"path/filepath"
+	"strings"
 
 	"github.com/cprakhar/datawhiz/internal/database/schema"
-	"github.com/cprakhar/datawhiz/internal/db_driver/sql"
 	"github.com/supabase-community/supabase-go"
 )
 
@@ -21,11 +21,88 @@ type ResponseConnection struct {
 	DBName         string `json:"dbName"`
 	IsActive       bool   `json:"isActive"`
 	DBFilePath     string `json:"dbFilePath,omitempty"`
+	QueryTimeout   int    `json:"queryTimeout,omitempty"`
+
+	PoolSettings *schema.PoolSettings `json:"poolSettings,omitempty"`
+	SSHTunnel    *schema.SSHTunnel    `json:"sshTunnel,omitempty"`   // without its secrets
+	TLS          *schema.TLSSettings  `json:"tlsSettings,omitempty"` // without its certificates and key
+}
+
+// Repository stores the connections of the users. Every lookup is scoped to the owner of the connection.
+type Repository interface {
+	// InsertOne inserts a new connection and returns the created connection.
+	InsertOne(conn *schema.Connection) (*ResponseConnection, error)
+	// Exists checks if the user already has a connection with the same name. Names are unique per user.
+	Exists(req *schema.ManualConnectionForm, userID string) (bool, error)
+	ListByUserID(userID string) ([]ResponseConnection, error)
+	Delete(id, userID string) error
+	// GetConnectionString returns the encrypted connection string of a connection, or "" if there is none.
+	GetConnectionString(id, userID string) (string, error)
+	// GetRecord returns the stored connection record, including its encrypted secrets.
+	GetRecord(id, userID string) (*schema.Connection, error)
+	SetActive(id, userID string, isActive bool) error
+	// GetByID returns a connection, or nil if there is none.
+	GetByID(id, userID string) (*ResponseConnection, error)
+	// SetAllInactive sets all connections to inactive (global).
+	SetAllInactive() error
+	SetAllInactiveForUser(userID string) error
+}
+
+func toResponseConnection(conn *schema.Connection) *ResponseConnection {
+	var sshTunnel *schema.SSHTunnel
+	if conn.SSHTunnel != nil {
+		sshTunnel = &schema.SSHTunnel{
+			Host:       conn.SSHTunnel.Host,
+			Port:       conn.SSHTunnel.Port,
+			User:       conn.SSHTunnel.User,
+			KnownHosts: conn.SSHTunnel.KnownHosts,
+		}
+	}
+	var tlsSettings *schema.TLSSettings
+	if conn.TLS != nil {
+		tlsSettings = &schema.TLSSettings{Mode: conn.TLS.Mode, ServerName: conn.TLS.ServerName}
+	}
+	return &ResponseConnection{
+		ID:             conn.ID,
+		Host:           conn.Host,
+		Port:           conn.Port,
+		Username:       conn.Username,
+		DBType:         conn.DBType,
+		ConnectionName: conn.ConnectionName,
+		SSLMode:        conn.SSLMode,
+		DBName:         conn.DBName,
+		IsActive:       conn.IsActive,
+		QueryTimeout:   conn.QueryTimeout,
+		PoolSettings:   conn.PoolSettings,
+		SSHTunnel:      sshTunnel,
+		TLS:            tlsSettings,
+	}
 }
 
-// InsertOneConnection inserts a new connection into the database and returns the created connection.
-func InsertOneConnection(client *supabase.Client, conn *schema.Connection) (*ResponseConnection, error) {
-	data, _, err := client.From("connections").Insert(conn, false, "", "representation", "exact").Single().Execute()
+// listResponse converts the connections of a listing, naming SQLite databases after their file.
+func listResponse(conns []schema.Connection) []ResponseConnection {
+	var response []ResponseConnection
+	for _, conn := range conns {
+		if conn.DBType == "sqlite" && conn.DBFilePath != "" {
+			fileName := filepath.Base(conn.DBFilePath)
+			conn.DBName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		}
+		response = append(response, *toResponseConnection(&conn))
+	}
+	return response
+}
+
+type supabaseRepository struct {
+	client *supabase.Client
+}
+
+// NewSupabaseRepository returns a repository of the connections kept in Supabase.
+func NewSupabaseRepository(client *supabase.Client) Repository {
+	return &supabaseRepository{client: client}
+}
+
+func (r *supabaseRepository) InsertOne(conn *schema.Connection) (*ResponseConnection, error) {
+	data, _, err := r.client.From("connections").Insert(conn, false, "", "representation", "exact").Single().Execute()
 	if err != nil {
 		return nil, err
 	}
@@ -35,56 +112,25 @@ func InsertOneConnection(client *supabase.Client, conn *schema.Connection) (*Res
 		return nil, err
 	}
 
-	return &ResponseConnection{
-		ID:             newConn.ID,
-		Host:           newConn.Host,
-		Port:           newConn.Port,
-		Username:       newConn.Username,
-		DBType:         newConn.DBType,
-		ConnectionName: newConn.ConnectionName,
-		SSLMode:        newConn.SSLMode,
-		DBName:         newConn.DBName,
-		IsActive:       newConn.IsActive,
-		DBFilePath:     newConn.DBFilePath,
-	}, nil
-}
-
-// CheckConnectionExistsHandler checks if a connection with the same name, db_type, host, port, and user_id exists for the user.
-func CheckConnectionExists(client *supabase.Client, req *schema.ManualConnectionForm, userId string) (bool, error) {
-	query := client.From("connections").Select("*", "exact", false).Eq("user_id", userId).
-		Eq("connection_name", req.ConnName).
-		Eq("db_type", req.DBType)
-
-	if req.DBType == "sqlite" {
-		query = query.Eq("db_filepath", req.DBFilePath)
-	} else {
-		query = query.Eq("host", req.Host).
-			Eq("port", req.Port).
-			Eq("username", req.Username)
-	}
-
-	data, count, err := query.Single().Execute()
+	created := toResponseConnection(&newConn)
+	created.DBFilePath = newConn.DBFilePath
+	return created, nil
+}
 
+func (r *supabaseRepository) Exists(req *schema.ManualConnectionForm, userId string) (bool, error) {
+	var conns []schema.Connection
+	_, err := r.client.From("connections").Select("id", "", false).
+		Eq("user_id", userId).
+		Eq("connection_name", req.ConnName).
+		ExecuteTo(&conns)
 	if err != nil {
-		if count == 0 {
-			return false, nil
-		}
-		return false, err
-	}
-	var conn schema.Connection
-	err = json.Unmarshal(data, &conn)
-	if err != nil {
-		log.Printf("Error unmarshalling connection data: %v", err)
 		return false, err
 	}
-	log.Printf("Connection data: %+v", conn)
-
-	return conn.ID != "", nil
+	return len(conns) > 0, nil
 }
 
-// GetConnectionsByUserID retrieves all connections for a given user ID and returns them as a slice of ResponseConnection.
-func GetConnectionsByUserID(client *supabase.Client, userID string) ([]ResponseConnection, error) {
-	data, _, err := client.From("connections").Select("*", "", false).Eq("user_id", userID).Execute()
+func (r *supabaseRepository) ListByUserID(userID string) ([]ResponseConnection, error) {
+	data, _, err := r.client.From("connections").Select("*", "", false).Eq("user_id", userID).Execute()
 	if err != nil {
 		return nil, err
 	}
@@ -93,31 +139,11 @@ func GetConnectionsByUserID(client *supabase.Client, userID string) ([]ResponseC
 	if err := json.Unmarshal(data, &connections); err != nil {
 		return nil, err
 	}
-
-	var response []ResponseConnection
-	for _, conn := range connections {
-		if conn.DBType == "sqlite" {
-			conn.DBName = sql.ExtractSQLiteDBName(conn.DBFilePath)
-		}
-		response = append(response, ResponseConnection{
-			ID:             conn.ID,
-			Host:           conn.Host,
-			Port:           conn.Port,
-			Username:       conn.Username,
-			DBType:         conn.DBType,
-			ConnectionName: conn.ConnectionName,
-			SSLMode:        conn.SSLMode,
-			DBName:         conn.DBName,
-			IsActive:       conn.IsActive,
-		})
-	}
-
-	return response, nil
+	return listResponse(connections), nil
 }
 
-// DeleteConnection deletes a connection by its ID and user ID.
-func DeleteConnection(client *supabase.Client, id, userID string) error {
-	_, _, err := client.From("connections").Delete("minimal", "").Eq("id", id).Eq("user_id", userID).Single().Execute()
+func (r *supabaseRepository) Delete(id, userID string) error {
+	_, _, err := r.client.From("connections").Delete("minimal", "").Eq("id", id).Eq("user_id", userID).Single().Execute()
 	if err != nil {
 		return err
 	}
@@ -125,9 +151,8 @@ func DeleteConnection(client *supabase.Client, id, userID string) error {
 	return nil
 }
 
-// GetConnectionStringByID retrieves the connection string for a specific connection ID and user ID.
-func GetConnectionStringByID(client *supabase.Client, id, userID string) (string, error) {
-	data, count, err := client.From("connections").Select("connection_string", "exact", false).
+func (r *supabaseRepository) GetConnectionString(id, userID string) (string, error) {
+	data, count, err := r.client.From("connections").Select("connection_string", "exact", false).
 		Eq("id", id).
 		Eq("user_id", userID).
 		Single().Execute()
@@ -148,9 +173,28 @@ func GetConnectionStringByID(client *supabase.Client, id, userID string) (string
 	return result.ConnString, nil
 }
 
-// SetConnectionActive updates the is_active status of a connection for a specific user.
-func SetConnectionActive(client *supabase.Client, id, userID string, isActive bool) error {
-	_, count, err := client.From("connections").
+func (r *supabaseRepository) GetRecord(id, userID string) (*schema.Connection, error) {
+	data, count, err := r.client.From("connections").Select("*", "exact", false).
+		Eq("id", id).
+		Eq("user_id", userID).
+		Single().Execute()
+
+	if err != nil {
+		if count == 0 {
+			return nil, errors.New("connection not found")
+		}
+		return nil, err
+	}
+
+	var conn schema.Connection
+	if err := json.Unmarshal(data, &conn); err != nil {
+		return nil, err
+	}
+	return &conn, nil
+}
+
+func (r *supabaseRepository) SetActive(id, userID string, isActive bool) error {
+	_, count, err := r.client.From("connections").
 		Update(map[string]interface{}{"is_active": isActive}, "minimal", "exact").
 		Eq("id", id).Eq("user_id", userID).Single().Execute()
 
@@ -163,9 +207,8 @@ func SetConnectionActive(client *supabase.Client, id, userID string, isActive bo
 	return nil
 }
 
-// GetConnectionByID retrieves a connection by its ID and user ID, returning it as a ResponseConnection.
-func GetConnectionByID(client *supabase.Client, id, userID string) (*ResponseConnection, error) {
-	data, count, err := client.From("connections").Select("*", "exact", false).
+func (r *supabaseRepository) GetByID(id, userID string) (*ResponseConnection, error) {
+	data, count, err := r.client.From("connections").Select("*", "exact", false).
 		Eq("id", id).Eq("user_id", userID).Single().Execute()
 	if err != nil {
 		if count == 0 {
@@ -178,23 +221,11 @@ func GetConnectionByID(client *supabase.Client, id, userID string) (*ResponseCon
 	if err := json.Unmarshal(data, &conn); err != nil {
 		return nil, err
 	}
-
-	return &ResponseConnection{
-		ID:             conn.ID,
-		Host:           conn.Host,
-		Port:           conn.Port,
-		Username:       conn.Username,
-		DBType:         conn.DBType,
-		ConnectionName: conn.ConnectionName,
-		SSLMode:        conn.SSLMode,
-		DBName:         conn.DBName,
-		IsActive:       conn.IsActive,
-	}, nil
+	return toResponseConnection(&conn), nil
 }
 
-// SetAllConnectionsInactive sets all connections to inactive (global).
-func SetAllConnectionsInactive(client *supabase.Client) error {
-	_, count, err := client.From("connections").
+func (r *supabaseRepository) SetAllInactive() error {
+	_, count, err := r.client.From("connections").
 		Update(map[string]interface{}{"is_active": false}, "minimal", "exact").
 		Match(map[string]string{"is_active": "TRUE"}).
 		Execute()
@@ -207,9 +238,8 @@ func SetAllConnectionsInactive(client *supabase.Client) error {
 	return nil
 }
 
-// SetAllConnectionsInactiveForUser sets all connections for a specific user to inactive.
-func SetAllConnectionsInactiveForUser(client *supabase.Client, userID string) error {
-	_, count, err := client.From("connections").
+func (r *supabaseRepository) SetAllInactiveForUser(userID string) error {
+	_, count, err := r.client.From("connections").
 		Update(map[string]interface{}{"is_active": false}, "minimal", "exact").
 		Match(map[string]string{"user_id": userID, "is_active": "TRUE"}).
 		Execute()
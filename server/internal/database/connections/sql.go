@@ -0,0 +1,193 @@
+package connections
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/database/sqlstore"
+	"github.com/google/uuid"
+)
+
+const connectionColumns = "id, user_id, port, host, username, password, created_at, is_active, db_type, connection_name, ssl_mode, db_name, db_filepath, connection_string, query_timeout, pool_settings, ssh_tunnel, tls_settings"
+
+type sqlRepository struct {
+	db *sqlstore.DB
+}
+
+// NewSQLRepository returns a repository of the connections kept in a PostgreSQL or SQLite store.
+func NewSQLRepository(db *sqlstore.DB) Repository {
+	return &sqlRepository{db: db}
+}
+
+func (r *sqlRepository) InsertOne(conn *schema.Connection) (*ResponseConnection, error) {
+	created := *conn
+	created.ID = uuid.NewString()
+	now := time.Now().UTC()
+	created.CreatedAt = &now
+	settings, err := jsonColumn(created.PoolSettings)
+	if err != nil {
+		return nil, err
+	}
+	sshTunnel, err := jsonColumn(created.SSHTunnel)
+	if err != nil {
+		return nil, err
+	}
+	tlsSettings, err := jsonColumn(created.TLS)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.db.Exec(r.db.Rebind("insert into connections ("+connectionColumns+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		created.ID, created.UserID, created.Port, created.Host, created.Username, created.Password, now, created.IsActive,
+		created.DBType, created.ConnectionName, created.SSLMode, created.DBName, created.DBFilePath, created.ConnString, created.QueryTimeout,
+		settings, sshTunnel, tlsSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	response := toResponseConnection(&created)
+	response.DBFilePath = created.DBFilePath
+	return response, nil
+}
+
+func (r *sqlRepository) Exists(req *schema.ManualConnectionForm, userID string) (bool, error) {
+	var count int
+	err := r.db.QueryRow(r.db.Rebind("select count(*) from connections where user_id = ? and connection_name = ?"), userID, req.ConnName).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *sqlRepository) ListByUserID(userID string) ([]ResponseConnection, error) {
+	rows, err := r.db.Query(r.db.Rebind("select "+connectionColumns+" from connections where user_id = ? order by created_at"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conns []schema.Connection
+	for rows.Next() {
+		conn, err := scanConnection(rows)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, *conn)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return listResponse(conns), nil
+}
+
+func (r *sqlRepository) Delete(id, userID string) error {
+	_, err := r.db.Exec(r.db.Rebind("delete from connections where id = ? and user_id = ?"), id, userID)
+	return err
+}
+
+func (r *sqlRepository) GetConnectionString(id, userID string) (string, error) {
+	conn, err := r.get(id, userID)
+	if err != nil || conn == nil {
+		return "", err
+	}
+	return conn.ConnString, nil
+}
+
+func (r *sqlRepository) GetRecord(id, userID string) (*schema.Connection, error) {
+	conn, err := r.get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, errors.New("connection not found")
+	}
+	return conn, nil
+}
+
+func (r *sqlRepository) SetActive(id, userID string, isActive bool) error {
+	res, err := r.db.Exec(r.db.Rebind("update connections set is_active = ? where id = ? and user_id = ?"), isActive, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("connection not found")
+	}
+	return nil
+}
+
+func (r *sqlRepository) GetByID(id, userID string) (*ResponseConnection, error) {
+	conn, err := r.get(id, userID)
+	if err != nil || conn == nil {
+		return nil, err
+	}
+	return toResponseConnection(conn), nil
+}
+
+func (r *sqlRepository) SetAllInactive() error {
+	_, err := r.db.Exec(r.db.Rebind("update connections set is_active = ? where is_active = ?"), false, true)
+	return err
+}
+
+func (r *sqlRepository) SetAllInactiveForUser(userID string) error {
+	_, err := r.db.Exec(r.db.Rebind("update connections set is_active = ? where user_id = ? and is_active = ?"), false, userID, true)
+	return err
+}
+
+// get returns a connection of a user, or nil if there is none.
+func (r *sqlRepository) get(id, userID string) (*schema.Connection, error) {
+	row := r.db.QueryRow(r.db.Rebind("select "+connectionColumns+" from connections where id = ? and user_id = ?"), id, userID)
+	conn, err := scanConnection(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return conn, err
+}
+
+func scanConnection(row interface {
+	Scan(dest ...interface{}) error
+}) (*schema.Connection, error) {
+	var (
+		conn        schema.Connection
+		createdAt   time.Time
+		settings    []byte
+		sshTunnel   []byte
+		tlsSettings []byte
+	)
+	err := row.Scan(&conn.ID, &conn.UserID, &conn.Port, &conn.Host, &conn.Username, &conn.Password, &createdAt, &conn.IsActive,
+		&conn.DBType, &conn.ConnectionName, &conn.SSLMode, &conn.DBName, &conn.DBFilePath, &conn.ConnString, &conn.QueryTimeout,
+		&settings, &sshTunnel, &tlsSettings)
+	if err != nil {
+		return nil, err
+	}
+	conn.CreatedAt = &createdAt
+	if settings != nil {
+		if err := json.Unmarshal(settings, &conn.PoolSettings); err != nil {
+			return nil, err
+		}
+	}
+	if sshTunnel != nil {
+		if err := json.Unmarshal(sshTunnel, &conn.SSHTunnel); err != nil {
+			return nil, err
+		}
+	}
+	if tlsSettings != nil {
+		if err := json.Unmarshal(tlsSettings, &conn.TLS); err != nil {
+			return nil, err
+		}
+	}
+	return &conn, nil
+}
+
+// jsonColumn encodes the value of a JSON column, which is null for a nil pointer.
+func jsonColumn[T any](value *T) (sql.NullString, error) {
+	if value == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
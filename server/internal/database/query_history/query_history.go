@@ -3,8 +3,11 @@ package queryhistory
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/supabase-community/postgrest-go"
 	"github.com/supabase-community/supabase-go"
 )
 
@@ -16,11 +19,57 @@ type QueryHistory struct {
 	GeneratedQuery string `json:"generated_query" binding:"required"`
 	ExecutedAt     time.Time `json:"executed_at" binding:"required"`
 	Duration       int64  `json:"duration" binding:"required"`
+	Success        bool   `json:"success"`
+	Error          string `json:"error,omitempty"` // why the query failed
+	Fingerprint    string `json:"fingerprint,omitempty"` // hash of the statement with its literals replaced
 }
 
-// SaveQueryHistory saves a query history record to the Supabase database.
-func SaveQueryHistory(client *supabase.Client, history *QueryHistory) error {
-	data, count, err := client.From("query_history").Insert(history, false, "", "representation", "exact").Single().Execute()
+// Filter narrows a listing of query history. Empty fields match everything.
+type Filter struct {
+	Search      string // full-text search over the prompts and the SQL
+	Since       *time.Time
+	Until       *time.Time
+	MinDuration *int64 // milliseconds
+	MaxDuration *int64 // milliseconds
+	Success     *bool
+	Limit       int
+	Offset      int
+}
+
+// Page is a page of query history with the number of records matching the filter.
+type Page struct {
+	Items  []QueryHistory `json:"items"`
+	Total  int64          `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// Repository stores the query history and the statistics of the statements run on the connections.
+type Repository interface {
+	Save(history *QueryHistory) error
+	// List returns a page of the query history of a user on a connection, latest first.
+	List(connectionID, userID string, filter Filter) (*Page, error)
+	// Delete deletes the query history of a user on a connection.
+	Delete(connectionID, userID string) error
+	// AddStats adds the statistics of a single execution to those of its fingerprint for its hour,
+	// creating them if there are none. The update is atomic, so that concurrent executions, on this
+	// server or another one, are all counted.
+	AddStats(stats *Stats) error
+	// ListStats returns the statistics of a user on a connection for the hours starting in [since, until).
+	ListStats(connectionID, userID string, since, until time.Time) ([]Stats, error)
+}
+
+type supabaseRepository struct {
+	client *supabase.Client
+}
+
+// NewSupabaseRepository returns a repository of the query history kept in Supabase.
+func NewSupabaseRepository(client *supabase.Client) Repository {
+	return &supabaseRepository{client: client}
+}
+
+func (r *supabaseRepository) Save(history *QueryHistory) error {
+	data, count, err := r.client.From("query_history").Insert(history, false, "", "representation", "exact").Single().Execute()
 	if err != nil {
 		if count == 0 {
 			return errors.New("failed to save query history")
@@ -35,32 +84,84 @@ func SaveQueryHistory(client *supabase.Client, history *QueryHistory) error {
 	return nil
 }
 
-// GetQueryHistoryByConnectionID retrieves query history records for a specific connection ID.
-func GetQueryHistoryByConnectionID(client *supabase.Client, connectionID string) ([]QueryHistory, error) {
-	data, count, err := client.From("query_history").Select("*", "exact", false).Eq("conn_id", connectionID).Execute()
-	if err != nil {
-		if count == 0 {
-			return nil, errors.New("no query history found for the given connection ID")
-		}
-		return nil, err
+func (r *supabaseRepository) List(connectionID, userID string, filter Filter) (*Page, error) {
+	query := r.client.From("query_history").Select("*", "exact", false).
+		Eq("conn_id", connectionID).
+		Eq("user_id", userID)
+	if filter.Search != "" {
+		// search is a generated tsvector over the prompt and the SQL.
+		query = query.TextSearch("search", filter.Search, "simple", "websearch")
+	}
+	if filter.Success != nil {
+		query = query.Eq("success", strconv.FormatBool(*filter.Success))
+	}
+
+	// Filters are kept per column, so the bounds of the ranges go into a single condition.
+	var bounds []string
+	if filter.Since != nil {
+		bounds = append(bounds, "executed_at.gte."+filter.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.Until != nil {
+		bounds = append(bounds, "executed_at.lt."+filter.Until.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.MinDuration != nil {
+		bounds = append(bounds, "duration.gte."+strconv.FormatInt(*filter.MinDuration, 10))
+	}
+	if filter.MaxDuration != nil {
+		bounds = append(bounds, "duration.lte."+strconv.FormatInt(*filter.MaxDuration, 10))
+	}
+	if len(bounds) > 0 {
+		query = query.And(strings.Join(bounds, ","), "")
 	}
 
-	var histories []QueryHistory
-	if err := json.Unmarshal(data, &histories); err != nil {
+	page := Page{Items: []QueryHistory{}, Limit: filter.Limit, Offset: filter.Offset}
+	total, err := query.
+		Order("executed_at", &postgrest.OrderOpts{Ascending: false}).
+		Range(filter.Offset, filter.Offset+filter.Limit-1, "").
+		ExecuteTo(&page.Items)
+	if err != nil {
 		return nil, err
 	}
+	page.Total = total
+	return &page, nil
+}
 
-	return histories, nil
+func (r *supabaseRepository) Delete(connectionID, userID string) error {
+	_, _, err := r.client.From("query_history").Delete("minimal", "").
+		Eq("conn_id", connectionID).
+		Eq("user_id", userID).
+		Execute()
+	return err
 }
 
-func DeleteQueryHistoryByConnectionID(client *supabase.Client, connectionID string) error {
-	_, count, err := client.From("query_history").Delete("minimal", "exact").Eq("conn_id", connectionID).Execute()
-	if err != nil {
-		if count == 0 {
-			return errors.New("no query history found for the given connection ID")
+func (r *supabaseRepository) AddStats(stats *Stats) error {
+	// The function is posted to through the query builder, since Rpc drops transport and HTTP errors.
+	_, _, err := r.client.From("rpc/add_query_stats").Insert(addStatsParams(stats), false, "", "minimal", "").Execute()
+	return err
+}
+
+func (r *supabaseRepository) ListStats(connectionID, userID string, since, until time.Time) ([]Stats, error) {
+	const pageSize = 1000
+	var buckets []Stats
+	for offset := 0; ; offset += pageSize {
+		var page []Stats
+		_, err := r.client.From("query_stats").Select("*", "", false).
+			Eq("conn_id", connectionID).
+			Eq("user_id", userID).
+			And(strings.Join([]string{
+				"bucket.gte." + since.UTC().Format(time.RFC3339Nano),
+				"bucket.lt." + until.UTC().Format(time.RFC3339Nano),
+			}, ","), "").
+			Order("id", &postgrest.OrderOpts{Ascending: true}).
+			Range(offset, offset+pageSize-1, "").
+			ExecuteTo(&page)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, page...)
+		if len(page) < pageSize {
+			break
 		}
-		return err
 	}
-
-	return nil
-}
\ No newline at end of file
+	return buckets, nil
+}
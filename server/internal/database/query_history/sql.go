@@ -0,0 +1,195 @@
+package queryhistory
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/sqlstore"
+	"github.com/google/uuid"
+)
+
+const (
+	historyColumns = "id, user_id, conn_id, query, generated_query, executed_at, duration, success, error, fingerprint"
+	statsColumns   = "id, user_id, conn_id, fingerprint, statement, tables, bucket, count, error_count, total_duration, max_duration, histogram, last_error, last_error_at, last_seen_at"
+)
+
+type sqlRepository struct {
+	db *sqlstore.DB
+}
+
+// NewSQLRepository returns a repository of the query history kept in a PostgreSQL or SQLite store.
+func NewSQLRepository(db *sqlstore.DB) Repository {
+	return &sqlRepository{db: db}
+}
+
+func (r *sqlRepository) Save(history *QueryHistory) error {
+	_, err := r.db.Exec(r.db.Rebind("insert into query_history ("+historyColumns+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		uuid.NewString(), history.UserID, history.ConnectionID, history.Query, history.GeneratedQuery,
+		history.ExecutedAt.UTC(), history.Duration, history.Success, history.Error, history.Fingerprint)
+	return err
+}
+
+func (r *sqlRepository) List(connectionID, userID string, filter Filter) (*Page, error) {
+	where := []string{"conn_id = ?", "user_id = ?"}
+	args := []interface{}{connectionID, userID}
+	if filter.Search != "" {
+		if r.db.Dialect == sqlstore.DialectPostgres {
+			where = append(where, "search @@ websearch_to_tsquery('simple', ?)")
+			args = append(args, filter.Search)
+		} else {
+			// SQLite has no text search configured, so every word has to appear in the prompt or the SQL.
+			for _, word := range strings.Fields(filter.Search) {
+				where = append(where, `(query || ' ' || generated_query) like ? escape '\'`)
+				args = append(args, "%"+escapeLike(word)+"%")
+			}
+		}
+	}
+	if filter.Success != nil {
+		where = append(where, "success = ?")
+		args = append(args, *filter.Success)
+	}
+	if filter.Since != nil {
+		where = append(where, "executed_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		where = append(where, "executed_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.MinDuration != nil {
+		where = append(where, "duration >= ?")
+		args = append(args, *filter.MinDuration)
+	}
+	if filter.MaxDuration != nil {
+		where = append(where, "duration <= ?")
+		args = append(args, *filter.MaxDuration)
+	}
+	condition := strings.Join(where, " and ")
+
+	page := Page{Items: []QueryHistory{}, Limit: filter.Limit, Offset: filter.Offset}
+	if err := r.db.QueryRow(r.db.Rebind("select count(*) from query_history where "+condition), args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(r.db.Rebind("select "+historyColumns+" from query_history where "+condition+" order by executed_at desc limit ? offset ?"),
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var history QueryHistory
+		err := rows.Scan(&history.ID, &history.UserID, &history.ConnectionID, &history.Query, &history.GeneratedQuery,
+			&history.ExecutedAt, &history.Duration, &history.Success, &history.Error, &history.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, history)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (r *sqlRepository) Delete(connectionID, userID string) error {
+	_, err := r.db.Exec(r.db.Rebind("delete from query_history where conn_id = ? and user_id = ?"), connectionID, userID)
+	return err
+}
+
+func (r *sqlRepository) AddStats(stats *Stats) error {
+	tables, err := json.Marshal(stats.Tables)
+	if err != nil {
+		return err
+	}
+	histogram, err := json.Marshal(stats.Histogram)
+	if err != nil {
+		return err
+	}
+
+	if r.db.Dialect == sqlstore.DialectPostgres {
+		_, err = r.db.Exec(r.db.Rebind("select add_query_stats(?::uuid, ?::uuid, ?::uuid, ?, ?, ?::jsonb, ?, ?, ?, ?::jsonb, ?, ?, ?, ?)"),
+			stats.ID, stats.UserID, stats.ConnectionID, stats.Fingerprint, stats.Statement, string(tables), stats.Bucket.UTC(),
+			stats.ErrorCount, stats.TotalDuration, string(histogram), stats.histogramIndex(), stats.LastError, nullTime(stats.LastErrorAt), stats.LastSeenAt.UTC())
+		return err
+	}
+
+	index := "$[" + strconv.Itoa(stats.histogramIndex()) + "]"
+	// In SQLite, max of a null is null, hence the coalesce for the time of the last error.
+	_, err = r.db.Exec("insert into query_stats ("+statsColumns+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+		`on conflict (conn_id, user_id, fingerprint, bucket) do update set
+			count = query_stats.count + excluded.count,
+			error_count = query_stats.error_count + excluded.error_count,
+			total_duration = query_stats.total_duration + excluded.total_duration,
+			max_duration = max(query_stats.max_duration, excluded.max_duration),
+			histogram = json_set(query_stats.histogram, ?, coalesce(json_extract(query_stats.histogram, ?), 0) + 1),
+			last_error = case when excluded.last_error_at >= query_stats.last_error_at or query_stats.last_error_at is null and excluded.last_error_at is not null
+				then excluded.last_error else query_stats.last_error end,
+			last_error_at = coalesce(max(query_stats.last_error_at, excluded.last_error_at), query_stats.last_error_at, excluded.last_error_at),
+			last_seen_at = max(query_stats.last_seen_at, excluded.last_seen_at)`,
+		stats.ID, stats.UserID, stats.ConnectionID, stats.Fingerprint, stats.Statement, string(tables), stats.Bucket.UTC(),
+		stats.Count, stats.ErrorCount, stats.TotalDuration, stats.MaxDuration,
+		string(histogram), stats.LastError, nullTime(stats.LastErrorAt), stats.LastSeenAt.UTC(),
+		index, index)
+	return err
+}
+
+// nullTime converts an optional time to a UTC column value.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (r *sqlRepository) ListStats(connectionID, userID string, since, until time.Time) ([]Stats, error) {
+	rows, err := r.db.Query(r.db.Rebind("select "+statsColumns+" from query_stats where conn_id = ? and user_id = ? and bucket >= ? and bucket < ?"),
+		connectionID, userID, since.UTC(), until.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []Stats
+	for rows.Next() {
+		stats, err := scanStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, *stats)
+	}
+	return buckets, rows.Err()
+}
+
+func scanStats(row interface {
+	Scan(dest ...interface{}) error
+}) (*Stats, error) {
+	var (
+		stats             Stats
+		tables, histogram []byte
+		lastErrorAt       sql.NullTime
+	)
+	err := row.Scan(&stats.ID, &stats.UserID, &stats.ConnectionID, &stats.Fingerprint, &stats.Statement, &tables, &stats.Bucket,
+		&stats.Count, &stats.ErrorCount, &stats.TotalDuration, &stats.MaxDuration, &histogram, &stats.LastError, &lastErrorAt, &stats.LastSeenAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tables, &stats.Tables); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(histogram, &stats.Histogram); err != nil {
+		return nil, err
+	}
+	if lastErrorAt.Valid {
+		stats.LastErrorAt = &lastErrorAt.Time
+	}
+	return &stats, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,235 @@
+package queryhistory
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/sqltext"
+	"github.com/google/uuid"
+)
+
+// durationBounds are the upper bounds in milliseconds of the buckets of the duration histogram.
+// The last bucket holds everything slower.
+var durationBounds = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000}
+
+// Stats aggregates the executions of a statement fingerprint on a connection within an hour.
+type Stats struct {
+	ID            string     `json:"id,omitempty"`
+	UserID        string     `json:"user_id"`
+	ConnectionID  string     `json:"conn_id"`
+	Fingerprint   string     `json:"fingerprint"` // hash of the statement
+	Statement     string     `json:"statement"`   // the statement with its literals replaced by ?
+	Tables        []string   `json:"tables"`
+	Bucket        time.Time  `json:"bucket"` // start of the hour
+	Count         int64      `json:"count"`
+	ErrorCount    int64      `json:"error_count"`
+	TotalDuration int64      `json:"total_duration"`
+	MaxDuration   int64      `json:"max_duration"`
+	Histogram     []int64    `json:"histogram"` // executions per bucket of durationBounds
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastSeenAt    time.Time  `json:"last_seen_at"`
+}
+
+// FingerprintSummary aggregates the executions of a statement fingerprint over a time window.
+// Durations are in milliseconds; the percentiles are estimated from the histogram.
+type FingerprintSummary struct {
+	Fingerprint string     `json:"fingerprint"`
+	Statement   string     `json:"statement"`
+	Tables      []string   `json:"tables"`
+	Count       int64      `json:"count"`
+	ErrorCount  int64      `json:"error_count"`
+	AvgDuration int64      `json:"avg_duration"`
+	P50Duration int64      `json:"p50_duration"`
+	P95Duration int64      `json:"p95_duration"`
+	MaxDuration int64      `json:"max_duration"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+}
+
+// Analytics lists the statements of a connection that took the most time and that ran the most
+// often within a time window.
+type Analytics struct {
+	Since        time.Time            `json:"since"`
+	Until        time.Time            `json:"until"`
+	Slowest      []FingerprintSummary `json:"slowest"`
+	MostFrequent []FingerprintSummary `json:"most_frequent"`
+}
+
+// Record saves a history record with the fingerprint of its statement and adds the execution
+// to the statistics of the fingerprint.
+func Record(repo Repository, history *QueryHistory, dbType string) error {
+	statement, tables := sqltext.Fingerprint(history.GeneratedQuery, dbType)
+	history.Fingerprint = sqltext.Hash(statement)
+	if err := repo.Save(history); err != nil {
+		return err
+	}
+	return addStats(repo, history, statement, tables)
+}
+
+// RecordCacheHit saves the history record of a result served from the query cache. It is left out
+// of the statistics, which show the load on the database.
+func RecordCacheHit(repo Repository, history *QueryHistory, dbType string) error {
+	statement, _ := sqltext.Fingerprint(history.GeneratedQuery, dbType)
+	history.Fingerprint = sqltext.Hash(statement)
+	return repo.Save(history)
+}
+
+func addStats(repo Repository, history *QueryHistory, statement string, tables []string) error {
+	stats := &Stats{
+		ID:           uuid.NewString(),
+		UserID:       history.UserID,
+		ConnectionID: history.ConnectionID,
+		Fingerprint:  history.Fingerprint,
+		Statement:    statement,
+		Tables:       tables,
+		Bucket:       history.ExecutedAt.UTC().Truncate(time.Hour),
+		Histogram:    make([]int64, len(durationBounds)+1),
+	}
+	stats.add(history)
+	return repo.AddStats(stats)
+}
+
+// histogramIndex returns the histogram bucket of the statistics of a single execution.
+func (s *Stats) histogramIndex() int {
+	return max(slices.IndexFunc(s.Histogram, func(n int64) bool { return n > 0 }), 0)
+}
+
+// addStatsParams are the arguments of the add_query_stats function for the statistics of a single execution.
+func addStatsParams(stats *Stats) map[string]interface{} {
+	return map[string]interface{}{
+		"p_id":              stats.ID,
+		"p_user_id":         stats.UserID,
+		"p_conn_id":         stats.ConnectionID,
+		"p_fingerprint":     stats.Fingerprint,
+		"p_statement":       stats.Statement,
+		"p_tables":          stats.Tables,
+		"p_bucket":          stats.Bucket.UTC(),
+		"p_error_count":     stats.ErrorCount,
+		"p_duration":        stats.TotalDuration,
+		"p_histogram":       stats.Histogram,
+		"p_histogram_index": stats.histogramIndex(),
+		"p_last_error":      stats.LastError,
+		"p_last_error_at":   stats.LastErrorAt,
+		"p_last_seen_at":    stats.LastSeenAt.UTC(),
+	}
+}
+
+// add counts an execution in the statistics.
+func (s *Stats) add(history *QueryHistory) {
+	if len(s.Histogram) != len(durationBounds)+1 {
+		s.Histogram = make([]int64, len(durationBounds)+1)
+	}
+	bucket, _ := slices.BinarySearch(durationBounds, history.Duration)
+	s.Histogram[bucket]++
+	s.Count++
+	s.TotalDuration += history.Duration
+	s.MaxDuration = max(s.MaxDuration, history.Duration)
+	if !history.Success {
+		s.ErrorCount++
+		if s.LastErrorAt == nil || !history.ExecutedAt.Before(*s.LastErrorAt) {
+			executedAt := history.ExecutedAt
+			s.LastError = history.Error
+			s.LastErrorAt = &executedAt
+		}
+	}
+	if history.ExecutedAt.After(s.LastSeenAt) {
+		s.LastSeenAt = history.ExecutedAt
+	}
+}
+
+// GetAnalytics summarizes the statistics of a user on a connection over the hours overlapping
+// [since, until) and returns up to limit of the slowest statements, by their 95th percentile,
+// and of the most frequent ones.
+func GetAnalytics(repo Repository, connectionID, userID string, since, until time.Time, limit int) (*Analytics, error) {
+	buckets, err := repo.ListStats(connectionID, userID, since.UTC().Truncate(time.Hour), until)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := summarize(buckets)
+	analytics := &Analytics{Since: since, Until: until}
+	slices.SortFunc(summaries, func(a, b FingerprintSummary) int {
+		return cmp.Or(cmp.Compare(b.P95Duration, a.P95Duration), cmp.Compare(b.AvgDuration, a.AvgDuration), cmp.Compare(a.Fingerprint, b.Fingerprint))
+	})
+	analytics.Slowest = slices.Clone(summaries[:min(limit, len(summaries))])
+	slices.SortFunc(summaries, func(a, b FingerprintSummary) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Fingerprint, b.Fingerprint))
+	})
+	analytics.MostFrequent = slices.Clone(summaries[:min(limit, len(summaries))])
+	return analytics, nil
+}
+
+// summarize merges the hourly statistics of each fingerprint.
+func summarize(buckets []Stats) []FingerprintSummary {
+	merged := make(map[string]*Stats)
+	for i := range buckets {
+		bucket := &buckets[i]
+		total, ok := merged[bucket.Fingerprint]
+		if !ok {
+			total = &Stats{Fingerprint: bucket.Fingerprint, Statement: bucket.Statement, Tables: bucket.Tables, Histogram: make([]int64, len(durationBounds)+1)}
+			merged[bucket.Fingerprint] = total
+		}
+		total.Count += bucket.Count
+		total.ErrorCount += bucket.ErrorCount
+		total.TotalDuration += bucket.TotalDuration
+		total.MaxDuration = max(total.MaxDuration, bucket.MaxDuration)
+		for j, n := range bucket.Histogram {
+			if j < len(total.Histogram) {
+				total.Histogram[j] += n
+			}
+		}
+		if bucket.LastErrorAt != nil && (total.LastErrorAt == nil || bucket.LastErrorAt.After(*total.LastErrorAt)) {
+			total.LastError, total.LastErrorAt = bucket.LastError, bucket.LastErrorAt
+		}
+		if bucket.LastSeenAt.After(total.LastSeenAt) {
+			total.LastSeenAt = bucket.LastSeenAt
+		}
+	}
+
+	summaries := make([]FingerprintSummary, 0, len(merged))
+	for _, total := range merged {
+		summary := FingerprintSummary{
+			Fingerprint: total.Fingerprint,
+			Statement:   total.Statement,
+			Tables:      total.Tables,
+			Count:       total.Count,
+			ErrorCount:  total.ErrorCount,
+			P50Duration: percentile(total, 0.5),
+			P95Duration: percentile(total, 0.95),
+			MaxDuration: total.MaxDuration,
+			LastError:   total.LastError,
+			LastErrorAt: total.LastErrorAt,
+			LastSeenAt:  total.LastSeenAt,
+		}
+		if total.Count > 0 {
+			summary.AvgDuration = total.TotalDuration / total.Count
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// percentile estimates a percentile of the durations as the upper bound of the histogram bucket
+// it falls in, capped by the slowest execution.
+func percentile(stats *Stats, q float64) int64 {
+	var count int64
+	for _, n := range stats.Histogram {
+		count += n
+	}
+	if count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(count)))
+	var seen int64
+	for i, n := range stats.Histogram {
+		seen += n
+		if seen >= rank && i < len(durationBounds) {
+			return min(durationBounds[i], stats.MaxDuration)
+		}
+	}
+	return stats.MaxDuration
+}
@@ -0,0 +1,120 @@
+package savedqueries
+
+import (
+	"errors"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+var ErrFolderNotFound = errors.New("folder not found")
+
+// Folder groups saved queries of a user. Folders nest through their parent.
+type Folder struct {
+	ID        string     `json:"id,omitempty"`
+	UserID    string     `json:"user_id"`
+	ParentID  *string    `json:"parent_id"`
+	Name      string     `json:"name"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// Share gives another user read access to a saved query, to view and run it.
+type Share struct {
+	QueryID   string     `json:"query_id"`
+	UserID    string     `json:"user_id"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// InsertFolder creates a folder.
+func InsertFolder(client *supabase.Client, folder *Folder) (*Folder, error) {
+	var created Folder
+	if _, err := client.From("saved_query_folders").Insert(folder, false, "", "representation", "").Single().ExecuteTo(&created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// ListFolders retrieves the folders of a user by name.
+func ListFolders(client *supabase.Client, userID string) ([]Folder, error) {
+	folders := []Folder{}
+	_, err := client.From("saved_query_folders").Select("*", "", false).
+		Eq("user_id", userID).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&folders)
+	if err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// GetFolder retrieves a folder of a user.
+func GetFolder(client *supabase.Client, id, userID string) (*Folder, error) {
+	var folders []Folder
+	if _, err := client.From("saved_query_folders").Select("*", "", false).Eq("id", id).Eq("user_id", userID).ExecuteTo(&folders); err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, ErrFolderNotFound
+	}
+	return &folders[0], nil
+}
+
+// UpdateFolder renames a folder of a user and moves it under another parent.
+func UpdateFolder(client *supabase.Client, folder *Folder) error {
+	update := map[string]interface{}{"name": folder.Name, "parent_id": folder.ParentID}
+	_, _, err := client.From("saved_query_folders").Update(update, "minimal", "").Eq("id", folder.ID).Eq("user_id", folder.UserID).Execute()
+	return err
+}
+
+// DeleteFolder deletes a folder of a user. Its queries and subfolders move up to its parent.
+func DeleteFolder(client *supabase.Client, folder *Folder) error {
+	parent := map[string]interface{}{"folder_id": folder.ParentID}
+	if _, _, err := client.From("saved_queries").Update(parent, "minimal", "").Eq("folder_id", folder.ID).Execute(); err != nil {
+		return err
+	}
+	parent = map[string]interface{}{"parent_id": folder.ParentID}
+	if _, _, err := client.From("saved_query_folders").Update(parent, "minimal", "").Eq("parent_id", folder.ID).Execute(); err != nil {
+		return err
+	}
+	_, _, err := client.From("saved_query_folders").Delete("minimal", "").Eq("id", folder.ID).Eq("user_id", folder.UserID).Execute()
+	return err
+}
+
+// IsSharedWith reports whether a saved query is shared with a user.
+func IsSharedWith(client *supabase.Client, queryID, userID string) (bool, error) {
+	var shares []Share
+	if _, err := client.From("saved_query_shares").Select("query_id", "", false).Eq("query_id", queryID).Eq("user_id", userID).ExecuteTo(&shares); err != nil {
+		return false, err
+	}
+	return len(shares) > 0, nil
+}
+
+// CanRead reports whether a user owns a saved query or has it shared with them.
+func CanRead(client *supabase.Client, query *SavedQuery, userID string) (bool, error) {
+	if query.UserID == userID {
+		return true, nil
+	}
+	return IsSharedWith(client, query.ID, userID)
+}
+
+// ListShares retrieves the users a saved query is shared with.
+func ListShares(client *supabase.Client, queryID string) ([]Share, error) {
+	shares := []Share{}
+	if _, err := client.From("saved_query_shares").Select("*", "", false).Eq("query_id", queryID).ExecuteTo(&shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// AddShare shares a saved query with a user. Sharing it again changes nothing.
+func AddShare(client *supabase.Client, share *Share) error {
+	_, _, err := client.From("saved_query_shares").Upsert(share, "query_id,user_id", "minimal", "").Execute()
+	return err
+}
+
+// RemoveShare stops sharing a saved query with a user.
+func RemoveShare(client *supabase.Client, queryID, userID string) error {
+	_, _, err := client.From("saved_query_shares").Delete("minimal", "").Eq("query_id", queryID).Eq("user_id", userID).Execute()
+	return err
+}
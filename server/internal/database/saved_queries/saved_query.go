@@ -0,0 +1,234 @@
+package savedqueries
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+var (
+	ErrNotFound        = errors.New("saved query not found")
+	ErrVersionConflict = errors.New("saved query was changed by someone else, reload it and try again")
+)
+
+// SavedQuery is a named query kept in the library of its owner. DBType is the engine the SQL is
+// written for; it runs on any connection of that engine. Params holds default values of the
+// {{name}} parameters of the query.
+type SavedQuery struct {
+	ID          string                 `json:"id,omitempty"`
+	UserID      string                 `json:"user_id"`
+	FolderID    *string                `json:"folder_id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Prompt      string                 `json:"prompt"`
+	Query       string                 `json:"query"`
+	DBType      string                 `json:"db_type"`
+	Params      map[string]interface{} `json:"params"`
+	Tags        []string               `json:"tags"`
+	Version     int                    `json:"version"`
+	CreatedAt   *time.Time             `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time             `json:"updated_at,omitempty"`
+}
+
+// Version is the content of a saved query as of one of its edits.
+type Version struct {
+	ID          string                 `json:"id,omitempty"`
+	QueryID     string                 `json:"query_id"`
+	Version     int                    `json:"version"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Prompt      string                 `json:"prompt"`
+	Query       string                 `json:"query"`
+	Params      map[string]interface{} `json:"params"`
+	Tags        []string               `json:"tags"`
+	CreatedBy   string                 `json:"created_by"`
+	CreatedAt   *time.Time             `json:"created_at,omitempty"`
+}
+
+// Filter narrows a listing of saved queries. Empty fields match everything.
+type Filter struct {
+	FolderID string // "root" matches queries outside any folder
+	Tag      string
+	Search   string // matched against the name and description
+}
+
+// versionOf returns the version record of the current content of a saved query.
+func versionOf(query *SavedQuery) *Version {
+	return &Version{
+		QueryID:     query.ID,
+		Version:     query.Version,
+		Name:        query.Name,
+		Description: query.Description,
+		Prompt:      query.Prompt,
+		Query:       query.Query,
+		Params:      query.Params,
+		Tags:        query.Tags,
+		CreatedBy:   query.UserID,
+	}
+}
+
+// InsertSavedQuery creates a saved query as its first version.
+func InsertSavedQuery(client *supabase.Client, query *SavedQuery) (*SavedQuery, error) {
+	query.Version = 1
+	data, _, err := client.From("saved_queries").Insert(query, false, "", "representation", "exact").Single().Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var saved SavedQuery
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	if err := insertVersion(client, versionOf(&saved)); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// GetSavedQuery retrieves a saved query by its ID.
+func GetSavedQuery(client *supabase.Client, id string) (*SavedQuery, error) {
+	var queries []SavedQuery
+	if _, err := client.From("saved_queries").Select("*", "", false).Eq("id", id).ExecuteTo(&queries); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, ErrNotFound
+	}
+	return &queries[0], nil
+}
+
+// ListSavedQueries retrieves the saved queries owned by a user, by name.
+func ListSavedQueries(client *supabase.Client, userID string, filter Filter) ([]SavedQuery, error) {
+	return listSavedQueries(filterQuery(client.From("saved_queries").Select("*", "", false).Eq("user_id", userID), filter))
+}
+
+// ListSharedQueries retrieves the saved queries other users shared with a user, by name.
+func ListSharedQueries(client *supabase.Client, userID string, filter Filter) ([]SavedQuery, error) {
+	var shares []Share
+	if _, err := client.From("saved_query_shares").Select("query_id", "", false).Eq("user_id", userID).ExecuteTo(&shares); err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return []SavedQuery{}, nil
+	}
+	ids := make([]string, len(shares))
+	for i, share := range shares {
+		ids[i] = share.QueryID
+	}
+	// Folders belong to the owner, so they do not apply to shared queries.
+	filter.FolderID = ""
+	return listSavedQueries(filterQuery(client.From("saved_queries").Select("*", "", false).In("id", ids), filter))
+}
+
+func filterQuery(query *postgrest.FilterBuilder, filter Filter) *postgrest.FilterBuilder {
+	switch filter.FolderID {
+	case "":
+	case "root":
+		query = query.Is("folder_id", "null")
+	default:
+		query = query.Eq("folder_id", filter.FolderID)
+	}
+	if filter.Tag != "" {
+		query = query.Contains("tags", []string{filter.Tag})
+	}
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		// Characters with a meaning in PostgREST filters are dropped from the pattern.
+		pattern := "*" + strings.NewReplacer(",", "", "(", "", ")", "", "*", "", "\"", "").Replace(search) + "*"
+		query = query.Or("name.ilike."+pattern+",description.ilike."+pattern, "")
+	}
+	return query.Order("name", &postgrest.OrderOpts{Ascending: true})
+}
+
+func listSavedQueries(query *postgrest.FilterBuilder) ([]SavedQuery, error) {
+	queries := []SavedQuery{}
+	if _, err := query.ExecuteTo(&queries); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// UpdateSavedQuery stores the edited content of a saved query as its next version. The update
+// only applies when the stored version is still the one the edit started from.
+func UpdateSavedQuery(client *supabase.Client, query *SavedQuery) (*SavedQuery, error) {
+	previous := query.Version
+	now := time.Now().UTC()
+	update := map[string]interface{}{
+		"folder_id":   query.FolderID,
+		"name":        query.Name,
+		"description": query.Description,
+		"prompt":      query.Prompt,
+		"query":       query.Query,
+		"params":      query.Params,
+		"tags":        query.Tags,
+		"version":     previous + 1,
+		"updated_at":  now,
+	}
+	var updated []SavedQuery
+	_, err := client.From("saved_queries").Update(update, "representation", "").
+		Eq("id", query.ID).
+		Eq("version", strconv.Itoa(previous)).
+		ExecuteTo(&updated)
+	if err != nil {
+		return nil, err
+	}
+	if len(updated) == 0 {
+		return nil, ErrVersionConflict
+	}
+	version := versionOf(&updated[0])
+	version.CreatedBy = query.UserID
+	if err := insertVersion(client, version); err != nil {
+		return nil, err
+	}
+	return &updated[0], nil
+}
+
+// DeleteSavedQuery deletes a saved query of a user with its versions and shares.
+func DeleteSavedQuery(client *supabase.Client, id, userID string) error {
+	if _, _, err := client.From("saved_query_shares").Delete("minimal", "").Eq("query_id", id).Execute(); err != nil {
+		return err
+	}
+	if _, _, err := client.From("saved_query_versions").Delete("minimal", "").Eq("query_id", id).Execute(); err != nil {
+		return err
+	}
+	_, _, err := client.From("saved_queries").Delete("minimal", "").Eq("id", id).Eq("user_id", userID).Execute()
+	return err
+}
+
+func insertVersion(client *supabase.Client, version *Version) error {
+	_, _, err := client.From("saved_query_versions").Insert(version, false, "", "minimal", "").Execute()
+	return err
+}
+
+// ListVersions retrieves the versions of a saved query, latest first.
+func ListVersions(client *supabase.Client, queryID string) ([]Version, error) {
+	versions := []Version{}
+	_, err := client.From("saved_query_versions").Select("*", "", false).
+		Eq("query_id", queryID).
+		Order("version", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&versions)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetVersion retrieves a version of a saved query.
+func GetVersion(client *supabase.Client, queryID string, version int) (*Version, error) {
+	var versions []Version
+	_, err := client.From("saved_query_versions").Select("*", "", false).
+		Eq("query_id", queryID).
+		Eq("version", strconv.Itoa(version)).
+		ExecuteTo(&versions)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return &versions[0], nil
+}
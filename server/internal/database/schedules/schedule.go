@@ -0,0 +1,268 @@
+package schedules
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/supabase-go"
+)
+
+var (
+	ErrNotFound    = errors.New("schedule not found")
+	ErrRunNotFound = errors.New("schedule run not found")
+)
+
+type CatchUp string
+
+const (
+	CatchUpSkip CatchUp = "skip" // missed runs are recorded as skipped
+	CatchUpOnce CatchUp = "once" // missed runs are replaced by a single run
+	CatchUpAll  CatchUp = "all"  // every missed run is made up, oldest first
+)
+
+type SinkType string
+
+const (
+	SinkNone    SinkType = "none"
+	SinkWebhook SinkType = "webhook"
+	SinkFile    SinkType = "file"
+	SinkEmail   SinkType = "email"
+)
+
+// Sink is where the output of each run is delivered.
+type Sink struct {
+	Type    SinkType          `json:"type"`
+	URL     string            `json:"url,omitempty"`     // webhook
+	Headers map[string]string `json:"headers,omitempty"` // webhook
+	Path    string            `json:"path,omitempty"`    // file, a directory below the drop directory
+	Format  string            `json:"format,omitempty"`  // file and email attachment, csv by default
+	To      []string          `json:"to,omitempty"`      // email
+	Subject string            `json:"subject,omitempty"` // email
+}
+
+// Schedule runs a saved query on a connection of its owner whenever its cron expression
+// matches in its time zone.
+type Schedule struct {
+	ID        string                 `json:"id,omitempty"`
+	UserID    string                 `json:"user_id"`
+	QueryID   string                 `json:"query_id"`
+	ConnID    string                 `json:"conn_id"`
+	DBName    string                 `json:"db_name"`
+	Name      string                 `json:"name"`
+	Cron      string                 `json:"cron"`
+	Timezone  string                 `json:"timezone"`
+	Params    map[string]interface{} `json:"params"`
+	Sink      Sink                   `json:"sink"`
+	CatchUp   CatchUp                `json:"catch_up"`
+	Enabled   bool                   `json:"enabled"`
+	NextRunAt *time.Time             `json:"next_run_at"`
+	LastRunAt *time.Time             `json:"last_run_at"`
+	CreatedAt *time.Time             `json:"created_at,omitempty"`
+	UpdatedAt *time.Time             `json:"updated_at,omitempty"`
+}
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunSkipped   RunStatus = "skipped"
+)
+
+type Trigger string
+
+const (
+	TriggerSchedule Trigger = "schedule"
+	TriggerCatchUp  Trigger = "catch_up"
+	TriggerManual   Trigger = "manual"
+)
+
+// Run is one execution of a schedule with the first rows of its result.
+type Run struct {
+	ID            string          `json:"id,omitempty"`
+	ScheduleID    string          `json:"schedule_id"`
+	UserID        string          `json:"user_id"`
+	Trigger       Trigger         `json:"trigger"`
+	Status        RunStatus       `json:"status"`
+	Error         string          `json:"error"`
+	Query         string          `json:"query"`
+	Columns       []result.Column `json:"columns"`
+	Rows          [][]interface{} `json:"rows"`
+	RowCount      int64           `json:"row_count"`
+	Truncated     bool            `json:"truncated"`
+	Delivered     bool            `json:"delivered"`
+	DeliveryError string          `json:"delivery_error"`
+	ScheduledAt   time.Time       `json:"scheduled_at"`
+	StartedAt     *time.Time      `json:"started_at"`
+	FinishedAt    *time.Time      `json:"finished_at"`
+	Duration      int64           `json:"duration"`
+}
+
+// InsertSchedule creates a schedule.
+func InsertSchedule(client *supabase.Client, schedule *Schedule) (*Schedule, error) {
+	var created Schedule
+	if _, err := client.From("schedules").Insert(schedule, false, "", "representation", "").Single().ExecuteTo(&created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetSchedule retrieves a schedule of a user.
+func GetSchedule(client *supabase.Client, id, userID string) (*Schedule, error) {
+	var schedules []Schedule
+	if _, err := client.From("schedules").Select("*", "", false).Eq("id", id).Eq("user_id", userID).ExecuteTo(&schedules); err != nil {
+		return nil, err
+	}
+	if len(schedules) == 0 {
+		return nil, ErrNotFound
+	}
+	return &schedules[0], nil
+}
+
+// ListSchedules retrieves the schedules of a user by name.
+func ListSchedules(client *supabase.Client, userID string) ([]Schedule, error) {
+	schedules := []Schedule{}
+	_, err := client.From("schedules").Select("*", "", false).
+		Eq("user_id", userID).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&schedules)
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// ListDueSchedules retrieves the enabled schedules of every user whose next run is not after now.
+func ListDueSchedules(client *supabase.Client, now time.Time) ([]Schedule, error) {
+	schedules := []Schedule{}
+	_, err := client.From("schedules").Select("*", "", false).
+		Eq("enabled", "true").
+		Lte("next_run_at", now.UTC().Format(time.RFC3339)).
+		ExecuteTo(&schedules)
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// UpdateSchedule stores the edited settings of a schedule of a user.
+func UpdateSchedule(client *supabase.Client, schedule *Schedule) (*Schedule, error) {
+	update := map[string]interface{}{
+		"query_id":    schedule.QueryID,
+		"conn_id":     schedule.ConnID,
+		"db_name":     schedule.DBName,
+		"name":        schedule.Name,
+		"cron":        schedule.Cron,
+		"timezone":    schedule.Timezone,
+		"params":      schedule.Params,
+		"sink":        schedule.Sink,
+		"catch_up":    schedule.CatchUp,
+		"enabled":     schedule.Enabled,
+		"next_run_at": schedule.NextRunAt,
+		"updated_at":  time.Now().UTC(),
+	}
+	var updated []Schedule
+	_, err := client.From("schedules").Update(update, "representation", "").
+		Eq("id", schedule.ID).
+		Eq("user_id", schedule.UserID).
+		ExecuteTo(&updated)
+	if err != nil {
+		return nil, err
+	}
+	if len(updated) == 0 {
+		return nil, ErrNotFound
+	}
+	return &updated[0], nil
+}
+
+// SetRunTimes records when a schedule last ran and when it runs next.
+func SetRunTimes(client *supabase.Client, id string, lastRunAt, nextRunAt *time.Time) error {
+	update := map[string]interface{}{"last_run_at": lastRunAt, "next_run_at": nextRunAt}
+	_, _, err := client.From("schedules").Update(update, "minimal", "").Eq("id", id).Execute()
+	return err
+}
+
+// DeleteSchedule deletes a schedule of a user with its runs.
+func DeleteSchedule(client *supabase.Client, id, userID string) error {
+	if _, _, err := client.From("schedule_runs").Delete("minimal", "").Eq("schedule_id", id).Eq("user_id", userID).Execute(); err != nil {
+		return err
+	}
+	_, _, err := client.From("schedules").Delete("minimal", "").Eq("id", id).Eq("user_id", userID).Execute()
+	return err
+}
+
+// InsertRun records the start of a run.
+func InsertRun(client *supabase.Client, run *Run) (*Run, error) {
+	var created Run
+	if _, err := client.From("schedule_runs").Insert(run, false, "", "representation", "").Single().ExecuteTo(&created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateRun stores the outcome of a run.
+func UpdateRun(client *supabase.Client, run *Run) error {
+	update := map[string]interface{}{
+		"status":         run.Status,
+		"error":          run.Error,
+		"query":          run.Query,
+		"columns":        run.Columns,
+		"rows":           run.Rows,
+		"row_count":      run.RowCount,
+		"truncated":      run.Truncated,
+		"delivered":      run.Delivered,
+		"delivery_error": run.DeliveryError,
+		"started_at":     run.StartedAt,
+		"finished_at":    run.FinishedAt,
+		"duration":       run.Duration,
+	}
+	_, _, err := client.From("schedule_runs").Update(update, "minimal", "").Eq("id", run.ID).Execute()
+	return err
+}
+
+// FailInterruptedRuns marks the runs left running by a previous server process as failed.
+func FailInterruptedRuns(client *supabase.Client) error {
+	update := map[string]interface{}{
+		"status":      RunFailed,
+		"error":       "interrupted by a server restart",
+		"finished_at": time.Now().UTC(),
+	}
+	_, _, err := client.From("schedule_runs").Update(update, "minimal", "").Eq("status", string(RunRunning)).Execute()
+	return err
+}
+
+// ListRuns retrieves the latest runs of a schedule of a user, without their rows.
+func ListRuns(client *supabase.Client, scheduleID, userID string, limit int) ([]Run, error) {
+	runs := []Run{}
+	_, err := client.From("schedule_runs").
+		Select("id,schedule_id,user_id,trigger,status,error,query,row_count,truncated,delivered,delivery_error,scheduled_at,started_at,finished_at,duration", "", false).
+		Eq("schedule_id", scheduleID).
+		Eq("user_id", userID).
+		Order("scheduled_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		ExecuteTo(&runs)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// GetRun retrieves a run of a schedule of a user with its rows.
+func GetRun(client *supabase.Client, id, scheduleID, userID string) (*Run, error) {
+	var runs []Run
+	_, err := client.From("schedule_runs").Select("*", "", false).
+		Eq("id", id).
+		Eq("schedule_id", scheduleID).
+		Eq("user_id", userID).
+		ExecuteTo(&runs)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrRunNotFound
+	}
+	return &runs[0], nil
+}
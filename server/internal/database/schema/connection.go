@@ -3,20 +3,60 @@ package schema
 import "time"
 
 type Connection struct {
-	ID             string     `json:"id,omitempty"`
-	UserID         string     `json:"user_id" binding:"required"`
-	Port           string     `json:"port,omitempty"`
-	Host           string     `json:"host,omitempty"`
-	Username       string     `json:"username,omitempty"`
-	Password       string     `json:"password,omitempty"`
-	CreatedAt      *time.Time `json:"created_at,omitempty"`
-	IsActive       bool       `json:"is_active,omitempty"`
-	DBType         string     `json:"db_type" binding:"required"`
-	ConnectionName string     `json:"connection_name" binding:"required"`
-	SSLMode        bool       `json:"ssl_mode"`
-	DBName         string     `json:"db_name" binding:"required"`
-	DBFilePath     string     `json:"db_filepath,omitempty"`
-	ConnString     string     `json:"connection_string,omitempty"`
+	ID             string        `json:"id,omitempty"`
+	UserID         string        `json:"user_id" binding:"required"`
+	Port           string        `json:"port,omitempty"`
+	Host           string        `json:"host,omitempty"`
+	Username       string        `json:"username,omitempty"`
+	Password       string        `json:"password,omitempty"`
+	CreatedAt      *time.Time    `json:"created_at,omitempty"`
+	IsActive       bool          `json:"is_active,omitempty"`
+	DBType         string        `json:"db_type" binding:"required"`
+	ConnectionName string        `json:"connection_name" binding:"required"`
+	SSLMode        bool          `json:"ssl_mode"`
+	DBName         string        `json:"db_name" binding:"required"`
+	DBFilePath     string        `json:"db_filepath,omitempty"`
+	ConnString     string        `json:"connection_string,omitempty"`
+	QueryTimeout   int           `json:"query_timeout,omitempty"`
+	PoolSettings   *PoolSettings `json:"pool_settings,omitempty"`
+	SSHTunnel      *SSHTunnel    `json:"ssh_tunnel,omitempty"`
+	TLS            *TLSSettings  `json:"tls_settings,omitempty"`
+}
+
+// SSHTunnel is the jump host a connection is reached through. Its password, private key and
+// passphrase are stored encrypted.
+type SSHTunnel struct {
+	Host       string `json:"host"`
+	Port       string `json:"port,omitempty"` // 22 by default
+	User       string `json:"user"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"` // PEM
+	Passphrase string `json:"passphrase,omitempty"` // of the private key
+	KnownHosts string `json:"knownHosts"`           // known_hosts lines with the host key of the jump host
+}
+
+// TLSSettings configures TLS between the pool and the database, overriding the SSL mode of the
+// connection. Its certificates and key are stored encrypted.
+type TLSSettings struct {
+	Mode       string `json:"mode"`                 // disable, prefer, require, verify-ca or verify-full
+	CACert     string `json:"caCert,omitempty"`     // PEM bundle, the system roots by default
+	ClientCert string `json:"clientCert,omitempty"` // PEM
+	ClientKey  string `json:"clientKey,omitempty"`  // PEM
+	ServerName string `json:"serverName,omitempty"` // the host of the connection by default
+}
+
+// PoolSettings overrides the pool configuration of the server for one connection and sets options
+// of its driver. Zero values keep the defaults.
+type PoolSettings struct {
+	MaxOpenConns     int               `json:"maxOpenConns,omitempty"`
+	MaxIdleConns     int               `json:"maxIdleConns,omitempty"`
+	ConnMaxLifetime  int               `json:"connMaxLifetime,omitempty"`  // seconds
+	ConnMaxIdleTime  int               `json:"connMaxIdleTime,omitempty"`  // seconds
+	StatementTimeout int               `json:"statementTimeout,omitempty"` // milliseconds, PostgreSQL, MySQL and MongoDB
+	ApplicationName  string            `json:"applicationName,omitempty"`  // PostgreSQL, MySQL and MongoDB
+	SearchPath       string            `json:"searchPath,omitempty"`       // PostgreSQL
+	ReadPreference   string            `json:"readPreference,omitempty"`   // MongoDB
+	Pragmas          map[string]string `json:"pragmas,omitempty"`          // SQLite
 }
 
 type ConnectionRequest struct {
@@ -25,20 +65,30 @@ type ConnectionRequest struct {
 }
 
 type ManualConnectionForm struct {
-	IsSRV      bool   `json:"isSrv,omitempty"`
-	Port       string `json:"port,omitempty"`
-	Host       string `json:"host,omitempty"`
-	Username   string `json:"username,omitempty"`
-	Password   string `json:"password,omitempty"`
-	DBType     string `json:"dbType" binding:"required"`
-	ConnName   string `json:"connName"`
-	SSLMode    bool   `json:"sslMode,omitempty"`
-	DBName     string `json:"dbName,omitempty"`
-	DBFilePath string `json:"dbFilePath,omitempty"`
+	IsSRV        bool   `json:"isSrv,omitempty"`
+	Port         string `json:"port,omitempty"`
+	Host         string `json:"host,omitempty"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	DBType       string `json:"dbType" binding:"required"`
+	ConnName     string `json:"connName"`
+	SSLMode      bool   `json:"sslMode,omitempty"`
+	DBName       string `json:"dbName,omitempty"`
+	DBFilePath   string `json:"dbFilePath,omitempty"`
+	QueryTimeout int    `json:"queryTimeout,omitempty"` // seconds
+
+	PoolSettings *PoolSettings `json:"poolSettings,omitempty"`
+	SSHTunnel    *SSHTunnel    `json:"sshTunnel,omitempty"`
+	TLS          *TLSSettings  `json:"tlsSettings,omitempty"`
 }
 
 type StringConnectionForm struct {
-	ConnString string `json:"connString" binding:"required"`
-	DBType     string `json:"dbType" binding:"required"`
-	ConnName   string `json:"connName"`
+	ConnString   string `json:"connString" binding:"required"`
+	DBType       string `json:"dbType" binding:"required"`
+	ConnName     string `json:"connName"`
+	QueryTimeout int    `json:"queryTimeout,omitempty"` // seconds
+
+	PoolSettings *PoolSettings `json:"poolSettings,omitempty"`
+	SSHTunnel    *SSHTunnel    `json:"sshTunnel,omitempty"`
+	TLS          *TLSSettings  `json:"tlsSettings,omitempty"`
 }
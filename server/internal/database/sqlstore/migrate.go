@@ -0,0 +1,228 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationName matches the files of the migrations, such as 0001_create_users.up.sql.
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLock is the key of the PostgreSQL advisory lock held while migrating, so that servers
+// starting together do not apply the same migration twice.
+const migrationLock = 73164411
+
+// Migration is a versioned change of the schema, with the script that reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied, and when.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrations returns the embedded migrations of a dialect in the order of their versions.
+func Migrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Migrate applies the migrations that have not been applied yet.
+func (db *DB) Migrate() error {
+	return db.MigrateTo(-1)
+}
+
+// MigrateTo applies the pending migrations up to a version, or reverts the applied ones above it.
+// A negative version stands for the latest one.
+func (db *DB) MigrateTo(version int) error {
+	return db.withMigrationLock(func(conn *sql.Conn, migrations []Migration, applied map[int]time.Time) error {
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; !ok && (version < 0 || migration.Version <= version) {
+				if err := db.apply(conn, migration, true); err != nil {
+					return err
+				}
+			}
+		}
+		for _, migration := range slices.Backward(migrations) {
+			if _, ok := applied[migration.Version]; ok && version >= 0 && migration.Version > version {
+				if err := db.apply(conn, migration, false); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback reverts the last steps applied migrations.
+func (db *DB) Rollback(steps int) error {
+	return db.withMigrationLock(func(conn *sql.Conn, migrations []Migration, applied map[int]time.Time) error {
+		for _, migration := range slices.Backward(migrations) {
+			if steps <= 0 {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				if err := db.apply(conn, migration, false); err != nil {
+					return err
+				}
+				steps--
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists the migrations of the store and when each one was applied.
+func (db *DB) Status() ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := db.withMigrationLock(func(conn *sql.Conn, migrations []Migration, applied map[int]time.Time) error {
+		for _, migration := range migrations {
+			entry := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				entry.AppliedAt = &appliedAt
+			}
+			status = append(status, entry)
+		}
+		return nil
+	})
+	return status, err
+}
+
+// withMigrationLock creates the table recording the applied migrations if needed and calls fn
+// with the migrations of the dialect and the time each applied one was applied at. On PostgreSQL
+// fn runs under an advisory lock held by conn.
+func (db *DB) withMigrationLock(fn func(conn *sql.Conn, migrations []Migration, applied map[int]time.Time) error) error {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if db.Dialect == DialectPostgres {
+		if _, err := conn.ExecContext(ctx, "select pg_advisory_lock($1)", migrationLock); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "select pg_advisory_unlock($1)", migrationLock)
+	}
+
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version integer primary key,
+		name text not null,
+		applied_at timestamp not null
+	)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, "select version, applied_at from schema_migrations")
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = appliedAt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, migrations, applied)
+}
+
+// apply runs the up or down script of a migration and records it in the same transaction.
+func (db *DB) apply(conn *sql.Conn, migration Migration, up bool) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record, args := migration.Down, "delete from schema_migrations where version = ?", []interface{}{migration.Version}
+	if up {
+		script = migration.Up
+		record = "insert into schema_migrations (version, name, applied_at) values (?, ?, ?)"
+		args = append(args, migration.Name, time.Now().UTC())
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		direction := "down"
+		if up {
+			direction = "up"
+		}
+		return fmt.Errorf("migration %d_%s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+	if _, err := tx.ExecContext(ctx, db.Rebind(record), args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,12 @@
+create table if not exists users (
+	id uuid primary key default gen_random_uuid(),
+	name text not null default '',
+	password text not null default '',
+	email text not null,
+	avatar_url text not null default '',
+	created_at timestamptz not null default now(),
+	oauth_provider text not null default '',
+	oauth_id text not null default ''
+);
+
+create unique index if not exists users_email_key on users (email);
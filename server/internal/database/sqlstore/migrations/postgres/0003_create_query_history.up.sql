@@ -0,0 +1,19 @@
+create table if not exists query_history (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users (id) on delete cascade,
+	conn_id uuid not null references connections (id) on delete cascade,
+	query text not null default '',
+	generated_query text not null default '',
+	executed_at timestamptz not null default now(),
+	duration bigint not null default 0
+);
+
+-- Columns added after the table was first created elsewhere.
+alter table query_history add column if not exists success boolean not null default true;
+alter table query_history add column if not exists error text not null default '';
+alter table query_history add column if not exists fingerprint text not null default '';
+alter table query_history add column if not exists search tsvector
+	generated always as (to_tsvector('simple', coalesce(query, '') || ' ' || coalesce(generated_query, ''))) stored;
+
+create index if not exists query_history_conn_id_user_id_executed_at_idx on query_history (conn_id, user_id, executed_at desc);
+create index if not exists query_history_search_idx on query_history using gin (search);
@@ -0,0 +1,19 @@
+create table if not exists query_stats (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users (id) on delete cascade,
+	conn_id uuid not null references connections (id) on delete cascade,
+	fingerprint text not null,
+	statement text not null default '',
+	tables jsonb not null default '[]',
+	bucket timestamptz not null,
+	count bigint not null default 0,
+	error_count bigint not null default 0,
+	total_duration bigint not null default 0,
+	max_duration bigint not null default 0,
+	histogram jsonb not null default '[]',
+	last_error text not null default '',
+	last_error_at timestamptz,
+	last_seen_at timestamptz not null
+);
+
+create unique index if not exists query_stats_conn_id_user_id_fingerprint_bucket_key on query_stats (conn_id, user_id, fingerprint, bucket);
@@ -0,0 +1,4 @@
+drop table if exists saved_query_shares;
+drop table if exists saved_query_versions;
+drop table if exists saved_queries;
+drop table if exists saved_query_folders;
@@ -0,0 +1,52 @@
+create table if not exists saved_query_folders (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users (id) on delete cascade,
+	parent_id uuid references saved_query_folders (id) on delete set null,
+	name text not null,
+	created_at timestamptz not null default now()
+);
+
+create index if not exists saved_query_folders_user_id_idx on saved_query_folders (user_id);
+
+create table if not exists saved_queries (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users (id) on delete cascade,
+	folder_id uuid references saved_query_folders (id) on delete set null,
+	name text not null,
+	description text not null default '',
+	prompt text not null default '',
+	query text not null,
+	db_type text not null default '',
+	params jsonb,
+	tags text[] not null default '{}',
+	version integer not null default 1,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+create index if not exists saved_queries_user_id_idx on saved_queries (user_id);
+create index if not exists saved_queries_tags_idx on saved_queries using gin (tags);
+
+create table if not exists saved_query_versions (
+	id uuid primary key default gen_random_uuid(),
+	query_id uuid not null references saved_queries (id) on delete cascade,
+	version integer not null,
+	name text not null,
+	description text not null default '',
+	prompt text not null default '',
+	query text not null,
+	params jsonb,
+	tags text[] not null default '{}',
+	created_by uuid references users (id) on delete set null,
+	created_at timestamptz not null default now(),
+	unique (query_id, version)
+);
+
+create table if not exists saved_query_shares (
+	query_id uuid not null references saved_queries (id) on delete cascade,
+	user_id uuid not null references users (id) on delete cascade,
+	created_at timestamptz not null default now(),
+	primary key (query_id, user_id)
+);
+
+create index if not exists saved_query_shares_user_id_idx on saved_query_shares (user_id);
@@ -0,0 +1,2 @@
+drop table if exists schedule_runs;
+drop table if exists schedules;
@@ -0,0 +1,44 @@
+create table if not exists schedules (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users (id) on delete cascade,
+	query_id uuid not null references saved_queries (id) on delete cascade,
+	conn_id uuid not null references connections (id) on delete cascade,
+	db_name text not null default '',
+	name text not null,
+	cron text not null,
+	timezone text not null default 'UTC',
+	params jsonb,
+	sink jsonb not null,
+	catch_up text not null default 'skip',
+	enabled boolean not null default true,
+	next_run_at timestamptz,
+	last_run_at timestamptz,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+create index if not exists schedules_user_id_idx on schedules (user_id);
+create index if not exists schedules_next_run_at_idx on schedules (next_run_at) where enabled;
+
+create table if not exists schedule_runs (
+	id uuid primary key default gen_random_uuid(),
+	schedule_id uuid not null references schedules (id) on delete cascade,
+	user_id uuid not null references users (id) on delete cascade,
+	trigger text not null,
+	status text not null,
+	error text not null default '',
+	query text not null default '',
+	columns jsonb,
+	rows jsonb,
+	row_count bigint not null default 0,
+	truncated boolean not null default false,
+	delivered boolean not null default false,
+	delivery_error text not null default '',
+	scheduled_at timestamptz not null,
+	started_at timestamptz,
+	finished_at timestamptz,
+	duration bigint not null default 0
+);
+
+create index if not exists schedule_runs_schedule_id_scheduled_at_idx on schedule_runs (schedule_id, scheduled_at desc);
+create index if not exists schedule_runs_running_idx on schedule_runs (status) where status = 'running';
@@ -0,0 +1,2 @@
+drop table if exists alert_events;
+drop table if exists alert_rules;
@@ -0,0 +1,39 @@
+create table if not exists alert_rules (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users (id) on delete cascade,
+	conn_id uuid not null references connections (id) on delete cascade,
+	db_name text not null default '',
+	name text not null,
+	query text not null,
+	interval_minutes integer not null,
+	condition jsonb not null,
+	notifiers jsonb not null default '[]',
+	notify_resolved boolean not null default false,
+	enabled boolean not null default true,
+	state jsonb not null default '{}',
+	next_check_at timestamptz,
+	last_checked_at timestamptz,
+	last_triggered_at timestamptz,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+create index if not exists alert_rules_user_id_idx on alert_rules (user_id);
+create index if not exists alert_rules_next_check_at_idx on alert_rules (next_check_at) where enabled;
+
+create table if not exists alert_events (
+	id uuid primary key default gen_random_uuid(),
+	rule_id uuid not null references alert_rules (id) on delete cascade,
+	user_id uuid not null references users (id) on delete cascade,
+	kind text not null,
+	message text not null default '',
+	value double precision,
+	row_count bigint,
+	rows jsonb,
+	notified boolean not null default false,
+	notify_error text not null default '',
+	created_at timestamptz not null default now()
+);
+
+create index if not exists alert_events_user_id_created_at_idx on alert_events (user_id, created_at desc);
+create index if not exists alert_events_rule_id_created_at_idx on alert_events (rule_id, created_at desc);
@@ -0,0 +1 @@
+alter table connections add column if not exists pool_settings jsonb;
@@ -0,0 +1 @@
+alter table connections drop column if exists ssh_tunnel;
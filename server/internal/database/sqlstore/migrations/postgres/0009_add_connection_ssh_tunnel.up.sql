@@ -0,0 +1 @@
+alter table connections add column if not exists ssh_tunnel jsonb;
@@ -0,0 +1 @@
+alter table connections add column if not exists tls_settings jsonb;
@@ -0,0 +1 @@
+drop function if exists add_query_stats(uuid, uuid, uuid, text, text, jsonb, timestamptz, bigint, bigint, jsonb, int, text, timestamptz, timestamptz);
@@ -0,0 +1,33 @@
+-- Counts an execution in the statistics of its fingerprint and hour in one statement, so that
+-- concurrent executions are all counted. The Supabase store calls it through RPC.
+create or replace function add_query_stats(
+	p_id uuid,
+	p_user_id uuid,
+	p_conn_id uuid,
+	p_fingerprint text,
+	p_statement text,
+	p_tables jsonb,
+	p_bucket timestamptz,
+	p_error_count bigint,
+	p_duration bigint,
+	p_histogram jsonb,
+	p_histogram_index int,
+	p_last_error text,
+	p_last_error_at timestamptz,
+	p_last_seen_at timestamptz
+) returns void language sql as $$
+	insert into query_stats as s (id, user_id, conn_id, fingerprint, statement, tables, bucket, count, error_count,
+		total_duration, max_duration, histogram, last_error, last_error_at, last_seen_at)
+	values (p_id, p_user_id, p_conn_id, p_fingerprint, p_statement, p_tables, p_bucket, 1, p_error_count,
+		p_duration, p_duration, p_histogram, p_last_error, p_last_error_at, p_last_seen_at)
+	on conflict (conn_id, user_id, fingerprint, bucket) do update set
+		count = s.count + 1,
+		error_count = s.error_count + excluded.error_count,
+		total_duration = s.total_duration + excluded.total_duration,
+		max_duration = greatest(s.max_duration, excluded.max_duration),
+		histogram = jsonb_set(s.histogram, array[p_histogram_index::text], to_jsonb(coalesce((s.histogram->>p_histogram_index)::bigint, 0) + 1)),
+		last_error = case when excluded.last_error_at >= s.last_error_at or s.last_error_at is null and excluded.last_error_at is not null
+			then excluded.last_error else s.last_error end,
+		last_error_at = greatest(s.last_error_at, excluded.last_error_at),
+		last_seen_at = greatest(s.last_seen_at, excluded.last_seen_at);
+$$;
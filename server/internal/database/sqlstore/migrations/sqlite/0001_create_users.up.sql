@@ -0,0 +1,12 @@
+create table if not exists users (
+	id text primary key,
+	name text not null default '',
+	password text not null default '',
+	email text not null,
+	avatar_url text not null default '',
+	created_at timestamp not null default current_timestamp,
+	oauth_provider text not null default '',
+	oauth_id text not null default ''
+);
+
+create unique index if not exists users_email_key on users (email);
@@ -0,0 +1,20 @@
+create table if not exists connections (
+	id text primary key,
+	user_id text not null references users (id) on delete cascade,
+	port text not null default '',
+	host text not null default '',
+	username text not null default '',
+	password text not null default '',
+	created_at timestamp not null default current_timestamp,
+	is_active boolean not null default 0,
+	db_type text not null,
+	connection_name text not null,
+	ssl_mode boolean not null default 0,
+	db_name text not null default '',
+	db_filepath text not null default '',
+	connection_string text not null default '',
+	query_timeout integer not null default 0
+);
+
+create index if not exists connections_user_id_idx on connections (user_id);
+create unique index if not exists connections_user_id_connection_name_key on connections (user_id, connection_name);
@@ -0,0 +1,14 @@
+create table if not exists query_history (
+	id text primary key,
+	user_id text not null references users (id) on delete cascade,
+	conn_id text not null references connections (id) on delete cascade,
+	query text not null default '',
+	generated_query text not null default '',
+	executed_at timestamp not null,
+	duration integer not null default 0,
+	success boolean not null default 1,
+	error text not null default '',
+	fingerprint text not null default ''
+);
+
+create index if not exists query_history_conn_id_user_id_executed_at_idx on query_history (conn_id, user_id, executed_at desc);
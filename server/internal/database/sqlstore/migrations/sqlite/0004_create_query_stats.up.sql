@@ -0,0 +1,19 @@
+create table if not exists query_stats (
+	id text primary key,
+	user_id text not null references users (id) on delete cascade,
+	conn_id text not null references connections (id) on delete cascade,
+	fingerprint text not null,
+	statement text not null default '',
+	tables text not null default '[]',
+	bucket timestamp not null,
+	count integer not null default 0,
+	error_count integer not null default 0,
+	total_duration integer not null default 0,
+	max_duration integer not null default 0,
+	histogram text not null default '[]',
+	last_error text not null default '',
+	last_error_at timestamp,
+	last_seen_at timestamp not null
+);
+
+create unique index if not exists query_stats_conn_id_user_id_fingerprint_bucket_key on query_stats (conn_id, user_id, fingerprint, bucket);
@@ -0,0 +1 @@
+alter table connections add column pool_settings text;
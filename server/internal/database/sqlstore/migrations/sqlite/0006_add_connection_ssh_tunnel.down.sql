@@ -0,0 +1 @@
+alter table connections drop column ssh_tunnel;
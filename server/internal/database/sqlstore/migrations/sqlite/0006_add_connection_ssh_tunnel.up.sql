@@ -0,0 +1 @@
+alter table connections add column ssh_tunnel text;
@@ -0,0 +1 @@
+alter table connections add column tls_settings text;
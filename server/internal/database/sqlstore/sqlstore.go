@@ -0,0 +1,76 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// DB is a PostgreSQL or SQLite database that holds the data of the application.
+type DB struct {
+	*sql.DB
+	Dialect string
+}
+
+// Connect connects to a PostgreSQL database, or opens an SQLite file. The schema is left as it is;
+// see Migrate.
+func Connect(dialect, dsn string) (*DB, error) {
+	var (
+		db  *sql.DB
+		err error
+	)
+	switch dialect {
+	case DialectPostgres:
+		db, err = sql.Open("pgx", dsn)
+	case DialectSQLite:
+		if dir := filepath.Dir(dsn); dir != "." {
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				return nil, err
+			}
+		}
+		db, err = sql.Open("sqlite3", "file:"+dsn+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	default:
+		return nil, fmt.Errorf("unknown store dialect %q", dialect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dialect == DialectSQLite {
+		// SQLite takes one writer at a time; a single connection keeps writers from failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+// Rebind rewrites the ? placeholders of a query into the ones of the dialect.
+func (db *DB) Rebind(query string) string {
+	if db.Dialect != DialectPostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/database/sqlstore"
+	"github.com/google/uuid"
+)
+
+const userColumns = "id, name, password, email, avatar_url, created_at, oauth_provider, oauth_id"
+
+type sqlRepository struct {
+	db *sqlstore.DB
+}
+
+// NewSQLRepository returns a repository of the users kept in a PostgreSQL or SQLite store.
+func NewSQLRepository(db *sqlstore.DB) Repository {
+	return &sqlRepository{db: db}
+}
+
+func (r *sqlRepository) InsertOne(user *schema.User) (*ResponseUser, error) {
+	created := *user
+	created.ID = uuid.NewString()
+	now := time.Now().UTC()
+	created.CreatedAt = &now
+	_, err := r.db.Exec(r.db.Rebind("insert into users ("+userColumns+") values (?, ?, ?, ?, ?, ?, ?, ?)"),
+		created.ID, created.Name, created.Password, created.Email, created.AvatarURL, now, created.OAuthProvider, created.OAuthID)
+	if err != nil {
+		return nil, err
+	}
+	return toResponseUser(&created), nil
+}
+
+func (r *sqlRepository) GetByEmail(email string) (*ResponseUser, error) {
+	user, err := r.GetCredentials(email)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return toResponseUser(user), nil
+}
+
+func (r *sqlRepository) GetByID(id string) (*ResponseUser, error) {
+	user, err := r.get("id", id)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return toResponseUser(user), nil
+}
+
+func (r *sqlRepository) GetCredentials(email string) (*schema.User, error) {
+	return r.get("email", email)
+}
+
+func (r *sqlRepository) UpsertOAuth(user *schema.User) (*ResponseUser, error) {
+	row := r.db.QueryRow(r.db.Rebind(`insert into users (`+userColumns+`) values (?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (email) do update set
+			name = excluded.name,
+			avatar_url = excluded.avatar_url,
+			oauth_provider = excluded.oauth_provider,
+			oauth_id = excluded.oauth_id
+		returning `+userColumns),
+		uuid.NewString(), user.Name, user.Password, user.Email, user.AvatarURL, time.Now().UTC(), user.OAuthProvider, user.OAuthID)
+	upserted, err := scanUser(row)
+	if err != nil {
+		return nil, err
+	}
+	return toResponseUser(upserted), nil
+}
+
+// get returns the user whose column has the value, or nil if there is none.
+func (r *sqlRepository) get(column, value string) (*schema.User, error) {
+	row := r.db.QueryRow(r.db.Rebind("select "+userColumns+" from users where "+column+" = ?"), value)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
+func scanUser(row *sql.Row) (*schema.User, error) {
+	var (
+		user      schema.User
+		createdAt time.Time
+	)
+	err := row.Scan(&user.ID, &user.Name, &user.Password, &user.Email, &user.AvatarURL, &createdAt, &user.OAuthProvider, &user.OAuthID)
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = &createdAt
+	return &user, nil
+}
@@ -15,9 +15,51 @@ type ResponseUser struct {
 	OAuthProvider string `json:"auth_provider"`
 }
 
-// InsertOneUser inserts a new user into the database and returns the created user.
-func InsertOneUser(client *supabase.Client, user *schema.User) (*ResponseUser, error) {
-	data, _, err := client.From("users").Insert(user, false, "", "representation", "exact").Single().Execute()
+// Repository stores the users. Lookups return nil without an error when there is no such user.
+type Repository interface {
+	// InsertOne inserts a new user and returns the created user.
+	InsertOne(user *schema.User) (*ResponseUser, error)
+	GetByEmail(email string) (*ResponseUser, error)
+	GetByID(id string) (*ResponseUser, error)
+	// GetCredentials returns the full record of a user, password hash included, for logging in.
+	GetCredentials(email string) (*schema.User, error)
+	// UpsertOAuth inserts a user signing in with an OAuth provider, or updates the user with the same email.
+	UpsertOAuth(user *schema.User) (*ResponseUser, error)
+}
+
+// CheckUserExists checks if a user exists by their email address.
+func CheckUserExists(repo Repository, email string) (bool, error) {
+	user, err := repo.GetByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toResponseUser(user *schema.User) *ResponseUser {
+	return &ResponseUser{
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		AvatarURL:     user.AvatarURL,
+		OAuthProvider: user.OAuthProvider,
+	}
+}
+
+type supabaseRepository struct {
+	client *supabase.Client
+}
+
+// NewSupabaseRepository returns a repository of the users kept in Supabase.
+func NewSupabaseRepository(client *supabase.Client) Repository {
+	return &supabaseRepository{client: client}
+}
+
+func (r *supabaseRepository) InsertOne(user *schema.User) (*ResponseUser, error) {
+	data, _, err := r.client.From("users").Insert(user, false, "", "representation", "exact").Single().Execute()
 	if err != nil {
 		return nil, err
 	}
@@ -26,75 +68,50 @@ func InsertOneUser(client *supabase.Client, user *schema.User) (*ResponseUser, e
 	if err := json.Unmarshal(data, &newUser); err != nil {
 		return nil, err
 	}
-	
-	return &ResponseUser{
-		ID:            newUser.ID,
-		Name:          newUser.Name,
-		Email:         newUser.Email,
-		AvatarURL:     newUser.AvatarURL,
-		OAuthProvider: newUser.OAuthProvider,
-	}, nil
+	return toResponseUser(&newUser), nil
 }
 
-// GetUserByEmail retrieves a user by their email address and returns it as a ResponseUser.
-func GetUserByEmail(client *supabase.Client, email string) (*ResponseUser, error) {
-	data, count, err := client.From("users").Select("*", "", false).Eq("email", email).Single().Execute()
+func (r *supabaseRepository) GetByEmail(email string) (*ResponseUser, error) {
+	user, err := r.GetCredentials(email)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return toResponseUser(user), nil
+}
+
+func (r *supabaseRepository) GetByID(id string) (*ResponseUser, error) {
+	data, count, err := r.client.From("users").Select("*", "exact", false).Eq("id", id).Single().Execute()
 	if err != nil {
 		if count == 0 {
 			return nil, nil
 		}
 		return nil, err
 	}
+
 	var user schema.User
 	if err := json.Unmarshal(data, &user); err != nil {
 		return nil, err
 	}
-	return &ResponseUser{
-		ID:            user.ID,
-		Name:          user.Name,
-		Email:         user.Email,
-		AvatarURL:     user.AvatarURL,
-		OAuthProvider: user.OAuthProvider,
-	}, nil
+	return toResponseUser(&user), nil
 }
 
-// GetUserByID retrieves a user by their ID and returns it as a ResponseUser.
-func GetUserByID(client *supabase.Client, id string) (*ResponseUser, error) {
-	data, count, err := client.From("users").Select("*", "exact", false).Eq("id", id).Single().Execute()
+func (r *supabaseRepository) GetCredentials(email string) (*schema.User, error) {
+	data, count, err := r.client.From("users").Select("*", "", false).Eq("email", email).Single().Execute()
 	if err != nil {
 		if count == 0 {
 			return nil, nil
 		}
 		return nil, err
 	}
-
 	var user schema.User
 	if err := json.Unmarshal(data, &user); err != nil {
 		return nil, err
 	}
-	return &ResponseUser{
-		ID:            user.ID,
-		Name:          user.Name,
-		Email:         user.Email,
-		AvatarURL:     user.AvatarURL,
-		OAuthProvider: user.OAuthProvider,
-	}, nil
-}
-
-// CheckUserExists checks if a user exists by their email address.
-func CheckUserExists(client *supabase.Client, email string) (bool, error) {
-	user, err := GetUserByEmail(client, email)
-	if err != nil {
-		return false, err
-	}
-	if user == nil {
-		return false, nil
-	}
-	return true, nil
+	return &user, nil
 }
 
-func UpsertOAuthUser(client *supabase.Client, user *schema.User) (*ResponseUser, error) {
-	data, _, err := client.From("users").Upsert(user, "email", "representation", "exact").Single().Execute()
+func (r *supabaseRepository) UpsertOAuth(user *schema.User) (*ResponseUser, error) {
+	data, _, err := r.client.From("users").Upsert(user, "email", "representation", "exact").Single().Execute()
 	if err != nil {
 		return nil, err
 	}
@@ -103,12 +120,5 @@ func UpsertOAuthUser(client *supabase.Client, user *schema.User) (*ResponseUser,
 	if err := json.Unmarshal(data, &upsertedUser); err != nil {
 		return nil, err
 	}
-
-	return &ResponseUser{
-		ID:            upsertedUser.ID,
-		Name:          upsertedUser.Name,
-		Email:         upsertedUser.Email,
-		AvatarURL:     upsertedUser.AvatarURL,
-		OAuthProvider: upsertedUser.OAuthProvider,
-	}, nil
-}
\ No newline at end of file
+	return toResponseUser(&upsertedUser), nil
+}
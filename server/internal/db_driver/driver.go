@@ -7,7 +7,11 @@ import (
 
 	"github.com/cprakhar/datawhiz/config"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
 	"github.com/cprakhar/datawhiz/internal/db_driver/nosql"
+	"github.com/cprakhar/datawhiz/internal/db_driver/plan"
+	"github.com/cprakhar/datawhiz/internal/db_driver/records"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
 	sql_ "github.com/cprakhar/datawhiz/internal/db_driver/sql"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -30,25 +34,40 @@ func CreateConnectionString(conn *schema.ManualConnectionForm) (string, error) {
 }
 
 // NewDBPool creates a new database connection pool based on the provided configuration and connection string.
-func NewDBPool(dbCfg *config.DBConfig, connStr, dbType string) (interface{}, error) {
+// The pool settings of the connection, if any, override the configuration and set options of the driver,
+// and its TLS settings, with their certificates and key decrypted, override the SSL mode of the connection string.
+func NewDBPool(ctx context.Context, dbCfg *config.DBConfig, connStr, dbType string, settings *schema.PoolSettings, tlsSettings *schema.TLSSettings) (interface{}, error) {
+	dbCfg = withPoolSettings(dbCfg, settings)
 	switch dbType {
 	case "postgresql":
-		return sql_.NewPostgresPool(dbCfg, connStr)
+		return sql_.NewPostgresPool(ctx, dbCfg, connStr, settings, tlsSettings)
 	case "mysql":
-		return sql_.NewMySQLPool(dbCfg, connStr)
+		return sql_.NewMySQLPool(ctx, dbCfg, connStr, settings, tlsSettings)
 	case "sqlite":
-		return sql_.NewSQLitePool(dbCfg, connStr)
+		return sql_.NewSQLitePool(ctx, dbCfg, connStr, settings)
 	case "mongodb":
-		return nosql.NewMongoDBPool(dbCfg, connStr)
+		return nosql.NewMongoDBPool(ctx, dbCfg, connStr, settings, tlsSettings)
 	default:
 		return nil, errors.New("unsupported database type: " + dbType)
 	}
 }
 
-// PingDB checks the connectivity to the database by pinging it.
-func PingDB(dbCfg *config.DBConfig, connString, dbType string) error {
+// PingDB checks the connectivity to the database by pinging it, through its SSH tunnel if it has one.
+func PingDB(ctx context.Context, dbCfg *config.DBConfig, connString, dbType string, settings *schema.PoolSettings, sshTunnel *schema.SSHTunnel, tlsSettings *schema.TLSSettings) error {
+	if sshTunnel != nil {
+		var err error
+		if tlsSettings, err = TunnelTLSSettings(tlsSettings, connString, dbType); err != nil {
+			return err
+		}
+		t, tunneled, err := OpenTunnel(ctx, sshTunnel, connString, dbType)
+		if err != nil {
+			return err
+		}
+		defer t.Close()
+		connString = tunneled
+	}
 
-	pool, err := NewDBPool(dbCfg, connString, dbType)
+	pool, err := NewDBPool(ctx, dbCfg, connString, dbType, settings, tlsSettings)
 	if err != nil {
 		return err
 	}
@@ -56,20 +75,20 @@ func PingDB(dbCfg *config.DBConfig, connString, dbType string) error {
 	switch dbType {
     case "postgresql":
         pgPool := pool.(*pgxpool.Pool)
-        defer pgPool.Close()
-        return sql_.PingPostgres(pgPool)
+        defer sql_.ClosePostgresPool(pgPool)
+        return sql_.PingPostgres(ctx, pgPool)
     case "mysql":
         sqlPool := pool.(*sql.DB)
         defer sqlPool.Close()
-        return sql_.PingMySQL(sqlPool)
+        return sql_.PingMySQL(ctx, sqlPool)
     case "sqlite":
         sqlPool := pool.(*sql.DB)
         defer sqlPool.Close()
-        return sql_.PingSQLite(sqlPool)
+        return sql_.PingSQLite(ctx, sqlPool)
     case "mongodb":
         mongoClient := pool.(*mongo.Client)
-        defer mongoClient.Disconnect(context.Background())
-        return nosql.PingMongoDB(mongoClient)
+        defer nosql.CloseMongoDBPool(context.Background(), mongoClient)
+        return nosql.PingMongoDB(ctx, mongoClient)
     default:
         return errors.New("unsupported database type: " + dbType)
     }
@@ -90,60 +109,62 @@ func ExtractDBDetails(conn *schema.StringConnectionForm) (*schema.ManualConnecti
 }
 
 // ExtractDBTables extracts the list of tables or collections from the database.
-func ExtractDBTables(pool interface{}, dbType string, dbName ...string) (interface{}, error) {
+func ExtractDBTables(ctx context.Context, pool interface{}, dbType string, dbName ...string) (interface{}, error) {
 	switch dbType {
 	case "postgresql":
-		return sql_.GetPostgresTables(pool.(*pgxpool.Pool))
+		return sql_.GetPostgresTables(ctx, pool.(*pgxpool.Pool))
 	case "mysql":
-		return sql_.GetMySQLTables(pool.(*sql.DB))
+		return sql_.GetMySQLTables(ctx, pool.(*sql.DB))
 	case "sqlite":
-		return sql_.GetSQLiteTables(pool.(*sql.DB))
+		return sql_.GetSQLiteTables(ctx, pool.(*sql.DB))
 	case "mongodb":
-		return nosql.GetMongoDBCollections(pool.(*mongo.Client), dbName[0])
+		return nosql.GetMongoDBCollections(ctx, pool.(*mongo.Client), dbName[0])
 	default:
 		return nil, errors.New("unsupported database type: " + dbType)
 	}
 }
 
 // GetTableSchema retrieves the schema of a specific table or collection in the database.
-func GetTableSchema(pool interface{}, dbType, dbName, tableName string) (interface{}, error) {
+func GetTableSchema(ctx context.Context, pool interface{}, dbType, dbName, tableName string) (interface{}, error) {
 	switch dbType {
 	case "postgresql":
-		return sql_.GetPostgresTableSchema(pool.(*pgxpool.Pool), tableName)
+		return sql_.GetPostgresTableSchema(ctx, pool.(*pgxpool.Pool), tableName)
 	case "mysql":
-		return sql_.GetMySQLTableSchema(pool.(*sql.DB), tableName)
+		return sql_.GetMySQLTableSchema(ctx, pool.(*sql.DB), tableName)
 	case "sqlite":
-		return sql_.GetSQLiteTableSchema(pool.(*sql.DB), tableName)
+		return sql_.GetSQLiteTableSchema(ctx, pool.(*sql.DB), tableName)
 	default:
 		return nil, errors.New("unsupported database type: " + dbType)
 	}
 }
 
-// GetTableRecords retrieves the records of a specific table or collection in the database.
-func GetTableRecords(pool interface{}, dbType, dbName, tableName string) (interface{}, error) {
+// GetTableRecords retrieves at most limit records of a specific table or collection in the database.
+func GetTableRecords(ctx context.Context, pool interface{}, dbType, dbName, tableName string, limit int64) (result.Rows, error) {
 	switch dbType {
 	case "postgresql":
-		return sql_.GetPostgresTableRecords(pool.(*pgxpool.Pool), tableName)
+		return sql_.GetPostgresTableRecords(ctx, pool.(*pgxpool.Pool), tableName, limit)
 	case "mysql":
-		return sql_.GetMySQLTableRecords(pool.(*sql.DB), tableName)
+		return sql_.GetMySQLTableRecords(ctx, pool.(*sql.DB), tableName, limit)
 	case "sqlite":
-		return sql_.GetSQLiteTableRecords(pool.(*sql.DB), tableName)
+		return sql_.GetSQLiteTableRecords(ctx, pool.(*sql.DB), tableName, limit)
 	case "mongodb":
-		return nosql.GetMongoDBCollectionRecords(pool.(*mongo.Client), dbName, tableName)
+		return nosql.GetMongoDBCollectionRecords(ctx, pool.(*mongo.Client), dbName, tableName, limit)
 	default:
 		return nil, errors.New("unsupported database type: " + dbType)
 	}
 }
 
-// RunQuery executes a query on the database and returns the result.
-func RunQuery(pool interface{}, dbType, dbName, query string) (interface{}, error) {
+// RunQuery executes a query on the database and returns a cursor over the result. Arguments
+// fill the placeholders of the engine, $1 on PostgreSQL and ? elsewhere.
+// The caller must close the returned rows.
+func RunQuery(ctx context.Context, pool interface{}, dbType, dbName, query string, args ...interface{}) (result.Rows, error) {
 	switch dbType {
 	case "postgresql":
-		return sql_.RunPostgresQuery(pool.(*pgxpool.Pool), query)
+		return sql_.RunPostgresQuery(ctx, pool.(*pgxpool.Pool), query, args...)
 	case "mysql":
-		return sql_.RunMySQLQuery(pool.(*sql.DB), query)
+		return sql_.RunMySQLQuery(ctx, pool.(*sql.DB), query, args...)
 	case "sqlite":
-		return sql_.RunSQLiteQuery(pool.(*sql.DB), query)
+		return sql_.RunSQLiteQuery(ctx, pool.(*sql.DB), query, args...)
 	// case "mongodb":
 	// 	return nosql.RunMongoDBQuery(pool.(*mongo.Client), dbName, tableName, query)
 	default:
@@ -151,11 +172,232 @@ func RunQuery(pool interface{}, dbType, dbName, query string) (interface{}, erro
 	}
 }
 
+// ApplyTableChanges validates a batch of row edits against the table and applies it in one transaction.
+// Edits are refused on SQL tables without a primary key unless allowNoPK is set.
+func ApplyTableChanges(ctx context.Context, pool interface{}, dbType, dbName, tableName string, changes []records.Change, allowNoPK bool) (*records.Summary, error) {
+	if dbType == "mongodb" {
+		return nosql.ApplyMongoDBChanges(ctx, pool.(*mongo.Client), dbName, tableName, changes)
+	}
+
+	rawSchema, err := GetTableSchema(ctx, pool, dbType, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	columns, ok := rawSchema.([]schema.ColumnSchema)
+	if !ok || len(columns) == 0 {
+		return nil, errors.New("table not found: " + tableName)
+	}
+	changes, err = records.Validate(columns, changes, allowNoPK)
+	if err != nil {
+		return nil, err
+	}
+
+	switch dbType {
+	case "postgresql":
+		return sql_.ApplyPostgresChanges(ctx, pool.(*pgxpool.Pool), tableName, changes)
+	case "mysql":
+		return sql_.ApplyMySQLChanges(ctx, pool.(*sql.DB), tableName, changes)
+	case "sqlite":
+		return sql_.ApplySQLiteChanges(ctx, pool.(*sql.DB), tableName, changes)
+	default:
+		return nil, errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// ExplainQuery returns the normalized execution plan of a query.
+func ExplainQuery(ctx context.Context, pool interface{}, dbType, dbName, query string, opts plan.Options) (*plan.Plan, error) {
+	switch dbType {
+	case "postgresql":
+		return sql_.ExplainPostgresQuery(ctx, pool.(*pgxpool.Pool), query, opts)
+	case "mysql":
+		return sql_.ExplainMySQLQuery(ctx, pool.(*sql.DB), query, opts)
+	case "sqlite":
+		return sql_.ExplainSQLiteQuery(ctx, pool.(*sql.DB), query, opts)
+	case "mongodb":
+		return nosql.ExplainMongoDBQuery(ctx, pool.(*mongo.Client), dbName, query, opts)
+	default:
+		return nil, errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// GetImportColumns returns the columns of an existing table as import targets, or nil when the
+// table does not exist. MongoDB collections have no fixed columns and always return nil.
+func GetImportColumns(ctx context.Context, pool interface{}, dbType, dbName, tableName string) ([]importer.Column, error) {
+	if dbType == "mongodb" {
+		return nil, nil
+	}
+	rawSchema, err := GetTableSchema(ctx, pool, dbType, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	tableColumns, _ := rawSchema.([]schema.ColumnSchema)
+	if len(tableColumns) == 0 {
+		return nil, nil
+	}
+	columns := make([]importer.Column, len(tableColumns))
+	for i, col := range tableColumns {
+		columns[i] = importer.Column{
+			Name:       col.Name,
+			Kind:       result.KindOf(col.Type),
+			Type:       col.Type,
+			Nullable:   col.IsNullable,
+			// Primary keys are often generated, so they may be left out like columns with a default.
+			Required:   !col.IsNullable && !col.IsPrimaryKey && !col.DefaultValue.Valid,
+			PrimaryKey: col.IsPrimaryKey,
+		}
+	}
+	return columns, nil
+}
+
+// CreateImportTable creates the table or collection an import loads into.
+func CreateImportTable(ctx context.Context, pool interface{}, dbType, dbName, tableName string, columns []importer.Column) error {
+	switch dbType {
+	case "postgresql":
+		return sql_.CreatePostgresTable(ctx, pool.(*pgxpool.Pool), tableName, columns)
+	case "mysql":
+		return sql_.CreateMySQLTable(ctx, pool.(*sql.DB), tableName, columns)
+	case "sqlite":
+		return sql_.CreateSQLiteTable(ctx, pool.(*sql.DB), tableName, columns)
+	case "mongodb":
+		return nosql.CreateMongoDBCollection(ctx, pool.(*mongo.Client), dbName, tableName)
+	default:
+		return errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// NewImportLoader returns the fastest bulk loader of the engine for the given target columns.
+func NewImportLoader(pool interface{}, dbType, dbName, tableName string, columns []importer.Column) (importer.Loader, error) {
+	switch dbType {
+	case "postgresql":
+		return sql_.NewPostgresLoader(pool.(*pgxpool.Pool), tableName, columns), nil
+	case "mysql":
+		return sql_.NewMySQLLoader(pool.(*sql.DB), tableName, columns), nil
+	case "sqlite":
+		return sql_.NewSQLiteLoader(pool.(*sql.DB), tableName, columns), nil
+	case "mongodb":
+		return nosql.NewMongoDBLoader(pool.(*mongo.Client), dbName, tableName, columns), nil
+	default:
+		return nil, errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// GetDumpColumns returns the columns of a table in order, with the full engine type of each so
+// that it can be recreated as it was. MongoDB collections have no fixed columns and return nil.
+func GetDumpColumns(ctx context.Context, pool interface{}, dbType, dbName, tableName string) ([]importer.Column, error) {
+	var columns []importer.Column
+	var err error
+	switch dbType {
+	case "postgresql":
+		columns, err = sql_.GetPostgresColumnTypes(ctx, pool.(*pgxpool.Pool), tableName)
+	case "mysql":
+		columns, err = sql_.GetMySQLColumnTypes(ctx, pool.(*sql.DB), tableName)
+	case "sqlite":
+		columns, err = sql_.GetSQLiteColumnTypes(ctx, pool.(*sql.DB), tableName)
+	case "mongodb":
+		return nil, nil
+	default:
+		return nil, errors.New("unsupported database type: " + dbType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("table not found: " + tableName)
+	}
+
+	described, err := GetImportColumns(ctx, pool, dbType, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]importer.Column, len(described))
+	for _, col := range described {
+		byName[col.Name] = col
+	}
+	for i, col := range columns {
+		info := byName[col.Name]
+		col.Kind = info.Kind
+		if col.Kind == "" || col.Kind == result.KindUnknown {
+			col.Kind = result.KindOf(col.Type)
+		}
+		col.Nullable = info.Nullable
+		col.PrimaryKey = info.PrimaryKey
+		if col.Kind == result.KindDecimal {
+			col.Precision, col.Scale = result.DecimalSize(col.Type)
+		}
+		columns[i] = col
+	}
+	return columns, nil
+}
+
+// TableDDL returns the CREATE TABLE statement of a table with the given columns in the
+// dialect of the engine. MongoDB collections have no DDL.
+func TableDDL(dbType, tableName string, columns []importer.Column) (string, error) {
+	switch dbType {
+	case "postgresql":
+		return sql_.PostgresTableDDL(tableName, columns), nil
+	case "mysql":
+		return sql_.MySQLTableDDL(tableName, columns), nil
+	case "sqlite":
+		return sql_.SQLiteTableDDL(tableName, columns), nil
+	case "mongodb":
+		return "", nil
+	default:
+		return "", errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// GetTableBatch reads at most limit rows of a table in the order of its key columns, starting after
+// the row with the given key values, or from the first row when after is empty. Key values are
+// given as they were encoded in a result. Without key columns the whole table is read at once.
+// MongoDB collections are always read in _id order.
+func GetTableBatch(ctx context.Context, pool interface{}, dbType, dbName, tableName string, keys []importer.Column, after []interface{}, limit int64) (result.Rows, error) {
+	keyNames := make([]string, len(keys))
+	for i, key := range keys {
+		keyNames[i] = key.Name
+	}
+	switch dbType {
+	case "postgresql":
+		return sql_.GetPostgresTableBatch(ctx, pool.(*pgxpool.Pool), tableName, keyNames, after, limit)
+	case "mysql":
+		return sql_.GetMySQLTableBatch(ctx, pool.(*sql.DB), tableName, keyNames, after, limit)
+	case "sqlite":
+		return sql_.GetSQLiteTableBatch(ctx, pool.(*sql.DB), tableName, keyNames, after, limit)
+	case "mongodb":
+		var id interface{}
+		kind := result.KindObjectID
+		if len(after) > 0 {
+			id = after[0]
+		}
+		if len(keys) > 0 {
+			kind = keys[0].Kind
+		}
+		return nosql.GetMongoDBCollectionBatch(ctx, pool.(*mongo.Client), dbName, tableName, id, kind, limit)
+	default:
+		return nil, errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// CountTableRows counts the rows of a table. MongoDB returns the estimate kept in the collection metadata.
+func CountTableRows(ctx context.Context, pool interface{}, dbType, dbName, tableName string) (int64, error) {
+	switch dbType {
+	case "postgresql":
+		return sql_.CountPostgresRows(ctx, pool.(*pgxpool.Pool), tableName)
+	case "mysql":
+		return sql_.CountMySQLRows(ctx, pool.(*sql.DB), tableName)
+	case "sqlite":
+		return sql_.CountSQLiteRows(ctx, pool.(*sql.DB), tableName)
+	case "mongodb":
+		return nosql.CountMongoDBDocuments(ctx, pool.(*mongo.Client), dbName, tableName)
+	default:
+		return 0, errors.New("unsupported database type: " + dbType)
+	}
+}
+
 // GetReleventTablesSchema retrieves the schema of relevant tables in the database.
-func GetReleventTablesSchema(pool interface{}, dbType string, tables []string) (map[string][]schema.ColumnSchema, error) {
+func GetReleventTablesSchema(ctx context.Context, pool interface{}, dbType string, tables []string) (map[string][]schema.ColumnSchema, error) {
 	result := make(map[string][]schema.ColumnSchema)
 	for _, table := range tables {
-		rawSchema, err := GetTableSchema(pool, dbType, "", table)
+		rawSchema, err := GetTableSchema(ctx, pool, dbType, "", table)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,316 @@
+package export
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatTSV     Format = "tsv"
+	FormatJSON    Format = "json"
+	FormatNDJSON  Format = "ndjson"
+	FormatXLSX    Format = "xlsx"
+	FormatParquet Format = "parquet"
+)
+
+// xlsxMaxRows is the number of data rows that fit on a worksheet below the header row.
+const xlsxMaxRows = 1048575
+
+var contentTypes = map[Format]string{
+	FormatCSV:     "text/csv",
+	FormatTSV:     "text/tab-separated-values",
+	FormatJSON:    "application/json",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatXLSX:    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+var ErrUnsupportedFormat = errors.New("unsupported export format, expected one of csv, tsv, json, ndjson, xlsx or parquet")
+
+// ParseFormat picks the export format from the format parameter or, when it is empty, from
+// the Accept header. CSV is the default.
+func ParseFormat(param, accept string) (Format, error) {
+	if param != "" {
+		format := Format(strings.ToLower(param))
+		if _, ok := contentTypes[format]; !ok {
+			return "", ErrUnsupportedFormat
+		}
+		return format, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == "application/x-parquet" {
+			return FormatParquet, nil
+		}
+		for format, contentType := range contentTypes {
+			if mediaType == contentType {
+				return format, nil
+			}
+		}
+	}
+	return FormatCSV, nil
+}
+
+// ContentType is the media type of the format.
+func (f Format) ContentType() string { return contentTypes[f] }
+
+// Extension is the file extension of the format.
+func (f Format) Extension() string { return "." + string(f) }
+
+// MaxRows is the most rows the format can hold, or zero when it is unbounded.
+func (f Format) MaxRows() int64 {
+	if f == FormatXLSX {
+		return xlsxMaxRows
+	}
+	return 0
+}
+
+type Quoting string
+
+const (
+	QuoteMinimal Quoting = "minimal" // quote fields that contain the delimiter, quotes or line breaks
+	QuoteAll     Quoting = "all"
+	QuoteNone    Quoting = "none"
+)
+
+// Options controls the layout of delimited and spreadsheet exports.
+type Options struct {
+	Delimiter rune    // CSV only, TSV always uses tabs
+	Quoting   Quoting // CSV and TSV
+	Header    bool    // CSV, TSV and XLSX
+	Null      string  // text written for NULL in CSV and TSV
+	SheetName string  // XLSX
+}
+
+// DefaultOptions returns the options of a conventional CSV file.
+func DefaultOptions() Options {
+	return Options{Delimiter: ',', Quoting: QuoteMinimal, Header: true, SheetName: "Export"}
+}
+
+// NewWriter returns a writer that encodes a result set in the given format. Rows are streamed
+// to w as they arrive, except for XLSX and Parquet which are written when the writer is closed
+// or a row group is full.
+func NewWriter(w io.Writer, format Format, opts Options) (result.Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newDelimitedWriter(w, opts), nil
+	case FormatTSV:
+		opts.Delimiter = '\t'
+		return newDelimitedWriter(w, opts), nil
+	case FormatJSON:
+		return &recordsWriter{out: newFlushWriter(w), array: true}, nil
+	case FormatNDJSON:
+		return &recordsWriter{out: newFlushWriter(w)}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w, opts), nil
+	case FormatParquet:
+		return newParquetWriter(w), nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// flushEvery is the number of rows buffered before the output is flushed to the client.
+const flushEvery = 256
+
+// flushWriter buffers output and flushes it through to an http.Flusher when there is one.
+type flushWriter struct {
+	*bufio.Writer
+	flusher http.Flusher
+	pending int
+}
+
+func newFlushWriter(w io.Writer) *flushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushWriter{Writer: bufio.NewWriter(w), flusher: flusher}
+}
+
+func (f *flushWriter) rowWritten() error {
+	f.pending++
+	if f.pending < flushEvery {
+		return nil
+	}
+	return f.flush()
+}
+
+func (f *flushWriter) flush() error {
+	f.pending = 0
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return nil
+}
+
+// cellText renders an encoded value as text. Nested values are written as JSON.
+func cellText(value interface{}, null string) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return null, nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return formatFloat(v), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case json.RawMessage:
+		return string(v), nil
+	case int, int8, int16, int32, uint, uint8, uint16, uint32, float32:
+		return fmt.Sprint(v), nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// delimitedWriter writes CSV or TSV.
+type delimitedWriter struct {
+	out       *flushWriter
+	opts      Options
+	delimiter string
+	record    []string
+}
+
+func newDelimitedWriter(w io.Writer, opts Options) *delimitedWriter {
+	if opts.Delimiter == 0 {
+		opts.Delimiter = ','
+	}
+	return &delimitedWriter{out: newFlushWriter(w), opts: opts, delimiter: string(opts.Delimiter)}
+}
+
+func (d *delimitedWriter) WriteHeader(columns []result.Column) error {
+	if !d.opts.Header {
+		return nil
+	}
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	return d.writeRecord(names)
+}
+
+func (d *delimitedWriter) WriteRow(values []interface{}) error {
+	d.record = d.record[:0]
+	for _, value := range values {
+		text, err := cellText(value, d.opts.Null)
+		if err != nil {
+			return err
+		}
+		d.record = append(d.record, text)
+	}
+	if err := d.writeRecord(d.record); err != nil {
+		return err
+	}
+	return d.out.rowWritten()
+}
+
+func (d *delimitedWriter) writeRecord(fields []string) error {
+	for i, field := range fields {
+		if i > 0 {
+			d.out.WriteString(d.delimiter)
+		}
+		if d.needsQuotes(field) {
+			d.out.WriteByte('"')
+			d.out.WriteString(strings.ReplaceAll(field, `"`, `""`))
+			d.out.WriteByte('"')
+		} else {
+			d.out.WriteString(field)
+		}
+	}
+	_, err := d.out.WriteString("\r\n")
+	return err
+}
+
+func (d *delimitedWriter) needsQuotes(field string) bool {
+	switch d.opts.Quoting {
+	case QuoteAll:
+		return true
+	case QuoteNone:
+		return false
+	}
+	return field != "" && (strings.Contains(field, d.delimiter) || strings.ContainsAny(field, "\"\r\n") ||
+		field[0] == ' ' || field[len(field)-1] == ' ')
+}
+
+func (d *delimitedWriter) Close(result.Summary, error) error {
+	return d.out.flush()
+}
+
+// recordsWriter writes rows as JSON objects keyed by column name, either as one JSON array
+// or as one object per line.
+type recordsWriter struct {
+	out     *flushWriter
+	array   bool
+	columns []result.Column
+	count   int64
+}
+
+func (r *recordsWriter) WriteHeader(columns []result.Column) error {
+	r.columns = columns
+	if r.array {
+		_, err := r.out.WriteString("[")
+		return err
+	}
+	return nil
+}
+
+func (r *recordsWriter) WriteRow(values []interface{}) error {
+	record := make(result.Object, len(values))
+	for i, value := range values {
+		record[i] = result.Field{Key: r.columns[i].Name, Value: value}
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if r.array && r.count > 0 {
+		r.out.WriteByte(',')
+	}
+	r.out.Write(b)
+	if !r.array {
+		r.out.WriteByte('\n')
+	}
+	r.count++
+	return r.out.rowWritten()
+}
+
+func (r *recordsWriter) Close(result.Summary, error) error {
+	if r.array {
+		r.out.WriteString("]\n")
+	}
+	return r.out.flush()
+}
@@ -0,0 +1,276 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroupSize is the number of rows buffered before a row group is written out.
+const parquetRowGroupSize = 64 * 1024
+
+// maxInt64Precision is the widest decimal that fits an INT64 physical type.
+const maxInt64Precision = 18
+
+// orderedGroup is a parquet group that keeps its fields in result set order, where
+// parquet.Group sorts them by name.
+type orderedGroup struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func (g *orderedGroup) Fields() []parquet.Field { return g.fields }
+
+type orderedField struct {
+	parquet.Node
+	name string
+}
+
+func (f *orderedField) Name() string { return f.name }
+
+func (f *orderedField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// parquetColumn is how the values of a result column are stored.
+type parquetColumn struct {
+	kind  result.Kind
+	node  parquet.Node
+	scale int
+}
+
+// parquetWriter writes a result set as a Parquet file with a schema derived from the column
+// kinds. Every column is optional since result sets do not reliably report nullability.
+type parquetWriter struct {
+	out     io.Writer
+	writer  *parquet.Writer
+	columns []parquetColumn
+	rows    []parquet.Row
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{out: w}
+}
+
+func parquetColumnOf(col result.Column) parquetColumn {
+	c := parquetColumn{kind: col.Kind}
+	switch col.Kind {
+	case result.KindInteger:
+		c.node = parquet.Int(64)
+	case result.KindFloat:
+		c.node = parquet.Leaf(parquet.DoubleType)
+	case result.KindDecimal:
+		if col.Precision != nil && *col.Precision > 0 && *col.Precision <= maxInt64Precision {
+			if col.Scale != nil {
+				c.scale = int(*col.Scale)
+			}
+			c.node = parquet.Decimal(c.scale, int(*col.Precision), parquet.Int64Type)
+		} else {
+			c.kind = result.KindString
+			c.node = parquet.String()
+		}
+	case result.KindBoolean:
+		c.node = parquet.Leaf(parquet.BooleanType)
+	case result.KindDate:
+		c.node = parquet.Date()
+	case result.KindTimestamp:
+		c.node = parquet.TimestampAdjusted(parquet.Microsecond, false)
+	case result.KindTimestampTZ:
+		c.node = parquet.Timestamp(parquet.Microsecond)
+	case result.KindUUID:
+		c.node = parquet.UUID()
+	case result.KindJSON, result.KindDocument, result.KindArray:
+		c.node = parquet.JSON()
+	case result.KindBinary:
+		c.node = parquet.Leaf(parquet.ByteArrayType)
+	default:
+		c.kind = result.KindString
+		c.node = parquet.String()
+	}
+	c.node = parquet.Optional(c.node)
+	return c
+}
+
+func (p *parquetWriter) WriteHeader(columns []result.Column) error {
+	group := &orderedGroup{Group: parquet.Group{}}
+	seen := make(map[string]int, len(columns))
+	p.columns = make([]parquetColumn, len(columns))
+	for i, col := range columns {
+		p.columns[i] = parquetColumnOf(col)
+
+		// Joins may return the same column name twice, which a Parquet group cannot hold.
+		name := col.Name
+		if n := seen[col.Name]; n > 0 {
+			name = col.Name + "_" + strconv.Itoa(n+1)
+		}
+		seen[col.Name]++
+		group.Group[name] = p.columns[i].node
+		group.fields = append(group.fields, &orderedField{Node: p.columns[i].node, name: name})
+	}
+	schema := parquet.NewSchema("export", group)
+	p.writer = parquet.NewWriter(p.out, schema, parquet.Compression(&parquet.Snappy))
+	return nil
+}
+
+func (p *parquetWriter) WriteRow(values []interface{}) error {
+	row := make(parquet.Row, len(values))
+	for i, value := range values {
+		v, ok := p.value(p.columns[i], value)
+		if !ok {
+			row[i] = parquet.NullValue().Level(0, 0, i)
+			continue
+		}
+		row[i] = v.Level(0, 1, i)
+	}
+	p.rows = append(p.rows, row)
+	if len(p.rows) < parquetRowGroupSize {
+		return nil
+	}
+	return p.flush()
+}
+
+func (p *parquetWriter) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if _, err := p.writer.WriteRows(p.rows); err != nil {
+		return err
+	}
+	p.rows = p.rows[:0]
+	return p.writer.Flush()
+}
+
+// value converts an encoded value into the physical type of its column. Values that do not
+// fit are written as null rather than failing the whole export.
+func (p *parquetWriter) value(col parquetColumn, value interface{}) (parquet.Value, bool) {
+	if value == nil {
+		return parquet.Value{}, false
+	}
+	switch col.kind {
+	case result.KindInteger:
+		switch v := value.(type) {
+		case int64:
+			return parquet.Int64Value(v), true
+		case uint64:
+			return parquet.Int64Value(int64(v)), true
+		case float64:
+			return parquet.Int64Value(int64(v)), true
+		case bool:
+			if v {
+				return parquet.Int64Value(1), true
+			}
+			return parquet.Int64Value(0), true
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return parquet.Int64Value(n), true
+			}
+		}
+	case result.KindFloat:
+		switch v := value.(type) {
+		case float64:
+			return parquet.DoubleValue(v), true
+		case int64:
+			return parquet.DoubleValue(float64(v)), true
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return parquet.DoubleValue(f), true
+			}
+		}
+	case result.KindDecimal:
+		if text, err := cellText(value, ""); err == nil {
+			if n, ok := unscaledDecimal(text, col.scale); ok {
+				return parquet.Int64Value(n), true
+			}
+		}
+	case result.KindBoolean:
+		switch v := value.(type) {
+		case bool:
+			return parquet.BooleanValue(v), true
+		case int64:
+			return parquet.BooleanValue(v != 0), true
+		}
+	case result.KindDate:
+		if v, ok := value.(string); ok {
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				return parquet.Int32Value(int32(t.Unix() / 86400)), true
+			}
+		}
+	case result.KindTimestamp:
+		if v, ok := value.(string); ok {
+			if t, err := time.Parse("2006-01-02T15:04:05.999999999", v); err == nil {
+				return parquet.Int64Value(t.UnixMicro()), true
+			}
+		}
+	case result.KindTimestampTZ:
+		if v, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return parquet.Int64Value(t.UnixMicro()), true
+			}
+		}
+	case result.KindUUID:
+		if v, ok := value.(string); ok {
+			if id, err := uuid.Parse(v); err == nil {
+				return parquet.FixedLenByteArrayValue(id[:]), true
+			}
+		}
+	case result.KindJSON, result.KindDocument, result.KindArray:
+		if v, ok := value.(json.RawMessage); ok {
+			return parquet.ByteArrayValue(v), true
+		}
+		if b, err := json.Marshal(value); err == nil {
+			return parquet.ByteArrayValue(b), true
+		}
+	case result.KindBinary:
+		switch v := value.(type) {
+		case []byte:
+			return parquet.ByteArrayValue(v), true
+		case string:
+			return parquet.ByteArrayValue([]byte(v)), true
+		}
+	default:
+		if text, err := cellText(value, ""); err == nil {
+			return parquet.ByteArrayValue([]byte(text)), true
+		}
+	}
+	return parquet.Value{}, false
+}
+
+// unscaledDecimal returns a decimal string as an integer count of 10^-scale units.
+func unscaledDecimal(text string, scale int) (int64, bool) {
+	f, ok := new(big.Float).SetPrec(256).SetString(strings.TrimSpace(text))
+	if !ok {
+		return 0, false
+	}
+	f.Mul(f, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !f.IsInt() {
+		// Round half away from zero, as databases do when casting to a smaller scale.
+		half := big.NewFloat(0.5)
+		if f.Sign() < 0 {
+			half.Neg(half)
+		}
+		f.Add(f, half)
+	}
+	n, accuracy := f.Int64()
+	return n, accuracy == big.Exact || !f.IsInt()
+}
+
+func (p *parquetWriter) Close(_ result.Summary, err error) error {
+	if p.writer == nil {
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.writer.Close()
+}
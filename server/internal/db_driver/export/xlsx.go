@@ -0,0 +1,157 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/xuri/excelize/v2"
+)
+
+// maxExactDigits is the number of significant digits a spreadsheet number holds without loss.
+// Decimals with more digits, and integers beyond 2^53, are written as text.
+const (
+	maxExactDigits  = 15
+	maxExactInteger = 1 << 53
+)
+
+// xlsxWriter streams rows into a single worksheet. The workbook is only complete once every
+// row has been written, so it is sent to the client when the writer is closed.
+type xlsxWriter struct {
+	out     io.Writer
+	opts    Options
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	columns []result.Column
+	row     int
+	cells   []interface{}
+
+	dateStyle      int
+	timestampStyle int
+}
+
+func newXLSXWriter(w io.Writer, opts Options) *xlsxWriter {
+	if opts.SheetName == "" {
+		opts.SheetName = "Export"
+	}
+	return &xlsxWriter{out: w, opts: opts}
+}
+
+func (x *xlsxWriter) WriteHeader(columns []result.Column) error {
+	x.columns = columns
+	x.file = excelize.NewFile()
+	sheet := x.file.GetSheetName(0)
+	if err := x.file.SetSheetName(sheet, x.opts.SheetName); err != nil {
+		return err
+	}
+
+	stream, err := x.file.NewStreamWriter(x.opts.SheetName)
+	if err != nil {
+		return err
+	}
+	x.stream = stream
+
+	dateFormat, timestampFormat := "yyyy-mm-dd", "yyyy-mm-dd hh:mm:ss"
+	if x.dateStyle, err = x.file.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat}); err != nil {
+		return err
+	}
+	if x.timestampStyle, err = x.file.NewStyle(&excelize.Style{CustomNumFmt: &timestampFormat}); err != nil {
+		return err
+	}
+
+	if !x.opts.Header {
+		return nil
+	}
+	headerStyle, err := x.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: col.Name}
+	}
+	// Keep the header in view while scrolling; panes must be set before any row.
+	if err := stream.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+	x.row = 1
+	return stream.SetRow("A1", header)
+}
+
+func (x *xlsxWriter) WriteRow(values []interface{}) error {
+	x.row++
+	x.cells = x.cells[:0]
+	for i, value := range values {
+		cell, err := x.cell(x.columns[i].Kind, value)
+		if err != nil {
+			return err
+		}
+		x.cells = append(x.cells, cell)
+	}
+	return x.stream.SetRow("A"+strconv.Itoa(x.row), x.cells)
+}
+
+// cell converts an encoded value into a typed spreadsheet cell where that is lossless and
+// into text otherwise.
+func (x *xlsxWriter) cell(kind result.Kind, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return v, nil
+	case int64:
+		if v > -maxExactInteger && v < maxExactInteger {
+			return v, nil
+		}
+	case float64:
+		if text := formatFloat(v); text == "NaN" || strings.HasSuffix(text, "Infinity") {
+			return text, nil
+		}
+		return v, nil
+	case string:
+		switch kind {
+		case result.KindDecimal:
+			if digits := strings.TrimLeft(strings.NewReplacer("-", "", ".", "").Replace(v), "0"); len(digits) <= maxExactDigits {
+				if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+					return f, nil
+				}
+			}
+		case result.KindDate:
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				return excelize.Cell{StyleID: x.dateStyle, Value: t}, nil
+			}
+		case result.KindTimestamp:
+			if t, err := time.Parse("2006-01-02T15:04:05.999999999", v); err == nil {
+				return excelize.Cell{StyleID: x.timestampStyle, Value: t}, nil
+			}
+		case result.KindTimestampTZ:
+			// Spreadsheets have no time zones, so instants are written in UTC.
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return excelize.Cell{StyleID: x.timestampStyle, Value: t.UTC()}, nil
+			}
+		}
+		return v, nil
+	case json.RawMessage:
+		return string(v), nil
+	}
+	return cellText(value, "")
+}
+
+func (x *xlsxWriter) Close(_ result.Summary, err error) error {
+	if x.file == nil {
+		return nil
+	}
+	defer x.file.Close()
+	if err != nil {
+		// A partial workbook is of no use, leave the response empty so the client sees the error.
+		return nil
+	}
+	if err := x.stream.Flush(); err != nil {
+		return err
+	}
+	return x.file.Write(x.out)
+}
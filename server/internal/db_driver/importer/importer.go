@@ -0,0 +1,373 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatTSV     Format = "tsv"
+	FormatJSON    Format = "json"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported import format, expected one of csv, tsv, json, ndjson or parquet")
+
+// ParseFormat picks the import format from the format parameter or, when it is empty, from
+// the extension of the uploaded file.
+func ParseFormat(param, fileName string) (Format, error) {
+	if param == "" {
+		param = strings.TrimPrefix(filepath.Ext(fileName), ".")
+		if param == "jsonl" {
+			param = string(FormatNDJSON)
+		}
+	}
+	switch format := Format(strings.ToLower(param)); format {
+	case FormatCSV, FormatTSV, FormatJSON, FormatNDJSON, FormatParquet:
+		return format, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Column is a column of an import, either read from the file or the target table. Kind drives
+// value conversion; Type, when set, is the engine type to create the column with.
+type Column struct {
+	Name       string      `json:"name" binding:"required"`
+	Kind       result.Kind `json:"kind"`
+	Type       string      `json:"type,omitempty"`
+	Nullable   bool        `json:"nullable"`
+	Required   bool        `json:"required,omitempty"` // the target column has no default and cannot be null
+	PrimaryKey bool        `json:"primary_key,omitempty"`
+	Precision  *int64      `json:"precision,omitempty"`
+	Scale      *int64      `json:"scale,omitempty"`
+}
+
+var typeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_ ]*(\([0-9, ]+\)[A-Za-z ]*)?( ?\[\])?$`)
+
+// ValidTypeName reports whether a type name, such as numeric(10,2) or timestamp(3) with time
+// zone, is plain enough to be written into a CREATE TABLE statement.
+func ValidTypeName(typ string) bool {
+	return typeNamePattern.MatchString(typ)
+}
+
+// ValidateColumns checks column definitions given by a client for a new table.
+func ValidateColumns(columns []Column) error {
+	if len(columns) == 0 {
+		return errors.New("at least one column is required")
+	}
+	seen := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		if strings.TrimSpace(col.Name) == "" {
+			return errors.New("column names cannot be empty")
+		}
+		if seen[strings.ToLower(col.Name)] {
+			return fmt.Errorf("duplicate column %q", col.Name)
+		}
+		seen[strings.ToLower(col.Name)] = true
+		// The type is written into the CREATE TABLE statement, so only plain type names are accepted.
+		if col.Type != "" && !ValidTypeName(col.Type) {
+			return fmt.Errorf("invalid type %q for column %q", col.Type, col.Name)
+		}
+	}
+	return nil
+}
+
+// Plan maps the columns of a file onto the columns of the target table.
+type Plan struct {
+	Targets []Column `json:"targets"`
+	Sources []string `json:"sources"` // the file column loaded into each target column
+	Ignored []string `json:"ignored,omitempty"`
+
+	indexes []int
+}
+
+// NewPlan matches file columns to target columns. Without a mapping, columns are matched by
+// name, ignoring case; a mapping lists the target of each file column, with an empty target
+// skipping the column. File columns without a target are ignored and reported.
+func NewPlan(fileColumns, targets []Column, mapping map[string]string) (*Plan, error) {
+	fileIndex := make(map[string]int, len(fileColumns))
+	for i, col := range fileColumns {
+		fileIndex[col.Name] = i
+	}
+	targetIndex := make(map[string]int, len(targets))
+	for i, col := range targets {
+		targetIndex[strings.ToLower(col.Name)] = i
+	}
+
+	source := make([]int, len(targets))
+	for i := range source {
+		source[i] = -1
+	}
+	plan := &Plan{}
+	for i, col := range fileColumns {
+		targetName := col.Name
+		if mapping != nil {
+			var ok bool
+			if targetName, ok = mapping[col.Name]; !ok || targetName == "" {
+				plan.Ignored = append(plan.Ignored, col.Name)
+				continue
+			}
+		}
+		t, ok := targetIndex[strings.ToLower(targetName)]
+		if !ok {
+			if mapping != nil {
+				return nil, fmt.Errorf("column %q is mapped to unknown column %q", col.Name, targetName)
+			}
+			plan.Ignored = append(plan.Ignored, col.Name)
+			continue
+		}
+		if source[t] >= 0 {
+			return nil, fmt.Errorf("columns %q and %q are both mapped to %q", fileColumns[source[t]].Name, col.Name, targets[t].Name)
+		}
+		source[t] = i
+	}
+	for name := range mapping {
+		if _, ok := fileIndex[name]; !ok {
+			return nil, fmt.Errorf("mapping refers to unknown file column %q", name)
+		}
+	}
+
+	var missing []string
+	for t, target := range targets {
+		if source[t] < 0 {
+			if target.Required {
+				missing = append(missing, target.Name)
+			}
+			continue
+		}
+		plan.Targets = append(plan.Targets, target)
+		plan.Sources = append(plan.Sources, fileColumns[source[t]].Name)
+		plan.indexes = append(plan.indexes, source[t])
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("required columns are not mapped: %s", strings.Join(missing, ", "))
+	}
+	if len(plan.Targets) == 0 {
+		return nil, errors.New("no file column matches a column of the table")
+	}
+	return plan, nil
+}
+
+// Loader writes a batch of converted rows, holding the plan's target columns in order.
+// A failed batch is retried one row at a time to find the rows at fault.
+type Loader func(ctx context.Context, rows [][]interface{}) error
+
+type ErrorPolicy string
+
+const (
+	OnErrorSkip  ErrorPolicy = "skip"  // report the row and carry on
+	OnErrorAbort ErrorPolicy = "abort" // stop at the first bad row
+)
+
+// maxReportedErrors bounds the row errors kept in a summary; the rest are only counted.
+const maxReportedErrors = 100
+
+// Options controls how rows are loaded.
+type Options struct {
+	BatchSize int
+	OnError   ErrorPolicy
+	MaxErrors int64 // stop once this many rows failed, zero for no limit
+}
+
+// RowError reports a row of the file that could not be loaded. Rows are numbered from 1,
+// not counting a header line.
+type RowError struct {
+	Row     int64  `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *RowError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("row %d, column %q: %s", e.Row, e.Column, e.Message)
+	}
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// Progress is reported after every batch.
+type Progress struct {
+	RowsRead   int64   `json:"rows_read"`
+	RowsLoaded int64   `json:"rows_loaded"`
+	RowsFailed int64   `json:"rows_failed"`
+	Percent    float64 `json:"percent"`
+}
+
+// Summary reports the outcome of an import. Batches are committed as they are loaded, so an
+// aborted import leaves the rows loaded so far in place.
+type Summary struct {
+	Table           string     `json:"table"`
+	Created         bool       `json:"created"`
+	Columns         []Column   `json:"columns"`
+	Sources         []string   `json:"sources"`
+	Ignored         []string   `json:"ignored,omitempty"`
+	RowsRead        int64      `json:"rows_read"`
+	RowsLoaded      int64      `json:"rows_loaded"`
+	RowsFailed      int64      `json:"rows_failed"`
+	Errors          []RowError `json:"errors"`
+	ErrorsTruncated bool       `json:"errors_truncated"`
+	Aborted         bool       `json:"aborted"`
+	Error           string     `json:"error,omitempty"`
+	Duration        int64      `json:"duration"`
+}
+
+func (s *Summary) addError(err RowError) {
+	s.RowsFailed++
+	if len(s.Errors) < maxReportedErrors {
+		s.Errors = append(s.Errors, err)
+	} else {
+		s.ErrorsTruncated = true
+	}
+}
+
+// pendingRow is a converted row waiting in a batch, with its row number for error reports.
+type pendingRow struct {
+	number int64
+	values []interface{}
+}
+
+// Run reads every row of the source, converts it to the plan's target columns and loads it
+// in batches. Bad rows are reported in the summary. The error is only set when the import
+// could not go on, in which case the summary holds what was done until then.
+func Run(ctx context.Context, src Source, plan *Plan, load Loader, opts Options, progress func(Progress)) (*Summary, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	startedAt := time.Now()
+	summary := &Summary{Columns: plan.Targets, Sources: plan.Sources, Ignored: plan.Ignored, Errors: []RowError{}}
+	defer func() { summary.Duration = time.Since(startedAt).Milliseconds() }()
+
+	stop := func(err error) (*Summary, error) {
+		summary.Aborted = true
+		summary.Error = err.Error()
+		return summary, err
+	}
+	// fail records a bad row and tells whether the import must stop.
+	fail := func(rowErr RowError) bool {
+		summary.addError(rowErr)
+		return opts.OnError == OnErrorAbort || (opts.MaxErrors > 0 && summary.RowsFailed >= opts.MaxErrors)
+	}
+
+	batch := make([]pendingRow, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows := make([][]interface{}, len(batch))
+		for i, row := range batch {
+			rows[i] = row.values
+		}
+		err := load(ctx, rows)
+		if err == nil {
+			summary.RowsLoaded += int64(len(batch))
+		} else {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Find the rows the database refused by loading them one at a time.
+			for _, row := range batch {
+				if err := load(ctx, [][]interface{}{row.values}); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					if fail(RowError{Row: row.number, Message: err.Error()}) {
+						batch = batch[:0]
+						return errTooManyErrors(opts, summary)
+					}
+					continue
+				}
+				summary.RowsLoaded++
+			}
+		}
+		batch = batch[:0]
+		if progress != nil {
+			progress(Progress{RowsRead: summary.RowsRead, RowsLoaded: summary.RowsLoaded, RowsFailed: summary.RowsFailed, Percent: src.Progress() * 100})
+		}
+		return nil
+	}
+
+	// abort loads the rows batched before the bad one, then stops.
+	abort := func() (*Summary, error) {
+		if err := flush(); err != nil {
+			return stop(err)
+		}
+		return stop(errTooManyErrors(opts, summary))
+	}
+
+	for {
+		values, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var rowErr *RowError
+			if !errors.As(err, &rowErr) {
+				return stop(err)
+			}
+			summary.RowsRead++
+			rowErr.Row = summary.RowsRead
+			if fail(*rowErr) {
+				return abort()
+			}
+			continue
+		}
+		summary.RowsRead++
+
+		converted, rowErr := convertRow(plan, values)
+		if rowErr != nil {
+			rowErr.Row = summary.RowsRead
+			if fail(*rowErr) {
+				return abort()
+			}
+			continue
+		}
+		batch = append(batch, pendingRow{number: summary.RowsRead, values: converted})
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return stop(err)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return stop(err)
+	}
+	return summary, nil
+}
+
+// ErrAborted is returned when an import stops because of bad rows.
+var ErrAborted = errors.New("import aborted")
+
+func errTooManyErrors(opts Options, summary *Summary) error {
+	if opts.OnError == OnErrorAbort {
+		last := summary.Errors[len(summary.Errors)-1]
+		return fmt.Errorf("%w at row %d: %s", ErrAborted, last.Row, last.Message)
+	}
+	return fmt.Errorf("%w after %d bad rows", ErrAborted, summary.RowsFailed)
+}
+
+// convertRow picks the planned columns out of a file row and converts them to their target kinds.
+func convertRow(plan *Plan, values []interface{}) ([]interface{}, *RowError) {
+	converted := make([]interface{}, len(plan.Targets))
+	for i, target := range plan.Targets {
+		v, err := ConvertValue(target.Kind, values[plan.indexes[i]])
+		if err != nil {
+			return nil, &RowError{Column: plan.Sources[i], Message: fmt.Sprintf("%v for column %q (%s)", err, target.Name, target.Kind)}
+		}
+		if v == nil && target.Required {
+			return nil, &RowError{Column: plan.Sources[i], Message: fmt.Sprintf("column %q cannot be null", target.Name)}
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
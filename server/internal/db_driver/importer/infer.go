@@ -0,0 +1,264 @@
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/records"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/google/uuid"
+)
+
+// Infer reads up to sampleSize rows to work out the kind of every column. Columns whose kind
+// the file already declares keep it. The rows read are returned as a preview.
+func Infer(src Source, sampleSize int) ([]Column, [][]interface{}, error) {
+	columns := append([]Column(nil), src.Columns()...)
+	typed := make([]bool, len(columns))
+	for i, col := range columns {
+		typed[i] = col.Kind != ""
+	}
+
+	var sample [][]interface{}
+	for len(sample) < sampleSize {
+		values, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var rowErr *RowError
+			if errors.As(err, &rowErr) {
+				continue
+			}
+			return nil, nil, err
+		}
+		sample = append(sample, values)
+		for i, value := range values {
+			if value == nil {
+				columns[i].Nullable = true
+				continue
+			}
+			if !typed[i] {
+				columns[i].Kind = mergeKinds(columns[i].Kind, kindOfValue(value))
+			}
+		}
+	}
+	for i := range columns {
+		if columns[i].Kind == "" {
+			// Only NULLs were seen, text is the safest choice.
+			columns[i].Kind = result.KindString
+			columns[i].Nullable = true
+		}
+	}
+	return columns, sample, nil
+}
+
+// mergeKinds widens the kind of a column to hold a value of another kind.
+func mergeKinds(a, b result.Kind) result.Kind {
+	switch {
+	case a == "" || a == b:
+		return b
+	case isOneOf(a, b, result.KindInteger, result.KindFloat):
+		return result.KindFloat
+	case isOneOf(a, b, result.KindDate, result.KindTimestamp):
+		return result.KindTimestamp
+	}
+	return result.KindString
+}
+
+func isOneOf(a, b, x, y result.Kind) bool {
+	return (a == x && b == y) || (a == y && b == x)
+}
+
+var (
+	timestampLayouts   = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04", "2006-01-02 15:04"}
+	timestampTZLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999Z0700", "2006-01-02 15:04:05.999999999 Z07:00"}
+)
+
+// kindOfValue returns the kind of a value read from a file. Text is checked for numbers and
+// booleans only when it comes from CSV, since JSON has its own types for those.
+func kindOfValue(value interface{}) result.Kind {
+	switch v := value.(type) {
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return result.KindInteger
+		}
+		return result.KindFloat
+	case bool:
+		return result.KindBoolean
+	case int64, int32, int, uint64:
+		return result.KindInteger
+	case float64, float32:
+		return result.KindFloat
+	case []byte:
+		return result.KindBinary
+	case map[string]interface{}, []interface{}, json.RawMessage:
+		return result.KindJSON
+	case string:
+		return kindOfText(v)
+	}
+	return result.KindString
+}
+
+func kindOfText(s string) result.Kind {
+	if s != strings.TrimSpace(s) {
+		return result.KindString
+	}
+	// Leading zeros are meaningful in codes such as ZIP or phone numbers, keep those as text.
+	if !strings.HasPrefix(strings.TrimPrefix(s, "-"), "0") || s == "0" {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return result.KindInteger
+		}
+	}
+	if isDecimalText(s) {
+		return result.KindFloat
+	}
+	switch strings.ToLower(s) {
+	case "true", "false":
+		return result.KindBoolean
+	}
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		return result.KindDate
+	}
+	for _, layout := range timestampLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return result.KindTimestamp
+		}
+	}
+	for _, layout := range timestampTZLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return result.KindTimestampTZ
+		}
+	}
+	if len(s) == 36 {
+		if _, err := uuid.Parse(s); err == nil {
+			return result.KindUUID
+		}
+	}
+	if (strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")) && json.Valid([]byte(s)) {
+		return result.KindJSON
+	}
+	return result.KindString
+}
+
+// isDecimalText reports whether s is a plain decimal number such as -12.5 or 1e6. Words like
+// NaN or Inf, which strconv accepts, are left as text.
+func isDecimalText(s string) bool {
+	if strings.ContainsAny(s, "nNiIxX_") {
+		return false
+	}
+	if digits := strings.TrimLeft(s, "-+"); strings.HasPrefix(digits, "0") && len(digits) > 1 && digits[1] != '.' {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// ConvertValue converts a value read from a file into the value bound for a column of the
+// given kind. CSV values are text; JSON and Parquet values may already be typed.
+func ConvertValue(kind result.Kind, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case json.Number:
+		switch kind {
+		case result.KindInteger, result.KindFloat, result.KindBoolean:
+			return convertText(kind, v.String())
+		}
+		return v.String(), nil
+	case int64:
+		if kind == result.KindInteger {
+			return v, nil
+		}
+		return ConvertValue(kind, json.Number(strconv.FormatInt(v, 10)))
+	case uint64:
+		if kind == result.KindInteger {
+			return v, nil
+		}
+		return ConvertValue(kind, json.Number(strconv.FormatUint(v, 10)))
+	case float64:
+		if kind == result.KindFloat {
+			return v, nil
+		}
+		return ConvertValue(kind, json.Number(strconv.FormatFloat(v, 'f', -1, 64)))
+	case bool:
+		return records.ConvertValue(kind, v)
+	case []byte:
+		if kind == result.KindBinary {
+			return v, nil
+		}
+		return convertText(kind, string(v))
+	case string:
+		return convertText(kind, v)
+	case json.RawMessage:
+		switch kind {
+		case result.KindJSON, result.KindDocument, result.KindArray, result.KindUnknown, result.KindString:
+			return string(v), nil
+		}
+		return nil, errors.New("expected a plain value, got a nested one")
+	case map[string]interface{}, []interface{}:
+		switch kind {
+		case result.KindJSON, result.KindDocument, result.KindArray, result.KindUnknown, result.KindString:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		}
+		return nil, errors.New("expected a plain value, got a nested one")
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", value)
+}
+
+func convertText(kind result.Kind, s string) (interface{}, error) {
+	switch kind {
+	case result.KindString, result.KindUnknown, result.KindDocument:
+		return s, nil
+	case result.KindJSON, result.KindArray:
+		// Text that already holds JSON is loaded as is, other text becomes a JSON string.
+		if json.Valid([]byte(s)) {
+			return s, nil
+		}
+		b, _ := json.Marshal(s)
+		return string(b), nil
+	case result.KindBinary:
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+			return b, nil
+		}
+		return []byte(s), nil
+	case result.KindInteger:
+		s = strings.TrimSpace(s)
+		// Spreadsheets write whole numbers as 12.0.
+		if trimmed := strings.TrimSuffix(strings.TrimRight(s, "0"), "."); strings.Contains(s, ".") && !strings.Contains(trimmed, ".") {
+			s = trimmed
+		}
+	case result.KindFloat, result.KindDecimal:
+		s = strings.TrimSpace(s)
+	case result.KindBoolean:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "yes", "y":
+			return true, nil
+		case "no", "n":
+			return false, nil
+		}
+	case result.KindTimestamp:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.Format("2006-01-02T15:04:05.999999999"), nil
+			}
+		}
+		fallthrough
+	case result.KindTimestampTZ:
+		for _, layout := range timestampTZLayouts[1:] {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.Format(time.RFC3339Nano), nil
+			}
+		}
+	}
+	return records.ConvertValue(kind, s)
+}
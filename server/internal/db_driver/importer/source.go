@@ -0,0 +1,600 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// File is an uploaded file. Parquet needs random access to read its footer.
+type File interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// Source reads the rows of a file. Next returns io.EOF after the last row and a *RowError
+// for a row that cannot be read but does not stop the rest of the file from being read.
+type Source interface {
+	// Columns lists the columns of the file. Kinds are only set for formats that carry types.
+	Columns() []Column
+	Next() ([]interface{}, error)
+	// Progress is the share of the file read so far, between 0 and 1.
+	Progress() float64
+}
+
+// ReadOptions controls how a file is parsed.
+type ReadOptions struct {
+	Delimiter rune   // CSV only, TSV always uses tabs
+	Header    bool   // CSV and TSV: the first line holds the column names
+	Null      string // CSV and TSV: text read as NULL, in addition to empty fields
+}
+
+// sampleRows is the number of JSON objects read ahead to find the columns of a JSON file.
+const sampleRows = 1000
+
+// Open returns a source reading the file in the given format.
+func Open(file File, size int64, format Format, opts ReadOptions) (Source, error) {
+	counter := &countingReader{r: file}
+	switch format {
+	case FormatCSV, FormatTSV:
+		if format == FormatTSV {
+			opts.Delimiter = '\t'
+		}
+		return newCSVSource(counter, size, opts)
+	case FormatJSON, FormatNDJSON:
+		return newJSONSource(counter, size, format == FormatNDJSON)
+	case FormatParquet:
+		return newParquetSource(file, size)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func fraction(read, size int64) float64 {
+	if size <= 0 {
+		return 0
+	}
+	return min(float64(read)/float64(size), 1)
+}
+
+// columnNames turns the names found in a file into usable, distinct column names.
+func columnNames(names []string) []Column {
+	columns := make([]Column, len(names))
+	seen := make(map[string]int, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = "column_" + strconv.Itoa(i+1)
+		}
+		if n := seen[strings.ToLower(name)]; n > 0 {
+			name += "_" + strconv.Itoa(n+1)
+		}
+		seen[strings.ToLower(name)]++
+		columns[i] = Column{Name: name}
+	}
+	return columns
+}
+
+// csvSource reads CSV or TSV. Every value is text, or nil for empty fields and the NULL marker.
+type csvSource struct {
+	reader  *csv.Reader
+	counter *countingReader
+	size    int64
+	null    string
+	columns []Column
+	first   []string // the first record when the file has no header
+}
+
+func newCSVSource(counter *countingReader, size int64, opts ReadOptions) (*csvSource, error) {
+	buffered := bufio.NewReader(counter)
+	// Spreadsheet programs often start UTF-8 files with a byte order mark.
+	if bom, err := buffered.Peek(3); err == nil && bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		buffered.Discard(3)
+	}
+	reader := csv.NewReader(buffered)
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = false
+
+	src := &csvSource{reader: reader, counter: counter, size: size, null: opts.Null}
+	record, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Header {
+		src.columns = columnNames(record)
+	} else {
+		src.columns = columnNames(make([]string, len(record)))
+		src.first = record
+	}
+	return src, nil
+}
+
+func (s *csvSource) Columns() []Column { return s.columns }
+
+func (s *csvSource) Next() ([]interface{}, error) {
+	record := s.first
+	s.first = nil
+	if record == nil {
+		var err error
+		record, err = s.reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &RowError{Message: parseErr.Err.Error()}
+			}
+			return nil, err
+		}
+	}
+	if len(record) != len(s.columns) {
+		return nil, &RowError{Message: fmt.Sprintf("expected %d fields, got %d", len(s.columns), len(record))}
+	}
+	values := make([]interface{}, len(record))
+	for i, field := range record {
+		if field == "" || (s.null != "" && field == s.null) {
+			continue
+		}
+		values[i] = field
+	}
+	return values, nil
+}
+
+func (s *csvSource) Progress() float64 { return fraction(s.counter.n, s.size) }
+
+// jsonSource reads a JSON array of objects, or one object per line. The columns are the keys
+// of the first objects in the order they appear; later objects may not add keys.
+type jsonSource struct {
+	counter *countingReader
+	size    int64
+	decoder *json.Decoder  // array of objects
+	lines   *bufio.Scanner // one object per line
+	columns []Column
+	index   map[string]int
+	pending [][]result.Field
+	done    bool
+}
+
+func newJSONSource(counter *countingReader, size int64, lineDelimited bool) (*jsonSource, error) {
+	src := &jsonSource{counter: counter, size: size, index: make(map[string]int)}
+	buffered := bufio.NewReader(counter)
+	if !lineDelimited {
+		// A JSON file may also hold one object per line, which reads like NDJSON.
+		for {
+			b, err := buffered.Peek(1)
+			if err != nil {
+				return nil, errors.New("the file is empty")
+			}
+			if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+				buffered.Discard(1)
+				continue
+			}
+			lineDelimited = b[0] != '['
+			break
+		}
+	}
+	if lineDelimited {
+		src.lines = bufio.NewScanner(buffered)
+		src.lines.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	} else {
+		src.decoder = json.NewDecoder(buffered)
+		src.decoder.UseNumber()
+		if _, err := src.decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for len(src.pending) < sampleRows {
+		fields, err := src.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var rowErr *RowError
+			if errors.As(err, &rowErr) {
+				// Keep the bad row in place so that it is reported with its row number.
+				src.pending = append(src.pending, nil)
+				continue
+			}
+			return nil, err
+		}
+		for _, field := range fields {
+			if _, ok := src.index[field.Key]; !ok {
+				src.index[field.Key] = len(names)
+				names = append(names, field.Key)
+			}
+		}
+		src.pending = append(src.pending, fields)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("the file holds no JSON objects")
+	}
+	src.columns = make([]Column, len(names))
+	for i, name := range names {
+		src.columns[i] = Column{Name: name}
+	}
+	return src, nil
+}
+
+// read returns the next object of the file with its keys in order.
+func (s *jsonSource) read() (result.Object, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	if s.decoder != nil {
+		if !s.decoder.More() {
+			s.done = true
+			return nil, io.EOF
+		}
+		object, err := decodeObject(s.decoder)
+		if err != nil {
+			// The decoder cannot find its way back into the array after a syntax error.
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return object, nil
+	}
+
+	for s.lines.Scan() {
+		line := bytes.TrimSpace(s.lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		object, err := decodeObject(decoder)
+		if err != nil {
+			return nil, &RowError{Message: "invalid JSON object: " + err.Error()}
+		}
+		return object, nil
+	}
+	s.done = true
+	if err := s.lines.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// decodeObject decodes a JSON object keeping the order of its keys.
+func decodeObject(decoder *json.Decoder) (result.Object, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object, got %v", token)
+	}
+	object := result.Object{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		value, err := jsonValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		object = append(object, result.Field{Key: token.(string), Value: value})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// jsonValue decodes a scalar JSON value. Objects and arrays are kept as raw JSON so that the
+// order of their keys survives the import.
+func jsonValue(raw json.RawMessage) (interface{}, error) {
+	if raw[0] == '{' || raw[0] == '[' {
+		return raw, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func (s *jsonSource) Columns() []Column { return s.columns }
+
+func (s *jsonSource) Next() ([]interface{}, error) {
+	var fields []result.Field
+	if len(s.pending) > 0 {
+		fields, s.pending = s.pending[0], s.pending[1:]
+		if fields == nil {
+			return nil, &RowError{Message: "invalid JSON object"}
+		}
+	} else {
+		object, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		fields = object
+	}
+
+	values := make([]interface{}, len(s.columns))
+	for _, field := range fields {
+		i, ok := s.index[field.Key]
+		if !ok {
+			return nil, &RowError{Column: field.Key, Message: fmt.Sprintf("field %q is not among the columns found in the first %d objects", field.Key, sampleRows)}
+		}
+		values[i] = field.Value
+	}
+	return values, nil
+}
+
+func (s *jsonSource) Progress() float64 { return fraction(s.counter.n, s.size) }
+
+// parquetSource reads a Parquet file. Column kinds come from the file schema, and values
+// are turned into the forms the rest of the import expects: temporal values and decimals
+// as text, nested values as JSON.
+type parquetSource struct {
+	reader  *parquet.Reader
+	fields  []parquet.Field
+	columns []Column
+	total   int64
+	read    int64
+}
+
+func newParquetSource(file io.ReaderAt, size int64) (*parquetSource, error) {
+	parquetFile, err := parquet.OpenFile(file, size)
+	if err != nil {
+		return nil, err
+	}
+	fields := parquetFile.Schema().Fields()
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name()
+	}
+	columns := columnNames(names)
+	for i, field := range fields {
+		columns[i].Kind, columns[i].Precision, columns[i].Scale = parquetKind(field)
+		columns[i].Nullable = field.Optional()
+	}
+	return &parquetSource{
+		reader:  parquet.NewReader(parquetFile),
+		fields:  fields,
+		columns: columns,
+		total:   parquetFile.NumRows(),
+	}, nil
+}
+
+func parquetKind(field parquet.Field) (result.Kind, *int64, *int64) {
+	if !field.Leaf() || field.Repeated() {
+		return result.KindJSON, nil, nil
+	}
+	logical := field.Type().LogicalType()
+	switch {
+	case logical == nil:
+	case logical.Date != nil:
+		return result.KindDate, nil, nil
+	case logical.Timestamp != nil:
+		if logical.Timestamp.IsAdjustedToUTC {
+			return result.KindTimestampTZ, nil, nil
+		}
+		return result.KindTimestamp, nil, nil
+	case logical.Time != nil:
+		return result.KindTime, nil, nil
+	case logical.Decimal != nil:
+		precision, scale := int64(logical.Decimal.Precision), int64(logical.Decimal.Scale)
+		return result.KindDecimal, &precision, &scale
+	case logical.UUID != nil:
+		return result.KindUUID, nil, nil
+	case logical.Json != nil, logical.Bson != nil:
+		return result.KindJSON, nil, nil
+	case logical.UTF8 != nil, logical.Enum != nil:
+		return result.KindString, nil, nil
+	case logical.Integer != nil:
+		return result.KindInteger, nil, nil
+	}
+	switch field.Type().Kind() {
+	case parquet.Boolean:
+		return result.KindBoolean, nil, nil
+	case parquet.Int32, parquet.Int64:
+		return result.KindInteger, nil, nil
+	case parquet.Float, parquet.Double:
+		return result.KindFloat, nil, nil
+	}
+	return result.KindBinary, nil, nil
+}
+
+func (s *parquetSource) Columns() []Column { return s.columns }
+
+func (s *parquetSource) Next() ([]interface{}, error) {
+	row := make(map[string]interface{}, len(s.fields))
+	if err := s.reader.Read(&row); err != nil {
+		return nil, err
+	}
+	s.read++
+	values := make([]interface{}, len(s.fields))
+	for i, field := range s.fields {
+		value, err := parquetValue(field, s.columns[i], row[field.Name()])
+		if err != nil {
+			return nil, &RowError{Column: s.columns[i].Name, Message: err.Error()}
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (s *parquetSource) Progress() float64 { return fraction(s.read, s.total) }
+
+func parquetValue(field parquet.Field, col Column, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	var logical *format.LogicalType
+	if field.Leaf() {
+		logical = field.Type().LogicalType()
+	}
+	switch col.Kind {
+	case result.KindDate:
+		if days, ok := toInt64(value); ok {
+			return time.Unix(days*86400, 0).UTC().Format("2006-01-02"), nil
+		}
+	case result.KindTimestamp, result.KindTimestampTZ:
+		if t, ok := value.(time.Time); ok {
+			return formatTimestamp(col.Kind, t), nil
+		}
+		if n, ok := toInt64(value); ok {
+			unit := logical.Timestamp.Unit
+			var t time.Time
+			switch {
+			case unit.Millis != nil:
+				t = time.UnixMilli(n)
+			case unit.Nanos != nil:
+				t = time.Unix(0, n)
+			default:
+				t = time.UnixMicro(n)
+			}
+			return formatTimestamp(col.Kind, t), nil
+		}
+	case result.KindTime:
+		if n, ok := toInt64(value); ok {
+			unit := logical.Time.Unit
+			var d time.Duration
+			switch {
+			case unit.Millis != nil:
+				d = time.Duration(n) * time.Millisecond
+			case unit.Nanos != nil:
+				d = time.Duration(n)
+			default:
+				d = time.Duration(n) * time.Microsecond
+			}
+			return time.Time{}.Add(d).Format("15:04:05.999999"), nil
+		}
+	case result.KindDecimal:
+		var unscaled *big.Int
+		if n, ok := toInt64(value); ok {
+			unscaled = big.NewInt(n)
+		} else if b, ok := toBytes(value); ok {
+			unscaled = twosComplement(b)
+		}
+		if unscaled != nil {
+			return scaleDecimal(unscaled, int(*col.Scale)), nil
+		}
+	case result.KindUUID:
+		if b, ok := toBytes(value); ok && len(b) == 16 {
+			return uuid.UUID(b).String(), nil
+		}
+	case result.KindJSON:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case result.KindBinary:
+		if b, ok := toBytes(value); ok {
+			return b, nil
+		}
+	case result.KindFloat:
+		if f, ok := value.(float32); ok {
+			return float64(f), nil
+		}
+		return value, nil
+	case result.KindInteger:
+		if n, ok := toInt64(value); ok {
+			return n, nil
+		}
+		return value, nil
+	default:
+		if b, ok := value.([]byte); ok {
+			return string(b), nil
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unexpected %T value for a %s column", value, col.Kind)
+}
+
+func formatTimestamp(kind result.Kind, t time.Time) string {
+	if kind == result.KindTimestampTZ {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.999999999")
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func toBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	case [16]byte:
+		return v[:], true
+	}
+	return nil, false
+}
+
+// twosComplement reads a big-endian two's complement integer, as Parquet stores wide decimals.
+func twosComplement(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
+
+// scaleDecimal writes an unscaled decimal with the given number of fraction digits.
+func scaleDecimal(unscaled *big.Int, scale int) string {
+	if scale <= 0 {
+		return unscaled.String()
+	}
+	digits := new(big.Int).Abs(unscaled).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	text := digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	if unscaled.Sign() < 0 {
+		text = "-" + text
+	}
+	return text
+}
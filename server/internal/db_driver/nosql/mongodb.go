@@ -2,6 +2,7 @@ package nosql
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"strings"
@@ -9,14 +10,21 @@ import (
 
 	"github.com/cprakhar/datawhiz/config"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/db_driver/plan"
+	"github.com/cprakhar/datawhiz/internal/db_driver/records"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tlsconfig"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 )
 
 // PingMongoDB pings the MongoDB server to check if it's reachable.
-func PingMongoDB(pool *mongo.Client) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func PingMongoDB(ctx context.Context, pool *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	err := pool.Ping(ctx, nil)
@@ -27,18 +35,49 @@ func PingMongoDB(pool *mongo.Client) error {
 }
 
 // NewMongoDBClient creates a new MongoDB pool with the provided connection string.
-func NewMongoDBPool(dbCfg *config.DBConfig, connStr string) (*mongo.Client, error) {
+func NewMongoDBPool(ctx context.Context, dbCfg *config.DBConfig, connStr string, settings *schema.PoolSettings, tlsSettings *schema.TLSSettings) (*mongo.Client, error) {
 	poolOpts := options.Client().ApplyURI(connStr)
 	poolOpts.SetMaxPoolSize(uint64(dbCfg.MaxOpenConns))
 	poolOpts.SetMaxConnIdleTime(dbCfg.ConnMaxIdleTime)
+	if settings != nil {
+		if settings.StatementTimeout > 0 {
+			poolOpts.SetTimeout(time.Duration(settings.StatementTimeout) * time.Millisecond)
+		}
+		if settings.ApplicationName != "" {
+			poolOpts.SetAppName(settings.ApplicationName)
+		}
+		if settings.ReadPreference != "" {
+			mode, err := readpref.ModeFromString(settings.ReadPreference)
+			if err != nil {
+				return nil, err
+			}
+			readPref, err := readpref.New(mode)
+			if err != nil {
+				return nil, err
+			}
+			poolOpts.SetReadPreference(readPref)
+		}
+	}
+	if tlsSettings != nil {
+		// The driver verifies the name of each host it connects to when the configuration has none.
+		tlsConfig, err := tlsconfig.Config(tlsSettings, "")
+		if err != nil {
+			return nil, err
+		}
+		// A nil configuration turns off the TLS of the connection string.
+		poolOpts.SetTLSConfig(tlsConfig)
+	}
+	monitor := &poolMonitor{}
+	poolOpts.SetPoolMonitor(&event.PoolMonitor{Event: monitor.event})
 
 	pool, err := mongo.Connect(poolOpts)
 	if err != nil {
 		return nil, err
 	}
+	poolMonitors.Store(pool, monitor)
 
-	if err := PingMongoDB(pool); err != nil {
-		pool.Disconnect(context.Background())
+	if err := PingMongoDB(ctx, pool); err != nil {
+		CloseMongoDBPool(context.Background(), pool)
 		return nil, err
 	}
 
@@ -130,10 +169,7 @@ func ExtractMongoDBDetails(conn *schema.StringConnectionForm) (*schema.ManualCon
 }
 
 // GetMongoDBCollections retrieves all collections from all databases in the MongoDB pool.
-func GetMongoDBCollections(pool *mongo.Client, dbName string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
+func GetMongoDBCollections(ctx context.Context, pool *mongo.Client, dbName string) ([]string, error) {
 	var result []string
 	db := pool.Database(dbName)
 	colls, err := db.ListCollectionNames(ctx, bson.D{})
@@ -145,33 +181,571 @@ func GetMongoDBCollections(pool *mongo.Client, dbName string) ([]string, error)
 	return result, nil
 }
 
-// GetMongoDBCollectionRecords retrieves the records of a specific collection in the MongoDB database.
-func GetMongoDBCollectionRecords(pool *mongo.Client, dbName, collectionName string) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// GetMongoDBCollectionRecords retrieves at most limit records of a specific collection in the MongoDB database.
+// Documents have no fixed schema, so they are buffered and the header is the union of their fields
+// in order of first appearance; fields missing from a document are returned as null.
+func GetMongoDBCollectionRecords(ctx context.Context, pool *mongo.Client, dbName, collectionName string, limit int64) (result.Rows, error) {
+	col := pool.Database(dbName).Collection(collectionName)
+	cursor, err := col.Find(ctx, bson.D{}, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	return readMongoDocuments(ctx, cursor)
+}
 
+// GetMongoDBCollectionBatch retrieves at most limit documents of a collection in _id order, starting
+// after the document with the given _id, or from the first document when after is nil. The _id is
+// given as it was encoded in a result and converted back with the kind of the field.
+func GetMongoDBCollectionBatch(ctx context.Context, pool *mongo.Client, dbName, collectionName string, after interface{}, kind result.Kind, limit int64) (result.Rows, error) {
+	filter := bson.D{}
+	if after != nil {
+		id, err := mongoImportValue(kind, after)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}}
+	}
 	col := pool.Database(dbName).Collection(collectionName)
-	cursor, err := col.Find(ctx, bson.D{}, options.Find().SetLimit(100))
+	cursor, err := col.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit))
 	if err != nil {
 		return nil, err
 	}
+	return readMongoDocuments(ctx, cursor)
+}
+
+// CountMongoDBDocuments returns the estimated number of documents of a collection, taken from its metadata.
+func CountMongoDBDocuments(ctx context.Context, pool *mongo.Client, dbName, collectionName string) (int64, error) {
+	return pool.Database(dbName).Collection(collectionName).EstimatedDocumentCount(ctx)
+}
+
+// readMongoDocuments buffers the documents of a cursor as rows.
+func readMongoDocuments(ctx context.Context, cursor *mongo.Cursor) (result.Rows, error) {
 	defer cursor.Close(ctx)
 
-	var records []map[string]interface{}
+	var columns []result.Column
+	colIndex := make(map[string]int)
+	present := make(map[int]int) // column index -> number of documents with a non-null value
+	var rows [][]interface{}
 	for cursor.Next(ctx) {
-		var doc bson.M
-		if err := cursor.Decode(&doc); err != nil {
+		elems, err := cursor.Current.Elements()
+		if err != nil {
 			return nil, err
 		}
-		record := make(map[string]interface{})
-		for k, v := range doc {
-			record[k] = v
+		row := make([]interface{}, len(columns))
+		for _, elem := range elems {
+			var val interface{}
+			if err := elem.Value().Unmarshal(&val); err != nil {
+				return nil, err
+			}
+			idx, ok := colIndex[elem.Key()]
+			if !ok {
+				idx = len(columns)
+				colIndex[elem.Key()] = idx
+				columns = append(columns, mongoColumn(elem.Key(), elem.Value().Type))
+			} else if columns[idx].Kind == result.KindUnknown {
+				// The field was null where it first appeared; describe it by its first real value.
+				columns[idx] = mongoColumn(elem.Key(), elem.Value().Type)
+			}
+			for len(row) <= idx {
+				row = append(row, nil)
+			}
+			row[idx] = mongoValue(val)
+			if row[idx] != nil {
+				present[idx]++
+			}
 		}
-		records = append(records, record)
+		rows = append(rows, row)
 	}
 	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
 
-	return records, nil
+	// Pad rows that were read before later documents introduced new fields.
+	for i := range rows {
+		for len(rows[i]) < len(columns) {
+			rows[i] = append(rows[i], nil)
+		}
+	}
+	for i := range columns {
+		nullable := present[i] < len(rows)
+		columns[i].Nullable = &nullable
+	}
+	return result.NewSliceRows(columns, rows), nil
+}
+
+// mongoTypeNames maps BSON types to the aliases MongoDB uses in $type queries.
+var mongoTypeNames = map[bson.Type]struct {
+	name string
+	kind result.Kind
+}{
+	bson.TypeDouble:           {"double", result.KindFloat},
+	bson.TypeString:           {"string", result.KindString},
+	bson.TypeEmbeddedDocument: {"object", result.KindDocument},
+	bson.TypeArray:            {"array", result.KindArray},
+	bson.TypeBinary:           {"binData", result.KindBinary},
+	bson.TypeUndefined:        {"undefined", result.KindUnknown},
+	bson.TypeObjectID:         {"objectId", result.KindObjectID},
+	bson.TypeBoolean:          {"bool", result.KindBoolean},
+	bson.TypeDateTime:         {"date", result.KindTimestampTZ},
+	bson.TypeNull:             {"null", result.KindUnknown},
+	bson.TypeRegex:            {"regex", result.KindString},
+	bson.TypeDBPointer:        {"dbPointer", result.KindString},
+	bson.TypeJavaScript:       {"javascript", result.KindString},
+	bson.TypeSymbol:           {"symbol", result.KindString},
+	bson.TypeCodeWithScope:    {"javascriptWithScope", result.KindString},
+	bson.TypeInt32:            {"int", result.KindInteger},
+	bson.TypeTimestamp:        {"timestamp", result.KindDocument},
+	bson.TypeInt64:            {"long", result.KindInteger},
+	bson.TypeDecimal128:       {"decimal", result.KindDecimal},
+	bson.TypeMinKey:           {"minKey", result.KindString},
+	bson.TypeMaxKey:           {"maxKey", result.KindString},
+}
+
+func mongoColumn(name string, typ bson.Type) result.Column {
+	if t, ok := mongoTypeNames[typ]; ok {
+		return result.Column{Name: name, Type: t.name, Kind: t.kind}
+	}
+	return result.Column{Name: name, Type: typ.String(), Kind: result.KindUnknown}
+}
+
+// mongoValue converts a decoded BSON value into its JSON representation: ObjectIDs as hex,
+// decimals as exact strings, dates as RFC 3339, UUID binaries as UUID strings, other binaries
+// as base64 and documents with their field order preserved.
+func mongoValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil, bson.Null, bson.Undefined:
+		return nil
+	case bson.ObjectID:
+		return v.Hex()
+	case bson.Decimal128:
+		return v.String()
+	case bson.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case bson.Binary:
+		if (v.Subtype == bson.TypeBinaryUUID || v.Subtype == bson.TypeBinaryUUIDOld) && len(v.Data) == 16 {
+			return result.Encode(result.Column{Kind: result.KindUUID}, [16]byte(v.Data))
+		}
+		return v.Data
+	case bson.Timestamp:
+		return result.Object{{Key: "t", Value: v.T}, {Key: "i", Value: v.I}}
+	case bson.Regex:
+		return "/" + v.Pattern + "/" + v.Options
+	case bson.JavaScript:
+		return string(v)
+	case bson.Symbol:
+		return string(v)
+	case bson.MinKey:
+		return "MinKey"
+	case bson.MaxKey:
+		return "MaxKey"
+	case bson.D:
+		doc := make(result.Object, len(v))
+		for i, elem := range v {
+			doc[i] = result.Field{Key: elem.Key, Value: mongoValue(elem.Value)}
+		}
+		return doc
+	case bson.A:
+		values := make([]interface{}, len(v))
+		for i, elem := range v {
+			values[i] = mongoValue(elem)
+		}
+		return values
+	}
+	return result.Encode(result.Column{}, val)
+}
+
+// ExplainMongoDBQuery returns the plan of a command such as find, aggregate, count or distinct,
+// given as an Extended JSON document, e.g. {"find": "users", "filter": {"age": {"$gt": 30}}}.
+// With ANALYZE the command is explained with executionStats verbosity, which runs the query
+// but never applies writes.
+func ExplainMongoDBQuery(ctx context.Context, pool *mongo.Client, dbName, query string, opts plan.Options) (*plan.Plan, error) {
+	var command bson.D
+	if err := bson.UnmarshalExtJSON([]byte(query), false, &command); err != nil {
+		return nil, errors.New("query must be a MongoDB command document in Extended JSON: " + err.Error())
+	}
+	if len(command) == 0 {
+		return nil, errors.New("empty command document")
+	}
+
+	verbosity := "queryPlanner"
+	if opts.Analyze {
+		verbosity = "executionStats"
+	}
+	output, err := pool.Database(dbName).RunCommand(ctx, bson.D{
+		{Key: "explain", Value: command},
+		{Key: "verbosity", Value: verbosity},
+	}).Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	// Relaxed Extended JSON keeps numbers as plain JSON numbers, which is all the plan needs.
+	extJSON, err := bson.MarshalExtJSON(output, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(extJSON, &raw); err != nil {
+		return nil, err
+	}
+
+	p := &plan.Plan{Engine: "mongodb", Analyzed: opts.Analyze, Raw: raw}
+	p.Root, p.ExecutionTime = mongoExplainTree(raw)
+	if p.Root == nil {
+		return nil, errors.New("explain output has no plan")
+	}
+	if opts.Buffers {
+		p.Notes = append(p.Notes, "MongoDB does not report buffer usage")
+	}
+	p.Annotate()
+	return p, nil
+}
+
+// mongoExplainTree builds the plan tree of an explain output. Aggregations report a list of
+// pipeline stages whose first $cursor stage holds the plan of the underlying query.
+func mongoExplainTree(raw map[string]interface{}) (*plan.Node, *float64) {
+	if stages, ok := raw["stages"].([]interface{}); ok {
+		var root *plan.Node
+		var execTime *float64
+		for _, elem := range stages {
+			stage, ok := elem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for name, spec := range stage {
+				if !strings.HasPrefix(name, "$") {
+					continue
+				}
+				if name == "$cursor" {
+					if cursor, ok := spec.(map[string]interface{}); ok {
+						root, execTime = mongoExplainTree(cursor)
+					}
+					continue
+				}
+				node := &plan.Node{Operation: name, Detail: mongoDetail(spec)}
+				node.ActualRows = mongoNumber(stage, "nReturned")
+				node.ActualTime = mongoNumber(stage, "executionTimeMillisEstimate")
+				if root != nil {
+					node.Children = []*plan.Node{root}
+				}
+				root = node
+			}
+		}
+		if root != nil && root.ActualTime != nil {
+			execTime = root.ActualTime
+		}
+		return root, execTime
+	}
+
+	planner, _ := raw["queryPlanner"].(map[string]interface{})
+	collection := ""
+	if namespace, ok := planner["namespace"].(string); ok {
+		if _, coll, found := strings.Cut(namespace, "."); found {
+			collection = coll
+		}
+	}
+
+	if stats, ok := raw["executionStats"].(map[string]interface{}); ok {
+		if stages, ok := stats["executionStages"].(map[string]interface{}); ok {
+			return mongoStageNode(stages, collection), mongoNumber(stats, "executionTimeMillis")
+		}
+	}
+	if winning, ok := planner["winningPlan"].(map[string]interface{}); ok {
+		// Plans from the slot-based engine nest the stage tree under queryPlan.
+		if queryPlan, ok := winning["queryPlan"].(map[string]interface{}); ok {
+			winning = queryPlan
+		}
+		return mongoStageNode(winning, collection), nil
+	}
+	return nil, nil
+}
+
+func mongoStageNode(stage map[string]interface{}, collection string) *plan.Node {
+	node := &plan.Node{}
+	node.Operation, _ = stage["stage"].(string)
+	node.Index, _ = stage["indexName"].(string)
+	switch node.Operation {
+	case "COLLSCAN", "IXSCAN", "FETCH", "COUNT_SCAN", "DISTINCT_SCAN", "IDHACK", "EXPRESS_IXSCAN", "EXPRESS_CLUSTERED_IXSCAN":
+		node.Relation = collection
+	}
+	if filter, ok := stage["filter"]; ok {
+		node.Detail = "filter: " + mongoDetail(filter)
+	} else if keyPattern, ok := stage["keyPattern"]; ok {
+		node.Detail = "keyPattern: " + mongoDetail(keyPattern)
+	}
+
+	node.ActualRows = mongoNumber(stage, "nReturned")
+	node.ActualTime = mongoNumber(stage, "executionTimeMillisEstimate")
+	node.EstimatedRows = mongoNumber(stage, "cardinalityEstimate")
+	node.TotalCost = mongoNumber(stage, "costEstimate")
+	node.RowsExamined = mongoNumber(stage, "docsExamined")
+	if node.RowsExamined == nil {
+		node.RowsExamined = mongoNumber(stage, "keysExamined")
+	}
+	node.FullScan = node.Operation == "COLLSCAN"
+	if usedDisk, _ := stage["usedDisk"].(bool); usedDisk {
+		node.SpilledToDisk = true
+	}
+	for _, key := range []string{"works", "advanced", "needTime", "isEOF", "direction", "isMultiKey", "indexBounds", "memUsage", "sortPattern", "limitAmount"} {
+		if value, ok := stage[key]; ok {
+			node.SetProperty(key, value)
+		}
+	}
+
+	if input, ok := stage["inputStage"].(map[string]interface{}); ok {
+		node.Children = append(node.Children, mongoStageNode(input, collection))
+	}
+	if inputs, ok := stage["inputStages"].([]interface{}); ok {
+		for _, elem := range inputs {
+			if input, ok := elem.(map[string]interface{}); ok {
+				node.Children = append(node.Children, mongoStageNode(input, collection))
+			}
+		}
+	}
+	return node
+}
+
+func mongoNumber(obj map[string]interface{}, key string) *float64 {
+	if v, ok := obj[key].(float64); ok {
+		return plan.Float(v)
+	}
+	return nil
+}
+
+func mongoDetail(value interface{}) string {
+	detail, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(detail)
+}
+
+// ApplyMongoDBChanges applies a batch of changes to a collection. Documents are identified by
+// _id and values may use Extended JSON, e.g. {"$date": "..."}. The batch runs in a transaction
+// when the deployment supports them; standalone servers apply it in order without one.
+func ApplyMongoDBChanges(ctx context.Context, pool *mongo.Client, dbName, collectionName string, changes []records.Change) (*records.Summary, error) {
+	ops, err := mongoChanges(changes)
+	if err != nil {
+		return nil, err
+	}
+	col := pool.Database(dbName).Collection(collectionName)
+
+	session, err := pool.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(context.Background())
+
+	summary, err := session.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
+		return applyMongoChanges(txCtx, col, ops, true)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 20 { // IllegalOperation: transactions need a replica set
+		return applyMongoChanges(ctx, col, ops, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return summary.(*records.Summary), nil
+}
+
+type mongoChange struct {
+	op        records.Op
+	filter    bson.D
+	doc       bson.D
+	ambiguous bool // the filter may match both an ObjectID and a string _id
+}
+
+// mongoChanges validates a batch and converts its keys and values to BSON.
+func mongoChanges(changes []records.Change) ([]mongoChange, error) {
+	var errs records.ValidationErrors
+	ops := make([]mongoChange, len(changes))
+	for i, change := range changes {
+		fail := func(column, message string) {
+			errs = append(errs, records.FieldError{Index: i, Column: column, Message: message})
+		}
+		ops[i].op = change.Op
+
+		switch change.Op {
+		case records.OpInsert:
+			if len(change.Values) == 0 {
+				fail("", "insert needs at least one value")
+			}
+		case records.OpUpdate, records.OpDelete:
+			id, ok := change.Key["_id"]
+			if !ok || len(change.Key) != 1 || id == nil {
+				fail("_id", "documents are identified by their _id only")
+				continue
+			}
+			filter, ambiguous, err := mongoIDFilter(id)
+			if err != nil {
+				fail("_id", err.Error())
+				continue
+			}
+			ops[i].filter, ops[i].ambiguous = filter, ambiguous
+			if change.Op == records.OpUpdate {
+				if len(change.Values) == 0 {
+					fail("", "update needs at least one value")
+				}
+				if _, ok := change.Values["_id"]; ok {
+					fail("_id", "the _id of a document cannot be changed")
+				}
+			} else if len(change.Values) > 0 {
+				fail("", "deletes take a key only, not values")
+			}
+		default:
+			fail("", "unknown operation "+string(change.Op))
+			continue
+		}
+
+		if len(change.Values) > 0 {
+			doc, err := mongoDocument(change.Values)
+			if err != nil {
+				fail("", err.Error())
+				continue
+			}
+			ops[i].doc = doc
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ops, nil
+}
+
+// mongoDocument converts JSON values, which may use Extended JSON, to a BSON document.
+func mongoDocument(values map[string]interface{}) (bson.D, error) {
+	extJSON, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(extJSON, false, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// mongoIDFilter matches a document by _id. A plain 24 character hex string matches either
+// an ObjectID or a string _id, since JSON cannot tell them apart; such a filter is reported as
+// ambiguous, as it may match two documents.
+func mongoIDFilter(id interface{}) (bson.D, bool, error) {
+	if s, ok := id.(string); ok {
+		if oid, err := bson.ObjectIDFromHex(s); err == nil {
+			return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{oid, s}}}}}, true, nil
+		}
+	}
+	doc, err := mongoDocument(map[string]interface{}{"_id": id})
+	if err != nil {
+		return nil, false, err
+	}
+	return doc, false, nil
+}
+
+func applyMongoChanges(ctx context.Context, col *mongo.Collection, ops []mongoChange, atomic bool) (*records.Summary, error) {
+	summary := &records.Summary{Atomic: atomic}
+	for i, op := range ops {
+		if op.ambiguous {
+			// UpdateOne and DeleteOne would pick one of the documents an ambiguous _id matches.
+			matched, err := col.CountDocuments(ctx, op.filter, options.Count().SetLimit(2))
+			if err != nil {
+				return summary, &records.ChangeError{Index: i, Err: err}
+			}
+			if err := records.CheckAffected(i, matched); err != nil {
+				return summary, err
+			}
+		}
+		var affected int64
+		switch op.op {
+		case records.OpInsert:
+			if _, err := col.InsertOne(ctx, op.doc); err != nil {
+				return summary, &records.ChangeError{Index: i, Err: err}
+			}
+			affected = 1
+		case records.OpUpdate:
+			res, err := col.UpdateOne(ctx, op.filter, bson.D{{Key: "$set", Value: op.doc}})
+			if err != nil {
+				return summary, &records.ChangeError{Index: i, Err: err}
+			}
+			affected = res.MatchedCount
+		case records.OpDelete:
+			res, err := col.DeleteOne(ctx, op.filter)
+			if err != nil {
+				return summary, &records.ChangeError{Index: i, Err: err}
+			}
+			affected = res.DeletedCount
+		}
+		if err := records.CheckAffected(i, affected); err != nil {
+			return summary, err
+		}
+		summary.Count(op.op)
+	}
+	return summary, nil
+}
+
+// CreateMongoDBCollection creates the collection an import loads into.
+func CreateMongoDBCollection(ctx context.Context, pool *mongo.Client, dbName, collectionName string) error {
+	return pool.Database(dbName).CreateCollection(ctx, collectionName)
+}
+
+// NewMongoDBLoader returns a loader that inserts each batch of rows as documents with one
+// unordered InsertMany. Temporal values are stored as dates and decimals as Decimal128.
+func NewMongoDBLoader(pool *mongo.Client, dbName, collectionName string, columns []importer.Column) importer.Loader {
+	col := pool.Database(dbName).Collection(collectionName)
+	return func(ctx context.Context, rows [][]interface{}) error {
+		docs := make([]interface{}, len(rows))
+		for i, row := range rows {
+			doc := make(bson.D, 0, len(columns))
+			for j, value := range row {
+				v, err := mongoImportValue(columns[j].Kind, value)
+				if err != nil {
+					return err
+				}
+				doc = append(doc, bson.E{Key: columns[j].Name, Value: v})
+			}
+			docs[i] = doc
+		}
+		_, err := col.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		return err
+	}
+}
+
+func mongoImportValue(kind result.Kind, value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	switch kind {
+	case result.KindObjectID:
+		if id, err := bson.ObjectIDFromHex(s); err == nil {
+			return id, nil
+		}
+	case result.KindDecimal:
+		return bson.ParseDecimal128(s)
+	case result.KindDate:
+		return time.Parse("2006-01-02", s)
+	case result.KindTimestamp, result.KindTimestampTZ:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02T15:04:05.999999999", s)
+	case result.KindJSON, result.KindDocument, result.KindArray:
+		// Wrap the value so that arrays and scalars decode as well as documents.
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON([]byte(`{"v":`+s+`}`), false, &doc); err != nil {
+			return nil, err
+		}
+		return doc[0].Value, nil
+	}
+	return value, nil
+}
+
+// ValidateReadPreference checks a read preference mode such as primary or secondaryPreferred.
+// An empty mode keeps the one of the connection string.
+func ValidateReadPreference(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	_, err := readpref.ModeFromString(mode)
+	return err
 }
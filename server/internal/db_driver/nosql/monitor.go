@@ -0,0 +1,88 @@
+package nosql
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// MongoPoolStats counts the events of the connection pools of a MongoDB client since it connected.
+type MongoPoolStats struct {
+	OpenConnections   int64   `json:"openConnections"`
+	InUse             int64   `json:"inUse"`
+	Created           int64   `json:"created"`
+	Closed            int64   `json:"closed"`
+	CheckedOut        int64   `json:"checkedOut"`
+	CheckOutFailed    int64   `json:"checkOutFailed"`
+	PoolCleared       int64   `json:"poolCleared"`
+	AvgCheckOutWaitMs float64 `json:"avgCheckOutWaitMs"`
+	MaxCheckOutWaitMs float64 `json:"maxCheckOutWaitMs"`
+}
+
+type poolMonitor struct {
+	created, closed, checkedOut, checkedIn, checkOutFailed, cleared atomic.Int64
+	checkOutWait, maxCheckOutWait                                   atomic.Int64 // nanoseconds
+}
+
+// poolMonitors holds the monitor of every connected client, keyed by *mongo.Client.
+var poolMonitors sync.Map
+
+func (m *poolMonitor) event(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		m.created.Add(1)
+	case event.ConnectionClosed:
+		m.closed.Add(1)
+	case event.ConnectionCheckedOut:
+		m.checkedOut.Add(1)
+		wait := int64(e.Duration)
+		m.checkOutWait.Add(wait)
+		for {
+			current := m.maxCheckOutWait.Load()
+			if wait <= current || m.maxCheckOutWait.CompareAndSwap(current, wait) {
+				break
+			}
+		}
+	case event.ConnectionCheckedIn:
+		m.checkedIn.Add(1)
+	case event.ConnectionCheckOutFailed:
+		m.checkOutFailed.Add(1)
+	case event.ConnectionPoolCleared:
+		m.cleared.Add(1)
+	}
+}
+
+func (m *poolMonitor) stats() MongoPoolStats {
+	stats := MongoPoolStats{
+		Created:           m.created.Load(),
+		Closed:            m.closed.Load(),
+		CheckedOut:        m.checkedOut.Load(),
+		CheckOutFailed:    m.checkOutFailed.Load(),
+		PoolCleared:       m.cleared.Load(),
+		MaxCheckOutWaitMs: float64(m.maxCheckOutWait.Load()) / 1e6,
+	}
+	stats.OpenConnections = stats.Created - stats.Closed
+	stats.InUse = max(stats.CheckedOut-m.checkedIn.Load(), 0)
+	if stats.CheckedOut > 0 {
+		stats.AvgCheckOutWaitMs = float64(m.checkOutWait.Load()) / float64(stats.CheckedOut) / 1e6
+	}
+	return stats
+}
+
+// GetMongoPoolStats returns the pool statistics of a client created by NewMongoDBPool.
+func GetMongoPoolStats(client *mongo.Client) (MongoPoolStats, bool) {
+	monitor, ok := poolMonitors.Load(client)
+	if !ok {
+		return MongoPoolStats{}, false
+	}
+	return monitor.(*poolMonitor).stats(), true
+}
+
+// CloseMongoDBPool disconnects a client created by NewMongoDBPool and forgets its statistics.
+func CloseMongoDBPool(ctx context.Context, client *mongo.Client) error {
+	poolMonitors.Delete(client)
+	return client.Disconnect(ctx)
+}
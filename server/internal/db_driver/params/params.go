@@ -0,0 +1,115 @@
+package params
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A parameter is written {{name}} in a query and stands for a single value, so it cannot be used
+// inside a quoted string or in place of an identifier.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+var ErrMissing = errors.New("missing parameter values")
+
+// Names returns the parameters of a query in order of first appearance.
+func Names(query string) []string {
+	var names []string
+	for _, m := range placeholderPattern.FindAllStringSubmatch(query, -1) {
+		if !slices.Contains(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// Validate checks that every value is for a parameter of the query and is a plain value.
+func Validate(query string, values map[string]interface{}) error {
+	names := Names(query)
+	var unknown []string
+	for name, value := range values {
+		if !slices.Contains(names, name) {
+			unknown = append(unknown, name)
+			continue
+		}
+		if _, err := argument(value); err != nil {
+			return fmt.Errorf("parameter %q: %w", name, err)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("the query has no parameters %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Bind replaces the parameters of a query with the placeholders of the engine and returns the
+// arguments in order. Values override defaults; a parameter without either is an error.
+func Bind(query, dbType string, defaults, values map[string]interface{}) (string, []interface{}, error) {
+	var args []interface{}
+	var missing []string
+	var bindErr error
+	positions := make(map[string]int)
+	bound := placeholderPattern.ReplaceAllStringFunc(query, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		value, ok := values[name]
+		if !ok {
+			value, ok = defaults[name]
+		}
+		if !ok {
+			if !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return placeholder
+		}
+		arg, err := argument(value)
+		if err != nil {
+			bindErr = fmt.Errorf("parameter %q: %w", name, err)
+			return placeholder
+		}
+		if dbType == "postgresql" {
+			// Numbered placeholders can be used more than once.
+			if n, ok := positions[name]; ok {
+				return "$" + strconv.Itoa(n)
+			}
+			args = append(args, arg)
+			positions[name] = len(args)
+			return "$" + strconv.Itoa(len(args))
+		}
+		args = append(args, arg)
+		return "?"
+	})
+	if len(missing) > 0 {
+		return "", nil, fmt.Errorf("%w: %s", ErrMissing, strings.Join(missing, ", "))
+	}
+	if bindErr != nil {
+		return "", nil, bindErr
+	}
+	return bound, args, nil
+}
+
+// argument turns a value decoded from JSON into a query argument. Whole numbers are passed as
+// integers so that they compare with integer columns everywhere.
+func argument(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, string, bool, int64:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	}
+	return nil, errors.New("expected a string, number, boolean or null")
+}
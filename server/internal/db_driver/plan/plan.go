@@ -0,0 +1,176 @@
+package plan
+
+import (
+	"fmt"
+	"math"
+)
+
+// Thresholds for flagging hot spots in a plan.
+const (
+	slowShare         = 0.2  // share of the execution time spent in a single node
+	costShare         = 0.5  // share of the estimated cost of a single node when not analyzed
+	misestimateFactor = 10.0 // ratio between estimated and actual rows
+	largeScanRows     = 1000 // rows read by a full scan
+	examinedRatio     = 100  // rows examined per row returned
+)
+
+// Options controls how a plan is produced.
+type Options struct {
+	Analyze bool // execute the statement and report actual rows and timings
+	Buffers bool // report buffer usage, PostgreSQL only
+}
+
+// Node is an operation of a plan tree. Costs are in the engine's own units, times are in
+// milliseconds and row counts are per loop, as engines report them.
+type Node struct {
+	Operation     string                 `json:"operation"`
+	Relation      string                 `json:"relation,omitempty"`
+	Index         string                 `json:"index,omitempty"`
+	Detail        string                 `json:"detail,omitempty"`
+	StartupCost   *float64               `json:"startup_cost,omitempty"`
+	TotalCost     *float64               `json:"total_cost,omitempty"`
+	EstimatedRows *float64               `json:"estimated_rows,omitempty"`
+	ActualRows    *float64               `json:"actual_rows,omitempty"`
+	Loops         *float64               `json:"loops,omitempty"`
+	ActualTime    *float64               `json:"actual_time,omitempty"` // including children, over all loops
+	SelfTime      *float64               `json:"self_time,omitempty"`   // excluding children
+	RowsExamined  *float64               `json:"rows_examined,omitempty"`
+	FullScan      bool                   `json:"full_scan"`
+	SpilledToDisk bool                   `json:"spilled_to_disk"`
+	Properties    map[string]interface{} `json:"properties,omitempty"`
+	HotSpots      []string               `json:"hot_spots,omitempty"`
+	Children      []*Node                `json:"children,omitempty"`
+}
+
+// Plan is the normalized execution plan of a statement.
+type Plan struct {
+	Engine        string      `json:"engine"`
+	Analyzed      bool        `json:"analyzed"`
+	Root          *Node       `json:"root"`
+	TotalCost     *float64    `json:"total_cost,omitempty"`
+	PlanningTime  *float64    `json:"planning_time,omitempty"`
+	ExecutionTime *float64    `json:"execution_time,omitempty"`
+	HotSpotCount  int         `json:"hot_spot_count"`
+	Notes         []string    `json:"notes,omitempty"`
+	Raw           interface{} `json:"raw"` // the engine's own output
+}
+
+// Float returns a pointer to f, for the optional numbers of a node.
+func Float(f float64) *float64 {
+	return &f
+}
+
+// SetProperty records an engine-specific detail of the node.
+func (n *Node) SetProperty(key string, value interface{}) {
+	if n.Properties == nil {
+		n.Properties = make(map[string]interface{})
+	}
+	n.Properties[key] = value
+}
+
+// Annotate derives self times and flags hot spots. Parsers call it once the tree is built.
+func (p *Plan) Annotate() {
+	if p.Root == nil {
+		return
+	}
+	computeSelfTime(p.Root)
+
+	if p.TotalCost == nil && p.Root.TotalCost != nil {
+		p.TotalCost = p.Root.TotalCost
+	}
+	totalTime := p.ExecutionTime
+	if totalTime == nil {
+		totalTime = p.Root.ActualTime
+	}
+
+	p.HotSpotCount = 0
+	walk(p.Root, func(n *Node) {
+		n.HotSpots = nil
+		flagNode(n, p, totalTime)
+		p.HotSpotCount += len(n.HotSpots)
+	})
+}
+
+func computeSelfTime(n *Node) {
+	for _, child := range n.Children {
+		computeSelfTime(child)
+	}
+	if n.ActualTime == nil || n.SelfTime != nil {
+		return
+	}
+	self := *n.ActualTime
+	for _, child := range n.Children {
+		if child.ActualTime != nil {
+			self -= *child.ActualTime
+		}
+	}
+	n.SelfTime = Float(math.Max(self, 0))
+}
+
+func flagNode(n *Node, p *Plan, totalTime *float64) {
+	if n.SelfTime != nil && totalTime != nil && *totalTime > 0 && *n.SelfTime >= 1 {
+		if share := *n.SelfTime / *totalTime; share >= slowShare {
+			n.HotSpots = append(n.HotSpots, fmt.Sprintf("%.0f%% of execution time", share*100))
+		}
+	}
+
+	if !p.Analyzed && n.TotalCost != nil && p.TotalCost != nil && *p.TotalCost > 0 && len(n.Children) > 0 {
+		self := *n.TotalCost
+		for _, child := range n.Children {
+			if child.TotalCost != nil {
+				self -= *child.TotalCost
+			}
+		}
+		if share := self / *p.TotalCost; share >= costShare {
+			n.HotSpots = append(n.HotSpots, fmt.Sprintf("%.0f%% of estimated cost", share*100))
+		}
+	}
+
+	if n.EstimatedRows != nil && n.ActualRows != nil {
+		estimated, actual := math.Max(*n.EstimatedRows, 1), math.Max(*n.ActualRows, 1)
+		if factor := math.Max(estimated, actual) / math.Min(estimated, actual); factor >= misestimateFactor {
+			n.HotSpots = append(n.HotSpots, fmt.Sprintf("row estimate off by %.0fx (estimated %.0f, actual %.0f)",
+				factor, *n.EstimatedRows, *n.ActualRows))
+		}
+	}
+
+	if n.FullScan {
+		if rows := scannedRows(n); rows >= largeScanRows {
+			n.HotSpots = append(n.HotSpots, fmt.Sprintf("full scan of %.0f rows", rows))
+		}
+	}
+
+	if n.RowsExamined != nil && n.ActualRows != nil && *n.RowsExamined >= largeScanRows {
+		if *n.RowsExamined/math.Max(*n.ActualRows, 1) >= examinedRatio {
+			n.HotSpots = append(n.HotSpots, fmt.Sprintf("examined %.0f rows to return %.0f", *n.RowsExamined, *n.ActualRows))
+		}
+	}
+
+	if n.SpilledToDisk {
+		n.HotSpots = append(n.HotSpots, "spilled to disk")
+	}
+}
+
+// scannedRows is the best known number of rows read by a node.
+func scannedRows(n *Node) float64 {
+	loops := 1.0
+	if n.Loops != nil && *n.Loops > 0 {
+		loops = *n.Loops
+	}
+	switch {
+	case n.RowsExamined != nil:
+		return *n.RowsExamined * loops
+	case n.ActualRows != nil:
+		return *n.ActualRows * loops
+	case n.EstimatedRows != nil:
+		return *n.EstimatedRows * loops
+	}
+	return 0
+}
+
+func walk(n *Node, fn func(*Node)) {
+	fn(n)
+	for _, child := range n.Children {
+		walk(child, fn)
+	}
+}
@@ -0,0 +1,340 @@
+package records
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/google/uuid"
+)
+
+type Op string
+
+const (
+	OpInsert Op = "insert"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+)
+
+var ErrNoPrimaryKey = errors.New("table has no primary key; set allow_without_primary_key to match rows on the given columns")
+
+// Change is a single edit of a batch. Updates and deletes identify their row by Key, which
+// holds the primary key columns or the _id of a MongoDB document.
+type Change struct {
+	Op     Op                     `json:"op" binding:"required"`
+	Key    map[string]interface{} `json:"key,omitempty"`
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// Summary reports the outcome of a batch of changes.
+type Summary struct {
+	Inserted int64 `json:"inserted"`
+	Updated  int64 `json:"updated"`
+	Deleted  int64 `json:"deleted"`
+	Atomic   bool  `json:"atomic"` // whether the batch was applied in a single transaction
+}
+
+// Count records that a change was applied.
+func (s *Summary) Count(op Op) {
+	switch op {
+	case OpInsert:
+		s.Inserted++
+	case OpUpdate:
+		s.Updated++
+	case OpDelete:
+		s.Deleted++
+	}
+}
+
+// FieldError is a validation error for a value of a change.
+type FieldError struct {
+	Index   int    `json:"index"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors lists every invalid value of a batch, so that all of them can be fixed at once.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fmt.Sprintf("change %d: %s", fieldErr.Index, fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ChangeError reports a change that could not be applied, which rolls the batch back.
+type ChangeError struct {
+	Index int
+	Err   error
+}
+
+func (e *ChangeError) Error() string {
+	return fmt.Sprintf("change %d: %v", e.Index, e.Err)
+}
+
+func (e *ChangeError) Unwrap() error { return e.Err }
+
+var (
+	ErrRowNotFound  = errors.New("no row matches the key")
+	ErrAmbiguousKey = errors.New("the key matches more than one row")
+)
+
+// CheckAffected turns the number of rows touched by an update or delete into an error unless
+// exactly one row was affected.
+func CheckAffected(index int, affected int64) error {
+	switch {
+	case affected == 0:
+		return &ChangeError{Index: index, Err: ErrRowNotFound}
+	case affected > 1:
+		return &ChangeError{Index: index, Err: ErrAmbiguousKey}
+	}
+	return nil
+}
+
+// SortedKeys returns the keys of a value map in a stable order for building statements.
+func SortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate checks a batch against the schema of a SQL table and converts every value into
+// the form drivers expect. Keys must hold exactly the primary key columns; tables without a
+// primary key are refused unless allowNoPK is set, in which case a key may use any columns.
+func Validate(columns []schema.ColumnSchema, changes []Change, allowNoPK bool) ([]Change, error) {
+	byName := make(map[string]schema.ColumnSchema, len(columns))
+	var primaryKey []string
+	for _, col := range columns {
+		byName[col.Name] = col
+		if col.IsPrimaryKey {
+			primaryKey = append(primaryKey, col.Name)
+		}
+	}
+
+	needsKey := false
+	for _, change := range changes {
+		needsKey = needsKey || change.Op != OpInsert
+	}
+	if needsKey && len(primaryKey) == 0 && !allowNoPK {
+		return nil, ErrNoPrimaryKey
+	}
+
+	var errs ValidationErrors
+	validated := make([]Change, len(changes))
+	for i, change := range changes {
+		fail := func(column, format string, args ...interface{}) {
+			errs = append(errs, FieldError{Index: i, Column: column, Message: fmt.Sprintf(format, args...)})
+		}
+		out := Change{Op: change.Op}
+
+		switch change.Op {
+		case OpInsert:
+			if len(change.Key) > 0 {
+				fail("", "inserts take values only, not a key")
+			}
+			if len(change.Values) == 0 {
+				fail("", "insert needs at least one value")
+			}
+			// Columns without a default must be given, except primary keys which are often generated.
+			for _, col := range columns {
+				if _, ok := change.Values[col.Name]; !ok && !col.IsNullable && !col.IsPrimaryKey && !col.DefaultValue.Valid {
+					fail(col.Name, "column %q is required", col.Name)
+				}
+			}
+		case OpUpdate, OpDelete:
+			if len(change.Key) == 0 {
+				fail("", "%s needs a key", change.Op)
+			}
+			if len(primaryKey) > 0 {
+				for _, name := range primaryKey {
+					if _, ok := change.Key[name]; !ok {
+						fail(name, "key is missing primary key column %q", name)
+					}
+				}
+				for name := range change.Key {
+					if col, ok := byName[name]; ok && !col.IsPrimaryKey {
+						fail(name, "key column %q is not part of the primary key", name)
+					}
+				}
+			}
+			if change.Op == OpUpdate && len(change.Values) == 0 {
+				fail("", "update needs at least one value")
+			}
+			if change.Op == OpDelete && len(change.Values) > 0 {
+				fail("", "deletes take a key only, not values")
+			}
+		default:
+			fail("", "unknown operation %q", change.Op)
+			continue
+		}
+
+		convert := func(values map[string]interface{}, isKey bool) map[string]interface{} {
+			if values == nil {
+				return nil
+			}
+			converted := make(map[string]interface{}, len(values))
+			for name, value := range values {
+				col, ok := byName[name]
+				if !ok {
+					fail(name, "unknown column %q", name)
+					continue
+				}
+				if value == nil {
+					if isKey {
+						fail(name, "key column %q cannot be null", name)
+					} else if !col.IsNullable {
+						fail(name, "column %q is not nullable", name)
+					}
+					converted[name] = nil
+					continue
+				}
+				v, err := ConvertValue(result.KindOf(col.Type), value)
+				if err != nil {
+					fail(name, "column %q (%s): %v", name, col.Type, err)
+					continue
+				}
+				converted[name] = v
+			}
+			return converted
+		}
+		out.Key = convert(change.Key, true)
+		out.Values = convert(change.Values, false)
+		validated[i] = out
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return validated, nil
+}
+
+var timeLayouts = map[result.Kind][]string{
+	result.KindDate:        {"2006-01-02"},
+	result.KindTime:        {"15:04:05.999999999", "15:04", "15:04:05.999999999Z07:00"},
+	result.KindTimestamp:   {"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02", time.RFC3339Nano},
+	result.KindTimestampTZ: {time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"},
+}
+
+// ConvertValue checks a JSON value against the kind of its column and returns the value to
+// bind as a statement parameter. Exact numbers and temporal values are passed as text so that
+// the database parses them without loss.
+func ConvertValue(kind result.Kind, value interface{}) (interface{}, error) {
+	switch kind {
+	case result.KindInteger:
+		switch v := value.(type) {
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+				return nil, errors.New("expected an integer; pass large integers as strings")
+			}
+			return int64(v), nil
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n, nil
+			}
+			if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return n, nil
+			}
+		case bool:
+			// MySQL and SQLite store booleans as small integers.
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		return nil, errors.New("expected an integer")
+
+	case result.KindFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, errors.New("expected a number")
+
+	case result.KindDecimal:
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case string:
+			if _, ok := new(big.Float).SetString(v); ok || v == "NaN" {
+				return v, nil
+			}
+		}
+		return nil, errors.New("expected a decimal number")
+
+	case result.KindBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, errors.New("expected a boolean")
+
+	case result.KindString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return nil, errors.New("expected a string")
+
+	case result.KindDate, result.KindTime, result.KindTimestamp, result.KindTimestampTZ:
+		v, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected an ISO 8601 string")
+		}
+		for _, layout := range timeLayouts[kind] {
+			if _, err := time.Parse(layout, v); err == nil {
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid %s %q", kind, v)
+
+	case result.KindUUID:
+		if v, ok := value.(string); ok {
+			if _, err := uuid.Parse(v); err == nil {
+				return v, nil
+			}
+		}
+		return nil, errors.New("expected a UUID")
+
+	case result.KindBinary:
+		if v, ok := value.(string); ok {
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, errors.New("expected a base64 string")
+
+	case result.KindJSON:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	// Intervals, geometries, arrays and engine-specific types are left for the database to parse.
+	return value, nil
+}
@@ -0,0 +1,39 @@
+package result
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+)
+
+// DecodeJSON turns a value written by the JSON writers back into the form Encode produced.
+// Objects and arrays are kept as raw JSON so that their key order survives.
+func DecodeJSON(kind Kind, value json.RawMessage) (interface{}, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	switch value[0] {
+	case 'n':
+		return nil, nil
+	case 't', 'f':
+		return value[0] == 't', nil
+	case '"':
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, err
+		}
+		if kind == KindBinary {
+			return base64.StdEncoding.DecodeString(s)
+		}
+		return s, nil
+	case '{', '[':
+		return value, nil
+	}
+	if n, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+		return n, nil
+	}
+	if n, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+		return n, nil
+	}
+	return strconv.ParseFloat(string(value), 64)
+}
@@ -0,0 +1,230 @@
+package result
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cprakhar/datawhiz/utils/uuid"
+)
+
+const (
+	dateLayout      = "2006-01-02"
+	timeLayout      = "15:04:05.999999999"
+	timestampLayout = "2006-01-02T15:04:05.999999999"
+)
+
+// Object is a JSON object that keeps the order of its fields, such as a MongoDB document.
+type Object []Field
+
+// Field is a key/value pair of an Object.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func (o Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Encode converts a value read from a driver into its JSON representation for the column:
+//   - decimals stay exact as strings, and non-finite floats become "NaN", "Infinity" or "-Infinity"
+//   - dates, times and timestamps use ISO 8601; only zoned timestamps carry an offset
+//   - JSON columns are embedded as JSON rather than as a string
+//   - binary values are base64 strings and geometries are EWKT strings, e.g. "SRID=4326;POINT(1 2)"
+//   - array elements are encoded with the element type of the column
+//
+// Drivers that return engine-specific types convert them first and then call Encode.
+func Encode(col Column, val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return encodeBytes(col, v)
+	case string:
+		return encodeString(col, v)
+	case time.Time:
+		return encodeTime(col.Kind, v)
+	case float32:
+		return encodeFloat(float64(v))
+	case float64:
+		return encodeFloat(v)
+	case [16]byte:
+		return uuid.ConvertUUIDifPossible(v)
+	case []interface{}:
+		if col.Kind == KindJSON {
+			return v
+		}
+		elemCol := ElementColumn(col)
+		values := make([]interface{}, len(v))
+		for i, elem := range v {
+			values[i] = Encode(elemCol, elem)
+		}
+		return values
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		map[string]interface{}, json.RawMessage, Object:
+		return v
+	case driver.Valuer:
+		inner, err := v.Value()
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return Encode(col, inner)
+	case json.Marshaler, encoding.TextMarshaler:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return val
+}
+
+func encodeBytes(col Column, b []byte) interface{} {
+	switch col.Kind {
+	case KindBinary:
+		return b
+	case KindInteger:
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+			return n
+		}
+		// MySQL BIT columns are returned as big-endian bytes.
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	case KindFloat:
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return encodeFloat(f)
+		}
+	case KindJSON:
+		if json.Valid(b) {
+			return json.RawMessage(append([]byte(nil), b...))
+		}
+	case KindGeometry:
+		// MySQL prefixes the WKB of a geometry with its SRID as a little-endian uint32.
+		if len(b) > 4 {
+			srid := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+			if wkt, err := wkbToEWKT(b[4:], srid); err == nil {
+				return wkt
+			}
+		}
+		return b
+	case KindUnknown:
+		if !utf8.Valid(b) {
+			return b
+		}
+	}
+	return encodeString(col, string(b))
+}
+
+func encodeString(col Column, s string) interface{} {
+	switch col.Kind {
+	case KindTimestamp:
+		// MySQL and SQLite separate date and time with a space.
+		if len(s) > len(dateLayout) && s[len(dateLayout)] == ' ' {
+			return s[:len(dateLayout)] + "T" + s[len(dateLayout)+1:]
+		}
+	case KindGeometry:
+		// PostGIS geometries arrive as hex-encoded EWKB.
+		if b, err := hex.DecodeString(s); err == nil {
+			if wkt, err := wkbToEWKT(b, 0); err == nil {
+				return wkt
+			}
+		}
+	case KindJSON:
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	}
+	return s
+}
+
+func encodeTime(kind Kind, t time.Time) string {
+	switch kind {
+	case KindDate:
+		return t.Format(dateLayout)
+	case KindTime:
+		return t.Format(timeLayout)
+	case KindTimestamp:
+		return t.Format(timestampLayout)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func encodeFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+// FormatInterval formats an interval as an ISO 8601 duration, e.g. P1Y2M3DT4H5M6.5S.
+func FormatInterval(months int32, days int32, microseconds int64) string {
+	var b strings.Builder
+	b.WriteByte('P')
+	if years := months / 12; years != 0 {
+		fmt.Fprintf(&b, "%dY", years)
+	}
+	if months%12 != 0 {
+		fmt.Fprintf(&b, "%dM", months%12)
+	}
+	if days != 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if microseconds != 0 {
+		b.WriteByte('T')
+		hours := microseconds / int64(time.Hour/time.Microsecond)
+		microseconds -= hours * int64(time.Hour/time.Microsecond)
+		minutes := microseconds / int64(time.Minute/time.Microsecond)
+		microseconds -= minutes * int64(time.Minute/time.Microsecond)
+		if hours != 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+		}
+		if minutes != 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+		}
+		if microseconds != 0 {
+			seconds := strconv.FormatFloat(float64(microseconds)/1e6, 'f', -1, 64)
+			b.WriteString(seconds + "S")
+		}
+	}
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
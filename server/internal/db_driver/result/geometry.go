@@ -0,0 +1,244 @@
+package result
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errInvalidWKB = errors.New("invalid WKB geometry")
+
+const (
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+var geometryNames = map[uint32]string{
+	1: "POINT",
+	2: "LINESTRING",
+	3: "POLYGON",
+	4: "MULTIPOINT",
+	5: "MULTILINESTRING",
+	6: "MULTIPOLYGON",
+	7: "GEOMETRYCOLLECTION",
+}
+
+// wkbToEWKT converts a WKB or PostGIS EWKB geometry to EWKT. The SRID embedded in EWKB
+// takes precedence over the given one; an SRID of 0 is omitted.
+func wkbToEWKT(b []byte, srid uint32) (string, error) {
+	r := &wkbReader{buf: b}
+	var sb strings.Builder
+	if err := r.geometry(&sb, &srid, true); err != nil {
+		return "", err
+	}
+	if r.pos != len(b) {
+		return "", errInvalidWKB
+	}
+	if srid != 0 {
+		return "SRID=" + strconv.FormatUint(uint64(srid), 10) + ";" + sb.String(), nil
+	}
+	return sb.String(), nil
+}
+
+type wkbReader struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) uint32() (uint32, error) {
+	if r.pos+4 > len(r.buf) {
+		return 0, errInvalidWKB
+	}
+	v := r.order.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *wkbReader) float64() (float64, error) {
+	if r.pos+8 > len(r.buf) {
+		return 0, errInvalidWKB
+	}
+	v := math.Float64frombits(r.order.Uint64(r.buf[r.pos:]))
+	r.pos += 8
+	return v, nil
+}
+
+func (r *wkbReader) geometry(sb *strings.Builder, srid *uint32, top bool) error {
+	if r.pos >= len(r.buf) {
+		return errInvalidWKB
+	}
+	switch r.buf[r.pos] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return errInvalidWKB
+	}
+	r.pos++
+
+	typ, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	dims := 2
+	suffix := ""
+	if typ&ewkbZ != 0 {
+		dims++
+		suffix += "Z"
+	}
+	if typ&ewkbM != 0 {
+		dims++
+		suffix += "M"
+	}
+	if typ&ewkbSRID != 0 {
+		s, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		if top {
+			*srid = s
+		}
+	}
+	typ &^= ewkbZ | ewkbM | ewkbSRID
+	// ISO WKB encodes dimensions in the thousands of the type code.
+	switch typ / 1000 {
+	case 1:
+		dims, suffix = 3, "Z"
+	case 2:
+		dims, suffix = 3, "M"
+	case 3:
+		dims, suffix = 4, "ZM"
+	}
+	typ %= 1000
+
+	name, ok := geometryNames[typ]
+	if !ok {
+		return errInvalidWKB
+	}
+	sb.WriteString(name)
+	if suffix != "" {
+		sb.WriteString(" " + suffix)
+	}
+
+	switch typ {
+	case 1:
+		return r.point(sb, dims)
+	case 2:
+		return r.points(sb, dims)
+	case 3:
+		return r.rings(sb, dims)
+	}
+
+	count, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteByte('(')
+	for i := range count {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		// Members of multi geometries repeat their own header; only collections keep the type name.
+		var member strings.Builder
+		if err := r.geometry(&member, srid, false); err != nil {
+			return err
+		}
+		text := member.String()
+		if typ != 7 {
+			if i := strings.IndexByte(text, '('); i >= 0 {
+				text = text[i:]
+			} else {
+				text = "EMPTY"
+			}
+		}
+		sb.WriteString(text)
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+func (r *wkbReader) coords(sb *strings.Builder, dims int) (bool, error) {
+	empty := true
+	for i := range dims {
+		v, err := r.float64()
+		if err != nil {
+			return false, err
+		}
+		if !math.IsNaN(v) {
+			empty = false
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return empty, nil
+}
+
+func (r *wkbReader) point(sb *strings.Builder, dims int) error {
+	var coords strings.Builder
+	empty, err := r.coords(&coords, dims)
+	if err != nil {
+		return err
+	}
+	// An empty point is encoded with NaN coordinates.
+	if empty {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteString("(" + coords.String() + ")")
+	return nil
+}
+
+func (r *wkbReader) points(sb *strings.Builder, dims int) error {
+	count, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteByte('(')
+	for i := range count {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		if _, err := r.coords(sb, dims); err != nil {
+			return err
+		}
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+func (r *wkbReader) rings(sb *strings.Builder, dims int) error {
+	count, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		sb.WriteString(" EMPTY")
+		return nil
+	}
+	sb.WriteByte('(')
+	for i := range count {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		if err := r.points(sb, dims); err != nil {
+			return err
+		}
+	}
+	sb.WriteByte(')')
+	return nil
+}
@@ -0,0 +1,209 @@
+package result
+
+import "database/sql"
+
+// Column describes a column of a result set. Type is the engine's own type name; the
+// optional fields are only set when the driver reports them.
+type Column struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Kind      Kind   `json:"kind"`
+	Nullable  *bool  `json:"nullable,omitempty"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+	Length    *int64 `json:"length,omitempty"`
+}
+
+// Rows is a forward-only cursor over a result set with an ordered column header.
+type Rows interface {
+	Columns() []Column
+	Next() bool
+	Values() ([]interface{}, error)
+	Err() error
+	Close()
+}
+
+// Summary describes a result set once it has been written.
+type Summary struct {
+	RowCount  int64 `json:"row_count"`
+	Truncated bool  `json:"truncated"`
+}
+
+// Writer receives a result set incrementally.
+type Writer interface {
+	WriteHeader(columns []Column) error
+	WriteRow(values []interface{}) error
+	Close(summary Summary, err error) error
+}
+
+// Copy streams the rows into the writer and closes it. At most maxRows rows are written
+// when maxRows is positive; the summary reports whether the result set was truncated.
+func Copy(w Writer, rows Rows, maxRows int64) (Summary, error) {
+	var summary Summary
+	err := copyRows(w, rows, maxRows, &summary)
+	if closeErr := w.Close(summary, err); err == nil {
+		err = closeErr
+	}
+	return summary, err
+}
+
+func copyRows(w Writer, rows Rows, maxRows int64, summary *Summary) error {
+	if err := w.WriteHeader(rows.Columns()); err != nil {
+		return err
+	}
+	for rows.Next() {
+		if maxRows > 0 && summary.RowCount >= maxRows {
+			summary.Truncated = true
+			return nil
+		}
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+		if err := w.WriteRow(values); err != nil {
+			return err
+		}
+		summary.RowCount++
+	}
+	return rows.Err()
+}
+
+// Peek advances the cursor to its first row so that query errors surface before any output
+// is written. The returned cursor yields the peeked row first.
+func Peek(rows Rows) (Rows, error) {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		return &peekedRows{Rows: rows, exhausted: true}, nil
+	}
+	return &peekedRows{Rows: rows, pending: true}, nil
+}
+
+type peekedRows struct {
+	Rows
+	pending   bool
+	exhausted bool
+}
+
+func (r *peekedRows) Next() bool {
+	if r.pending {
+		r.pending = false
+		return true
+	}
+	if r.exhausted {
+		return false
+	}
+	return r.Rows.Next()
+}
+
+// SQLRows adapts *sql.Rows to Rows. The release function, if any, is called on Close.
+type SQLRows struct {
+	rows    *sql.Rows
+	columns []Column
+	release func()
+}
+
+// NewSQLRows wraps the rows of a database/sql query.
+func NewSQLRows(rows *sql.Rows, release func()) (*SQLRows, error) {
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	columns := make([]Column, len(colTypes))
+	for i, colType := range colTypes {
+		col := Column{Name: colType.Name(), Type: colType.DatabaseTypeName()}
+		col.Kind = KindOf(col.Type)
+		if nullable, ok := colType.Nullable(); ok {
+			col.Nullable = &nullable
+		}
+		if precision, scale, ok := colType.DecimalSize(); ok {
+			col.Precision, col.Scale = &precision, &scale
+		}
+		if length, ok := colType.Length(); ok {
+			col.Length = &length
+		}
+		setDeclaredSize(&col)
+		columns[i] = col
+	}
+	return &SQLRows{rows: rows, columns: columns, release: release}, nil
+}
+
+func (r *SQLRows) Columns() []Column { return r.columns }
+
+func (r *SQLRows) Next() bool { return r.rows.Next() }
+
+func (r *SQLRows) Values() ([]interface{}, error) {
+	values := make([]interface{}, len(r.columns))
+	valuePtrs := make([]interface{}, len(r.columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	if err := r.rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+	for i, val := range values {
+		values[i] = Encode(r.columns[i], val)
+	}
+	return values, nil
+}
+
+func (r *SQLRows) Err() error { return r.rows.Err() }
+
+func (r *SQLRows) Close() {
+	r.rows.Close()
+	if r.release != nil {
+		r.release()
+	}
+}
+
+// SliceRows is a Rows over values that are already in memory.
+type SliceRows struct {
+	columns []Column
+	rows    [][]interface{}
+	index   int
+}
+
+// NewSliceRows returns a cursor over the given rows.
+func NewSliceRows(columns []Column, rows [][]interface{}) *SliceRows {
+	return &SliceRows{columns: columns, rows: rows, index: -1}
+}
+
+func (r *SliceRows) Columns() []Column { return r.columns }
+
+func (r *SliceRows) Next() bool {
+	r.index++
+	return r.index < len(r.rows)
+}
+
+func (r *SliceRows) Values() ([]interface{}, error) { return r.rows[r.index], nil }
+
+func (r *SliceRows) Err() error { return nil }
+
+func (r *SliceRows) Close() {}
+
+// Collect reads at most maxRows rows into memory. It is meant for callers that need the whole
+// result set at once; handlers should stream instead. The cursor is left open so that a caller
+// can interrupt a truncated query before closing it.
+func Collect(rows Rows, maxRows int64) ([][]interface{}, Summary, error) {
+	var summary Summary
+	var data [][]interface{}
+	for rows.Next() {
+		if maxRows > 0 && summary.RowCount >= maxRows {
+			summary.Truncated = true
+			break
+		}
+		values, err := rows.Values()
+		if err != nil {
+			return nil, summary, err
+		}
+		data = append(data, values)
+		summary.RowCount++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, summary, err
+	}
+	return data, summary, nil
+}
@@ -0,0 +1,148 @@
+package result
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Kind is the engine-independent category of a column. Clients use it to render values
+// and exporters use it to pick a target type without knowing every engine's type names.
+type Kind string
+
+const (
+	KindInteger     Kind = "integer"
+	KindFloat       Kind = "float"
+	KindDecimal     Kind = "decimal"
+	KindBoolean     Kind = "boolean"
+	KindString      Kind = "string"
+	KindBinary      Kind = "binary"
+	KindDate        Kind = "date"
+	KindTime        Kind = "time"
+	KindTimestamp   Kind = "timestamp"
+	KindTimestampTZ Kind = "timestamptz"
+	KindInterval    Kind = "interval"
+	KindJSON        Kind = "json"
+	KindArray       Kind = "array"
+	KindUUID        Kind = "uuid"
+	KindGeometry    Kind = "geometry"
+	KindObjectID    Kind = "objectid"
+	KindDocument    Kind = "document"
+	KindUnknown     Kind = "unknown"
+)
+
+var sqlKinds = map[string]Kind{
+	"INT2": KindInteger, "INT4": KindInteger, "INT8": KindInteger, "INT": KindInteger,
+	"INTEGER": KindInteger, "SMALLINT": KindInteger, "TINYINT": KindInteger, "MEDIUMINT": KindInteger,
+	"BIGINT": KindInteger, "YEAR": KindInteger, "OID": KindInteger, "BIT": KindInteger,
+
+	"FLOAT4": KindFloat, "FLOAT8": KindFloat, "FLOAT": KindFloat, "DOUBLE": KindFloat,
+	"REAL": KindFloat, "DOUBLE PRECISION": KindFloat,
+
+	"NUMERIC": KindDecimal, "DECIMAL": KindDecimal, "MONEY": KindDecimal,
+
+	"BOOL": KindBoolean, "BOOLEAN": KindBoolean,
+
+	"TEXT": KindString, "VARCHAR": KindString, "CHAR": KindString, "BPCHAR": KindString,
+	"NAME": KindString, "CITEXT": KindString, "ENUM": KindString, "SET": KindString,
+	"TINYTEXT": KindString, "MEDIUMTEXT": KindString, "LONGTEXT": KindString, "CLOB": KindString,
+	"NCHAR": KindString, "NVARCHAR": KindString, "CHARACTER VARYING": KindString, "XML": KindString,
+	"INET": KindString, "CIDR": KindString, "MACADDR": KindString, "VARBIT": KindString,
+
+	"BYTEA": KindBinary, "BLOB": KindBinary, "TINYBLOB": KindBinary, "MEDIUMBLOB": KindBinary,
+	"LONGBLOB": KindBinary, "BINARY": KindBinary, "VARBINARY": KindBinary,
+
+	"CHARACTER": KindString, "ARRAY": KindArray,
+	"TIME WITHOUT TIME ZONE": KindTime, "TIME WITH TIME ZONE": KindTime,
+	"TIMESTAMP WITHOUT TIME ZONE": KindTimestamp, "TIMESTAMP WITH TIME ZONE": KindTimestampTZ,
+
+	"DATE":        KindDate,
+	"TIME":        KindTime,
+	"TIMETZ":      KindTime,
+	"TIMESTAMP":   KindTimestamp,
+	"DATETIME":    KindTimestamp,
+	"TIMESTAMPTZ": KindTimestampTZ,
+	"INTERVAL":    KindInterval,
+	"JSON":        KindJSON,
+	"JSONB":       KindJSON,
+	"UUID":        KindUUID,
+
+	"GEOMETRY": KindGeometry, "GEOGRAPHY": KindGeometry, "POINT": KindGeometry,
+	"LINESTRING": KindGeometry, "POLYGON": KindGeometry, "MULTIPOINT": KindGeometry,
+	"MULTILINESTRING": KindGeometry, "MULTIPOLYGON": KindGeometry,
+	"GEOMETRYCOLLECTION": KindGeometry, "GEOMCOLLECTION": KindGeometry,
+}
+
+// KindOf maps a SQL engine type name, as reported by PostgreSQL, MySQL or SQLite result sets
+// or information_schema, to its kind.
+func KindOf(typeName string) Kind {
+	name := strings.ToUpper(strings.TrimSpace(typeName))
+	if name == "" {
+		return KindUnknown
+	}
+	// PostgreSQL reports array types with a leading underscore, e.g. _int4.
+	if strings.HasPrefix(name, "_") || strings.HasSuffix(name, "[]") {
+		return KindArray
+	}
+	name = strings.TrimPrefix(name, "UNSIGNED ")
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	if kind, ok := sqlKinds[name]; ok {
+		return kind
+	}
+
+	// SQLite accepts any declared type, so fall back to its column affinity rules.
+	switch {
+	case strings.Contains(name, "INT"):
+		return KindInteger
+	case strings.Contains(name, "CHAR"), strings.Contains(name, "CLOB"), strings.Contains(name, "TEXT"):
+		return KindString
+	case strings.Contains(name, "BLOB"):
+		return KindBinary
+	case strings.Contains(name, "REAL"), strings.Contains(name, "FLOA"), strings.Contains(name, "DOUB"):
+		return KindFloat
+	}
+	return KindUnknown
+}
+
+// ElementColumn describes the elements of an array column.
+func ElementColumn(col Column) Column {
+	elemType := strings.TrimSuffix(strings.TrimPrefix(col.Type, "_"), "[]")
+	return Column{Name: col.Name, Type: elemType, Kind: KindOf(elemType)}
+}
+
+// setDeclaredSize fills in the size of a column from a declared type such as NUMERIC(10,2)
+// or VARCHAR(255), for drivers that only report the declaration.
+func setDeclaredSize(col *Column) {
+	open, end := strings.IndexByte(col.Type, '('), strings.IndexByte(col.Type, ')')
+	if open < 0 || end < open {
+		return
+	}
+	parts := strings.Split(col.Type[open+1:end], ",")
+	first, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return
+	}
+	switch col.Kind {
+	case KindDecimal, KindFloat:
+		if col.Precision == nil {
+			col.Precision = &first
+			if len(parts) > 1 {
+				if scale, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64); err == nil {
+					col.Scale = &scale
+				}
+			}
+		}
+	case KindString, KindBinary:
+		if col.Length == nil {
+			col.Length = &first
+		}
+	}
+}
+
+// DecimalSize returns the precision and scale declared in a type such as NUMERIC(10,2).
+func DecimalSize(typeName string) (precision, scale *int64) {
+	col := Column{Type: typeName, Kind: KindDecimal}
+	setDeclaredSize(&col)
+	return col.Precision, col.Scale
+}
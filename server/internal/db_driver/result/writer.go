@@ -0,0 +1,182 @@
+package result
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// flushEvery is the number of rows buffered before the output is flushed to the client.
+const flushEvery = 256
+
+// Fields are extra top-level fields written alongside a result set.
+type Fields map[string]interface{}
+
+// streamWriter buffers output and flushes it through to an http.Flusher when there is one.
+type streamWriter struct {
+	buf     *bufio.Writer
+	flusher http.Flusher
+	pending int
+}
+
+func newStreamWriter(w io.Writer) streamWriter {
+	flusher, _ := w.(http.Flusher)
+	return streamWriter{buf: bufio.NewWriter(w), flusher: flusher}
+}
+
+func (s *streamWriter) rowWritten() error {
+	s.pending++
+	if s.pending < flushEvery {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *streamWriter) flush() error {
+	s.pending = 0
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// writeFields writes the fields as `"key":value,` pairs in a stable order.
+func (s *streamWriter) writeFields(fields Fields) error {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := s.writeField(key, fields[key]); err != nil {
+			return err
+		}
+		s.buf.WriteByte(',')
+	}
+	return nil
+}
+
+func (s *streamWriter) writeField(key string, value interface{}) error {
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.buf.Write(encodedKey)
+	s.buf.WriteByte(':')
+	s.buf.Write(encodedValue)
+	return nil
+}
+
+// writeSummary writes the summary, the trailer fields and the error, if any, as the last fields of an object.
+func (s *streamWriter) writeSummary(summary Summary, trailer func() Fields, err error) error {
+	if trailer != nil {
+		if err := s.writeFields(trailer()); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		s.writeField("error", err.Error())
+		s.buf.WriteByte(',')
+	}
+	s.writeField("row_count", summary.RowCount)
+	s.buf.WriteByte(',')
+	return s.writeField("truncated", summary.Truncated)
+}
+
+// NDJSONWriter streams a result set as newline-delimited JSON: a header line with the
+// columns, one JSON array per row, and a trailer line with the summary.
+type NDJSONWriter struct {
+	streamWriter
+	Header  Fields
+	Trailer func() Fields
+}
+
+// NewNDJSONWriter returns a Writer that writes newline-delimited JSON to w.
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{streamWriter: newStreamWriter(w)}
+}
+
+func (n *NDJSONWriter) WriteHeader(columns []Column) error {
+	n.buf.WriteByte('{')
+	if err := n.writeFields(n.Header); err != nil {
+		return err
+	}
+	if err := n.writeField("columns", columns); err != nil {
+		return err
+	}
+	n.buf.WriteString("}\n")
+	return n.flush()
+}
+
+func (n *NDJSONWriter) WriteRow(values []interface{}) error {
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	n.buf.Write(encoded)
+	n.buf.WriteByte('\n')
+	return n.rowWritten()
+}
+
+func (n *NDJSONWriter) Close(summary Summary, err error) error {
+	n.buf.WriteByte('{')
+	if err := n.writeSummary(summary, n.Trailer, err); err != nil {
+		return err
+	}
+	n.buf.WriteString("}\n")
+	return n.flush()
+}
+
+// JSONWriter streams a result set as a single JSON object that is written in chunks:
+// the header fields and columns, the rows as JSON arrays, then the summary.
+type JSONWriter struct {
+	streamWriter
+	Header  Fields
+	Trailer func() Fields
+	rows    int64
+}
+
+// NewJSONWriter returns a Writer that writes a chunked JSON object to w.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{streamWriter: newStreamWriter(w)}
+}
+
+func (j *JSONWriter) WriteHeader(columns []Column) error {
+	j.buf.WriteByte('{')
+	if err := j.writeFields(j.Header); err != nil {
+		return err
+	}
+	if err := j.writeField("columns", columns); err != nil {
+		return err
+	}
+	j.buf.WriteString(`,"rows":[`)
+	return j.flush()
+}
+
+func (j *JSONWriter) WriteRow(values []interface{}) error {
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if j.rows > 0 {
+		j.buf.WriteByte(',')
+	}
+	j.buf.Write(encoded)
+	j.rows++
+	return j.rowWritten()
+}
+
+func (j *JSONWriter) Close(summary Summary, err error) error {
+	j.buf.WriteString("],")
+	if err := j.writeSummary(summary, j.Trailer, err); err != nil {
+		return err
+	}
+	j.buf.WriteByte('}')
+	return j.flush()
+}
@@ -0,0 +1,71 @@
+package dbdriver
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/nosql"
+	sql_ "github.com/cprakhar/datawhiz/internal/db_driver/sql"
+)
+
+// ValidatePoolSettings checks the pool settings of a connection before they are saved.
+func ValidatePoolSettings(settings *schema.PoolSettings, dbType string) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.MaxOpenConns < 0 || settings.MaxIdleConns < 0 || settings.ConnMaxLifetime < 0 ||
+		settings.ConnMaxIdleTime < 0 || settings.StatementTimeout < 0 {
+		return errors.New("pool sizes and durations cannot be negative")
+	}
+	if settings.MaxOpenConns > 0 && settings.MaxIdleConns > settings.MaxOpenConns {
+		return errors.New("max idle connections cannot exceed max open connections")
+	}
+
+	switch dbType {
+	case "postgresql":
+		if settings.ReadPreference != "" || len(settings.Pragmas) > 0 {
+			return errors.New("read preference and pragmas do not apply to PostgreSQL")
+		}
+	case "mysql":
+		if settings.SearchPath != "" || settings.ReadPreference != "" || len(settings.Pragmas) > 0 {
+			return errors.New("search path, read preference and pragmas do not apply to MySQL")
+		}
+	case "sqlite":
+		if settings.StatementTimeout > 0 || settings.ApplicationName != "" || settings.SearchPath != "" || settings.ReadPreference != "" {
+			return errors.New("only pool sizes and pragmas apply to SQLite")
+		}
+		return sql_.ValidateSQLitePragmas(settings.Pragmas)
+	case "mongodb":
+		if settings.SearchPath != "" || len(settings.Pragmas) > 0 {
+			return errors.New("search path and pragmas do not apply to MongoDB")
+		}
+		return nosql.ValidateReadPreference(settings.ReadPreference)
+	}
+	return nil
+}
+
+// withPoolSettings returns the pool configuration of the server with the overrides of a connection.
+func withPoolSettings(dbCfg *config.DBConfig, settings *schema.PoolSettings) *config.DBConfig {
+	if settings == nil {
+		return dbCfg
+	}
+	merged := *dbCfg
+	if settings.MaxOpenConns > 0 {
+		merged.MaxOpenConns = settings.MaxOpenConns
+	}
+	if settings.MaxIdleConns > 0 {
+		merged.MaxIdleConns = settings.MaxIdleConns
+	}
+	if settings.ConnMaxLifetime > 0 {
+		merged.ConnMaxLifetime = time.Duration(settings.ConnMaxLifetime) * time.Second
+	}
+	if settings.ConnMaxIdleTime > 0 {
+		merged.ConnMaxIdleTime = time.Duration(settings.ConnMaxIdleTime) * time.Second
+	}
+	if merged.MaxOpenConns > 0 {
+		merged.MaxIdleConns = min(merged.MaxIdleConns, merged.MaxOpenConns)
+	}
+	return &merged
+}
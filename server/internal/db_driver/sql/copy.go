@@ -0,0 +1,94 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// keysetQuery returns the statement reading the rows of a table in the order of its key columns,
+// starting after the row with the given key values, or from the first row when after is empty.
+// Without key columns the whole table is read in one statement.
+func (d dialect) keysetQuery(tableName string, keys []string, after []interface{}, limit int64) (string, []interface{}) {
+	query := "SELECT * FROM " + d.quote(tableName)
+	if len(keys) == 0 {
+		return query, nil
+	}
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = d.quote(key)
+	}
+	var args []interface{}
+	if len(after) > 0 {
+		placeholders := make([]string, len(after))
+		for i := range after {
+			placeholders[i] = d.placeholder(i + 1)
+		}
+		// Row values compare column by column, in the same order as the ORDER BY.
+		query += " WHERE (" + strings.Join(quoted, ", ") + ") > (" + strings.Join(placeholders, ", ") + ")"
+		args = after
+	}
+	query += " ORDER BY " + strings.Join(quoted, ", ")
+	if limit > 0 {
+		query += " LIMIT " + strconv.FormatInt(limit, 10)
+	}
+	return query, args
+}
+
+// GetPostgresTableBatch reads at most limit rows of a table in key order after the given key values.
+// Key values come back as they were encoded in a result, so they are sent as untyped literals
+// with the simple protocol and the server reads them as the type of their column.
+func GetPostgresTableBatch(ctx context.Context, pool *pgxpool.Pool, tableName string, keys []string, after []interface{}, limit int64) (result.Rows, error) {
+	query, args := postgresDialect.keysetQuery(tableName, keys, after, limit)
+	rows, err := pool.Query(ctx, query, append([]interface{}{pgx.QueryExecModeSimpleProtocol}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return newPostgresRows(ctx, pool, rows, nil), nil
+}
+
+// GetMySQLTableBatch reads at most limit rows of a table in key order after the given key values.
+func GetMySQLTableBatch(ctx context.Context, db *sql.DB, tableName string, keys []string, after []interface{}, limit int64) (result.Rows, error) {
+	query, args := mysqlDialect.keysetQuery(tableName, keys, after, limit)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result.NewSQLRows(rows, nil)
+}
+
+// GetSQLiteTableBatch reads at most limit rows of a table in key order after the given key values.
+func GetSQLiteTableBatch(ctx context.Context, db *sql.DB, tableName string, keys []string, after []interface{}, limit int64) (result.Rows, error) {
+	query, args := sqliteDialect.keysetQuery(tableName, keys, after, limit)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result.NewSQLRows(rows, nil)
+}
+
+// CountPostgresRows counts the rows of a table.
+func CountPostgresRows(ctx context.Context, pool *pgxpool.Pool, tableName string) (int64, error) {
+	var count int64
+	err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM "+postgresDialect.quote(tableName)).Scan(&count)
+	return count, err
+}
+
+// CountMySQLRows counts the rows of a table.
+func CountMySQLRows(ctx context.Context, db *sql.DB, tableName string) (int64, error) {
+	var count int64
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+mysqlDialect.quote(tableName)).Scan(&count)
+	return count, err
+}
+
+// CountSQLiteRows counts the rows of a table.
+func CountSQLiteRows(ctx context.Context, db *sql.DB, tableName string) (int64, error) {
+	var count int64
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+sqliteDialect.quote(tableName)).Scan(&count)
+	return count, err
+}
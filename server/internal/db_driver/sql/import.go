@@ -0,0 +1,447 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxMySQLPlaceholders is the most parameters a MySQL prepared statement accepts.
+const maxMySQLPlaceholders = 65535
+
+var postgresTypes = map[result.Kind]string{
+	result.KindInteger:     "BIGINT",
+	result.KindFloat:       "DOUBLE PRECISION",
+	result.KindDecimal:     "NUMERIC",
+	result.KindBoolean:     "BOOLEAN",
+	result.KindBinary:      "BYTEA",
+	result.KindDate:        "DATE",
+	result.KindTime:        "TIME",
+	result.KindTimestamp:   "TIMESTAMP",
+	result.KindTimestampTZ: "TIMESTAMPTZ",
+	result.KindInterval:    "INTERVAL",
+	result.KindJSON:        "JSONB",
+	result.KindDocument:    "JSONB",
+	result.KindArray:       "JSONB",
+	result.KindUUID:        "UUID",
+}
+
+var mysqlTypes = map[result.Kind]string{
+	result.KindInteger:     "BIGINT",
+	result.KindFloat:       "DOUBLE",
+	result.KindDecimal:     "DECIMAL(38,10)",
+	result.KindBoolean:     "BOOLEAN",
+	result.KindString:      "LONGTEXT",
+	result.KindBinary:      "LONGBLOB",
+	result.KindDate:        "DATE",
+	result.KindTime:        "TIME(6)",
+	result.KindTimestamp:   "DATETIME(6)",
+	result.KindTimestampTZ: "DATETIME(6)",
+	result.KindJSON:        "JSON",
+	result.KindDocument:    "JSON",
+	result.KindArray:       "JSON",
+	result.KindUUID:        "CHAR(36)",
+}
+
+var sqliteTypes = map[result.Kind]string{
+	result.KindInteger:     "INTEGER",
+	result.KindFloat:       "REAL",
+	result.KindDecimal:     "NUMERIC",
+	result.KindBoolean:     "BOOLEAN",
+	result.KindBinary:      "BLOB",
+	result.KindDate:        "DATE",
+	result.KindTime:        "TIME",
+	result.KindTimestamp:   "DATETIME",
+	result.KindTimestampTZ: "DATETIME",
+	result.KindJSON:        "JSON",
+	result.KindDocument:    "JSON",
+	result.KindArray:       "JSON",
+}
+
+// columnType returns the type a new column of an import is created with.
+func columnType(types map[result.Kind]string, col importer.Column, decimalType func(p, s int64) string) string {
+	if col.Type != "" {
+		return col.Type
+	}
+	if col.Kind == result.KindDecimal && col.Precision != nil && decimalType != nil {
+		var scale int64
+		if col.Scale != nil {
+			scale = *col.Scale
+		}
+		return decimalType(*col.Precision, scale)
+	}
+	if typ, ok := types[col.Kind]; ok {
+		return typ
+	}
+	return "TEXT"
+}
+
+func decimalType(precision, scale int64) string {
+	return "DECIMAL(" + strconv.FormatInt(precision, 10) + "," + strconv.FormatInt(scale, 10) + ")"
+}
+
+// createTableStatement builds the CREATE TABLE statement for the columns of an import.
+func (d dialect) createTableStatement(tableName string, columns []importer.Column, types map[result.Kind]string) string {
+	definitions := make([]string, 0, len(columns)+1)
+	var keys []string
+	for _, col := range columns {
+		definition := d.quote(col.Name) + " " + columnType(types, col, decimalType)
+		if !col.Nullable {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, definition)
+		if col.PrimaryKey {
+			keys = append(keys, d.quote(col.Name))
+		}
+	}
+	if len(keys) > 0 {
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	return "CREATE TABLE " + d.quote(tableName) + " (" + strings.Join(definitions, ", ") + ")"
+}
+
+// mysqlKeyColumns gives key columns without an explicit type a type MySQL can index, since
+// it cannot put a primary key on LONGTEXT or LONGBLOB.
+func mysqlKeyColumns(columns []importer.Column) []importer.Column {
+	keyed := make([]importer.Column, len(columns))
+	for i, col := range columns {
+		if col.PrimaryKey && col.Type == "" {
+			if col.Kind == result.KindBinary {
+				col.Type = "VARBINARY(255)"
+			} else if _, ok := mysqlTypes[col.Kind]; !ok || col.Kind == result.KindString {
+				col.Type = "VARCHAR(255)"
+			}
+		}
+		keyed[i] = col
+	}
+	return keyed
+}
+
+// PostgresTableDDL returns the CREATE TABLE statement of a table with the given columns.
+func PostgresTableDDL(tableName string, columns []importer.Column) string {
+	return postgresDialect.createTableStatement(tableName, columns, postgresTypes)
+}
+
+// MySQLTableDDL returns the CREATE TABLE statement of a table with the given columns.
+func MySQLTableDDL(tableName string, columns []importer.Column) string {
+	return mysqlDialect.createTableStatement(tableName, mysqlKeyColumns(columns), mysqlTypes)
+}
+
+// SQLiteTableDDL returns the CREATE TABLE statement of a table with the given columns.
+func SQLiteTableDDL(tableName string, columns []importer.Column) string {
+	return sqliteDialect.createTableStatement(tableName, columns, sqliteTypes)
+}
+
+// CreatePostgresTable creates the table an import loads into.
+func CreatePostgresTable(ctx context.Context, pool *pgxpool.Pool, tableName string, columns []importer.Column) error {
+	_, err := pool.Exec(ctx, PostgresTableDDL(tableName, columns))
+	return err
+}
+
+// CreateMySQLTable creates the table an import loads into.
+func CreateMySQLTable(ctx context.Context, pool *sql.DB, tableName string, columns []importer.Column) error {
+	_, err := pool.ExecContext(ctx, MySQLTableDDL(tableName, columns))
+	return err
+}
+
+// CreateSQLiteTable creates the table an import loads into.
+func CreateSQLiteTable(ctx context.Context, db *sql.DB, tableName string, columns []importer.Column) error {
+	_, err := db.ExecContext(ctx, SQLiteTableDDL(tableName, columns))
+	return err
+}
+
+// GetPostgresColumnTypes returns the columns of a table in order with their full type names,
+// modifiers included, such as numeric(10,2) or character varying(255).
+func GetPostgresColumnTypes(ctx context.Context, pool *pgxpool.Pool, tableName string) ([]importer.Column, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT a.attname, format_type(a.atttypid, a.atttypmod) FROM pg_attribute a "+
+			"JOIN pg_class c ON c.oid = a.attrelid JOIN pg_namespace n ON n.oid = c.relnamespace "+
+			"WHERE n.nspname = 'public' AND c.relname = $1 AND a.attnum > 0 AND NOT a.attisdropped "+
+			"ORDER BY a.attnum", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []importer.Column
+	for rows.Next() {
+		var col importer.Column
+		if err := rows.Scan(&col.Name, &col.Type); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// GetMySQLColumnTypes returns the columns of a table in order with their full type names,
+// such as decimal(10,2) or int unsigned.
+func GetMySQLColumnTypes(ctx context.Context, pool *sql.DB, tableName string) ([]importer.Column, error) {
+	return scanColumnTypes(pool.QueryContext(ctx,
+		"SELECT column_name, column_type FROM information_schema.columns "+
+			"WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position", tableName))
+}
+
+// GetSQLiteColumnTypes returns the columns of a table in order with their declared types.
+func GetSQLiteColumnTypes(ctx context.Context, db *sql.DB, tableName string) ([]importer.Column, error) {
+	return scanColumnTypes(db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?) ORDER BY cid", tableName))
+}
+
+func scanColumnTypes(rows *sql.Rows, err error) ([]importer.Column, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []importer.Column
+	for rows.Next() {
+		var col importer.Column
+		if err := rows.Scan(&col.Name, &col.Type); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+func columnNames(columns []importer.Column) []string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// NewPostgresLoader returns a loader that copies rows into a table with the COPY protocol.
+func NewPostgresLoader(pool *pgxpool.Pool, tableName string, columns []importer.Column) importer.Loader {
+	names := columnNames(columns)
+	typeMap := pgtype.NewMap()
+	var oids []uint32
+	return func(ctx context.Context, rows [][]interface{}) error {
+		if oids == nil {
+			var err error
+			if oids, err = postgresColumnOIDs(ctx, pool, tableName, names); err != nil {
+				return err
+			}
+		}
+		copyRows := make([][]interface{}, len(rows))
+		for i, row := range rows {
+			values := make([]interface{}, len(row))
+			for j, value := range row {
+				v, err := postgresCopyValue(typeMap, oids[j], columns[j].Kind, value)
+				if err != nil {
+					return err
+				}
+				values[j] = v
+			}
+			copyRows[i] = values
+		}
+		_, err := pool.CopyFrom(ctx, pgx.Identifier{tableName}, names, pgx.CopyFromRows(copyRows))
+		return err
+	}
+}
+
+// postgresColumnOIDs looks up the types of the columns a loader copies into.
+func postgresColumnOIDs(ctx context.Context, pool *pgxpool.Pool, tableName string, names []string) ([]uint32, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = postgresDialect.quote(name)
+	}
+	rows, err := pool.Query(ctx, "SELECT "+strings.Join(quoted, ", ")+" FROM "+postgresDialect.quote(tableName)+" LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fields := rows.FieldDescriptions()
+	oids := make([]uint32, len(fields))
+	for i, field := range fields {
+		oids[i] = field.DataTypeOID
+	}
+	return oids, nil
+}
+
+// postgresCopyValue turns the text form of values into types pgx can send in the binary
+// format COPY uses.
+func postgresCopyValue(typeMap *pgtype.Map, oid uint32, kind result.Kind, value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	switch kind {
+	case result.KindDecimal:
+		var n pgtype.Numeric
+		if err := n.Scan(s); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case result.KindDate:
+		return time.Parse("2006-01-02", s)
+	case result.KindTime:
+		var t pgtype.Time
+		if err := t.Scan(s); err != nil {
+			return nil, err
+		}
+		return t, nil
+	case result.KindTimestamp:
+		for _, layout := range []string{"2006-01-02T15:04:05.999999999", time.RFC3339Nano} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Parse("2006-01-02", s)
+	case result.KindTimestampTZ:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02T15:04:05.999999999", s)
+	case result.KindUUID:
+		id, err := uuid.Parse(s)
+		return [16]byte(id), err
+	}
+	return postgresTextValue(typeMap, oid, s)
+}
+
+// postgresTextValue parses text with the codec of the column type, for types such as
+// interval, inet or arrays that pgx cannot send from a string in binary format. Text, JSON
+// and types pgx does not know, such as enums, are sent as they are.
+func postgresTextValue(typeMap *pgtype.Map, oid uint32, s string) (interface{}, error) {
+	typ, ok := typeMap.TypeForOID(oid)
+	if !ok || typeMap.PlanEncode(oid, pgtype.BinaryFormatCode, s) != nil {
+		return s, nil
+	}
+	if _, isArray := typ.Codec.(*pgtype.ArrayCodec); isArray {
+		if strings.HasPrefix(strings.TrimSpace(s), "[") {
+			literal, err := postgresArrayLiteral(s)
+			if err != nil {
+				return nil, err
+			}
+			s = literal
+		}
+		// Scanning into an Array keeps the dimensions of multidimensional arrays.
+		var array pgtype.Array[interface{}]
+		if err := typeMap.Scan(oid, pgtype.TextFormatCode, []byte(s), &array); err != nil {
+			return nil, err
+		}
+		return array, nil
+	}
+	var native interface{}
+	if err := typeMap.Scan(oid, pgtype.TextFormatCode, []byte(s), &native); err != nil {
+		return nil, err
+	}
+	return native, nil
+}
+
+// postgresArrayLiteral turns a JSON array, as arrays are encoded in results, into the text
+// form of a PostgreSQL array: [[1,2],[3,null]] becomes {{1,2},{3,NULL}}.
+func postgresArrayLiteral(s string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var elems []interface{}
+	if err := decoder.Decode(&elems); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	writePostgresArray(&b, elems)
+	return b.String(), nil
+}
+
+func writePostgresArray(b *strings.Builder, elems []interface{}) {
+	b.WriteByte('{')
+	for i, elem := range elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		switch v := elem.(type) {
+		case nil:
+			b.WriteString("NULL")
+		case []interface{}:
+			writePostgresArray(b, v)
+		case json.Number:
+			b.WriteString(v.String())
+		case bool:
+			b.WriteString(strconv.FormatBool(v))
+		default:
+			text, ok := v.(string)
+			if !ok {
+				raw, _ := json.Marshal(v)
+				text = string(raw)
+			}
+			b.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`)
+		}
+	}
+	b.WriteByte('}')
+}
+
+// NewMySQLLoader returns a loader that inserts rows with multi-row INSERT statements, each
+// within the placeholder limit of the server.
+func NewMySQLLoader(pool *sql.DB, tableName string, columns []importer.Column) importer.Loader {
+	return func(ctx context.Context, rows [][]interface{}) error {
+		perStatement := max(maxMySQLPlaceholders/len(columns), 1)
+		tx, err := pool.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for start := 0; start < len(rows); start += perStatement {
+			chunk := rows[start:min(start+perStatement, len(rows))]
+			query, args := mysqlDialect.insertStatement(tableName, columns, chunk)
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+}
+
+// NewSQLiteLoader returns a loader that inserts each batch in one transaction with a
+// prepared statement.
+func NewSQLiteLoader(db *sql.DB, tableName string, columns []importer.Column) importer.Loader {
+	query, _ := sqliteDialect.insertStatement(tableName, columns, make([][]interface{}, 1))
+	return func(ctx context.Context, rows [][]interface{}) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, row := range rows {
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+}
+
+// insertStatement builds an INSERT of one or more rows.
+func (d dialect) insertStatement(tableName string, columns []importer.Column, rows [][]interface{}) (string, []interface{}) {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = d.quote(col.Name)
+	}
+	var args []interface{}
+	tuples := make([]string, len(rows))
+	for i, row := range rows {
+		placeholders := make([]string, len(columns))
+		for j := range columns {
+			if row != nil {
+				args = append(args, row[j])
+			}
+			placeholders[j] = d.placeholder(i*len(columns) + j + 1)
+		}
+		tuples[i] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	return "INSERT INTO " + d.quote(tableName) + " (" + strings.Join(quoted, ", ") + ") VALUES " + strings.Join(tuples, ", "), args
+}
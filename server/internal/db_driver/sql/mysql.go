@@ -2,19 +2,28 @@ package sql
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cprakhar/datawhiz/config"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/cprakhar/datawhiz/internal/db_driver/plan"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tlsconfig"
+	"github.com/cprakhar/datawhiz/internal/executions"
+	"github.com/go-sql-driver/mysql"
 )
 
 // PingMySQL pings the MySQL database to check if it's reachable.
-func PingMySQL(pool *sql.DB) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func PingMySQL(ctx context.Context, pool *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if err := pool.PingContext(ctx); err != nil {
@@ -24,7 +33,33 @@ func PingMySQL(pool *sql.DB) error {
 }
 
 // NewMySQLClient creates a new MySQL pool with the provided connection string.
-func NewMySQLPool(dbCfg *config.DBConfig, connStr string) (*sql.DB, error) {
+func NewMySQLPool(ctx context.Context, dbCfg *config.DBConfig, connStr string, settings *schema.PoolSettings, tlsSettings *schema.TLSSettings) (*sql.DB, error) {
+	if settings != nil && (settings.StatementTimeout > 0 || settings.ApplicationName != "") || tlsSettings != nil {
+		mysqlCfg, err := mysql.ParseDSN(connStr)
+		if err != nil {
+			return nil, err
+		}
+		if settings != nil && settings.StatementTimeout > 0 {
+			// System variables in the parameters are set on every connection. MySQL only
+			// limits the execution time of SELECT statements.
+			if mysqlCfg.Params == nil {
+				mysqlCfg.Params = make(map[string]string)
+			}
+			mysqlCfg.Params["max_execution_time"] = strconv.Itoa(settings.StatementTimeout)
+		}
+		if settings != nil && settings.ApplicationName != "" {
+			mysqlCfg.ConnectionAttributes = "program_name:" + settings.ApplicationName
+		}
+		if tlsSettings != nil {
+			if mysqlCfg.TLSConfig, err = registerMySQLTLS(tlsSettings); err != nil {
+				return nil, err
+			}
+			// The configuration parsed from the tls parameter takes precedence over the named one.
+			mysqlCfg.TLS = nil
+		}
+		connStr = mysqlCfg.FormatDSN()
+	}
+
 	pool, err := sql.Open("mysql", connStr)
 	if err != nil {
 		return nil, err
@@ -36,13 +71,38 @@ func NewMySQLPool(dbCfg *config.DBConfig, connStr string) (*sql.DB, error) {
 	pool.SetConnMaxLifetime(dbCfg.ConnMaxLifetime)
 	pool.SetConnMaxIdleTime(dbCfg.ConnMaxIdleTime)
 
-	if err := PingMySQL(pool); err != nil {
+	if err := PingMySQL(ctx, pool); err != nil {
 		pool.Close()
 		return nil, err
 	}
 	return pool, nil
 }
 
+// registerMySQLTLS registers the TLS configuration of TLS settings with the driver and returns
+// the value of the tls parameter that selects it. Configurations are named after their settings,
+// so that pools with the same settings share one and activations do not grow the registry.
+func registerMySQLTLS(settings *schema.TLSSettings) (string, error) {
+	switch settings.Mode {
+	case tlsconfig.Disable:
+		return "false", nil
+	case tlsconfig.Prefer:
+		// The driver falls back to a plain connection when the server does not support TLS.
+		return "preferred", nil
+	}
+
+	// The driver verifies the host of the connection when the configuration has no server name.
+	tlsConfig, err := tlsconfig.Config(settings, "")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{settings.Mode, settings.CACert, settings.ClientCert, settings.ClientKey, settings.ServerName}, "\x00")))
+	name := "datawhiz-" + hex.EncodeToString(sum[:16])
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
 // CreateMySQLConnectionString constructs a MySQL connection string from the provided connection form.
 func CreateMySQLConnectionString(conn *schema.ManualConnectionForm) (string, error) {
 	if conn.Host == "" {
@@ -137,8 +197,8 @@ func ExtractMySQLDetails(conn *schema.StringConnectionForm) (*schema.ManualConne
 }
 
 // GetMySQLTables retrieves the list of tables in the MySQL database.
-func GetMySQLTables(pool *sql.DB) ([]string, error) {
-	rows, err := pool.Query("SHOW TABLES")
+func GetMySQLTables(ctx context.Context, pool *sql.DB) ([]string, error) {
+	rows, err := pool.QueryContext(ctx, "SHOW TABLES")
 	if err != nil {
 		return nil, err
 	}
@@ -161,13 +221,13 @@ func GetMySQLTables(pool *sql.DB) ([]string, error) {
 }
 
 // GetMySQLTableSchema retrieves the schema of a specific table in the MySQL database.
-func GetMySQLTableSchema(pool *sql.DB, tableName string) ([]schema.ColumnSchema, error) {
+func GetMySQLTableSchema(ctx context.Context, pool *sql.DB, tableName string) ([]schema.ColumnSchema, error) {
 	var columns []schema.ColumnSchema
 
 	//1. Query all columns
 	query := "SELECT column_name, data_type, is_nullable, column_default FROM information_schema.columns " +
 		"WHERE table_schema = DATABASE() AND table_name = ?"
-	rows, err := pool.Query(query, tableName)
+	rows, err := pool.QueryContext(ctx, query, tableName)
 	if err != nil {
 		return nil, err
 	}
@@ -177,7 +237,7 @@ func GetMySQLTableSchema(pool *sql.DB, tableName string) ([]schema.ColumnSchema,
 	pkQuery := "SELECT kcu.column_name FROM information_schema.table_constraints tc " +
 		"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name " +
 		"WHERE tc.table_schema = DATABASE() AND tc.table_name = ? AND tc.constraint_type = 'PRIMARY KEY'"
-	pkRows, err := pool.Query(pkQuery, tableName)
+	pkRows, err := pool.QueryContext(ctx, pkQuery, tableName)
 	if err != nil {
 		return nil, err
 	}
@@ -196,7 +256,7 @@ func GetMySQLTableSchema(pool *sql.DB, tableName string) ([]schema.ColumnSchema,
 		"FROM information_schema.table_constraints tc " +
 		"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name " +
 		"WHERE tc.table_schema = DATABASE() AND tc.table_name = ? AND tc.constraint_type = 'FOREIGN KEY'"
-	fkRows, err := pool.Query(fkQuery, tableName)
+	fkRows, err := pool.QueryContext(ctx, fkQuery, tableName)
 	if err != nil {
 		return nil, err
 	}
@@ -220,7 +280,7 @@ func GetMySQLTableSchema(pool *sql.DB, tableName string) ([]schema.ColumnSchema,
 	uniqueQuery := "SELECT kcu.column_name FROM information_schema.table_constraints tc " +
 		"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name " +
 		"WHERE tc.table_schema = DATABASE() AND tc.table_name = ? AND tc.constraint_type = 'UNIQUE'"
-	uniqueRows, err := pool.Query(uniqueQuery, tableName)
+	uniqueRows, err := pool.QueryContext(ctx, uniqueQuery, tableName)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +297,7 @@ func GetMySQLTableSchema(pool *sql.DB, tableName string) ([]schema.ColumnSchema,
 	//5. Get Indexes (column_name, index_name)
 	indexQuery := "SELECT column_name, index_name FROM information_schema.statistics " +
 		"WHERE table_schema = DATABASE() AND table_name = ?"
-	indexRows, err := pool.Query(indexQuery, tableName)
+	indexRows, err := pool.QueryContext(ctx, indexQuery, tableName)
 	if err != nil {
 		return nil, err
 	}
@@ -290,95 +350,326 @@ func GetMySQLTableSchema(pool *sql.DB, tableName string) ([]schema.ColumnSchema,
 	return columns, nil
 }
 
-// GetMySQLTableRecords retrieves the records of a specific table in the MySQL database.
-func GetMySQLTableRecords(pool *sql.DB, tableName string) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	query := "SELECT * FROM " + tableName
-	rows, err := pool.QueryContext(ctx, query)
+// GetMySQLTableRecords retrieves at most limit records of a specific table in the MySQL database.
+func GetMySQLTableRecords(ctx context.Context, pool *sql.DB, tableName string, limit int64) (result.Rows, error) {
+	rows, err := pool.QueryContext(ctx, "SELECT * FROM "+mysqlDialect.quote(tableName)+" LIMIT "+strconv.FormatInt(limit, 10))
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return result.NewSQLRows(rows, nil)
+}
 
-	columns, err := rows.Columns()
+// acquireMySQLConn reserves a connection and, when the context belongs to an execution, registers
+// a KILL QUERY for its server thread. Cancelling the context only drops the client connection in
+// go-sql-driver, which would leave the statement running on the server.
+func acquireMySQLConn(ctx context.Context, pool *sql.DB) (*sql.Conn, error) {
+	conn, err := pool.Conn(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	var records []map[string]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
+	if exec := executions.FromContext(ctx); exec != nil {
+		var threadID int64
+		if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&threadID); err != nil {
+			conn.Close()
 			return nil, err
 		}
+		exec.SetCanceller(func(cancelCtx context.Context) error {
+			_, err := pool.ExecContext(cancelCtx, "KILL QUERY "+strconv.FormatInt(threadID, 10))
+			return err
+		})
+	}
+	return conn, nil
+}
 
-		record := make(map[string]interface{})
-		for i, colName := range columns {
-			val := values[i]
-			if val == nil {
-				record[colName] = nil
-			} else {
-				record[colName] = val
-			}
-		}
-		records = append(records, record)
+// RunMySQLQuery executes a query on the MySQL database and returns a cursor over the results.
+func RunMySQLQuery(ctx context.Context, pool *sql.DB, query string, args ...interface{}) (result.Rows, error) {
+	conn, err := acquireMySQLConn(ctx, pool)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := rows.Err(); err != nil {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return records, nil
+	return result.NewSQLRows(rows, func() { conn.Close() })
 }
 
-// RunMySQLQuery executes a query on the MySQL database and returns the results.
-func RunMySQLQuery(pool *sql.DB, query string) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	rows, err := pool.QueryContext(ctx, query)
+// ExplainMySQLQuery returns the plan of a query from EXPLAIN FORMAT=JSON, or from the tree of
+// EXPLAIN ANALYZE when actuals are requested. EXPLAIN ANALYZE runs the statement, so it runs
+// inside a transaction that is rolled back.
+func ExplainMySQLQuery(ctx context.Context, pool *sql.DB, query string, opts plan.Options) (*plan.Plan, error) {
+	conn, err := acquireMySQLConn(ctx, pool)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer conn.Close()
 
-	columns, err := rows.Columns()
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	var results []map[string]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
+	explain := "EXPLAIN FORMAT=JSON "
+	if opts.Analyze {
+		explain = "EXPLAIN ANALYZE "
+	}
+	var output string
+	if err := tx.QueryRowContext(ctx, explain+query).Scan(&output); err != nil {
+		return nil, err
+	}
 
-		if err := rows.Scan(valuePtrs...); err != nil {
+	p := &plan.Plan{Engine: "mysql", Analyzed: opts.Analyze}
+	if opts.Buffers {
+		p.Notes = append(p.Notes, "MySQL does not report buffer usage")
+	}
+	if opts.Analyze {
+		p.Raw = output
+		p.Root = parseMySQLAnalyzeTree(output)
+	} else {
+		var raw map[string]interface{}
+		if err := json.Unmarshal([]byte(output), &raw); err != nil {
 			return nil, err
 		}
+		p.Raw = raw
+		if block, ok := raw["query_block"].(map[string]interface{}); ok {
+			p.Root = mysqlQueryBlock(block)
+		}
+	}
+	p.Annotate()
+	return p, nil
+}
+
+// mysqlAccessTypes names the access types of EXPLAIN FORMAT=JSON.
+var mysqlAccessTypes = map[string]string{
+	"ALL":             "Table Scan",
+	"index":           "Full Index Scan",
+	"range":           "Index Range Scan",
+	"ref":             "Index Lookup",
+	"ref_or_null":     "Index Lookup",
+	"eq_ref":          "Unique Index Lookup",
+	"const":           "Constant Lookup",
+	"system":          "Constant Lookup",
+	"fulltext":        "Fulltext Index Lookup",
+	"index_merge":     "Index Merge",
+	"unique_subquery": "Unique Subquery",
+	"index_subquery":  "Index Subquery",
+}
 
-		result := make(map[string]interface{})
-		for i, colName := range columns {
-			val := values[i]
-			if val == nil {
-				result[colName] = nil
-			} else {
-				result[colName] = val
+// mysqlFloat reads a number that MySQL may report either as a JSON number or as a string.
+func mysqlFloat(obj map[string]interface{}, key string) *float64 {
+	switch v := obj[key].(type) {
+	case float64:
+		return plan.Float(v)
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return plan.Float(f)
+		}
+	}
+	return nil
+}
+
+func mysqlQueryBlock(block map[string]interface{}) *plan.Node {
+	node := &plan.Node{Operation: "Query Block"}
+	if id, ok := block["select_id"].(float64); ok {
+		node.Detail = "select #" + strconv.FormatFloat(id, 'f', -1, 64)
+	}
+	if costInfo, ok := block["cost_info"].(map[string]interface{}); ok {
+		node.TotalCost = mysqlFloat(costInfo, "query_cost")
+	}
+	node.Children = mysqlChildren(block)
+	return node
+}
+
+// mysqlChildren collects the operations nested in a query block or operation object.
+func mysqlChildren(obj map[string]interface{}) []*plan.Node {
+	var children []*plan.Node
+	operations := []struct{ key, name string }{
+		{"ordering_operation", "Sort"},
+		{"grouping_operation", "Group"},
+		{"duplicates_removal", "Distinct"},
+		{"windowing", "Window"},
+	}
+	for _, op := range operations {
+		if inner, ok := obj[op.key].(map[string]interface{}); ok {
+			node := &plan.Node{Operation: op.name, Children: mysqlChildren(inner)}
+			if filesort, _ := inner["using_filesort"].(bool); filesort {
+				node.Operation += " (filesort)"
+			}
+			if temporary, _ := inner["using_temporary_table"].(bool); temporary {
+				node.SetProperty("using_temporary_table", true)
 			}
+			if costInfo, ok := inner["cost_info"].(map[string]interface{}); ok {
+				if cost := mysqlFloat(costInfo, "sort_cost"); cost != nil {
+					node.SetProperty("sort_cost", *cost)
+				}
+			}
+			children = append(children, node)
 		}
-		results = append(results, result)
 	}
+	if table, ok := obj["table"].(map[string]interface{}); ok {
+		children = append(children, mysqlTableNode(table))
+	}
+	if loop, ok := obj["nested_loop"].([]interface{}); ok {
+		node := &plan.Node{Operation: "Nested Loop"}
+		for _, elem := range loop {
+			if inner, ok := elem.(map[string]interface{}); ok {
+				node.Children = append(node.Children, mysqlChildren(inner)...)
+			}
+		}
+		children = append(children, node)
+	}
+	if union, ok := obj["union_result"].(map[string]interface{}); ok {
+		node := &plan.Node{Operation: "Union"}
+		if specs, ok := union["query_specifications"].([]interface{}); ok {
+			for _, spec := range specs {
+				if inner, ok := spec.(map[string]interface{}); ok {
+					node.Children = append(node.Children, mysqlChildren(inner)...)
+				}
+			}
+		}
+		children = append(children, node)
+	}
+	if block, ok := obj["query_block"].(map[string]interface{}); ok {
+		children = append(children, mysqlQueryBlock(block))
+	}
+	for _, key := range []string{"attached_subqueries", "optimized_away_subqueries"} {
+		if subqueries, ok := obj[key].([]interface{}); ok {
+			for _, subquery := range subqueries {
+				if inner, ok := subquery.(map[string]interface{}); ok {
+					children = append(children, mysqlChildren(inner)...)
+				}
+			}
+		}
+	}
+	return children
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+func mysqlTableNode(table map[string]interface{}) *plan.Node {
+	node := &plan.Node{}
+	node.Relation, _ = table["table_name"].(string)
+	node.Index, _ = table["key"].(string)
+	node.Detail, _ = table["attached_condition"].(string)
+
+	accessType, _ := table["access_type"].(string)
+	node.Operation = mysqlAccessTypes[accessType]
+	if node.Operation == "" {
+		node.Operation = "Table Access (" + accessType + ")"
+	}
+	node.FullScan = accessType == "ALL"
+
+	node.EstimatedRows = mysqlFloat(table, "rows_produced_per_join")
+	node.RowsExamined = mysqlFloat(table, "rows_examined_per_scan")
+	if costInfo, ok := table["cost_info"].(map[string]interface{}); ok {
+		read, eval := mysqlFloat(costInfo, "read_cost"), mysqlFloat(costInfo, "eval_cost")
+		if read != nil && eval != nil {
+			node.TotalCost = plan.Float(*read + *eval)
+		}
+		if prefix := mysqlFloat(costInfo, "prefix_cost"); prefix != nil {
+			node.SetProperty("prefix_cost", *prefix)
+		}
+	}
+	if filtered := mysqlFloat(table, "filtered"); filtered != nil {
+		node.SetProperty("filtered", *filtered)
+	}
+	if keys, ok := table["possible_keys"]; ok {
+		node.SetProperty("possible_keys", keys)
 	}
-	return results, nil
-}
\ No newline at end of file
+	if inner, ok := table["materialized_from_subquery"].(map[string]interface{}); ok {
+		node.Children = mysqlChildren(inner)
+	}
+	return node
+}
+
+var (
+	mysqlCostPattern   = regexp.MustCompile(`\(cost=(?:[\d.e+-]+\.\.)?([\d.e+-]+) rows=([\d.e+-]+)\)`)
+	mysqlActualPattern = regexp.MustCompile(`\(actual time=([\d.e+-]+)\.\.([\d.e+-]+) rows=([\d.e+-]+) loops=([\d.e+-]+)\)`)
+	mysqlOnPattern     = regexp.MustCompile(` on (\S+)`)
+	mysqlUsingPattern  = regexp.MustCompile(` using (\S+)`)
+)
+
+// parseMySQLAnalyzeTree parses the indented tree printed by EXPLAIN ANALYZE, where every
+// operation starts with "-> " and children are indented below their parent.
+func parseMySQLAnalyzeTree(output string) *plan.Node {
+	type frame struct {
+		indent int
+		node   *plan.Node
+	}
+	var roots []*plan.Node
+	var stack []frame
+	var last *plan.Node
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(trimmed, "-> ") {
+			// Long descriptions continue on the following lines.
+			if last != nil && strings.TrimSpace(line) != "" {
+				last.Detail += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		node := mysqlAnalyzeNode(strings.TrimPrefix(trimmed, "-> "))
+		last = node
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, node)
+		} else {
+			parent := stack[len(stack)-1].node
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, frame{indent: indent, node: node})
+	}
+
+	if len(roots) == 1 {
+		return roots[0]
+	}
+	return &plan.Node{Operation: "Query", Children: roots}
+}
+
+func mysqlAnalyzeNode(text string) *plan.Node {
+	description := text
+	if i := strings.Index(text, "  ("); i >= 0 {
+		description = text[:i]
+	}
+	node := &plan.Node{Operation: description, Detail: description}
+	if i := strings.Index(description, ": "); i >= 0 {
+		node.Operation = description[:i]
+	} else if i := strings.Index(description, " on "); i >= 0 {
+		node.Operation = description[:i]
+	}
+	if m := mysqlOnPattern.FindStringSubmatch(description); m != nil {
+		node.Relation = m[1]
+	}
+	if m := mysqlUsingPattern.FindStringSubmatch(description); m != nil {
+		node.Index = m[1]
+	}
+	node.FullScan = strings.HasPrefix(description, "Table scan")
+
+	parse := func(s string) *float64 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil
+		}
+		return plan.Float(f)
+	}
+	if m := mysqlCostPattern.FindStringSubmatch(text); m != nil {
+		node.TotalCost = parse(m[1])
+		node.EstimatedRows = parse(m[2])
+	}
+	if m := mysqlActualPattern.FindStringSubmatch(text); m != nil {
+		node.ActualRows = parse(m[3])
+		node.Loops = parse(m[4])
+		// Actual times are averages per loop.
+		if last := parse(m[2]); last != nil && node.Loops != nil {
+			node.ActualTime = plan.Float(*last * *node.Loops)
+		}
+	} else if strings.Contains(text, "(never executed)") {
+		node.SetProperty("never_executed", true)
+	}
+	return node
+}
@@ -2,19 +2,29 @@ package sql
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cprakhar/datawhiz/config"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
-	"github.com/cprakhar/datawhiz/utils/uuid"
+	"github.com/cprakhar/datawhiz/internal/db_driver/plan"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tlsconfig"
+	"github.com/cprakhar/datawhiz/internal/executions"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // PingPostgres pings the PostgreSQL database to check if it's reachable.
-func PingPostgres(pool *pgxpool.Pool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func PingPostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	conn, err := pool.Acquire(ctx)
@@ -30,8 +40,8 @@ func PingPostgres(pool *pgxpool.Pool) error {
 }
 
 // NewPostgresClient creates a new PostgreSQL client with the provided connection string.
-func NewPostgresPool(dbCfg *config.DBConfig, connStr string) (*pgxpool.Pool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func NewPostgresPool(ctx context.Context, dbCfg *config.DBConfig, connStr string, settings *schema.PoolSettings, tlsSettings *schema.TLSSettings) (*pgxpool.Pool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	poolConfig, err := pgxpool.ParseConfig(connStr)
@@ -41,13 +51,34 @@ func NewPostgresPool(dbCfg *config.DBConfig, connStr string) (*pgxpool.Pool, err
 
 	poolConfig.MaxConns = int32(dbCfg.MaxOpenConns)
 	poolConfig.MaxConnIdleTime = dbCfg.ConnMaxIdleTime
+	if dbCfg.ConnMaxLifetime > 0 {
+		poolConfig.MaxConnLifetime = dbCfg.ConnMaxLifetime
+	}
+	if settings != nil {
+		// Runtime parameters are sent in the startup message of every connection of the pool.
+		params := poolConfig.ConnConfig.RuntimeParams
+		if settings.StatementTimeout > 0 {
+			params["statement_timeout"] = strconv.Itoa(settings.StatementTimeout)
+		}
+		if settings.ApplicationName != "" {
+			params["application_name"] = settings.ApplicationName
+		}
+		if settings.SearchPath != "" {
+			params["search_path"] = settings.SearchPath
+		}
+	}
+	if tlsSettings != nil {
+		if err := withPostgresTLS(&poolConfig.ConnConfig.Config, tlsSettings); err != nil {
+			return nil, err
+		}
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := PingPostgres(pool); err != nil {
+	if err := PingPostgres(ctx, pool); err != nil {
 		pool.Close()
 		return nil, err
 	}
@@ -55,6 +86,41 @@ func NewPostgresPool(dbCfg *config.DBConfig, connStr string) (*pgxpool.Pool, err
 	return pool, nil
 }
 
+// withPostgresTLS replaces the TLS configuration that the SSL mode of a connection string gives
+// each host with that of the TLS settings. Under prefer, each host is tried again without TLS.
+func withPostgresTLS(connConfig *pgconn.Config, settings *schema.TLSSettings) error {
+	type address struct {
+		host string
+		port uint16
+	}
+	addresses := []address{{connConfig.Host, connConfig.Port}}
+	for _, fallback := range connConfig.Fallbacks {
+		if addr := (address{fallback.Host, fallback.Port}); addr != addresses[len(addresses)-1] {
+			addresses = append(addresses, addr)
+		}
+	}
+
+	var attempts []*pgconn.FallbackConfig
+	for _, addr := range addresses {
+		var tlsConfig *tls.Config
+		// Unix sockets do not use TLS.
+		if !strings.HasPrefix(addr.host, "/") {
+			var err error
+			if tlsConfig, err = tlsconfig.Config(settings, addr.host); err != nil {
+				return err
+			}
+		}
+		attempts = append(attempts, &pgconn.FallbackConfig{Host: addr.host, Port: addr.port, TLSConfig: tlsConfig})
+		if tlsConfig != nil && settings.Mode == tlsconfig.Prefer {
+			attempts = append(attempts, &pgconn.FallbackConfig{Host: addr.host, Port: addr.port})
+		}
+	}
+
+	connConfig.TLSConfig = attempts[0].TLSConfig
+	connConfig.Fallbacks = attempts[1:]
+	return nil
+}
+
 // CreatePostgresConnectionString constructs a PostgreSQL connection string from the provided connection form.
 func CreatePostgresConnectionString(conn *schema.ManualConnectionForm) (string, error) {
 	if conn.Host == "" {
@@ -129,8 +195,8 @@ func ExtractPostgresDetails(conn *schema.StringConnectionForm) (*schema.ManualCo
 }
 
 // GetPostgresTables retrieves the list of tables in the PostgreSQL database.
-func GetPostgresTables(pool *pgxpool.Pool) ([]string, error) {
-	rows, err := pool.Query(context.Background(),
+func GetPostgresTables(ctx context.Context, pool *pgxpool.Pool) ([]string, error) {
+	rows, err := pool.Query(ctx,
 		"SELECT table_name FROM information_schema.tables WHERE table_schema = 'public'",
 	)
 	if err != nil {
@@ -153,11 +219,11 @@ func GetPostgresTables(pool *pgxpool.Pool) ([]string, error) {
 }
 
 // GetPostgresTableSchema retrieves the schema of a specific table in the PostgreSQL database.
-func GetPostgresTableSchema(pool *pgxpool.Pool, tableName string) ([]schema.ColumnSchema, error) {
+func GetPostgresTableSchema(ctx context.Context, pool *pgxpool.Pool, tableName string) ([]schema.ColumnSchema, error) {
 	var columns []schema.ColumnSchema
 
 	// 1. Get all columns
-	rows, err := pool.Query(context.Background(),
+	rows, err := pool.Query(ctx,
 		"SELECT column_name, data_type, is_nullable, column_default "+
 			"FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1", tableName)
 	if err != nil {
@@ -166,7 +232,7 @@ func GetPostgresTableSchema(pool *pgxpool.Pool, tableName string) ([]schema.Colu
 	defer rows.Close()
 
 	// 2. Get PKs
-	pkRows, err := pool.Query(context.Background(),
+	pkRows, err := pool.Query(ctx,
 		"SELECT kcu.column_name FROM information_schema.table_constraints tc "+
 			"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name "+
 			"WHERE tc.table_schema = 'public' AND tc.table_name = $1 AND tc.constraint_type = 'PRIMARY KEY'", tableName)
@@ -184,7 +250,7 @@ func GetPostgresTableSchema(pool *pgxpool.Pool, tableName string) ([]schema.Colu
 	}
 
 	// 3. Get Uniques
-	uniqueRows, err := pool.Query(context.Background(),
+	uniqueRows, err := pool.Query(ctx,
 		"SELECT kcu.column_name FROM information_schema.table_constraints tc "+
 			"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name "+
 			"WHERE tc.table_schema = 'public' AND tc.table_name = $1 AND tc.constraint_type = 'UNIQUE'", tableName)
@@ -202,7 +268,7 @@ func GetPostgresTableSchema(pool *pgxpool.Pool, tableName string) ([]schema.Colu
 	}
 
 	// 4. Get FKs
-	fkRows, err := pool.Query(context.Background(),
+	fkRows, err := pool.Query(ctx,
 		"SELECT kcu.column_name, ccu.table_name AS foreign_table, ccu.column_name AS foreign_column "+
 			"FROM information_schema.table_constraints tc "+
 			"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name "+
@@ -228,7 +294,7 @@ func GetPostgresTableSchema(pool *pgxpool.Pool, tableName string) ([]schema.Colu
 	}
 
 	// 5. Get Indexes (column_name, index_name)
-	indexRows, err := pool.Query(context.Background(),
+	indexRows, err := pool.Query(ctx,
 		`SELECT a.attname AS column_name, i.relname AS index_name
 				FROM pg_class t, pg_class i, pg_index ix, pg_attribute a
 				WHERE t.oid = ix.indrelid
@@ -280,73 +346,392 @@ func GetPostgresTableSchema(pool *pgxpool.Pool, tableName string) ([]schema.Colu
 	return columns, nil
 }
 
-// GetPostgresTableRecords retrieves the records of a specific table in the PostgreSQL database.
-func GetPostgresTableRecords(pool *pgxpool.Pool, tableName string) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// postgresRows adapts pgx rows to result.Rows.
+type postgresRows struct {
+	rows    pgx.Rows
+	columns []result.Column
+	release func()
+}
+
+func newPostgresRows(ctx context.Context, pool *pgxpool.Pool, rows pgx.Rows, release func()) *postgresRows {
+	typeMap := rows.Conn().TypeMap()
+	fields := rows.FieldDescriptions()
+	columns := make([]result.Column, len(fields))
+	for i, field := range fields {
+		col := result.Column{Name: field.Name, Type: strconv.FormatUint(uint64(field.DataTypeOID), 10)}
+		if typ, ok := typeMap.TypeForOID(field.DataTypeOID); ok {
+			col.Type = typ.Name
+		}
+		setPostgresTypeModifier(&col, field.TypeModifier)
+		columns[i] = col
+	}
+	describePostgresColumns(ctx, pool, fields, columns)
+	for i := range columns {
+		columns[i].Kind = result.KindOf(columns[i].Type)
+	}
+	return &postgresRows{rows: rows, columns: columns, release: release}
+}
+
+// setPostgresTypeModifier decodes the precision, scale or length packed into a type modifier.
+func setPostgresTypeModifier(col *result.Column, typmod int32) {
+	if typmod < 0 {
+		return
+	}
+	switch col.Type {
+	case "numeric":
+		precision := int64((typmod - 4) >> 16 & 0xffff)
+		scale := int64((typmod - 4) & 0xffff)
+		col.Precision, col.Scale = &precision, &scale
+	case "varchar", "bpchar":
+		length := int64(typmod - 4)
+		col.Length = &length
+	case "timestamp", "timestamptz", "time", "timetz":
+		precision := int64(typmod)
+		col.Precision = &precision
+	}
+}
+
+// postgresCatalog caches what describePostgresColumns found in the catalog of the database of a
+// pool, so that only the first result with a given column or type queries it. Nullability set
+// by a later ALTER TABLE shows once the pool is opened again.
+type postgresCatalog struct {
+	mu        sync.Mutex
+	notNull   map[[2]uint32]*bool // (table OID, attribute number) → attnotnull, nil if not found
+	typeNames map[uint32]string   // type OID → name, "" if not found
+}
+
+// postgresCatalogs holds the catalog cache of every open pool, keyed by *pgxpool.Pool.
+var postgresCatalogs sync.Map
+
+// ClosePostgresPool closes a pool and drops its catalog cache.
+func ClosePostgresPool(pool *pgxpool.Pool) {
+	pool.Close()
+	// Close waits for the connections in use, so no result describes its columns afterwards.
+	postgresCatalogs.Delete(pool)
+}
+
+func catalogOf(pool *pgxpool.Pool) *postgresCatalog {
+	catalog, _ := postgresCatalogs.LoadOrStore(pool, &postgresCatalog{
+		notNull:   make(map[[2]uint32]*bool),
+		typeNames: make(map[uint32]string),
+	})
+	return catalog.(*postgresCatalog)
+}
+
+// describePostgresColumns looks up what the wire protocol does not carry: the nullability of
+// columns that come straight from a table and the names of types pgx does not know, such as
+// PostGIS geometry. The lookup is best effort and runs on another connection of the pool, so it
+// is skipped when the pool cannot hand one out, and only for what the pool has not looked up yet.
+func describePostgresColumns(ctx context.Context, pool *pgxpool.Pool, fields []pgconn.FieldDescription, columns []result.Column) {
+	if pool == nil || pool.Stat().MaxConns() < 2 {
+		return
+	}
+	catalog := catalogOf(pool)
+
+	var relIDs []uint32
+	var attNums []int16
+	var typeOIDs []uint32
+	catalog.mu.Lock()
+	for i, field := range fields {
+		if field.TableOID != 0 && field.TableAttributeNumber > 0 {
+			if _, ok := catalog.notNull[[2]uint32{field.TableOID, uint32(field.TableAttributeNumber)}]; !ok {
+				relIDs = append(relIDs, field.TableOID)
+				attNums = append(attNums, int16(field.TableAttributeNumber))
+			}
+		}
+		if columns[i].Type == strconv.FormatUint(uint64(field.DataTypeOID), 10) {
+			if _, ok := catalog.typeNames[field.DataTypeOID]; !ok {
+				typeOIDs = append(typeOIDs, field.DataTypeOID)
+			}
+		}
+	}
+	catalog.mu.Unlock()
+
+	if len(relIDs) > 0 || len(typeOIDs) > 0 {
+		lookUpPostgresCatalog(ctx, pool, catalog, relIDs, attNums, typeOIDs)
+	}
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	for i, field := range fields {
+		if attNotNull := catalog.notNull[[2]uint32{field.TableOID, uint32(field.TableAttributeNumber)}]; attNotNull != nil {
+			nullable := !*attNotNull
+			columns[i].Nullable = &nullable
+		}
+		if columns[i].Type == strconv.FormatUint(uint64(field.DataTypeOID), 10) {
+			if name := catalog.typeNames[field.DataTypeOID]; name != "" {
+				columns[i].Type = name
+			}
+		}
+	}
+}
+
+// lookUpPostgresCatalog queries the catalog for the given columns and types and caches what it
+// finds, along with what it does not. Nothing is cached when a query fails, so that it is retried.
+func lookUpPostgresCatalog(ctx context.Context, pool *pgxpool.Pool, catalog *postgresCatalog, relIDs []uint32, attNums []int16, typeOIDs []uint32) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	rows, err := pool.Query(ctx, "SELECT * FROM "+tableName)
+	if len(relIDs) > 0 {
+		rows, err := pool.Query(ctx,
+			"SELECT a.attrelid, a.attnum, a.attnotnull FROM pg_attribute a "+
+				"JOIN unnest($1::oid[], $2::int2[]) AS f(relid, attnum) ON a.attrelid = f.relid AND a.attnum = f.attnum",
+			relIDs, attNums)
+		if err == nil {
+			notNull := make(map[[2]uint32]*bool)
+			for rows.Next() {
+				var relID uint32
+				var attNum int16
+				var attNotNull bool
+				if rows.Scan(&relID, &attNum, &attNotNull) == nil {
+					notNull[[2]uint32{relID, uint32(attNum)}] = &attNotNull
+				}
+			}
+			rows.Close()
+			if rows.Err() == nil {
+				catalog.mu.Lock()
+				for i, relID := range relIDs {
+					key := [2]uint32{relID, uint32(attNums[i])}
+					catalog.notNull[key] = notNull[key]
+				}
+				catalog.mu.Unlock()
+			}
+		}
+	}
+
+	if len(typeOIDs) > 0 {
+		rows, err := pool.Query(ctx, "SELECT oid, typname FROM pg_type WHERE oid = ANY($1)", typeOIDs)
+		if err == nil {
+			names := make(map[uint32]string)
+			for rows.Next() {
+				var oid uint32
+				var name string
+				if rows.Scan(&oid, &name) == nil {
+					names[oid] = name
+				}
+			}
+			rows.Close()
+			if rows.Err() == nil {
+				catalog.mu.Lock()
+				for _, oid := range typeOIDs {
+					catalog.typeNames[oid] = names[oid]
+				}
+				catalog.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (r *postgresRows) Columns() []result.Column { return r.columns }
+
+func (r *postgresRows) Next() bool { return r.rows.Next() }
+
+func (r *postgresRows) Values() ([]interface{}, error) {
+	values, err := r.rows.Values()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	for i, val := range values {
+		values[i] = result.Encode(r.columns[i], postgresValue(val))
+	}
+	return values, nil
+}
 
-	columns := rows.FieldDescriptions()
-	var records []map[string]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
+// postgresValue converts the pgx types whose driver value is not the representation
+// result.Encode expects.
+func postgresValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case pgtype.Interval:
+		if !v.Valid {
+			return nil
 		}
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
+		return result.FormatInterval(v.Months, v.Days, v.Microseconds)
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = postgresValue(elem)
 		}
+	}
+	return val
+}
 
-		record := make(map[string]interface{})
-		for i, col := range columns {
-			record[string(col.Name)] = uuid.ConvertUUIDifPossible(values[i])
-		}
-		records = append(records, record)
+func (r *postgresRows) Err() error { return r.rows.Err() }
+
+func (r *postgresRows) Close() {
+	r.rows.Close()
+	if r.release != nil {
+		r.release()
 	}
-	if err := rows.Err(); err != nil {
+}
+
+// GetPostgresTableRecords retrieves at most limit records of a specific table in the PostgreSQL database.
+func GetPostgresTableRecords(ctx context.Context, pool *pgxpool.Pool, tableName string, limit int64) (result.Rows, error) {
+	rows, err := pool.Query(ctx, "SELECT * FROM "+postgresDialect.quote(tableName)+" LIMIT "+strconv.FormatInt(limit, 10))
+	if err != nil {
 		return nil, err
 	}
-	return records, nil
+	return newPostgresRows(ctx, pool, rows, nil), nil
 }
 
-// RunPostgresQuery executes a raw SQL query on the PostgreSQL database and returns the results.
-func RunPostgresQuery(pool *pgxpool.Pool, query string) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// acquirePostgresConn acquires a connection and, when the context belongs to an execution,
+// registers a server-side cancel for its backend so an abandoned or cancelled query does not
+// keep running after the client has gone away.
+func acquirePostgresConn(ctx context.Context, pool *pgxpool.Pool) (*pgxpool.Conn, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exec := executions.FromContext(ctx); exec != nil {
+		pid := conn.Conn().PgConn().PID()
+		exec.SetCanceller(func(cancelCtx context.Context) error {
+			_, err := pool.Exec(cancelCtx, "SELECT pg_cancel_backend($1)", pid)
+			return err
+		})
+	}
+	return conn, nil
+}
 
-	rows, err := pool.Query(ctx, query)
+// RunPostgresQuery executes a raw SQL query on the PostgreSQL database and returns a cursor over the results.
+// Arguments are sent as literals with the simple protocol, so that the server reads each as the
+// type it is compared with, as if it had been written in the query.
+func RunPostgresQuery(ctx context.Context, pool *pgxpool.Pool, query string, args ...interface{}) (result.Rows, error) {
+	conn, err := acquirePostgresConn(ctx, pool)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	columns := rows.FieldDescriptions()
-	var results []map[string]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
+	if len(args) > 0 {
+		args = append([]interface{}{pgx.QueryExecModeSimpleProtocol}, args...)
+	}
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return newPostgresRows(ctx, pool, rows, conn.Release), nil
+}
+
+// ExplainPostgresQuery returns the plan of a query from EXPLAIN (FORMAT JSON). With ANALYZE the
+// statement runs inside a transaction that is rolled back, so data changes are not kept.
+func ExplainPostgresQuery(ctx context.Context, pool *pgxpool.Pool, query string, opts plan.Options) (*plan.Plan, error) {
+	conn, err := acquirePostgresConn(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	explain := "EXPLAIN (FORMAT JSON"
+	if opts.Analyze {
+		explain += ", ANALYZE"
+		if opts.Buffers {
+			explain += ", BUFFERS"
 		}
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
+	}
+	explain += ") " + query
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var output string
+	if err := tx.QueryRow(ctx, explain).Scan(&output); err != nil {
+		return nil, err
+	}
+
+	var raw []map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &raw); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty plan")
+	}
+
+	p := &plan.Plan{Engine: "postgresql", Analyzed: opts.Analyze, Raw: raw}
+	if root, ok := raw[0]["Plan"].(map[string]interface{}); ok {
+		p.Root = postgresPlanNode(root)
+	}
+	if v, ok := raw[0]["Planning Time"].(float64); ok {
+		p.PlanningTime = plan.Float(v)
+	}
+	if v, ok := raw[0]["Execution Time"].(float64); ok {
+		p.ExecutionTime = plan.Float(v)
+	}
+	if opts.Buffers && !opts.Analyze {
+		p.Notes = append(p.Notes, "BUFFERS requires ANALYZE and was ignored")
+	}
+	p.Annotate()
+	return p, nil
+}
+
+// postgresPlanKeys are the node keys that map onto the plan model; the rest become properties.
+var postgresPlanKeys = map[string]bool{
+	"Node Type": true, "Relation Name": true, "Index Name": true, "Plans": true,
+	"Startup Cost": true, "Total Cost": true, "Plan Rows": true, "Actual Rows": true,
+	"Actual Loops": true, "Actual Total Time": true, "Actual Startup Time": true,
+}
+
+func postgresPlanNode(raw map[string]interface{}) *plan.Node {
+	node := &plan.Node{}
+	node.Operation, _ = raw["Node Type"].(string)
+	node.Relation, _ = raw["Relation Name"].(string)
+	node.Index, _ = raw["Index Name"].(string)
+	if strategy, ok := raw["Strategy"].(string); ok && strategy != "Plain" {
+		node.Operation = strategy + " " + node.Operation
+	}
+	if joinType, ok := raw["Join Type"].(string); ok && joinType != "Inner" {
+		node.Operation += " (" + joinType + ")"
+	}
+	for _, key := range []string{"Index Cond", "Hash Cond", "Merge Cond", "Join Filter", "Filter", "Recheck Cond"} {
+		if cond, ok := raw[key].(string); ok {
+			node.Detail = key + ": " + cond
+			break
 		}
+	}
 
-		result := make(map[string]interface{})
-		for i, col := range columns {
-			result[string(col.Name)] = uuid.ConvertUUIDifPossible(values[i])
+	number := func(key string) *float64 {
+		if v, ok := raw[key].(float64); ok {
+			return plan.Float(v)
 		}
-		results = append(results, result)
+		return nil
+	}
+	node.StartupCost = number("Startup Cost")
+	node.TotalCost = number("Total Cost")
+	node.EstimatedRows = number("Plan Rows")
+	node.ActualRows = number("Actual Rows")
+	node.Loops = number("Actual Loops")
+	// Actual times are averages per loop.
+	if total := number("Actual Total Time"); total != nil {
+		loops := 1.0
+		if node.Loops != nil {
+			loops = *node.Loops
+		}
+		node.ActualTime = plan.Float(*total * loops)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+
+	node.FullScan = node.Operation == "Seq Scan"
+	if node.FullScan && node.ActualRows != nil {
+		removed, _ := raw["Rows Removed by Filter"].(float64)
+		node.RowsExamined = plan.Float(*node.ActualRows + removed)
+	}
+	if spaceType, _ := raw["Sort Space Type"].(string); spaceType == "Disk" {
+		node.SpilledToDisk = true
+	}
+	if written, _ := raw["Temp Written Blocks"].(float64); written > 0 {
+		node.SpilledToDisk = true
 	}
 
-	return results, nil
-}
\ No newline at end of file
+	for key, value := range raw {
+		if !postgresPlanKeys[key] {
+			node.SetProperty(key, value)
+		}
+	}
+	if children, ok := raw["Plans"].([]interface{}); ok {
+		for _, child := range children {
+			if childRaw, ok := child.(map[string]interface{}); ok {
+				node.Children = append(node.Children, postgresPlanNode(childRaw))
+			}
+		}
+	}
+	return node
+}
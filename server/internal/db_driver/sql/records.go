@@ -0,0 +1,154 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/records"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// dialect holds what differs between engines when building row edit statements.
+type dialect struct {
+	quote       func(name string) string
+	placeholder func(n int) string
+}
+
+func quoteDouble(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteBacktick(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+var (
+	postgresDialect = dialect{quote: quoteDouble, placeholder: func(n int) string { return "$" + strconv.Itoa(n) }}
+	mysqlDialect    = dialect{quote: quoteBacktick, placeholder: func(int) string { return "?" }}
+	sqliteDialect   = dialect{quote: quoteDouble, placeholder: func(int) string { return "?" }}
+)
+
+// where builds the condition matching the key columns of a change.
+func (d dialect) where(key map[string]interface{}, args []interface{}) (string, []interface{}) {
+	conditions := make([]string, 0, len(key))
+	for _, name := range records.SortedKeys(key) {
+		args = append(args, key[name])
+		conditions = append(conditions, d.quote(name)+" = "+d.placeholder(len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// statement builds the INSERT, UPDATE or DELETE statement of a validated change.
+func (d dialect) statement(table string, change records.Change) (string, []interface{}) {
+	var args []interface{}
+	switch change.Op {
+	case records.OpInsert:
+		names := records.SortedKeys(change.Values)
+		columns := make([]string, len(names))
+		placeholders := make([]string, len(names))
+		for i, name := range names {
+			args = append(args, change.Values[name])
+			columns[i] = d.quote(name)
+			placeholders[i] = d.placeholder(len(args))
+		}
+		return "INSERT INTO " + d.quote(table) + " (" + strings.Join(columns, ", ") + ") VALUES (" +
+			strings.Join(placeholders, ", ") + ")", args
+	case records.OpUpdate:
+		names := records.SortedKeys(change.Values)
+		assignments := make([]string, len(names))
+		for i, name := range names {
+			args = append(args, change.Values[name])
+			assignments[i] = d.quote(name) + " = " + d.placeholder(len(args))
+		}
+		where, args := d.where(change.Key, args)
+		return "UPDATE " + d.quote(table) + " SET " + strings.Join(assignments, ", ") + " WHERE " + where, args
+	default:
+		where, args := d.where(change.Key, args)
+		return "DELETE FROM " + d.quote(table) + " WHERE " + where, args
+	}
+}
+
+// ApplyPostgresChanges applies a validated batch of changes to a table in one transaction.
+func ApplyPostgresChanges(ctx context.Context, pool *pgxpool.Pool, tableName string, changes []records.Change) (*records.Summary, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	summary := &records.Summary{Atomic: true}
+	for i, change := range changes {
+		query, args := postgresDialect.statement(tableName, change)
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return nil, &records.ChangeError{Index: i, Err: err}
+		}
+		if change.Op != records.OpInsert {
+			if err := records.CheckAffected(i, tag.RowsAffected()); err != nil {
+				return nil, err
+			}
+		}
+		summary.Count(change.Op)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// ApplyMySQLChanges applies a validated batch of changes to a table in one transaction.
+func ApplyMySQLChanges(ctx context.Context, pool *sql.DB, tableName string, changes []records.Change) (*records.Summary, error) {
+	return applySQLChanges(ctx, pool, mysqlDialect, tableName, changes, true)
+}
+
+// ApplySQLiteChanges applies a validated batch of changes to a table in one transaction.
+func ApplySQLiteChanges(ctx context.Context, db *sql.DB, tableName string, changes []records.Change) (*records.Summary, error) {
+	return applySQLChanges(ctx, db, sqliteDialect, tableName, changes, false)
+}
+
+// applySQLChanges runs a batch through database/sql. MySQL reports the rows an UPDATE changed
+// rather than the rows it matched, so countMatches makes updates count their matches first.
+func applySQLChanges(ctx context.Context, db *sql.DB, d dialect, tableName string, changes []records.Change, countMatches bool) (*records.Summary, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	summary := &records.Summary{Atomic: true}
+	for i, change := range changes {
+		if countMatches && change.Op == records.OpUpdate {
+			where, args := d.where(change.Key, nil)
+			var matches int64
+			err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+d.quote(tableName)+" WHERE "+where+" FOR UPDATE", args...).Scan(&matches)
+			if err != nil {
+				return nil, &records.ChangeError{Index: i, Err: err}
+			}
+			if err := records.CheckAffected(i, matches); err != nil {
+				return nil, err
+			}
+		}
+
+		query, args := d.statement(tableName, change)
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, &records.ChangeError{Index: i, Err: err}
+		}
+		if change.Op == records.OpDelete || (change.Op == records.OpUpdate && !countMatches) {
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if err := records.CheckAffected(i, affected); err != nil {
+				return nil, err
+			}
+		}
+		summary.Count(change.Op)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
@@ -3,18 +3,26 @@ package sql
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"maps"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cprakhar/datawhiz/config"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/cprakhar/datawhiz/internal/db_driver/plan"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/mattn/go-sqlite3"
 )
 
 // PingSQLite pings the SQLite database to check if it's reachable.
-func PingSQLite(pool *sql.DB) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func PingSQLite(ctx context.Context, pool *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if err := pool.PingContext(ctx); err != nil {
@@ -24,12 +32,22 @@ func PingSQLite(pool *sql.DB) error {
 }
 
 // NewSQLitePool creates a new SQLite pool with the provided file path.
-func NewSQLitePool(dbCfg *config.DBConfig, filePath string) (*sql.DB, error) {
+// The pragmas of the pool settings are set on every connection of the pool.
+func NewSQLitePool(ctx context.Context, dbCfg *config.DBConfig, filePath string, settings *schema.PoolSettings) (*sql.DB, error) {
 
 	dsn := "file:" + filePath + "?cache=shared&mode=rwc&_journal_mode=WAL&_sync=FULL"
-	pool, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
+	var pool *sql.DB
+	if settings != nil && len(settings.Pragmas) > 0 {
+		if err := ValidateSQLitePragmas(settings.Pragmas); err != nil {
+			return nil, err
+		}
+		pool = sql.OpenDB(sqliteConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{ConnectHook: setPragmas(settings.Pragmas)}})
+	} else {
+		var err error
+		pool, err = sql.Open("sqlite3", dsn)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	pool.SetMaxIdleConns(dbCfg.MaxIdleConns)
@@ -37,7 +55,7 @@ func NewSQLitePool(dbCfg *config.DBConfig, filePath string) (*sql.DB, error) {
 	pool.SetConnMaxLifetime(dbCfg.ConnMaxLifetime)
 	pool.SetConnMaxIdleTime(dbCfg.ConnMaxIdleTime)
 
-	if err := PingSQLite(pool); err != nil {
+	if err := PingSQLite(ctx, pool); err != nil {
 		pool.Close()
 		return nil, err
 	}
@@ -45,6 +63,65 @@ func NewSQLitePool(dbCfg *config.DBConfig, filePath string) (*sql.DB, error) {
 	return pool, nil
 }
 
+// sqlitePragmas are the pragmas a connection can set, with the values each one accepts.
+var sqlitePragmas = map[string]*regexp.Regexp{
+	"auto_vacuum":         regexp.MustCompile(`^(?i:none|full|incremental|[0-2])$`),
+	"automatic_index":     regexp.MustCompile(`^(?i:on|off|true|false|[01])$`),
+	"busy_timeout":        regexp.MustCompile(`^\d+$`),
+	"cache_size":          regexp.MustCompile(`^-?\d+$`),
+	"case_sensitive_like": regexp.MustCompile(`^(?i:on|off|true|false|[01])$`),
+	"foreign_keys":        regexp.MustCompile(`^(?i:on|off|true|false|[01])$`),
+	"journal_mode":        regexp.MustCompile(`^(?i:delete|truncate|persist|memory|wal|off)$`),
+	"mmap_size":           regexp.MustCompile(`^\d+$`),
+	"query_only":          regexp.MustCompile(`^(?i:on|off|true|false|[01])$`),
+	"recursive_triggers":  regexp.MustCompile(`^(?i:on|off|true|false|[01])$`),
+	"secure_delete":       regexp.MustCompile(`^(?i:on|off|true|false|fast|[01])$`),
+	"synchronous":         regexp.MustCompile(`^(?i:off|normal|full|extra|[0-3])$`),
+	"temp_store":          regexp.MustCompile(`^(?i:default|file|memory|[0-2])$`),
+}
+
+// ValidateSQLitePragmas checks that pragmas are known and their values are valid, since they are
+// written into the PRAGMA statements as they are.
+func ValidateSQLitePragmas(pragmas map[string]string) error {
+	for name, value := range pragmas {
+		valid, ok := sqlitePragmas[name]
+		if !ok {
+			return errors.New("unsupported SQLite pragma: " + name)
+		}
+		if !valid.MatchString(value) {
+			return errors.New("invalid value for SQLite pragma " + name + ": " + value)
+		}
+	}
+	return nil
+}
+
+// setPragmas returns a connect hook that sets pragmas, in the order of their names.
+func setPragmas(pragmas map[string]string) func(*sqlite3.SQLiteConn) error {
+	names := slices.Sorted(maps.Keys(pragmas))
+	return func(conn *sqlite3.SQLiteConn) error {
+		for _, name := range names {
+			if _, err := conn.Exec("PRAGMA "+name+" = "+pragmas[name], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sqliteConnector opens the connections of a pool with a driver of its own, to run its connect hook.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
 // CreateSQLiteConnectionString constructs a SQLite connection string from the provided connection form.
 func CreateSQLiteConnectionString(conn *schema.ManualConnectionForm) (string, error) {
 	if conn.DBFilePath == "" {
@@ -57,21 +134,9 @@ func CreateSQLiteConnectionString(conn *schema.ManualConnectionForm) (string, er
 	return conn.DBFilePath, nil
 }
 
-// ExtractSQLiteDBName extracts the database name from the SQLite file path.
-func ExtractSQLiteDBName(filePath string) string {
-
-	if filePath != "" {
-		// Extract the file name without extension
-		fileName := filepath.Base(filePath)
-		dbName := fileName[:len(fileName)-len(filepath.Ext(fileName))]
-		return dbName
-	}
-	return ""
-}
-
 // GetSQLiteTables retrieves the list of tables in the SQLite database.
-func GetSQLiteTables(db *sql.DB) ([]string, error) {
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table'")
+func GetSQLiteTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table'")
 	if err != nil {
 		return nil, err
 	}
@@ -94,20 +159,20 @@ func GetSQLiteTables(db *sql.DB) ([]string, error) {
 }
 
 // GetSQLiteTableSchema retrieves the schema of a specific table in the SQLite database.
-func GetSQLiteTableSchema(db *sql.DB, tableName string) ([]schema.ColumnSchema, error) {
+func GetSQLiteTableSchema(ctx context.Context, db *sql.DB, tableName string) ([]schema.ColumnSchema, error) {
 	var columns []schema.ColumnSchema
 
 	// 1. Query all columns (pk and notnull as int)
-	query := "PRAGMA table_info(" + tableName + ")"
-	rows, err := db.Query(query)
+	query := "PRAGMA table_info(" + sqliteDialect.quote(tableName) + ")"
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	// 2. Get FKs
-	fkQuery := "PRAGMA foreign_key_list(" + tableName + ")"
-	fkRows, err := db.Query(fkQuery)
+	fkQuery := "PRAGMA foreign_key_list(" + sqliteDialect.quote(tableName) + ")"
+	fkRows, err := db.QueryContext(ctx, fkQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -129,8 +194,8 @@ func GetSQLiteTableSchema(db *sql.DB, tableName string) ([]schema.ColumnSchema,
 	}
 
 	// 3. Get indexes and unique constraints
-	idxListQuery := "PRAGMA index_list('" + tableName + "')"
-	idxListRows, err := db.Query(idxListQuery)
+	idxListQuery := "PRAGMA index_list(" + sqliteDialect.quote(tableName) + ")"
+	idxListRows, err := db.QueryContext(ctx, idxListQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -153,8 +218,8 @@ func GetSQLiteTableSchema(db *sql.DB, tableName string) ([]schema.ColumnSchema,
 		idxMetaMap[indexName] = idxMeta{Unique: unique == 1, Origin: origin}
 
 		// For each index, get columns
-		idxInfoQuery := "PRAGMA index_info('" + indexName + "')"
-		idxInfoRows, err := db.Query(idxInfoQuery)
+		idxInfoQuery := "PRAGMA index_info(" + sqliteDialect.quote(indexName) + ")"
+		idxInfoRows, err := db.QueryContext(ctx, idxInfoQuery)
 		if err != nil {
 			return nil, err
 		}
@@ -208,92 +273,144 @@ func GetSQLiteTableSchema(db *sql.DB, tableName string) ([]schema.ColumnSchema,
 	return columns, nil
 }
 
-// GetSQLiteTableRecords retrieves the records of a specific table in the SQLite database.
-func GetSQLiteTableRecords(db *sql.DB, tableName string) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// GetSQLiteTableRecords retrieves at most limit records of a specific table in the SQLite database.
+func GetSQLiteTableRecords(ctx context.Context, db *sql.DB, tableName string, limit int64) (result.Rows, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM "+sqliteDialect.quote(tableName)+" LIMIT "+strconv.FormatInt(limit, 10))
+	if err != nil {
+		return nil, err
+	}
+	return result.NewSQLRows(rows, nil)
+}
 
-	rows, err := db.QueryContext(ctx, "SELECT * FROM "+tableName)
+// RunSQLiteQuery executes a query on the SQLite database and returns a cursor over the results.
+// The sqlite3 driver interrupts the statement when the context is cancelled.
+func RunSQLiteQuery(ctx context.Context, db *sql.DB, query string, args ...interface{}) (result.Rows, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return result.NewSQLRows(rows, nil)
+}
 
-	columns, err := rows.Columns()
+var (
+	sqliteTablePattern = regexp.MustCompile(`^(?:SCAN|SEARCH)(?: TABLE)? (\S+)`)
+	sqliteIndexPattern = regexp.MustCompile(`USING (?:COVERING )?INDEX (\S+)`)
+	sqliteRowsPattern  = regexp.MustCompile(`\(~(\d+) rows\)`)
+)
+
+// ExplainSQLiteQuery returns the plan of a query from EXPLAIN QUERY PLAN. SQLite has no costs or
+// per-operation actuals, so with ANALYZE the statement is only timed as a whole, inside a
+// transaction that is rolled back.
+func ExplainSQLiteQuery(ctx context.Context, db *sql.DB, query string, opts plan.Options) (*plan.Plan, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query)
 	if err != nil {
 		return nil, err
 	}
-	var records []map[string]interface{}
+	defer rows.Close()
+
+	type step struct {
+		ID     int64  `json:"id"`
+		Parent int64  `json:"parent"`
+		Detail string `json:"detail"`
+	}
+	var steps []step
+	nodes := make(map[int64]*plan.Node)
+	root := &plan.Node{Operation: "Query"}
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
+		var st step
+		var notUsed interface{}
+		if err := rows.Scan(&st.ID, &st.Parent, &notUsed, &st.Detail); err != nil {
 			return nil, err
 		}
+		steps = append(steps, st)
 
-		record := make(map[string]interface{})
-		for i, colName := range columns {
-			val := values[i]
-			if val == nil {
-				record[colName] = nil
-			} else {
-				record[colName] = val
-			}
+		node := sqlitePlanNode(st.Detail)
+		nodes[st.ID] = node
+		parent, ok := nodes[st.Parent]
+		if !ok {
+			parent = root
 		}
-		records = append(records, record)
+		parent.Children = append(parent.Children, node)
 	}
-
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return records, nil
+	rows.Close()
+
+	p := &plan.Plan{Engine: "sqlite", Analyzed: opts.Analyze, Root: root, Raw: steps}
+	if len(root.Children) == 1 {
+		p.Root = root.Children[0]
+	}
+	if opts.Buffers {
+		p.Notes = append(p.Notes, "SQLite does not report buffer usage")
+	}
+	if opts.Analyze {
+		count, elapsed, err := timeSQLiteQuery(ctx, db, query)
+		if err != nil {
+			return nil, err
+		}
+		p.Root = root
+		root.ActualRows = plan.Float(float64(count))
+		root.ActualTime = plan.Float(elapsed)
+		p.ExecutionTime = plan.Float(elapsed)
+		p.Notes = append(p.Notes, "SQLite does not report actuals per operation; the statement was timed as a whole")
+	}
+	p.Annotate()
+	return p, nil
 }
 
-// RunSQLiteQuery executes a query on the SQLite database and returns the results.
-func RunSQLiteQuery(db *sql.DB, query string) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func sqlitePlanNode(detail string) *plan.Node {
+	node := &plan.Node{Operation: detail, Detail: detail}
+	if m := sqliteTablePattern.FindStringSubmatch(detail); m != nil {
+		node.Relation = m[1]
+	}
+	if m := sqliteIndexPattern.FindStringSubmatch(detail); m != nil {
+		node.Index = m[1]
+	}
+	if m := sqliteRowsPattern.FindStringSubmatch(detail); m != nil {
+		if rows, err := strconv.ParseFloat(m[1], 64); err == nil {
+			node.EstimatedRows = plan.Float(rows)
+		}
+	}
 
-	rows, err := db.QueryContext(ctx, query)
+	switch {
+	case strings.HasPrefix(detail, "SCAN ") && node.Index != "":
+		node.Operation = "Index Scan"
+	case strings.HasPrefix(detail, "SCAN "):
+		node.Operation = "Table Scan"
+		node.FullScan = node.Relation != "" && !strings.HasPrefix(node.Relation, "CONSTANT")
+	case strings.HasPrefix(detail, "SEARCH "):
+		node.Operation = "Index Search"
+		if strings.Contains(detail, "INTEGER PRIMARY KEY") {
+			node.Index = "PRIMARY KEY"
+		}
+	case strings.HasPrefix(detail, "USE TEMP B-TREE"):
+		node.Operation = "Temp B-Tree"
+	}
+	return node
+}
+
+// timeSQLiteQuery runs the statement to completion and reports its row count and duration in milliseconds.
+func timeSQLiteQuery(ctx context.Context, db *sql.DB, query string) (int64, float64, error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	columns, err := rows.Columns()
+	start := time.Now()
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
+	defer rows.Close()
 
-	var results []map[string]interface{}
+	var count int64
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
-		}
-
-		result := make(map[string]interface{})
-		for i, colName := range columns {
-			val := values[i]
-			if val == nil {
-				result[colName] = nil
-			} else {
-				result[colName] = val
-			}
-		}
-		results = append(results, result)
+		count++
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, 0, err
 	}
-
-	return results, nil
-}
\ No newline at end of file
+	return count, float64(time.Since(start).Microseconds()) / 1000, nil
+}
@@ -0,0 +1,196 @@
+package sqltext
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// tableWords are the keywords a table name follows.
+var tableWords = map[string]bool{"from": true, "join": true, "into": true, "update": true, "table": true}
+
+// skipWords may come between a table keyword and the table name.
+var skipWords = map[string]bool{"if": true, "not": true, "exists": true, "only": true, "ignore": true}
+
+// fromFunctions take arguments with from in them.
+var fromFunctions = map[string]bool{"extract": true, "substring": true, "trim": true, "overlay": true, "position": true}
+
+// clauseWords end a table reference, so a word after a table name that is not one of them is an alias.
+var clauseWords = map[string]bool{
+	"where": true, "join": true, "inner": true, "left": true, "right": true, "full": true, "cross": true,
+	"natural": true, "on": true, "using": true, "group": true, "order": true, "having": true, "limit": true,
+	"offset": true, "fetch": true, "for": true, "union": true, "intersect": true, "except": true,
+	"window": true, "set": true, "values": true, "select": true, "returning": true, "default": true,
+	"lateral": true, "straight_join": true, "partition": true, "tablesample": true,
+}
+
+// Fingerprint returns the shape of a statement: literals and placeholders become ?, lists of
+// them collapse into one, keywords and bare identifiers are lower-cased and the spacing is
+// canonical, so statements that only differ in their values share a fingerprint. It also
+// returns the tables the statement names, as far as they can be told from its text.
+func Fingerprint(query, dbType string) (string, []string) {
+	tokens := Scan(query, dbType)
+	for len(tokens) > 0 && tokens[len(tokens)-1].Kind == Semicolon {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	parts := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		switch token.Kind {
+		case Number, String, Param:
+			parts = append(parts, "?")
+		case Word:
+			parts = append(parts, token.Lower())
+		case Symbol:
+			if token.Text == ")" {
+				parts = closeList(parts)
+				continue
+			}
+			parts = append(parts, token.Text)
+		default:
+			parts = append(parts, token.Text)
+		}
+	}
+
+	var sb strings.Builder
+	for i, part := range parts {
+		if i > 0 && parts[i-1] != "(" && parts[i-1] != "." && !slices.Contains([]string{")", ",", ".", ";"}, part) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(part)
+	}
+	return sb.String(), tables(tokens)
+}
+
+// closeList appends a closing parenthesis. A parenthesized list of values collapses into (?),
+// and so does a list of such lists, like the rows of a multi-row VALUES.
+func closeList(parts []string) []string {
+	open := len(parts) - 1
+	for open >= 0 && (parts[open] == "?" || parts[open] == ",") {
+		open--
+	}
+	if open >= 0 && parts[open] == "(" && open < len(parts)-1 {
+		parts = append(parts[:open+1], "?")
+	}
+	parts = append(parts, ")")
+
+	n := len(parts)
+	if n >= 7 && parts[n-4] == "," && slices.Equal(parts[n-3:], []string{"(", "?", ")"}) && slices.Equal(parts[n-7:n-4], []string{"(", "?", ")"}) {
+		parts = parts[:n-4]
+	}
+	return parts
+}
+
+// Hash is a short identifier of a fingerprint.
+func Hash(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:16])
+}
+
+// tables returns the sorted names of the tables a statement refers to, leaving out the names of
+// its common table expressions and the functions it selects from.
+func tables(tokens []Token) []string {
+	ctes := make(map[string]bool)
+	for i := 0; i+2 < len(tokens); i++ {
+		if isName(tokens[i]) && tokens[i+1].Kind == Word && tokens[i+1].Lower() == "as" && tokens[i+2].Text == "(" {
+			ctes[nameOf(tokens[i])] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	names := []string{}
+	var calls []string // the function of each open parenthesis, if any
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case tokens[i].Text == "(":
+			call := ""
+			if i > 0 && tokens[i-1].Kind == Word {
+				call = tokens[i-1].Lower()
+			}
+			calls = append(calls, call)
+			continue
+		case tokens[i].Text == ")" && len(calls) > 0:
+			calls = calls[:len(calls)-1]
+			continue
+		case tokens[i].Kind != Word || !tableWords[tokens[i].Lower()]:
+			continue
+		case tokens[i].Lower() == "from" && len(calls) > 0 && fromFunctions[calls[len(calls)-1]],
+			tokens[i].Lower() == "from" && i > 0 && tokens[i-1].Lower() == "distinct":
+			// extract(year from ts) and a is distinct from b have no table.
+			continue
+		}
+		// Only from and join can be followed by a function, and only from by a list.
+		list := tokens[i].Lower() == "from"
+		functions := list || tokens[i].Lower() == "join"
+		j := i + 1
+		for j < len(tokens) && tokens[j].Kind == Word && skipWords[tokens[j].Lower()] {
+			j++
+		}
+		for {
+			name, next := qualifiedName(tokens, j)
+			if name == "" || (functions && next < len(tokens) && tokens[next].Text == "(") {
+				break
+			}
+			if !ctes[name] && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			j = next
+			// Skip the alias of the table.
+			if j < len(tokens) && tokens[j].Kind == Word && tokens[j].Lower() == "as" {
+				j++
+			}
+			if j < len(tokens) && isName(tokens[j]) && !(tokens[j].Kind == Word && clauseWords[tokens[j].Lower()]) {
+				j++
+			}
+			if !list || j >= len(tokens) || tokens[j].Text != "," {
+				break
+			}
+			j++
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// qualifiedName reads a possibly schema-qualified name starting at index start and returns it
+// with the index of the token after it.
+func qualifiedName(tokens []Token, start int) (string, int) {
+	var parts []string
+	i := start
+	for i < len(tokens) && isName(tokens[i]) {
+		if tokens[i].Kind == Word && clauseWords[tokens[i].Lower()] {
+			break
+		}
+		parts = append(parts, nameOf(tokens[i]))
+		i++
+		if i+1 < len(tokens) && tokens[i].Text == "." {
+			i++
+			continue
+		}
+		break
+	}
+	return strings.Join(parts, "."), i
+}
+
+func isName(token Token) bool {
+	return token.Kind == Word || token.Kind == Identifier
+}
+
+// nameOf returns a name as the database sees it: bare words folded to lower case, quoted
+// identifiers without their quotes.
+func nameOf(token Token) string {
+	if token.Kind == Word {
+		return token.Lower()
+	}
+	text := token.Text
+	if len(text) < 2 {
+		return text
+	}
+	quote := text[:1]
+	if quote == "[" {
+		return text[1 : len(text)-1]
+	}
+	return strings.ReplaceAll(text[1:len(text)-1], quote+quote, quote)
+}
@@ -0,0 +1,232 @@
+package sqltext
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Kind int
+
+const (
+	Word       Kind = iota // keyword or bare identifier
+	Number                 // numeric literal
+	String                 // string literal, dollar-quoted strings included
+	Identifier             // quoted identifier
+	Param                  // placeholder, $n in PostgreSQL and ? elsewhere
+	Semicolon
+	Symbol // operator or punctuation
+	Hint   // MySQL /*! ... */ comment, whose content MySQL runs
+)
+
+// Token is a lexical unit of a statement. Comments and whitespace are not tokens; they only
+// set Space on the token that follows them.
+type Token struct {
+	Kind  Kind
+	Text  string
+	Space bool
+}
+
+// Lower is the text of a word in lower case, for comparing keywords.
+func (t Token) Lower() string {
+	return strings.ToLower(t.Text)
+}
+
+const symbolRunes = "<>=!|&+-*/%^~:@#"
+
+// Scan splits a statement of the given engine into tokens. It only knows as much SQL as it
+// takes to tell literals, identifiers and comments apart; it does not check the syntax.
+func Scan(query, dbType string) []Token {
+	var tokens []Token
+	runes := []rune(query)
+	space := false
+	add := func(kind Kind, start, end int) {
+		tokens = append(tokens, Token{Kind: kind, Text: string(runes[start:end]), Space: space})
+		space = false
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			i++
+
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-',
+			r == '#' && dbType == "mysql":
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			space = true
+
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := i + 2
+			for end+1 < len(runes) && !(runes[end] == '*' && runes[end+1] == '/') {
+				end++
+			}
+			end = min(end+2, len(runes))
+			if dbType == "mysql" && i+2 < len(runes) && runes[i+2] == '!' {
+				add(Hint, i, end)
+			} else {
+				space = true
+			}
+			i = end
+
+		case r == '\'':
+			end := quoted(runes, i, r, dbType == "mysql")
+			add(String, i, end)
+			i = end
+
+		case r == '"':
+			// MySQL reads double quotes as strings unless ANSI_QUOTES is set.
+			end := quoted(runes, i, r, dbType == "mysql")
+			if dbType == "mysql" {
+				add(String, i, end)
+			} else {
+				add(Identifier, i, end)
+			}
+			i = end
+
+		case r == '`':
+			end := quoted(runes, i, r, false)
+			add(Identifier, i, end)
+			i = end
+
+		case r == '[' && dbType == "sqlite":
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			end = min(end+1, len(runes))
+			add(Identifier, i, end)
+			i = end
+
+		case r == '$' && dbType == "postgresql" && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			end := i + 1
+			for end < len(runes) && unicode.IsDigit(runes[end]) {
+				end++
+			}
+			add(Param, i, end)
+			i = end
+
+		case r == '$' && dbType == "postgresql" && dollarTag(runes, i) != nil:
+			tag := dollarTag(runes, i)
+			end := len(runes)
+			for j := i + len(tag); j+len(tag) <= len(runes); j++ {
+				if string(runes[j:j+len(tag)]) == string(tag) {
+					end = j + len(tag)
+					break
+				}
+			}
+			add(String, i, end)
+			i = end
+
+		case r == '?' && dbType != "postgresql":
+			add(Param, i, i+1)
+			i++
+
+		case r == ';':
+			add(Semicolon, i, i+1)
+			i++
+
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			end := number(runes, i)
+			add(Number, i, end)
+			i = end
+
+		case isWordRune(r):
+			end := i
+			for end < len(runes) && isWordRune(runes[end]) {
+				end++
+			}
+			add(Word, i, end)
+			i = end
+
+		case isSymbolRune(r, dbType):
+			end := i + 1
+			for end < len(runes) && isSymbolRune(runes[end], dbType) &&
+				!(runes[end] == '-' && end+1 < len(runes) && runes[end+1] == '-') &&
+				!(runes[end] == '/' && end+1 < len(runes) && runes[end+1] == '*') {
+				end++
+			}
+			add(Symbol, i, end)
+			i = end
+
+		default:
+			add(Symbol, i, i+1)
+			i++
+		}
+	}
+	return tokens
+}
+
+// isSymbolRune reports whether r can be part of an operator. ? is a placeholder outside
+// PostgreSQL, where it starts the jsonb operators instead.
+func isSymbolRune(r rune, dbType string) bool {
+	return strings.ContainsRune(symbolRunes, r) || r == '?' && dbType == "postgresql"
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// quoted returns the index after the literal or quoted identifier starting at start. A doubled
+// quote stands for the quote itself; backslashes escape the next character when allowed.
+func quoted(runes []rune, start int, quote rune, backslash bool) int {
+	for i := start + 1; i < len(runes); i++ {
+		switch {
+		case backslash && runes[i] == '\\':
+			i++
+		case runes[i] == quote:
+			if i+1 < len(runes) && runes[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(runes)
+}
+
+// dollarTag returns the tag of a PostgreSQL dollar-quoted string starting at start, such as
+// "$$" or "$body$", or nil when there is none.
+func dollarTag(runes []rune, start int) []rune {
+	for i := start + 1; i < len(runes); i++ {
+		switch {
+		case runes[i] == '$':
+			return runes[start : i+1]
+		case runes[i] == '_' || unicode.IsLetter(runes[i]) || (i > start+1 && unicode.IsDigit(runes[i])):
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// number returns the index after the numeric literal starting at start: decimal with an
+// optional fraction and exponent, or hexadecimal.
+func number(runes []rune, start int) int {
+	i := start
+	if runes[i] == '0' && i+1 < len(runes) && (runes[i+1] == 'x' || runes[i+1] == 'X') {
+		i += 2
+		for i < len(runes) && strings.ContainsRune("0123456789abcdefABCDEF", runes[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+		i++
+	}
+	if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+		j := i + 1
+		if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+			j++
+		}
+		if j < len(runes) && unicode.IsDigit(runes[j]) {
+			i = j
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
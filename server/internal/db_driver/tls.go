@@ -0,0 +1,49 @@
+package dbdriver
+
+import (
+	"errors"
+	"net"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tlsconfig"
+)
+
+// ValidateTLSSettings checks the TLS settings of a connection, with their certificates and key
+// decrypted, before they are saved.
+func ValidateTLSSettings(settings *schema.TLSSettings, dbType string) error {
+	if settings == nil {
+		return nil
+	}
+	switch dbType {
+	case "sqlite":
+		return errors.New("SQLite connections do not use TLS")
+	case "mysql":
+		if settings.Mode == tlsconfig.Prefer && settings.ClientCert != "" {
+			return errors.New("MySQL only presents a client certificate under require, verify-ca or verify-full")
+		}
+	case "mongodb":
+		if settings.Mode == tlsconfig.Prefer {
+			return errors.New("MongoDB connections either require TLS or disable it")
+		}
+	}
+	return tlsconfig.Validate(settings)
+}
+
+// TunnelTLSSettings returns a copy of TLS settings that keep verifying the database host of a
+// connection string once the string points to the local end of an SSH tunnel.
+func TunnelTLSSettings(settings *schema.TLSSettings, connStr, dbType string) (*schema.TLSSettings, error) {
+	if settings == nil || settings.ServerName != "" {
+		return settings, nil
+	}
+	target, err := tunnelTarget(connStr, dbType)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	out := *settings
+	out.ServerName = host
+	return &out, nil
+}
@@ -0,0 +1,124 @@
+// Package tlsconfig builds the TLS configuration of a connection from its TLS settings.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/utils/secure"
+)
+
+// The modes of TLS settings, named after the SSL modes of libpq.
+const (
+	Disable    = "disable"     // plain connections
+	Prefer     = "prefer"      // TLS when the server supports it, without verifying the server
+	Require    = "require"     // TLS, verifying the server only against a given CA bundle
+	VerifyCA   = "verify-ca"   // TLS with a server certificate signed by a trusted CA
+	VerifyFull = "verify-full" // as verify-ca, with a certificate for the name of the server
+)
+
+// Validate checks TLS settings, with their certificates and key decrypted.
+func Validate(settings *schema.TLSSettings) error {
+	_, err := Config(settings, "")
+	return err
+}
+
+// Config builds the TLS configuration of settings, with their certificates and key decrypted.
+// It returns nil when TLS is disabled. Under verify-full, host is the name the certificate of the
+// server must have when settings have no server name; if both are empty, the driver fills in the
+// host it connects to.
+func Config(settings *schema.TLSSettings, host string) (*tls.Config, error) {
+	switch settings.Mode {
+	case Disable:
+		if settings.CACert != "" || settings.ClientCert != "" || settings.ClientKey != "" {
+			return nil, errors.New("certificates do not apply when TLS is disabled")
+		}
+		return nil, nil
+	case Prefer, Require, VerifyCA, VerifyFull:
+	default:
+		return nil, errors.New("invalid TLS mode: " + settings.Mode)
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: settings.ServerName}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	if (settings.ClientCert == "") != (settings.ClientKey == "") {
+		return nil, errors.New("a client certificate needs its key, and a key its certificate")
+	}
+	if settings.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(settings.ClientCert), []byte(settings.ClientKey))
+		if err != nil {
+			return nil, errors.New("invalid client certificate: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	var roots *x509.CertPool // the system roots
+	if settings.CACert != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(settings.CACert)) {
+			return nil, errors.New("invalid CA bundle: no PEM certificate found")
+		}
+	}
+
+	switch {
+	case settings.Mode == VerifyFull:
+		config.RootCAs = roots
+	case settings.Mode == VerifyCA || settings.Mode == Require && roots != nil:
+		// As in libpq, require with a CA bundle verifies the chain. The standard verification also
+		// checks the name, so it is skipped for a chain-only one.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyChain(state, roots)
+		}
+	default:
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+// verifyChain checks that the certificate of the server is signed by one of the roots, whatever
+// name it is for.
+func verifyChain(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server sent no TLS certificate")
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Encrypt returns a copy of TLS settings with their certificates and key encrypted, to be stored.
+func Encrypt(settings *schema.TLSSettings, key string) (*schema.TLSSettings, error) {
+	return transformPEMs(settings, func(pem string) (string, error) {
+		return secure.Encrypt(pem, key)
+	})
+}
+
+// Decrypt returns a copy of stored TLS settings with their certificates and key decrypted.
+func Decrypt(settings *schema.TLSSettings, key string) (*schema.TLSSettings, error) {
+	return transformPEMs(settings, func(pem string) (string, error) {
+		return secure.Decrypt(pem, key)
+	})
+}
+
+func transformPEMs(settings *schema.TLSSettings, transform func(string) (string, error)) (*schema.TLSSettings, error) {
+	out := *settings
+	for _, pem := range []*string{&out.CACert, &out.ClientCert, &out.ClientKey} {
+		if *pem == "" {
+			continue
+		}
+		var err error
+		if *pem, err = transform(*pem); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
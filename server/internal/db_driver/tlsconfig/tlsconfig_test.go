@@ -0,0 +1,227 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+)
+
+// testCA signs the certificates of a test database server and of its clients.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+// issue returns the PEM certificate and key of a server with the given name, or of a client.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// newServer serves TLS as db.internal with a certificate of ca, and requires a client certificate
+// of ca when clientCA is set.
+func newServer(t *testing.T, ca *testCA, clientCA bool) string {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, "db.internal", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = x509.NewCertPool()
+		config.ClientCAs.AddCert(ca.cert)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// handshake connects to a server with the configuration of settings, for a database at host.
+func handshake(t *testing.T, addr string, settings *schema.TLSSettings, host string) error {
+	t.Helper()
+	config, err := Config(settings, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Under TLS 1.3 the server checks the certificate of the client after the handshake.
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func TestVerifyModes(t *testing.T) {
+	ca, other := newCA(t), newCA(t)
+	addr := newServer(t, ca, false)
+
+	tests := []struct {
+		settings schema.TLSSettings
+		host     string
+		ok       bool
+	}{
+		{schema.TLSSettings{Mode: Require}, "127.0.0.1", true},
+		{schema.TLSSettings{Mode: Prefer}, "127.0.0.1", true},
+		{schema.TLSSettings{Mode: Require, CACert: other.pem}, "127.0.0.1", false},
+		{schema.TLSSettings{Mode: VerifyCA, CACert: ca.pem}, "127.0.0.1", true},
+		{schema.TLSSettings{Mode: VerifyCA, CACert: other.pem}, "127.0.0.1", false},
+		{schema.TLSSettings{Mode: VerifyCA}, "127.0.0.1", false}, // not signed by a system root
+		{schema.TLSSettings{Mode: VerifyFull, CACert: ca.pem}, "db.internal", true},
+		{schema.TLSSettings{Mode: VerifyFull, CACert: ca.pem}, "127.0.0.1", false},
+		{schema.TLSSettings{Mode: VerifyFull, CACert: ca.pem, ServerName: "db.internal"}, "127.0.0.1", true},
+		{schema.TLSSettings{Mode: VerifyFull, CACert: other.pem}, "db.internal", false},
+	}
+	for _, test := range tests {
+		err := handshake(t, addr, &test.settings, test.host)
+		if test.ok && err != nil {
+			t.Errorf("%s with %+v: %v", test.host, test.settings, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s with %+v: connected", test.host, test.settings)
+		}
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca := newCA(t)
+	addr := newServer(t, ca, true)
+
+	settings := &schema.TLSSettings{Mode: VerifyFull, CACert: ca.pem}
+	if err := handshake(t, addr, settings, "db.internal"); err == nil {
+		t.Fatal("connected without a client certificate")
+	}
+	settings.ClientCert, settings.ClientKey = ca.issue(t, "app", x509.ExtKeyUsageClientAuth)
+	if err := handshake(t, addr, settings, "db.internal"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	ca := newCA(t)
+	certPEM, keyPEM := ca.issue(t, "app", x509.ExtKeyUsageClientAuth)
+
+	config, err := Config(&schema.TLSSettings{Mode: Disable}, "db.internal")
+	if err != nil || config != nil {
+		t.Fatalf("disable: %v, %v", config, err)
+	}
+	for _, settings := range []schema.TLSSettings{
+		{Mode: "allow"},
+		{Mode: Disable, CACert: ca.pem},
+		{Mode: VerifyFull, CACert: "not a certificate"},
+		{Mode: Require, ClientCert: certPEM},
+		{Mode: Require, ClientKey: keyPEM},
+		{Mode: Require, ClientCert: ca.pem, ClientKey: keyPEM},
+	} {
+		if err := Validate(&settings); err == nil {
+			t.Errorf("validated %+v", settings)
+		}
+	}
+	if err := Validate(&schema.TLSSettings{Mode: VerifyFull, CACert: ca.pem, ClientCert: certPEM, ClientKey: keyPEM}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncryptPEMs(t *testing.T) {
+	const key = "0123456789abcdef0123456789abcdef"
+	settings := &schema.TLSSettings{Mode: VerifyFull, CACert: "ca", ClientCert: "cert", ClientKey: "key", ServerName: "db.internal"}
+
+	encrypted, err := Encrypt(settings, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted.CACert == "ca" || encrypted.ClientCert == "cert" || encrypted.ClientKey == "key" ||
+		encrypted.Mode != VerifyFull || encrypted.ServerName != "db.internal" {
+		t.Fatalf("encrypted settings: %+v", encrypted)
+	}
+	decrypted, err := Decrypt(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decrypted != *settings {
+		t.Fatalf("decrypted settings: %+v, want %+v", decrypted, settings)
+	}
+}
@@ -0,0 +1,125 @@
+package dbdriver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tunnel"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// OpenTunnel opens an SSH tunnel to the database of a connection string and returns the
+// connection string that reaches the database through it. The secrets of settings must be decrypted.
+func OpenTunnel(ctx context.Context, settings *schema.SSHTunnel, connStr, dbType string) (*tunnel.Tunnel, string, error) {
+	remote, err := tunnelTarget(connStr, dbType)
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := tunnel.Open(ctx, settings, remote)
+	if err != nil {
+		return nil, "", err
+	}
+	tunneled, err := throughTunnel(connStr, dbType, t.Addr())
+	if err != nil {
+		t.Close()
+		return nil, "", err
+	}
+	return t, tunneled, nil
+}
+
+// tunnelTarget returns the host:port of the database of a connection string.
+func tunnelTarget(connStr, dbType string) (string, error) {
+	switch dbType {
+	case "postgresql":
+		config, err := pgconn.ParseConfig(connStr)
+		if err != nil {
+			return "", err
+		}
+		// Fallbacks also retry the same host without TLS.
+		for _, fallback := range config.Fallbacks {
+			if fallback.Host != config.Host || fallback.Port != config.Port {
+				return "", errors.New("an SSH tunnel reaches a single PostgreSQL host")
+			}
+		}
+		if strings.HasPrefix(config.Host, "/") {
+			return "", errors.New("an SSH tunnel cannot reach a PostgreSQL Unix socket")
+		}
+		return net.JoinHostPort(config.Host, strconv.Itoa(int(config.Port))), nil
+	case "mysql":
+		config, err := mysql.ParseDSN(connStr)
+		if err != nil {
+			return "", err
+		}
+		if config.Net != "tcp" {
+			return "", errors.New("an SSH tunnel only reaches MySQL over TCP")
+		}
+		return config.Addr, nil
+	case "mongodb":
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "mongodb" {
+			return "", errors.New("an SSH tunnel cannot reach the hosts of a mongodb+srv connection string")
+		}
+		if strings.Contains(u.Host, ",") {
+			return "", errors.New("an SSH tunnel reaches a single MongoDB host")
+		}
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "27017"), nil
+		}
+		return u.Host, nil
+	case "sqlite":
+		return "", errors.New("SQLite connections cannot use an SSH tunnel")
+	default:
+		return "", errors.New("unsupported database type: " + dbType)
+	}
+}
+
+// throughTunnel points a connection string to the local address of a tunnel.
+func throughTunnel(connStr, dbType, localAddr string) (string, error) {
+	host, port, err := net.SplitHostPort(localAddr)
+	if err != nil {
+		return "", err
+	}
+
+	switch dbType {
+	case "postgresql":
+		if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+			u, err := url.Parse(connStr)
+			if err != nil {
+				return "", err
+			}
+			u.Host = localAddr
+			return u.String(), nil
+		}
+		// In keyword/value strings the last setting of a keyword wins.
+		return connStr + " host=" + host + " port=" + port, nil
+	case "mysql":
+		config, err := mysql.ParseDSN(connStr)
+		if err != nil {
+			return "", err
+		}
+		config.Addr = localAddr
+		return config.FormatDSN(), nil
+	case "mongodb":
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "", err
+		}
+		u.Host = localAddr
+		// The members of a replica set advertise addresses the tunnel does not forward.
+		query := u.Query()
+		query.Set("directConnection", "true")
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	default:
+		return "", errors.New("unsupported database type: " + dbType)
+	}
+}
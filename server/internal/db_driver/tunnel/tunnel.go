@@ -0,0 +1,323 @@
+// Package tunnel forwards a local port to a database through an SSH jump host.
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/utils/secure"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// keepAliveInterval is how often the tunnel checks that the jump host still answers.
+var keepAliveInterval = 30 * time.Second
+
+// dialTimeout bounds connecting and authenticating to the jump host.
+const dialTimeout = 10 * time.Second
+
+// Tunnel listens on a local port and forwards every connection it accepts to a remote address
+// through an SSH connection to a jump host. The SSH connection is kept alive, and opened again
+// when the jump host drops it.
+type Tunnel struct {
+	jumpHost string
+	remote   string
+	config   *ssh.ClientConfig
+	listener net.Listener
+
+	mu     sync.Mutex
+	client *ssh.Client // nil until reconnected after a failure
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Validate checks the settings of a tunnel, with its secrets decrypted.
+func Validate(settings *schema.SSHTunnel) error {
+	_, err := clientConfig(settings)
+	return err
+}
+
+// Open connects to the jump host and starts forwarding a local port to remote, the host:port of
+// the database as seen from the jump host. The secrets of settings must be decrypted.
+func Open(ctx context.Context, settings *schema.SSHTunnel, remote string) (*Tunnel, error) {
+	config, err := clientConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	port := settings.Port
+	if port == "" {
+		port = "22"
+	}
+
+	t := &Tunnel{
+		jumpHost: net.JoinHostPort(settings.Host, port),
+		remote:   remote,
+		config:   config,
+		closed:   make(chan struct{}),
+	}
+	// Connect right away, so that wrong credentials or host keys fail the activation.
+	t.client, err = t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.client.Close()
+		return nil, err
+	}
+
+	go t.accept()
+	go t.keepAlive()
+	return t, nil
+}
+
+// Addr returns the local host:port that is forwarded to the remote address.
+func (t *Tunnel) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// Close stops forwarding and closes the SSH connection, with the forwarded connections on it.
+func (t *Tunnel) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.listener.Close()
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.client != nil {
+			t.client.Close()
+			t.client = nil
+		}
+	})
+	return err
+}
+
+func (t *Tunnel) accept() {
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(local)
+	}
+}
+
+func (t *Tunnel) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := t.dialRemote()
+	if err != nil {
+		log.Println("Error forwarding through SSH tunnel to", t.remote+":", err)
+		return
+	}
+	defer remote.Close()
+
+	// Either side hanging up ends the forward, and the deferred closes end the other copy.
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dialRemote opens a connection to the remote address through the jump host, connecting to the
+// jump host again once if the SSH connection has been lost.
+func (t *Tunnel) dialRemote() (net.Conn, error) {
+	client, err := t.sshClient()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial("tcp", t.remote)
+	if err == nil {
+		return conn, nil
+	}
+
+	t.drop(client)
+	if client, err = t.sshClient(); err != nil {
+		return nil, err
+	}
+	return client.Dial("tcp", t.remote)
+}
+
+// sshClient returns the SSH connection to the jump host, connecting again if it was dropped.
+func (t *Tunnel) sshClient() (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.closed:
+		return nil, errors.New("ssh tunnel closed")
+	default:
+	}
+	if t.client == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		client, err := t.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.client = client
+	}
+	return t.client, nil
+}
+
+// drop closes an SSH connection that failed, unless it has already been replaced.
+func (t *Tunnel) drop(client *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == client {
+		t.client = nil
+	}
+	client.Close()
+}
+
+func (t *Tunnel) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-ticker.C:
+		}
+
+		t.mu.Lock()
+		client := t.client
+		t.mu.Unlock()
+		if client == nil {
+			continue
+		}
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			log.Println("SSH tunnel to", t.jumpHost, "lost:", err)
+			t.drop(client)
+		}
+	}
+}
+
+func (t *Tunnel) dial(ctx context.Context) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", t.jumpHost)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, t.jumpHost, t.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// clientConfig builds the SSH configuration of a tunnel, which authenticates with its private key
+// or password and only trusts the host keys in its known_hosts lines.
+func clientConfig(settings *schema.SSHTunnel) (*ssh.ClientConfig, error) {
+	if settings.Host == "" || settings.User == "" {
+		return nil, errors.New("ssh tunnel needs a host and a user")
+	}
+	if settings.KnownHosts == "" {
+		return nil, errors.New("ssh tunnel needs the known_hosts entry of its host")
+	}
+
+	var auth []ssh.AuthMethod
+	if settings.PrivateKey != "" {
+		var (
+			signer ssh.Signer
+			err    error
+		)
+		if settings.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(settings.PrivateKey), []byte(settings.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(settings.PrivateKey))
+		}
+		if err != nil {
+			return nil, errors.New("invalid ssh private key: " + err.Error())
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if settings.Password != "" {
+		auth = append(auth, ssh.Password(settings.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("ssh tunnel needs a private key or a password")
+	}
+
+	hostKeyCallback, err := knownHostsCallback(settings.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            settings.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// knownHostsCallback checks host keys against known_hosts lines. The knownhosts package only
+// reads files, so the lines go through a temporary one.
+func knownHostsCallback(lines string) (ssh.HostKeyCallback, error) {
+	file, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(lines + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := knownhosts.New(file.Name())
+	if err != nil {
+		return nil, errors.New("invalid known_hosts: " + err.Error())
+	}
+	return callback, nil
+}
+
+// Encrypt returns a copy of the settings of a tunnel with its secrets encrypted, to be stored.
+func Encrypt(settings *schema.SSHTunnel, key string) (*schema.SSHTunnel, error) {
+	return transformSecrets(settings, func(secret string) (string, error) {
+		return secure.Encrypt(secret, key)
+	})
+}
+
+// Decrypt returns a copy of the stored settings of a tunnel with its secrets decrypted.
+func Decrypt(settings *schema.SSHTunnel, key string) (*schema.SSHTunnel, error) {
+	return transformSecrets(settings, func(secret string) (string, error) {
+		return secure.Decrypt(secret, key)
+	})
+}
+
+func transformSecrets(settings *schema.SSHTunnel, transform func(string) (string, error)) (*schema.SSHTunnel, error) {
+	out := *settings
+	for _, secret := range []*string{&out.Password, &out.PrivateKey, &out.Passphrase} {
+		if *secret == "" {
+			continue
+		}
+		var err error
+		if *secret, err = transform(*secret); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
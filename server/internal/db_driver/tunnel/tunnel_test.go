@@ -0,0 +1,342 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an in-process SSH server that accepts a password or a public key and serves
+// direct-tcpip channels, as a jump host does for local port-forwards.
+type testServer struct {
+	listener   net.Listener
+	hostKey    ssh.Signer
+	keepAlives atomic.Int32
+
+	mu    sync.Mutex
+	conns []*ssh.ServerConn
+}
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+func newTestServer(t *testing.T, password string, authorized ssh.PublicKey) *testServer {
+	t.Helper()
+	hostKey, _ := newSigner(t)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, given []byte) (*ssh.Permissions, error) {
+			if conn.User() == "tunnel" && password != "" && string(given) == password {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "tunnel" && authorized != nil && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, hostKey: hostKey}
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropAll()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, sshConn)
+	s.mu.Unlock()
+
+	go func() {
+		for req := range reqs {
+			if req.Type == "keepalive@openssh.com" {
+				s.keepAlives.Add(1)
+			}
+			if req.WantReply {
+				req.Reply(req.Type == "keepalive@openssh.com", nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+			continue
+		}
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, channelReqs, err := newChannel.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channelReqs)
+		go func() {
+			defer channel.Close()
+			defer remote.Close()
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(channel, remote); done <- struct{}{} }()
+			go func() { io.Copy(remote, channel); done <- struct{}{} }()
+			<-done
+		}()
+	}
+}
+
+// dropAll closes the SSH connections of every client, as a restarting jump host would.
+func (s *testServer) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) settings() *schema.SSHTunnel {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &schema.SSHTunnel{
+		Host:       host,
+		Port:       port,
+		User:       "tunnel",
+		KnownHosts: knownhosts.Line([]string{knownhosts.Normalize(s.listener.Addr().String())}, s.hostKey.PublicKey()),
+	}
+}
+
+// newEchoServer stands in for the database behind the jump host.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func openTunnel(t *testing.T, settings *schema.SSHTunnel, remote string) *Tunnel {
+	t.Helper()
+	tun, err := Open(context.Background(), settings, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tun.Close() })
+	return tun
+}
+
+func assertEcho(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("got %q through the tunnel, want %q", reply, "ping")
+	}
+}
+
+func TestTunnelWithPassword(t *testing.T) {
+	server := newTestServer(t, "secret", nil)
+	settings := server.settings()
+	settings.Password = "secret"
+
+	tun := openTunnel(t, settings, newEchoServer(t))
+	assertEcho(t, tun.Addr())
+	assertEcho(t, tun.Addr())
+}
+
+func TestTunnelWithPrivateKey(t *testing.T) {
+	signer, key := newSigner(t)
+	server := newTestServer(t, "", signer.PublicKey())
+	echo := newEchoServer(t)
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := server.settings()
+	settings.PrivateKey = string(pem.EncodeToMemory(block))
+	assertEcho(t, openTunnel(t, settings, echo).Addr())
+
+	block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.PrivateKey = string(pem.EncodeToMemory(block))
+	settings.Passphrase = "passphrase"
+	assertEcho(t, openTunnel(t, settings, echo).Addr())
+}
+
+func TestTunnelRejectsBadCredentials(t *testing.T) {
+	server := newTestServer(t, "secret", nil)
+
+	settings := server.settings()
+	settings.Password = "wrong"
+	if tun, err := Open(context.Background(), settings, newEchoServer(t)); err == nil {
+		tun.Close()
+		t.Fatal("opened a tunnel with a wrong password")
+	}
+}
+
+func TestTunnelVerifiesHostKey(t *testing.T) {
+	server := newTestServer(t, "secret", nil)
+	other, _ := newSigner(t)
+
+	settings := server.settings()
+	settings.Password = "secret"
+	settings.KnownHosts = knownhosts.Line([]string{knownhosts.Normalize(server.listener.Addr().String())}, other.PublicKey())
+	if tun, err := Open(context.Background(), settings, newEchoServer(t)); err == nil {
+		tun.Close()
+		t.Fatal("opened a tunnel to a host with an unknown key")
+	}
+
+	settings.KnownHosts = ""
+	if err := Validate(settings); err == nil {
+		t.Fatal("validated a tunnel without known_hosts")
+	}
+}
+
+func TestTunnelReconnects(t *testing.T) {
+	server := newTestServer(t, "secret", nil)
+	settings := server.settings()
+	settings.Password = "secret"
+
+	tun := openTunnel(t, settings, newEchoServer(t))
+	assertEcho(t, tun.Addr())
+	server.dropAll()
+	assertEcho(t, tun.Addr())
+}
+
+func TestTunnelKeepAlive(t *testing.T) {
+	interval := keepAliveInterval
+	keepAliveInterval = 10 * time.Millisecond
+	t.Cleanup(func() { keepAliveInterval = interval })
+
+	server := newTestServer(t, "secret", nil)
+	settings := server.settings()
+	settings.Password = "secret"
+	openTunnel(t, settings, newEchoServer(t))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.keepAlives.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no keepalive reached the jump host")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTunnelClose(t *testing.T) {
+	server := newTestServer(t, "secret", nil)
+	settings := server.settings()
+	settings.Password = "secret"
+
+	tun := openTunnel(t, settings, newEchoServer(t))
+	if err := tun.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.DialTimeout("tcp", tun.Addr(), time.Second); err == nil {
+		conn.Close()
+		t.Fatal("tunnel still listens after Close")
+	}
+}
+
+func TestEncryptSecrets(t *testing.T) {
+	const key = "0123456789abcdef0123456789abcdef"
+	settings := &schema.SSHTunnel{Host: "bastion", User: "tunnel", Password: "secret", PrivateKey: "key", KnownHosts: "line"}
+
+	encrypted, err := Encrypt(settings, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted.Password == "secret" || encrypted.PrivateKey == "key" || encrypted.Passphrase != "" || encrypted.KnownHosts != "line" {
+		t.Fatalf("encrypted settings: %+v", encrypted)
+	}
+	decrypted, err := Decrypt(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decrypted != *settings {
+		t.Fatalf("decrypted settings: %+v, want %+v", decrypted, settings)
+	}
+}
@@ -0,0 +1,187 @@
+package dump
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/export"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+// FormatVersion is the version of the archive layout written by Write. Restore reads archives
+// up to this version.
+const FormatVersion = 1
+
+// An archive is a zip file holding:
+//
+//	manifest.json            the Manifest, written last
+//	schema.sql               CREATE TABLE statements in the dialect of the source engine
+//	data/0001_<table>.ndjson one JSON object per row, values encoded like query results
+const (
+	manifestName = "manifest.json"
+	schemaName   = "schema.sql"
+)
+
+var ErrUnknownTable = errors.New("unknown table")
+
+// Manifest describes the content of an archive.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Engine    string    `json:"engine"`
+	Database  string    `json:"database,omitempty"`
+	Schema    string    `json:"schema,omitempty"`
+	Tables    []Table   `json:"tables"`
+}
+
+// Table is a dumped table or collection. Columns carry the engine type of the source, which
+// is kept on a restore into the same engine; other engines create the columns from their kind.
+type Table struct {
+	Name     string            `json:"name"`
+	Columns  []importer.Column `json:"columns"`
+	RowCount int64             `json:"row_count"`
+	Data     string            `json:"data"`
+}
+
+// ResolveTables checks the requested tables against the tables of the database. No request
+// means every table.
+func ResolveTables(ctx context.Context, pool interface{}, dbType, dbName string, requested []string) ([]string, error) {
+	rawTables, err := dbdriver.ExtractDBTables(ctx, pool, dbType, dbName)
+	if err != nil {
+		return nil, err
+	}
+	tables, _ := rawTables.([]string)
+	if len(requested) == 0 {
+		return tables, nil
+	}
+	for _, name := range requested {
+		if !slices.Contains(tables, name) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTable, name)
+		}
+	}
+	return requested, nil
+}
+
+// Write dumps the schema and rows of the tables into an archive. Tables are read one after the
+// other, so a database that is written to meanwhile is not captured as a single snapshot. When
+// an error is returned the archive is left without its central directory, so that a partial
+// dump cannot be mistaken for a complete one.
+func Write(ctx context.Context, w io.Writer, pool interface{}, dbType, dbName string, tables []string) (*Manifest, error) {
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Engine:    dbType,
+		Database:  dbName,
+		Tables:    make([]Table, 0, len(tables)),
+	}
+	archive := zip.NewWriter(w)
+
+	var ddl strings.Builder
+	for i, name := range tables {
+		table, err := writeTable(ctx, archive, pool, dbType, dbName, name, i+1)
+		if err != nil {
+			return manifest, fmt.Errorf("dumping %s: %w", name, err)
+		}
+		statement, err := dbdriver.TableDDL(dbType, name, table.Columns)
+		if err != nil {
+			return manifest, err
+		}
+		if statement != "" {
+			ddl.WriteString(statement + ";\n\n")
+		}
+		manifest.Tables = append(manifest.Tables, *table)
+	}
+
+	if ddl.Len() > 0 {
+		manifest.Schema = schemaName
+		if err := writeFile(archive, schemaName, []byte(ddl.String())); err != nil {
+			return manifest, err
+		}
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeFile(archive, manifestName, b); err != nil {
+		return manifest, err
+	}
+	return manifest, archive.Close()
+}
+
+// writeTable writes the rows of a table to its data file.
+func writeTable(ctx context.Context, archive *zip.Writer, pool interface{}, dbType, dbName, name string, n int) (*Table, error) {
+	columns, err := dbdriver.GetDumpColumns(ctx, pool, dbType, dbName, name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dbdriver.GetTableRecords(ctx, pool, dbType, dbName, name, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if columns == nil {
+		columns = DocumentColumns(rows.Columns())
+	}
+
+	table := &Table{Name: name, Columns: columns, Data: fmt.Sprintf("data/%04d_%s.ndjson", n, fileName(name))}
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: table.Data, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	writer, err := export.NewWriter(file, export.FormatNDJSON, export.DefaultOptions())
+	if err != nil {
+		return nil, err
+	}
+	summary, err := result.Copy(writer, rows, 0)
+	table.RowCount = summary.RowCount
+	return table, err
+}
+
+// DocumentColumns describes the fields found in the documents of a collection. _id is the key;
+// fields that were null in every document hold text.
+func DocumentColumns(fields []result.Column) []importer.Column {
+	columns := make([]importer.Column, len(fields))
+	for i, field := range fields {
+		col := importer.Column{Name: field.Name, Kind: field.Kind, Nullable: field.Nullable == nil || *field.Nullable}
+		if col.Kind == result.KindUnknown {
+			col.Kind = result.KindString
+		}
+		if col.Name == "_id" {
+			col.PrimaryKey = true
+			col.Nullable = false
+		}
+		columns[i] = col
+	}
+	return columns
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// fileName turns a table name into a name that is safe to use in any file system.
+func fileName(table string) string {
+	name := unsafeFileChars.ReplaceAllString(table, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+func writeFile(archive *zip.Writer, name string, content []byte) error {
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	return err
+}
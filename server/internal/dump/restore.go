@@ -0,0 +1,296 @@
+package dump
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+var (
+	ErrInvalidArchive = errors.New("invalid archive")
+	ErrTableExists    = errors.New("table already exists")
+	ErrLossyTypes     = errors.New("columns have no matching type on the target engine")
+)
+
+// Archive is an archive opened for restore.
+type Archive struct {
+	Manifest Manifest
+	files    *zip.Reader
+}
+
+// OpenArchive reads the manifest of an archive and checks that every data file it lists is there.
+func OpenArchive(r io.ReaderAt, size int64) (*Archive, error) {
+	files, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	file, err := files.Open(manifestName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no manifest, the dump may not have completed", ErrInvalidArchive)
+	}
+	defer file.Close()
+
+	archive := &Archive{files: files}
+	if err := json.NewDecoder(file).Decode(&archive.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	manifest := archive.Manifest
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: version %d is not supported, expected at most %d", ErrInvalidArchive, manifest.Version, FormatVersion)
+	}
+	for _, table := range manifest.Tables {
+		if strings.TrimSpace(table.Name) == "" {
+			return nil, fmt.Errorf("%w: a table has no name", ErrInvalidArchive)
+		}
+		if _, err := files.Open(table.Data); err != nil {
+			return nil, fmt.Errorf("%w: missing data file for %s", ErrInvalidArchive, table.Name)
+		}
+	}
+	return archive, nil
+}
+
+// open returns a source reading the rows of a table.
+func (a *Archive) open(table Table) (importer.Source, io.Closer, error) {
+	file, err := a.files.Open(table.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	src, err := importer.Open(streamFile{file}, info.Size(), importer.FormatNDJSON, importer.ReadOptions{})
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return src, file, nil
+}
+
+// streamFile is a compressed file of the archive. NDJSON is read front to back, so it never
+// needs random access.
+type streamFile struct {
+	io.Reader
+}
+
+func (streamFile) ReadAt([]byte, int64) (int, error) {
+	return 0, errors.New("archive files cannot be read at an offset")
+}
+
+type IfExists string
+
+const (
+	IfExistsFail   IfExists = "fail"   // refuse to restore when a table already exists
+	IfExistsSkip   IfExists = "skip"   // leave existing tables alone
+	IfExistsAppend IfExists = "append" // load the rows into existing tables
+)
+
+// RestoreOptions controls what is restored and how.
+type RestoreOptions struct {
+	Tables   []string // tables of the archive to restore, all when empty
+	IfExists IfExists
+	// Lossy creates columns without a matching type on the target engine, such as intervals on
+	// MySQL or geometries anywhere but their own engine, as text instead of refusing the restore.
+	Lossy bool
+	Load  importer.Options
+}
+
+const (
+	StatusCreated  = "created"
+	StatusAppended = "appended"
+	StatusSkipped  = "skipped"
+)
+
+// TableResult reports the restore of one table.
+type TableResult struct {
+	Table   string            `json:"table"`
+	Status  string            `json:"status"`
+	Summary *importer.Summary `json:"summary,omitempty"`
+}
+
+// RestoreSummary reports the outcome of a restore.
+type RestoreSummary struct {
+	Version   int           `json:"version"`
+	Engine    string        `json:"engine"` // engine the archive was dumped from
+	CreatedAt time.Time     `json:"created_at"`
+	Tables    []TableResult `json:"tables"`
+	Duration  int64         `json:"duration"`
+}
+
+// restoreStep is a table to restore and the columns it is loaded into.
+type restoreStep struct {
+	table   Table
+	status  string
+	targets []importer.Column
+}
+
+// Restore loads the tables of the archive into the database. Every table is checked before
+// anything is written; tables are then created and loaded one after the other, so a failure
+// leaves the tables restored until then in place. Foreign keys and indexes other than the
+// primary key are not part of an archive.
+func Restore(ctx context.Context, archive *Archive, pool interface{}, dbType, dbName string, opts RestoreOptions, progress func(table string, p importer.Progress)) (*RestoreSummary, error) {
+	startedAt := time.Now()
+	summary := &RestoreSummary{
+		Version:   archive.Manifest.Version,
+		Engine:    archive.Manifest.Engine,
+		CreatedAt: archive.Manifest.CreatedAt,
+		Tables:    []TableResult{},
+	}
+	defer func() { summary.Duration = time.Since(startedAt).Milliseconds() }()
+
+	steps, err := planRestore(ctx, archive, pool, dbType, dbName, opts)
+	if err != nil {
+		return summary, err
+	}
+	for _, step := range steps {
+		tableResult := TableResult{Table: step.table.Name, Status: step.status}
+		if step.status != StatusSkipped {
+			tableResult.Summary, err = restoreTable(ctx, archive, pool, dbType, dbName, step, opts.Load, progress)
+		}
+		summary.Tables = append(summary.Tables, tableResult)
+		if err != nil {
+			return summary, fmt.Errorf("restoring %s: %w", step.table.Name, err)
+		}
+	}
+	return summary, nil
+}
+
+// planRestore decides what happens to every table, failing before any change when a table
+// exists and may not be touched or has columns the target engine cannot hold.
+func planRestore(ctx context.Context, archive *Archive, pool interface{}, dbType, dbName string, opts RestoreOptions) ([]restoreStep, error) {
+	tables := archive.Manifest.Tables
+	if len(opts.Tables) > 0 {
+		tables = nil
+		for _, name := range opts.Tables {
+			i := slices.IndexFunc(archive.Manifest.Tables, func(t Table) bool { return t.Name == name })
+			if i < 0 {
+				return nil, fmt.Errorf("%w: %s is not in the archive", ErrUnknownTable, name)
+			}
+			tables = append(tables, archive.Manifest.Tables[i])
+		}
+	}
+
+	existing, err := ResolveTables(ctx, pool, dbType, dbName, nil)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]restoreStep, 0, len(tables))
+	for _, table := range tables {
+		step := restoreStep{table: table, status: StatusCreated}
+		if slices.Contains(existing, table.Name) {
+			switch opts.IfExists {
+			case IfExistsSkip:
+				step.status = StatusSkipped
+			case IfExistsAppend:
+				step.status = StatusAppended
+				if step.targets, err = dbdriver.GetImportColumns(ctx, pool, dbType, dbName, table.Name); err != nil {
+					return nil, err
+				}
+				if step.targets == nil {
+					step.targets = DocumentTargets(table.Columns)
+				}
+			default:
+				return nil, fmt.Errorf("%w: %s", ErrTableExists, table.Name)
+			}
+		} else if len(table.Columns) == 0 {
+			// Only an empty collection has no columns, it is created without any.
+			if dbType != "mongodb" {
+				return nil, fmt.Errorf("%w: %s has no columns to create", ErrInvalidArchive, table.Name)
+			}
+		} else {
+			if step.targets, err = TargetColumns(archive.Manifest.Engine, dbType, table.Columns, opts.Lossy); err != nil {
+				return nil, fmt.Errorf("%s: %w", table.Name, err)
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// lossyKinds are the kinds each engine has no column type for.
+var lossyKinds = map[string][]result.Kind{
+	"postgresql": {result.KindGeometry, result.KindUnknown},
+	"mysql":      {result.KindInterval, result.KindGeometry, result.KindUnknown},
+	"sqlite":     {result.KindInterval, result.KindGeometry, result.KindUnknown},
+}
+
+// TargetColumns returns the columns a table is created with. The source types are kept on
+// the same engine; other engines get the type of each kind.
+func TargetColumns(sourceEngine, dbType string, columns []importer.Column, lossy bool) ([]importer.Column, error) {
+	targets := make([]importer.Column, len(columns))
+	var unmatched []string
+	for i, col := range columns {
+		col.Required = false
+		// The archive is user input, so only plain type names make it into the DDL.
+		if sourceEngine != dbType || !importer.ValidTypeName(col.Type) {
+			col.Type = ""
+		}
+		if col.Type == "" && slices.Contains(lossyKinds[dbType], col.Kind) {
+			if !lossy {
+				unmatched = append(unmatched, col.Name+" ("+string(col.Kind)+")")
+			}
+			col.Kind = result.KindString
+		}
+		targets[i] = col
+	}
+	if len(unmatched) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrLossyTypes, strings.Join(unmatched, ", "))
+	}
+	return targets, importer.ValidateColumns(targets)
+}
+
+// DocumentTargets returns the fields documents of an existing collection are written with.
+func DocumentTargets(columns []importer.Column) []importer.Column {
+	targets := make([]importer.Column, len(columns))
+	for i, col := range columns {
+		col.Required = false
+		col.Type = ""
+		targets[i] = col
+	}
+	return targets
+}
+
+// restoreTable creates the table when needed and loads its rows.
+func restoreTable(ctx context.Context, archive *Archive, pool interface{}, dbType, dbName string, step restoreStep, opts importer.Options, progress func(table string, p importer.Progress)) (*importer.Summary, error) {
+	if step.status == StatusCreated {
+		if err := dbdriver.CreateImportTable(ctx, pool, dbType, dbName, step.table.Name, step.targets); err != nil {
+			return nil, err
+		}
+	}
+	if step.table.RowCount == 0 {
+		return &importer.Summary{Table: step.table.Name, Created: step.status == StatusCreated, Columns: step.targets, Errors: []importer.RowError{}}, nil
+	}
+
+	src, file, err := archive.open(step.table)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	plan, err := importer.NewPlan(src.Columns(), step.targets, nil)
+	if err != nil {
+		return nil, err
+	}
+	loader, err := dbdriver.NewImportLoader(pool, dbType, dbName, step.table.Name, plan.Targets)
+	if err != nil {
+		return nil, err
+	}
+	var report func(importer.Progress)
+	if progress != nil {
+		report = func(p importer.Progress) { progress(step.table.Name, p) }
+	}
+	summary, err := importer.Run(ctx, src, plan, loader, opts, report)
+	summary.Table = step.table.Name
+	summary.Created = step.status == StatusCreated
+	return summary, err
+}
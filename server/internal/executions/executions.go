@@ -0,0 +1,147 @@
+package executions
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("execution not found")
+	ErrDuplicate = errors.New("execution ID already in use")
+)
+
+// Execution tracks a running query so that it can be cancelled by its ID.
+type Execution struct {
+	ID        string    `json:"id"`
+	ConnID    string    `json:"conn_id"`
+	UserID    string    `json:"-"`
+	StartedAt time.Time `json:"started_at"`
+
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	stop      func() bool
+	canceller func(context.Context) error
+	cancelled bool
+}
+
+type execCtxKey struct{}
+
+var (
+	execMap   = make(map[string]*Execution) // key: user ID + "/" + execution ID
+	execMutex sync.RWMutex                  // Mutex to protect access to execMap
+)
+
+// execKey scopes the client-supplied ID of an execution to its user, so that users cannot
+// collide with or probe each other's executions.
+func execKey(id, userID string) string {
+	return userID + "/" + id
+}
+
+// Start registers a new execution and returns a context bound to the given timeout.
+// When the context ends before Finish is called, the engine-specific canceller is invoked
+// so the query is stopped on the database server as well.
+func Start(parent context.Context, id, connID, userID string, timeout time.Duration) (context.Context, *Execution, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	exec := &Execution{
+		ID:        id,
+		ConnID:    connID,
+		UserID:    userID,
+		StartedAt: time.Now(),
+		cancel:    cancel,
+	}
+
+	execMutex.Lock()
+	key := execKey(id, userID)
+	if _, exists := execMap[key]; exists {
+		execMutex.Unlock()
+		cancel()
+		return nil, nil, ErrDuplicate
+	}
+	execMap[key] = exec
+	execMutex.Unlock()
+
+	exec.stop = context.AfterFunc(ctx, exec.interrupt)
+	return context.WithValue(ctx, execCtxKey{}, exec), exec, nil
+}
+
+// FromContext returns the execution attached to the context, if any.
+func FromContext(ctx context.Context) *Execution {
+	exec, _ := ctx.Value(execCtxKey{}).(*Execution)
+	return exec
+}
+
+// SetCanceller sets the function used to stop the query on the database server.
+func (e *Execution) SetCanceller(fn func(context.Context) error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.canceller = fn
+}
+
+// Cancelled reports whether the execution was cancelled through Cancel.
+func (e *Execution) Cancelled() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cancelled
+}
+
+// Interrupt stops the query on the database server without marking the execution as
+// cancelled, for example once the rest of its rows are no longer needed.
+func (e *Execution) Interrupt() {
+	e.cancel()
+}
+
+// Finish releases the execution and removes it from the registry.
+func (e *Execution) Finish() {
+	e.stop()
+	e.cancel()
+
+	execMutex.Lock()
+	delete(execMap, execKey(e.ID, e.UserID))
+	execMutex.Unlock()
+}
+
+// interrupt runs the engine-specific canceller once the execution context is done.
+func (e *Execution) interrupt() {
+	e.mu.Lock()
+	canceller := e.canceller
+	e.mu.Unlock()
+	if canceller == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	canceller(ctx)
+}
+
+// Cancel cancels the running execution with the given ID owned by the given user.
+func Cancel(id, userID string) error {
+	execMutex.RLock()
+	exec, exists := execMap[execKey(id, userID)]
+	execMutex.RUnlock()
+	if !exists {
+		return ErrNotFound
+	}
+
+	exec.mu.Lock()
+	exec.cancelled = true
+	exec.mu.Unlock()
+	exec.cancel()
+	return nil
+}
+
+// ListByConnection returns the running executions for a connection owned by the given user.
+func ListByConnection(connID, userID string) []*Execution {
+	execMutex.RLock()
+	defer execMutex.RUnlock()
+
+	var result []*Execution
+	for _, exec := range execMap {
+		if exec.ConnID == connID && exec.UserID == userID {
+			result = append(result, exec)
+		}
+	}
+	return result
+}
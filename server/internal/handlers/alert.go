@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/alerts"
+	"github.com/cprakhar/datawhiz/internal/db_driver/params"
+	"github.com/cprakhar/datawhiz/internal/scheduler"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type RequestAlertRule struct {
+	ConnID          string            `json:"conn_id" binding:"required"`
+	DBName          string            `json:"db_name"`
+	Name            string            `json:"name" binding:"required"`
+	Query           string            `json:"query" binding:"required"`
+	IntervalMinutes int               `json:"interval_minutes" binding:"required"`
+	Condition       alerts.Condition  `json:"condition"`
+	Notifiers       []alerts.Notifier `json:"notifiers"`
+	NotifyResolved  bool              `json:"notify_resolved"`
+	Enabled         *bool             `json:"enabled"` // true by default
+}
+
+// validateAlertRule checks an alert rule request and fills in its defaults, writing the error
+// response when it is invalid.
+func (h *Handler) validateAlertRule(ctx *gin.Context, req *RequestAlertRule, userID string) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		response.BadRequest(ctx, "Name is required", nil)
+		return false
+	}
+	if req.IntervalMinutes < 1 || req.IntervalMinutes > 1440 {
+		response.BadRequest(ctx, "Interval must be between 1 and 1440 minutes", nil)
+		return false
+	}
+	if len(params.Names(req.Query)) > 0 {
+		response.BadRequest(ctx, "Alert queries cannot have parameters", nil)
+		return false
+	}
+	if req.Notifiers == nil {
+		req.Notifiers = []alerts.Notifier{}
+	}
+	if err := scheduler.ValidateAlert(&req.Condition, req.Notifiers); err != nil {
+		response.BadRequest(ctx, "Invalid alert rule", err)
+		return false
+	}
+	if req.Enabled == nil {
+		enabled := true
+		req.Enabled = &enabled
+	}
+
+	conn, err := h.Cfg.Connections.GetRecord(req.ConnID, userID)
+	if err != nil {
+		response.BadRequest(ctx, "Connection not found", err)
+		return false
+	}
+	switch conn.DBType {
+	case "postgresql", "mysql", "sqlite":
+	default:
+		response.BadRequest(ctx, "Alerts run on postgresql, mysql or sqlite connections", nil)
+		return false
+	}
+	return true
+}
+
+// getAlertRule loads the alert rule from the route parameters and writes the error response if it cannot be found.
+func (h *Handler) getAlertRule(ctx *gin.Context) (*alerts.Rule, bool) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	rule, err := alerts.GetRule(h.Cfg.DBClient, ctx.Param("alert_id"), userID)
+	if err != nil {
+		if errors.Is(err, alerts.ErrNotFound) {
+			response.NotFound(ctx, "Alert rule not found")
+			return nil, false
+		}
+		response.InternalError(ctx, err)
+		return nil, false
+	}
+	return rule, true
+}
+
+// HandleCreateAlertRule creates an alert rule for the authenticated user. Its first check runs
+// on the next tick of the scheduler and records the baseline later checks compare with.
+func (h *Handler) HandleCreateAlertRule(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var req RequestAlertRule
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	if !h.validateAlertRule(ctx, &req, userID) {
+		return
+	}
+
+	now := time.Now().UTC()
+	rule, err := alerts.InsertRule(h.Cfg.DBClient, &alerts.Rule{
+		UserID:          userID,
+		ConnID:          req.ConnID,
+		DBName:          req.DBName,
+		Name:            req.Name,
+		Query:           req.Query,
+		IntervalMinutes: req.IntervalMinutes,
+		Condition:       req.Condition,
+		Notifiers:       req.Notifiers,
+		NotifyResolved:  req.NotifyResolved,
+		Enabled:         *req.Enabled,
+		State:           alerts.State{Status: alerts.StatusPending},
+		NextCheckAt:     &now,
+	})
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusCreated, "Alert rule created", rule)
+}
+
+// HandleGetAlertRules lists the alert rules of the authenticated user.
+func (h *Handler) HandleGetAlertRules(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	rules, err := alerts.ListRules(h.Cfg.DBClient, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Alert rules", rules)
+}
+
+// HandleGetAlertRule retrieves an alert rule with its state.
+func (h *Handler) HandleGetAlertRule(ctx *gin.Context) {
+	rule, ok := h.getAlertRule(ctx)
+	if !ok {
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Alert rule", rule)
+}
+
+// HandleUpdateAlertRule changes the settings of an alert rule. Its state starts over with a new baseline.
+func (h *Handler) HandleUpdateAlertRule(ctx *gin.Context) {
+	rule, ok := h.getAlertRule(ctx)
+	if !ok {
+		return
+	}
+
+	var req RequestAlertRule
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	if !h.validateAlertRule(ctx, &req, rule.UserID) {
+		return
+	}
+
+	now := time.Now().UTC()
+	updated, err := alerts.UpdateRule(h.Cfg.DBClient, &alerts.Rule{
+		ID:              rule.ID,
+		UserID:          rule.UserID,
+		ConnID:          req.ConnID,
+		DBName:          req.DBName,
+		Name:            req.Name,
+		Query:           req.Query,
+		IntervalMinutes: req.IntervalMinutes,
+		Condition:       req.Condition,
+		Notifiers:       req.Notifiers,
+		NotifyResolved:  req.NotifyResolved,
+		Enabled:         *req.Enabled,
+		NextCheckAt:     &now,
+	})
+	if err != nil {
+		if errors.Is(err, alerts.ErrNotFound) {
+			response.NotFound(ctx, "Alert rule not found")
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Alert rule updated", updated)
+}
+
+// HandleDeleteAlertRule deletes an alert rule with its events.
+func (h *Handler) HandleDeleteAlertRule(ctx *gin.Context) {
+	rule, ok := h.getAlertRule(ctx)
+	if !ok {
+		return
+	}
+	if err := alerts.DeleteRule(h.Cfg.DBClient, rule.ID, rule.UserID); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Alert rule deleted")
+}
+
+// HandleCheckAlertRule checks an alert rule right away and returns the events it produced.
+func (h *Handler) HandleCheckAlertRule(ctx *gin.Context) {
+	rule, ok := h.getAlertRule(ctx)
+	if !ok {
+		return
+	}
+
+	events, err := scheduler.CheckNow(rule)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrAlreadyChecking) {
+			response.Error(ctx, http.StatusConflict, "Alert rule is already being checked", err)
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	if events == nil {
+		events = []alerts.Event{}
+	}
+	response.JSON(ctx, http.StatusOK, "Alert rule checked", gin.H{"state": rule.State, "events": events})
+}
+
+// HandleGetAlertEvents lists the events of the alert rules of the authenticated user, latest
+// first. They can be narrowed to a rule, a kind and a time range.
+func (h *Handler) HandleGetAlertEvents(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	filter := alerts.EventFilter{
+		RuleID: ctx.Param("alert_id"),
+		Kind:   alerts.EventKind(ctx.Query("kind")),
+		Limit:  100,
+	}
+	if filter.RuleID == "" {
+		filter.RuleID = ctx.Query("rule_id")
+	}
+	if text := ctx.Query("limit"); text != "" {
+		limit, err := strconv.Atoi(text)
+		if err != nil || limit <= 0 || limit > 1000 {
+			response.BadRequest(ctx, "Limit must be between 1 and 1000", err)
+			return
+		}
+		filter.Limit = limit
+	}
+	for name, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if text := ctx.Query(name); text != "" {
+			t, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				response.BadRequest(ctx, "Invalid "+name+" time, expected RFC 3339", err)
+				return
+			}
+			*target = &t
+		}
+	}
+
+	events, err := alerts.ListEvents(h.Cfg.DBClient, userID, filter)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Alert events", events)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/cprakhar/datawhiz/internal/llm"
 	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
 	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -35,14 +37,18 @@ func (h *Handler) HandleGenerateQuery(ctx *gin.Context) {
 		return
 	}
 
-	poolMgr, err := poolmanager.GetPool(connID)
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
 	if err != nil {
 		log.Println("Error getting pool manager:", err)
 		response.InternalError(ctx, err)
 		return
 	}
 
-	releventSchemas, err := dbdriver.GetReleventTablesSchema(poolMgr.Pool, poolMgr.DBType, tables)
+	schemaCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.queryTimeout(poolMgr, 0))
+	defer cancel()
+
+	releventSchemas, err := dbdriver.GetReleventTablesSchema(schemaCtx, poolMgr.Pool, poolMgr.DBType, tables)
 	if err != nil {
 		log.Println("Error getting relevent schemas:", err)
 		response.InternalError(ctx, err)
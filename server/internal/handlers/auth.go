@@ -1,11 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 
-	"github.com/cprakhar/datawhiz/internal/database/connections"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
 	"github.com/cprakhar/datawhiz/internal/database/users"
 	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
@@ -35,7 +33,7 @@ func (h *Handler) HandleRegister(ctx *gin.Context) {
 		return
 	}
 
-	exists, err := users.CheckUserExists(h.Cfg.DBClient, req.Email)
+	exists, err := users.CheckUserExists(h.Cfg.Users, req.Email)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -55,7 +53,7 @@ func (h *Handler) HandleRegister(ctx *gin.Context) {
 		Email:    req.Email,
 		Password: hashedPassword,
 	}
-	createdUser, err := users.InsertOneUser(h.Cfg.DBClient, newUser)
+	createdUser, err := h.Cfg.Users.InsertOne(newUser)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -71,24 +69,14 @@ func (h *Handler) HandleLogin(ctx *gin.Context) {
 		return
 	}
 
-	exists, err := users.CheckUserExists(h.Cfg.DBClient, req.Email)
-	if err != nil {
-		response.InternalError(ctx, err)
-		return
-	}
-	if !exists {
-		response.Unauthorized(ctx, fmt.Sprintf("User not found with email: %s", req.Email))
-		return
-	}
 	// Fetch full user (with password) for validation
-	var dbUser schema.User
-	data, _, err := h.Cfg.DBClient.From("users").Select("*", "", false).Eq("email", req.Email).Single().Execute()
+	dbUser, err := h.Cfg.Users.GetCredentials(req.Email)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
-	if err := json.Unmarshal(data, &dbUser); err != nil {
-		response.InternalError(ctx, err)
+	if dbUser == nil {
+		response.Unauthorized(ctx, fmt.Sprintf("User not found with email: %s", req.Email))
 		return
 	}
 	if err := password.ValidatePassword(req.Password, dbUser.Password); err != nil {
@@ -123,7 +111,7 @@ func (h *Handler) HandleLogout(ctx *gin.Context) {
 	userID := session.Get("user_id")
 	
 	poolmanager.DeactivateAllUserPools(userID.(string))
-	err := connections.SetAllConnectionsInactiveForUser(h.Cfg.DBClient, userID.(string))
+	err := h.Cfg.Connections.SetAllInactiveForUser(userID.(string))
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
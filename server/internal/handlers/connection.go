@@ -6,12 +6,22 @@ import (
 	"github.com/cprakhar/datawhiz/internal/database/connections"
 	"github.com/cprakhar/datawhiz/internal/database/schema"
 	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tlsconfig"
+	"github.com/cprakhar/datawhiz/internal/db_driver/tunnel"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
 	"github.com/cprakhar/datawhiz/utils/response"
 	"github.com/cprakhar/datawhiz/utils/secure"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
+// connectionWithHealth is a connection along with the health of its pool, if it is active.
+type connectionWithHealth struct {
+	connections.ResponseConnection
+	Health *poolmanager.Health `json:"health,omitempty"`
+}
+
 // HandlePingConnection checks the connectivity to a database connection by pinging it.
 func (h *Handler) HandlePingConnection(ctx *gin.Context) {
 	var req schema.ConnectionRequest
@@ -21,7 +31,7 @@ func (h *Handler) HandlePingConnection(ctx *gin.Context) {
 	}
 
 	if req.StringConn != nil {
-		if err := dbdriver.PingDB(h.Cfg.DBConfig, req.StringConn.ConnString, req.StringConn.DBType); err != nil {
+		if err := dbdriver.PingDB(ctx.Request.Context(), h.Cfg.DBConfig, req.StringConn.ConnString, req.StringConn.DBType, req.StringConn.PoolSettings, req.StringConn.SSHTunnel, req.StringConn.TLS); err != nil {
 			response.BadRequest(ctx, "Failed to ping connection", err)
 			return
 		}
@@ -31,7 +41,7 @@ func (h *Handler) HandlePingConnection(ctx *gin.Context) {
 			response.InternalError(ctx, err)
 			return
 		}
-		if err := dbdriver.PingDB(h.Cfg.DBConfig, connString, req.ManualConn.DBType); err != nil {
+		if err := dbdriver.PingDB(ctx.Request.Context(), h.Cfg.DBConfig, connString, req.ManualConn.DBType, req.ManualConn.PoolSettings, req.ManualConn.SSHTunnel, req.ManualConn.TLS); err != nil {
 			response.BadRequest(ctx, "Failed to ping connection", err)
 			return
 		}
@@ -56,6 +66,20 @@ func (h *Handler) HandleCreateConnection(ctx *gin.Context) {
 			response.BadRequest(ctx, "Invalid connection string", err)
 			return
 		}
+		if err := dbdriver.ValidatePoolSettings(req.StringConn.PoolSettings, conn.DBType); err != nil {
+			response.BadRequest(ctx, "Invalid pool settings", err)
+			return
+		}
+		sshTunnel, err := h.encryptTunnel(req.StringConn.SSHTunnel)
+		if err != nil {
+			response.BadRequest(ctx, "Invalid SSH tunnel", err)
+			return
+		}
+		tlsSettings, err := h.encryptTLS(req.StringConn.TLS, conn.DBType)
+		if err != nil {
+			response.BadRequest(ctx, "Invalid TLS settings", err)
+			return
+		}
 
 		encryptedPassword, err := secure.Encrypt(conn.Password, h.Cfg.Env.EncryptionKey)
 		if err != nil {
@@ -63,7 +87,7 @@ func (h *Handler) HandleCreateConnection(ctx *gin.Context) {
 			return
 		}
 
-		exists, err := connections.CheckConnectionExists(h.Cfg.DBClient, conn, userID.(string))
+		exists, err := h.Cfg.Connections.Exists(conn, userID.(string))
 		if err != nil {
 			response.InternalError(ctx, err)
 			return
@@ -91,8 +115,12 @@ func (h *Handler) HandleCreateConnection(ctx *gin.Context) {
 			DBName:         conn.DBName,
 			DBFilePath:     conn.DBFilePath,
 			ConnString:     encryptedConnString,
+			QueryTimeout:   req.StringConn.QueryTimeout,
+			PoolSettings:   req.StringConn.PoolSettings,
+			SSHTunnel:      sshTunnel,
+			TLS:            tlsSettings,
 		}
-		createdConn, err := connections.InsertOneConnection(h.Cfg.DBClient, newConn)
+		createdConn, err := h.Cfg.Connections.InsertOne(newConn)
 		if err != nil {
 			response.InternalError(ctx, err)
 			return
@@ -100,7 +128,22 @@ func (h *Handler) HandleCreateConnection(ctx *gin.Context) {
 		response.JSON(ctx, http.StatusCreated, "Connection created successfully", createdConn)
 		return
 	} else if req.ManualConn != nil {
-		exists, err := connections.CheckConnectionExists(h.Cfg.DBClient, req.ManualConn, userID.(string))
+		if err := dbdriver.ValidatePoolSettings(req.ManualConn.PoolSettings, req.ManualConn.DBType); err != nil {
+			response.BadRequest(ctx, "Invalid pool settings", err)
+			return
+		}
+		sshTunnel, err := h.encryptTunnel(req.ManualConn.SSHTunnel)
+		if err != nil {
+			response.BadRequest(ctx, "Invalid SSH tunnel", err)
+			return
+		}
+		tlsSettings, err := h.encryptTLS(req.ManualConn.TLS, req.ManualConn.DBType)
+		if err != nil {
+			response.BadRequest(ctx, "Invalid TLS settings", err)
+			return
+		}
+
+		exists, err := h.Cfg.Connections.Exists(req.ManualConn, userID.(string))
 		if err != nil {
 			response.InternalError(ctx, err)
 			return
@@ -140,9 +183,13 @@ func (h *Handler) HandleCreateConnection(ctx *gin.Context) {
 			DBName:         req.ManualConn.DBName,
 			DBFilePath:     req.ManualConn.DBFilePath,
 			ConnString:     encryptedConnString,
+			QueryTimeout:   req.ManualConn.QueryTimeout,
+			PoolSettings:   req.ManualConn.PoolSettings,
+			SSHTunnel:      sshTunnel,
+			TLS:            tlsSettings,
 		}
 
-		createdConn, err := connections.InsertOneConnection(h.Cfg.DBClient, newConn)
+		createdConn, err := h.Cfg.Connections.InsertOne(newConn)
 		if err != nil {
 			response.InternalError(ctx, err)
 			return
@@ -151,22 +198,45 @@ func (h *Handler) HandleCreateConnection(ctx *gin.Context) {
 	}
 }
 
+// encryptTunnel validates the SSH tunnel of a new connection, if it has one, and encrypts its secrets.
+func (h *Handler) encryptTunnel(sshTunnel *schema.SSHTunnel) (*schema.SSHTunnel, error) {
+	if sshTunnel == nil {
+		return nil, nil
+	}
+	if err := tunnel.Validate(sshTunnel); err != nil {
+		return nil, err
+	}
+	return tunnel.Encrypt(sshTunnel, h.Cfg.Env.EncryptionKey)
+}
+
+// encryptTLS validates the TLS settings of a new connection, if it has any, and encrypts their certificates and key.
+func (h *Handler) encryptTLS(settings *schema.TLSSettings, dbType string) (*schema.TLSSettings, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	if err := dbdriver.ValidateTLSSettings(settings, dbType); err != nil {
+		return nil, err
+	}
+	return tlsconfig.Encrypt(settings, h.Cfg.Env.EncryptionKey)
+}
+
 // HandleGetConnections retrieves all connections for the authenticated user.
 func (h *Handler) HandleGetConnections(ctx *gin.Context) {
     session := sessions.Default(ctx)
     userID := session.Get("user_id").(string)
 
-    conns, err := connections.GetConnectionsByUserID(h.Cfg.DBClient, userID)
+    conns, err := h.Cfg.Connections.ListByUserID(userID)
     if err != nil {
         response.InternalError(ctx, err)
         return
     }
 
-	if conns == nil {
-		conns = []connections.ResponseConnection{}
+	withHealth := make([]connectionWithHealth, 0, len(conns))
+	for _, conn := range conns {
+		withHealth = append(withHealth, connectionWithHealth{conn, poolmanager.GetHealth(conn.ID, userID)})
 	}
 
-    response.JSON(ctx, http.StatusOK, "User connections", conns)
+    response.JSON(ctx, http.StatusOK, "User connections", withHealth)
 }
 
 // HandleDeleteConnection deletes a connection by its ID for the authenticated user.
@@ -183,11 +253,12 @@ func (h *Handler) HandleDeleteConnection(ctx *gin.Context) {
 		response.BadRequest(ctx, "Connection ID is required", nil)
 		return
 	}
-	err := connections.DeleteConnection(h.Cfg.DBClient, connID, userID)
+	err := h.Cfg.Connections.Delete(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
+	querycache.InvalidateConnection(connID)
 
 	response.OK(ctx, "Connection deleted successfully")
 }
@@ -203,7 +274,7 @@ func (h *Handler) HandleGetConnection(ctx *gin.Context) {
 		return
 	}
 
-	conn, err := connections.GetConnectionByID(h.Cfg.DBClient, connID, userID.(string))
+	conn, err := h.Cfg.Connections.GetByID(connID, userID.(string))
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -213,5 +284,5 @@ func (h *Handler) HandleGetConnection(ctx *gin.Context) {
 		return
 	}
 
-	response.JSON(ctx, http.StatusOK, "Connection details", conn)
+	response.JSON(ctx, http.StatusOK, "Connection details", connectionWithHealth{*conn, poolmanager.GetHealth(connID, userID.(string))})
 }
\ No newline at end of file
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	copyjobs "github.com/cprakhar/datawhiz/internal/copy_jobs"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/dump"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RequestCopyTable struct {
+	Source string `json:"source" binding:"required"`
+	Target string `json:"target"` // defaults to the source name
+}
+
+type RequestCopyJob struct {
+	SourceID  string             `json:"source_id" binding:"required"`
+	SourceDB  string             `json:"source_db"`
+	TargetID  string             `json:"target_id" binding:"required"`
+	TargetDB  string             `json:"target_db"`
+	Tables    []RequestCopyTable `json:"tables"` // every table of the source when empty
+	BatchSize int                `json:"batch_size"`
+	IfExists  string             `json:"if_exists"`
+	Lossy     bool               `json:"lossy"`
+	OnError   string             `json:"on_error"`
+	MaxErrors int64              `json:"max_errors"`
+}
+
+// getCopyJob loads the job from the route parameters and writes the error response if it cannot be found.
+func getCopyJob(ctx *gin.Context) (*copyjobs.Job, bool) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	jobID := ctx.Param("job_id")
+	if jobID == "" {
+		response.BadRequest(ctx, "Job ID is required", nil)
+		return nil, false
+	}
+
+	job, err := copyjobs.GetJob(jobID, userID)
+	if err != nil {
+		response.NotFound(ctx, "Job not found")
+		return nil, false
+	}
+	return job, true
+}
+
+// HandleSubmitCopyJob queues a job copying tables from one connection to another, possibly of
+// another engine. Both connections must be active while the job runs.
+func (h *Handler) HandleSubmitCopyJob(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var req RequestCopyJob
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request", err)
+		return
+	}
+
+	opts := copyjobs.Options{BatchSize: req.BatchSize, Lossy: req.Lossy, MaxErrors: req.MaxErrors}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.BatchSize > 50000 || opts.MaxErrors < 0 {
+		response.BadRequest(ctx, "Batch size must be at most 50000 and max errors cannot be negative", nil)
+		return
+	}
+	switch ifExists := dump.IfExists(req.IfExists); ifExists {
+	case "":
+		opts.IfExists = dump.IfExistsFail
+	case dump.IfExistsFail, dump.IfExistsSkip, dump.IfExistsAppend:
+		opts.IfExists = ifExists
+	default:
+		response.BadRequest(ctx, "If exists must be one of fail, skip or append", nil)
+		return
+	}
+	switch onError := importer.ErrorPolicy(req.OnError); onError {
+	case "":
+		opts.OnError = importer.OnErrorSkip
+	case importer.OnErrorSkip, importer.OnErrorAbort:
+		opts.OnError = onError
+	default:
+		response.BadRequest(ctx, "On error must be skip or abort", nil)
+		return
+	}
+
+	source, err := poolmanager.GetPool(req.SourceID, userID)
+	if err != nil {
+		response.BadRequest(ctx, "Source connection is not active", err)
+		return
+	}
+	target, err := poolmanager.GetPool(req.TargetID, userID)
+	if err != nil {
+		response.BadRequest(ctx, "Target connection is not active", err)
+		return
+	}
+	if req.SourceID == req.TargetID && req.SourceDB == req.TargetDB {
+		response.BadRequest(ctx, "Source and target must be different databases", nil)
+		return
+	}
+
+	// Tables are checked up front so that a job does not fail after copying part of them.
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), 30*time.Second)
+	defer cancel()
+	var names []string
+	for _, table := range req.Tables {
+		names = append(names, table.Source)
+	}
+	names, err = dump.ResolveTables(reqCtx, source.Pool, source.DBType, req.SourceDB, names)
+	if err != nil {
+		if errors.Is(err, dump.ErrUnknownTable) {
+			response.NotFound(ctx, err.Error())
+			return
+		}
+		respondQueryError(ctx, nil, err)
+		return
+	}
+	existing, err := dump.ResolveTables(reqCtx, target.Pool, target.DBType, req.TargetDB, nil)
+	if err != nil {
+		respondQueryError(ctx, nil, err)
+		return
+	}
+
+	tables := make([]copyjobs.Table, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		tables[i] = copyjobs.Table{Source: name, Target: name}
+		if i < len(req.Tables) && req.Tables[i].Target != "" {
+			tables[i].Target = req.Tables[i].Target
+		}
+		if seen[tables[i].Target] {
+			response.BadRequest(ctx, "Tables are copied to the same target: "+tables[i].Target, nil)
+			return
+		}
+		seen[tables[i].Target] = true
+		if opts.IfExists == dump.IfExistsFail && slices.Contains(existing, tables[i].Target) {
+			response.Error(ctx, http.StatusConflict, "Table already exists, set if_exists to skip or append", errors.New(tables[i].Target))
+			return
+		}
+	}
+
+	jobID := uuid.NewString()
+	err = copyjobs.Submit(&copyjobs.Job{
+		ID:       jobID,
+		UserID:   userID,
+		SourceID: req.SourceID,
+		SourceDB: req.SourceDB,
+		TargetID: req.TargetID,
+		TargetDB: req.TargetDB,
+		Options:  opts,
+		Tables:   tables,
+	})
+	if err != nil {
+		if errors.Is(err, copyjobs.ErrQueueFull) {
+			response.Error(ctx, http.StatusServiceUnavailable, "Copy job queue is full", err)
+			return
+		}
+		response.BadRequest(ctx, "Failed to submit copy job", err)
+		return
+	}
+
+	job, err := copyjobs.GetJob(jobID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusAccepted, "Copy job submitted", job)
+}
+
+// HandleGetCopyJobs lists the copy jobs of the authenticated user.
+func (h *Handler) HandleGetCopyJobs(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	jobs := copyjobs.ListJobs(userID)
+	if jobs == nil {
+		jobs = []copyjobs.Job{}
+	}
+	response.JSON(ctx, http.StatusOK, "Copy jobs", jobs)
+}
+
+// HandleGetCopyJob retrieves the progress of a copy job.
+func (h *Handler) HandleGetCopyJob(ctx *gin.Context) {
+	job, ok := getCopyJob(ctx)
+	if !ok {
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Copy job status", job)
+}
+
+// HandleResumeCopyJob queues a failed or cancelled copy job again from its last checkpoint.
+func (h *Handler) HandleResumeCopyJob(ctx *gin.Context) {
+	job, ok := getCopyJob(ctx)
+	if !ok {
+		return
+	}
+
+	if err := copyjobs.ResumeJob(job.ID, job.UserID); err != nil {
+		switch {
+		case errors.Is(err, copyjobs.ErrNotResumable):
+			response.Error(ctx, http.StatusConflict, "Copy job cannot be resumed", err)
+		case errors.Is(err, copyjobs.ErrQueueFull):
+			response.Error(ctx, http.StatusServiceUnavailable, "Copy job queue is full", err)
+		default:
+			response.InternalError(ctx, err)
+		}
+		return
+	}
+
+	job, err := copyjobs.GetJob(job.ID, job.UserID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusAccepted, "Copy job resumed", job)
+}
+
+// HandleCancelCopyJob cancels a queued or running copy job.
+func (h *Handler) HandleCancelCopyJob(ctx *gin.Context) {
+	job, ok := getCopyJob(ctx)
+	if !ok {
+		return
+	}
+
+	if err := copyjobs.CancelJob(job.ID, job.UserID); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Copy job cancellation requested")
+}
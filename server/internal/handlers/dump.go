@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	"github.com/cprakhar/datawhiz/internal/dump"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// trailerDumpError is sent after a dump that failed mid-stream, since the status code is gone
+// by the time the archive is written.
+const trailerDumpError = "X-Dump-Error"
+
+// splitList splits a comma-separated parameter, ignoring blanks.
+func splitList(param string) []string {
+	var items []string
+	for _, item := range strings.Split(param, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// HandleDumpDatabase streams the schema and rows of the tables listed in the tables parameter,
+// or of every table, as a zip archive that can be restored into any connection.
+func (h *Handler) HandleDumpDatabase(ctx *gin.Context) {
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	dbName := ctx.Query("db_name")
+	timeout, _ := strconv.Atoi(ctx.Query("timeout"))
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.bulkTimeout(poolMgr, timeout))
+	defer cancel()
+
+	tables, err := dump.ResolveTables(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, splitList(ctx.Query("tables")))
+	if err != nil {
+		if errors.Is(err, dump.ErrUnknownTable) {
+			response.NotFound(ctx, err.Error())
+			return
+		}
+		respondQueryError(ctx, nil, err)
+		return
+	}
+
+	fileName := dbName
+	if fileName == "" {
+		fileName = connID
+	}
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fileName + "-" + time.Now().UTC().Format("20060102T150405Z") + ".zip",
+	}))
+	ctx.Header("Trailer", trailerDumpError)
+	ctx.Status(http.StatusOK)
+
+	if _, err := dump.Write(reqCtx, ctx.Writer, poolMgr.Pool, poolMgr.DBType, dbName, tables); err != nil {
+		log.Println("Error dumping database:", err)
+		ctx.Writer.Header().Set(trailerDumpError, err.Error())
+	}
+}
+
+// HandleRestoreDatabase loads an uploaded archive into the connection. The form fields are
+// tables, if_exists (fail, skip or append), lossy, batch_size, on_error and max_errors. NDJSON
+// clients get a progress line after every batch and the summary last.
+func (h *Handler) HandleRestoreDatabase(ctx *gin.Context) {
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	file, fileHeader, ok := h.uploadedFile(ctx)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	opts := dump.RestoreOptions{Tables: splitList(ctx.PostForm("tables"))}
+	switch ifExists := dump.IfExists(ctx.DefaultPostForm("if_exists", string(dump.IfExistsFail))); ifExists {
+	case dump.IfExistsFail, dump.IfExistsSkip, dump.IfExistsAppend:
+		opts.IfExists = ifExists
+	default:
+		response.BadRequest(ctx, "If exists must be one of fail, skip or append", nil)
+		return
+	}
+	if lossy := ctx.PostForm("lossy"); lossy != "" {
+		if opts.Lossy, err = strconv.ParseBool(lossy); err != nil {
+			response.BadRequest(ctx, "Lossy must be true or false", err)
+			return
+		}
+	}
+	if opts.Load, err = parseLoadOptions(ctx); err != nil {
+		response.BadRequest(ctx, "Invalid load options", err)
+		return
+	}
+
+	archive, err := dump.OpenArchive(file, fileHeader.Size)
+	if err != nil {
+		response.BadRequest(ctx, "Failed to read the archive", err)
+		return
+	}
+
+	timeout, _ := strconv.Atoi(ctx.Query("timeout"))
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.bulkTimeout(poolMgr, timeout))
+	defer cancel()
+
+	// The stream starts with the first progress line, so that a restore refused before loading
+	// anything still gets an error status.
+	streaming := wantsNDJSON(ctx)
+	encoder := json.NewEncoder(ctx.Writer)
+	startStream := func() {
+		if !ctx.Writer.Written() {
+			ctx.Header("Content-Type", "application/x-ndjson")
+			ctx.Status(http.StatusOK)
+		}
+	}
+	var progress func(string, importer.Progress)
+	if streaming {
+		progress = func(table string, p importer.Progress) {
+			startStream()
+			encoder.Encode(gin.H{"table": table, "progress": p})
+			ctx.Writer.Flush()
+		}
+	}
+
+	summary, err := dump.Restore(reqCtx, archive, poolMgr.Pool, poolMgr.DBType, ctx.Query("db_name"), opts, progress)
+	querycache.InvalidateConnection(connID)
+	if err != nil {
+		log.Println("Error restoring archive:", err)
+	}
+
+	if streaming && (err == nil || ctx.Writer.Written()) {
+		startStream()
+		line := gin.H{"summary": summary}
+		if err != nil {
+			line["error"] = err.Error()
+		}
+		encoder.Encode(line)
+		return
+	}
+	switch {
+	case err == nil:
+		response.JSON(ctx, http.StatusOK, "Restore finished", summary)
+	case errors.Is(err, dump.ErrTableExists):
+		response.Error(ctx, http.StatusConflict, "Table already exists, set if_exists to skip or append", err)
+	case errors.Is(err, dump.ErrUnknownTable), errors.Is(err, dump.ErrLossyTypes), errors.Is(err, dump.ErrInvalidArchive):
+		response.BadRequest(ctx, "Cannot restore the archive", err)
+	case len(summary.Tables) > 0:
+		status := http.StatusInternalServerError
+		if errors.Is(err, importer.ErrAborted) {
+			status = http.StatusUnprocessableEntity
+		}
+		ctx.JSON(status, response.Response{Success: false, Message: "Restore stopped", Data: summary, Error: err.Error()})
+	default:
+		respondQueryError(ctx, nil, err)
+	}
+}
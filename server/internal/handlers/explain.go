@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/plan"
+	"github.com/cprakhar/datawhiz/internal/executions"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RequestExplainQuery struct {
+	GeneratedQuery string `json:"generated_query" binding:"required"`
+	Analyze        bool   `json:"analyze"` // execute the statement to report actual rows and timings
+	Buffers        bool   `json:"buffers"`
+	ExecutionID    string `json:"execution_id"`
+	Timeout        int    `json:"timeout"` // seconds, overrides the connection timeout
+}
+
+// HandleExplainQuery returns the execution plan of a query as a normalized plan tree.
+func (h *Handler) HandleExplainQuery(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var req RequestExplainQuery
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	dbName := ctx.Query("db_name")
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	execID := req.ExecutionID
+	if execID == "" {
+		execID = uuid.NewString()
+	}
+	// An ANALYZE runs the statement, so it is tracked like any other execution and can be cancelled.
+	execCtx, exec, err := executions.Start(ctx.Request.Context(), execID, connID, userID, h.queryTimeout(poolMgr, req.Timeout))
+	if err != nil {
+		response.BadRequest(ctx, "Invalid execution ID", err)
+		return
+	}
+	defer exec.Finish()
+
+	queryPlan, err := dbdriver.ExplainQuery(execCtx, poolMgr.Pool, poolMgr.DBType, dbName, req.GeneratedQuery, plan.Options{
+		Analyze: req.Analyze,
+		Buffers: req.Buffers,
+	})
+	if err != nil {
+		respondQueryError(ctx, exec, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Query plan", queryPlan)
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/export"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/executions"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	queryjobs "github.com/cprakhar/datawhiz/internal/query_jobs"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Trailers sent after an export, since the status code is gone by the time the rows are written.
+const (
+	trailerRowCount  = "X-Export-Row-Count"
+	trailerTruncated = "X-Export-Truncated"
+	trailerError     = "X-Export-Error"
+)
+
+type RequestExportQuery struct {
+	GeneratedQuery string `json:"generated_query" binding:"required"`
+	ExecutionID    string `json:"execution_id"`
+	Timeout        int    `json:"timeout"` // seconds, overrides the connection timeout
+	FileName       string `json:"file_name"`
+}
+
+// exportRequest is the format and layout of an export, read from the query parameters.
+type exportRequest struct {
+	format  export.Format
+	options export.Options
+	maxRows int64
+}
+
+// parseExportRequest reads the export format from the format parameter or the Accept header,
+// and the layout from the delimiter, quote, header, null and sheet parameters.
+func (h *Handler) parseExportRequest(ctx *gin.Context) (*exportRequest, bool) {
+	format, err := export.ParseFormat(ctx.Query("format"), ctx.GetHeader("Accept"))
+	if err != nil {
+		response.BadRequest(ctx, "Invalid export format", err)
+		return nil, false
+	}
+
+	opts := export.DefaultOptions()
+	if delimiter := ctx.Query("delimiter"); delimiter != "" {
+		if delimiter == "tab" || delimiter == `\t` {
+			delimiter = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+			response.BadRequest(ctx, "Delimiter must be a single character other than a quote or line break", nil)
+			return nil, false
+		}
+		opts.Delimiter = r
+	}
+	switch quoting := export.Quoting(ctx.DefaultQuery("quote", string(export.QuoteMinimal))); quoting {
+	case export.QuoteMinimal, export.QuoteAll, export.QuoteNone:
+		opts.Quoting = quoting
+	default:
+		response.BadRequest(ctx, "Quote must be one of minimal, all or none", nil)
+		return nil, false
+	}
+	if header := ctx.Query("header"); header != "" {
+		if opts.Header, err = strconv.ParseBool(header); err != nil {
+			response.BadRequest(ctx, "Header must be true or false", err)
+			return nil, false
+		}
+	}
+	opts.Null = ctx.Query("null")
+	if sheet := ctx.Query("sheet"); sheet != "" {
+		opts.SheetName = sheet
+	}
+
+	// The max_rows parameter can only lower the server cap, which the format may lower further.
+	maxRows := int64(h.Cfg.DBConfig.MaxExportRows)
+	if requested, err := strconv.ParseInt(ctx.Query("max_rows"), 10, 64); err == nil && requested > 0 {
+		if maxRows <= 0 || requested < maxRows {
+			maxRows = requested
+		}
+	}
+	if formatMax := format.MaxRows(); formatMax > 0 && (maxRows <= 0 || formatMax < maxRows) {
+		maxRows = formatMax
+	}
+	return &exportRequest{format: format, options: opts, maxRows: maxRows}, true
+}
+
+// writeExport streams the rows to the response as a file download. The row count, whether the
+// export was truncated and any error that happened mid-stream are sent as HTTP trailers.
+func writeExport(ctx *gin.Context, req *exportRequest, rows result.Rows, fileName string) (result.Summary, error) {
+	ctx.Header("Content-Type", req.format.ContentType())
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fileName + req.format.Extension(),
+	}))
+	ctx.Header("Trailer", trailerRowCount+", "+trailerTruncated+", "+trailerError)
+	ctx.Status(http.StatusOK)
+
+	writer, err := export.NewWriter(ctx.Writer, req.format, req.options)
+	if err != nil {
+		return result.Summary{}, err
+	}
+	summary, err := result.Copy(writer, rows, req.maxRows)
+
+	trailer := ctx.Writer.Header()
+	trailer.Set(trailerRowCount, strconv.FormatInt(summary.RowCount, 10))
+	trailer.Set(trailerTruncated, strconv.FormatBool(summary.Truncated))
+	if err != nil {
+		trailer.Set(trailerError, err.Error())
+	}
+	return summary, err
+}
+
+// HandleExportTable downloads the records of a table in the requested format.
+func (h *Handler) HandleExportTable(ctx *gin.Context) {
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	tableName := ctx.Param("table_name")
+	if tableName == "" {
+		response.BadRequest(ctx, "Table name is required", nil)
+		return
+	}
+
+	req, ok := h.parseExportRequest(ctx)
+	if !ok {
+		return
+	}
+
+	dbName := ctx.Query("db_name")
+	timeout, _ := strconv.Atoi(ctx.Query("timeout"))
+
+	// Exports read whole tables, so they get the maximum timeout unless the request asks otherwise.
+	exportTimeout := h.Cfg.DBConfig.MaxQueryTimeout
+	if timeout > 0 || exportTimeout <= 0 {
+		exportTimeout = h.queryTimeout(poolMgr, timeout)
+	}
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), exportTimeout)
+	defer cancel()
+
+	limit := int64(math.MaxInt64)
+	if req.maxRows > 0 {
+		// Fetch one extra row so that a truncated export can be told apart from one that fits.
+		limit = req.maxRows + 1
+	}
+	rows, err := dbdriver.GetTableRecords(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, tableName, limit)
+	if err == nil {
+		rows, err = result.Peek(rows)
+	}
+	if err != nil {
+		respondQueryError(ctx, nil, err)
+		return
+	}
+	defer rows.Close()
+
+	if _, err := writeExport(ctx, req, rows, tableName); err != nil {
+		log.Println("Error exporting table:", err)
+	}
+}
+
+// HandleExportQuery runs a query and downloads its results in the requested format.
+func (h *Handler) HandleExportQuery(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var body RequestExportQuery
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	req, ok := h.parseExportRequest(ctx)
+	if !ok {
+		return
+	}
+
+	dbName := ctx.Query("db_name")
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	execID := body.ExecutionID
+	if execID == "" {
+		execID = uuid.NewString()
+	}
+	exportTimeout := h.Cfg.DBConfig.MaxQueryTimeout
+	if body.Timeout > 0 || exportTimeout <= 0 {
+		exportTimeout = h.queryTimeout(poolMgr, body.Timeout)
+	}
+	execCtx, exec, err := executions.Start(ctx.Request.Context(), execID, connID, userID, exportTimeout)
+	if err != nil {
+		response.BadRequest(ctx, "Invalid execution ID", err)
+		return
+	}
+	defer exec.Finish()
+
+	rows, err := dbdriver.RunQuery(execCtx, poolMgr.Pool, poolMgr.DBType, dbName, body.GeneratedQuery)
+	if err == nil {
+		rows, err = result.Peek(rows)
+	}
+	if err != nil {
+		respondQueryError(ctx, exec, err)
+		return
+	}
+	defer rows.Close()
+
+	fileName := body.FileName
+	if fileName == "" {
+		fileName = "query-" + time.Now().UTC().Format("20060102-150405")
+	}
+	summary, err := writeExport(ctx, req, rows, fileName)
+	if summary.Truncated {
+		// The remaining rows are not needed, stop the query instead of draining it.
+		exec.Interrupt()
+	}
+	if err != nil {
+		log.Println("Error exporting query result:", err)
+	}
+}
+
+// HandleExportQueryJobResults downloads the results of a finished asynchronous query job in
+// the requested format.
+func (h *Handler) HandleExportQueryJobResults(ctx *gin.Context) {
+	job, ok := getQueryJob(ctx)
+	if !ok {
+		return
+	}
+
+	req, ok := h.parseExportRequest(ctx)
+	if !ok {
+		return
+	}
+
+	rows, err := queryjobs.OpenResult(job)
+	if err != nil {
+		response.BadRequest(ctx, "Job results are not available", job.Status)
+		return
+	}
+	defer rows.Close()
+
+	if _, err := writeExport(ctx, req, rows, job.ID); err != nil {
+		log.Println("Error exporting query job results:", err)
+	}
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/importer"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// inferSampleRows is the number of rows read to infer the columns of a file.
+	inferSampleRows = 1000
+	// previewRows is the number of rows returned by an import preview.
+	previewRows = 20
+)
+
+// importRequest is an upload and the form fields that describe how to read and load it.
+type importRequest struct {
+	file    multipart.File
+	size    int64
+	format  importer.Format
+	read    importer.ReadOptions
+	load    importer.Options
+	create  bool
+	columns []importer.Column
+	mapping map[string]string
+}
+
+type ResponseImportPreview struct {
+	Format       importer.Format   `json:"format"`
+	Columns      []importer.Column `json:"columns"`
+	Sample       [][]interface{}   `json:"sample"`
+	TableExists  bool              `json:"table_exists"`
+	TableColumns []importer.Column `json:"table_columns,omitempty"`
+	Plan         *importer.Plan    `json:"plan,omitempty"`
+	PlanError    string            `json:"plan_error,omitempty"`
+}
+
+// uploadedFile returns the file uploaded in the file field, within the import size limit.
+func (h *Handler) uploadedFile(ctx *gin.Context) (multipart.File, *multipart.FileHeader, bool) {
+	if maxSize := h.Cfg.Env.MaxImportSize; maxSize > 0 {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize)
+	}
+	file, fileHeader, err := ctx.Request.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			response.Error(ctx, http.StatusRequestEntityTooLarge, "File is too large", err)
+			return nil, nil, false
+		}
+		response.BadRequest(ctx, "A file is required", err)
+		return nil, nil, false
+	}
+	return file, fileHeader, true
+}
+
+// parseLoadOptions reads how rows are loaded from the batch_size, on_error and max_errors fields.
+func parseLoadOptions(ctx *gin.Context) (importer.Options, error) {
+	opts := importer.Options{BatchSize: 1000, OnError: importer.OnErrorSkip}
+	if batchSize, err := strconv.Atoi(ctx.PostForm("batch_size")); err == nil && batchSize > 0 {
+		opts.BatchSize = min(batchSize, 10000)
+	}
+	switch onError := importer.ErrorPolicy(ctx.DefaultPostForm("on_error", string(importer.OnErrorSkip))); onError {
+	case importer.OnErrorSkip, importer.OnErrorAbort:
+		opts.OnError = onError
+	default:
+		return opts, errors.New("on_error must be skip or abort")
+	}
+	if maxErrors, err := strconv.ParseInt(ctx.PostForm("max_errors"), 10, 64); err == nil && maxErrors > 0 {
+		opts.MaxErrors = maxErrors
+	}
+	return opts, nil
+}
+
+// bulkTimeout is the timeout of imports and dumps, which move whole tables and so get the
+// maximum timeout unless the request asks otherwise.
+func (h *Handler) bulkTimeout(poolMgr *poolmanager.PoolManager, seconds int) time.Duration {
+	if timeout := h.Cfg.DBConfig.MaxQueryTimeout; seconds <= 0 && timeout > 0 {
+		return timeout
+	}
+	return h.queryTimeout(poolMgr, seconds)
+}
+
+// parseImportRequest reads the uploaded file and the form fields: format, delimiter, header,
+// null, create, columns and mapping (as JSON), batch_size, on_error and max_errors.
+func (h *Handler) parseImportRequest(ctx *gin.Context) (*importRequest, bool) {
+	file, fileHeader, ok := h.uploadedFile(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	req := &importRequest{file: file, size: fileHeader.Size}
+	fail := func(message string, err interface{}) (*importRequest, bool) {
+		file.Close()
+		response.BadRequest(ctx, message, err)
+		return nil, false
+	}
+
+	var err error
+	if req.format, err = importer.ParseFormat(ctx.PostForm("format"), fileHeader.Filename); err != nil {
+		return fail("Invalid import format", err)
+	}
+
+	req.read = importer.ReadOptions{Delimiter: ',', Header: true, Null: ctx.PostForm("null")}
+	if delimiter := ctx.PostForm("delimiter"); delimiter != "" {
+		if delimiter == "tab" || delimiter == `\t` {
+			delimiter = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return fail("Delimiter must be a single character other than a quote or line break", nil)
+		}
+		req.read.Delimiter = r
+	}
+	if header := ctx.PostForm("header"); header != "" {
+		if req.read.Header, err = strconv.ParseBool(header); err != nil {
+			return fail("Header must be true or false", err)
+		}
+	}
+	if create := ctx.PostForm("create"); create != "" {
+		if req.create, err = strconv.ParseBool(create); err != nil {
+			return fail("Create must be true or false", err)
+		}
+	}
+	if columns := ctx.PostForm("columns"); columns != "" {
+		if err := json.Unmarshal([]byte(columns), &req.columns); err != nil {
+			return fail("Columns must be a JSON array of columns", err)
+		}
+		if err := importer.ValidateColumns(req.columns); err != nil {
+			return fail("Invalid columns", err)
+		}
+	}
+	if mapping := ctx.PostForm("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &req.mapping); err != nil {
+			return fail("Mapping must be a JSON object from file columns to table columns", err)
+		}
+	}
+	if req.load, err = parseLoadOptions(ctx); err != nil {
+		return fail("Invalid load options", err)
+	}
+	return req, true
+}
+
+// open reads the file from the start.
+func (req *importRequest) open() (importer.Source, error) {
+	if _, err := req.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return importer.Open(req.file, req.size, req.format, req.read)
+}
+
+// inferColumns reads a sample of the file to find its columns and their kinds.
+func (req *importRequest) inferColumns() ([]importer.Column, [][]interface{}, error) {
+	src, err := req.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return importer.Infer(src, inferSampleRows)
+}
+
+// HandlePreviewImport infers the columns of an uploaded file and shows how they would map onto
+// the target table, without loading anything.
+func (h *Handler) HandlePreviewImport(ctx *gin.Context) {
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	tableName := ctx.Param("table_name")
+	if tableName == "" {
+		response.BadRequest(ctx, "Table name is required", nil)
+		return
+	}
+
+	req, ok := h.parseImportRequest(ctx)
+	if !ok {
+		return
+	}
+	defer req.file.Close()
+
+	columns, sample, err := req.inferColumns()
+	if err != nil {
+		response.BadRequest(ctx, "Failed to read the file", err)
+		return
+	}
+	if len(sample) > previewRows {
+		sample = sample[:previewRows]
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.queryTimeout(poolMgr, 0))
+	defer cancel()
+
+	tableColumns, err := dbdriver.GetImportColumns(reqCtx, poolMgr.Pool, poolMgr.DBType, ctx.Query("db_name"), tableName)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	preview := ResponseImportPreview{
+		Format:       req.format,
+		Columns:      columns,
+		Sample:       sample,
+		TableExists:  tableColumns != nil,
+		TableColumns: tableColumns,
+	}
+	if tableColumns != nil {
+		if preview.Plan, err = importer.NewPlan(columns, tableColumns, req.mapping); err != nil {
+			preview.PlanError = err.Error()
+		}
+	}
+	response.JSON(ctx, http.StatusOK, "Import preview", preview)
+}
+
+// HandleImportTable bulk loads an uploaded file into a table, creating the table first when
+// asked to. NDJSON clients get a progress line after every batch and the summary last.
+func (h *Handler) HandleImportTable(ctx *gin.Context) {
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+
+	tableName := ctx.Param("table_name")
+	if tableName == "" {
+		response.BadRequest(ctx, "Table name is required", nil)
+		return
+	}
+
+	req, ok := h.parseImportRequest(ctx)
+	if !ok {
+		return
+	}
+	defer req.file.Close()
+
+	dbName := ctx.Query("db_name")
+	timeout, _ := strconv.Atoi(ctx.Query("timeout"))
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.bulkTimeout(poolMgr, timeout))
+	defer cancel()
+
+	fileColumns, _, err := req.inferColumns()
+	if err != nil {
+		response.BadRequest(ctx, "Failed to read the file", err)
+		return
+	}
+
+	targets, err := dbdriver.GetImportColumns(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, tableName)
+	if err != nil {
+		respondQueryError(ctx, nil, err)
+		return
+	}
+	switch {
+	case req.create && targets != nil:
+		response.Error(ctx, http.StatusConflict, "Table already exists", tableName)
+		return
+	case req.create:
+		targets = req.columns
+		if targets == nil {
+			// A sample cannot prove a column is never null, so inferred columns accept nulls.
+			targets = make([]importer.Column, len(fileColumns))
+			for i, col := range fileColumns {
+				col.Nullable = true
+				targets[i] = col
+			}
+		}
+	case targets == nil && poolMgr.DBType == "mongodb":
+		targets = fileColumns
+	case targets == nil:
+		response.NotFound(ctx, "Table not found, set create to create it from the file")
+		return
+	}
+
+	plan, err := importer.NewPlan(fileColumns, targets, req.mapping)
+	if err != nil {
+		response.BadRequest(ctx, "Invalid column mapping", err)
+		return
+	}
+
+	if req.create {
+		if err := dbdriver.CreateImportTable(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, tableName, targets); err != nil {
+			response.BadRequest(ctx, "Failed to create table", err)
+			return
+		}
+	}
+
+	loader, err := dbdriver.NewImportLoader(poolMgr.Pool, poolMgr.DBType, dbName, tableName, plan.Targets)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	src, err := req.open()
+	if err != nil {
+		response.BadRequest(ctx, "Failed to read the file", err)
+		return
+	}
+
+	var progress func(importer.Progress)
+	streaming := wantsNDJSON(ctx)
+	if streaming {
+		ctx.Header("Content-Type", "application/x-ndjson")
+		ctx.Status(http.StatusOK)
+		encoder := json.NewEncoder(ctx.Writer)
+		progress = func(p importer.Progress) {
+			encoder.Encode(gin.H{"progress": p})
+			ctx.Writer.Flush()
+		}
+	}
+
+	summary, err := importer.Run(reqCtx, src, plan, loader, req.load, progress)
+	querycache.InvalidateConnection(connID)
+	summary.Table = tableName
+	summary.Created = req.create
+	if err != nil {
+		log.Println("Error importing into table:", err)
+	}
+
+	if streaming {
+		json.NewEncoder(ctx.Writer).Encode(gin.H{"summary": summary})
+		return
+	}
+	switch {
+	case err == nil:
+		response.JSON(ctx, http.StatusOK, "Import finished", summary)
+	case errors.Is(err, importer.ErrAborted):
+		ctx.JSON(http.StatusUnprocessableEntity, response.Response{Success: false, Message: "Import aborted", Data: summary, Error: err.Error()})
+	default:
+		respondQueryError(ctx, nil, err)
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	queryjobs "github.com/cprakhar/datawhiz/internal/query_jobs"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type ResponseJobResults struct {
+	Job      *queryjobs.Job `json:"job"`
+	Page     int64          `json:"page"`
+	PageSize int64          `json:"page_size"`
+	Rows     interface{}    `json:"rows"`
+}
+
+// getQueryJob loads the job from the route parameters and writes the error response if it cannot be found.
+func getQueryJob(ctx *gin.Context) (*queryjobs.Job, bool) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return nil, false
+	}
+	jobID := ctx.Param("job_id")
+	if jobID == "" {
+		response.BadRequest(ctx, "Job ID is required", nil)
+		return nil, false
+	}
+
+	job, err := queryjobs.GetJob(jobID, userID)
+	if err != nil || job.ConnID != connID {
+		response.NotFound(ctx, "Job not found")
+		return nil, false
+	}
+	return job, true
+}
+
+// HandleGetQueryJobs lists the asynchronous query jobs of a connection for the authenticated user.
+func (h *Handler) HandleGetQueryJobs(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	jobs := queryjobs.ListJobs(connID, userID)
+	if jobs == nil {
+		jobs = []queryjobs.Job{}
+	}
+	response.JSON(ctx, http.StatusOK, "Query jobs", jobs)
+}
+
+// HandleGetQueryJob retrieves the status of an asynchronous query job.
+func (h *Handler) HandleGetQueryJob(ctx *gin.Context) {
+	job, ok := getQueryJob(ctx)
+	if !ok {
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Query job status", job)
+}
+
+// HandleGetQueryJobResults retrieves a page of results of a finished asynchronous query job.
+func (h *Handler) HandleGetQueryJobResults(ctx *gin.Context) {
+	job, ok := getQueryJob(ctx)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		response.BadRequest(ctx, "Invalid page", err)
+		return
+	}
+	pageSize, err := strconv.ParseInt(ctx.DefaultQuery("page_size", "100"), 10, 64)
+	if err != nil || pageSize < 1 || pageSize > 10000 {
+		response.BadRequest(ctx, "Invalid page size", err)
+		return
+	}
+
+	rows, err := queryjobs.ReadResultPage(job, (page-1)*pageSize, pageSize)
+	if err != nil {
+		if errors.Is(err, queryjobs.ErrNotFinished) {
+			response.BadRequest(ctx, "Job results are not available", job.Status)
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+
+	response.JSON(ctx, http.StatusOK, "Query job results", &ResponseJobResults{
+		Job:      job,
+		Page:     page,
+		PageSize: pageSize,
+		Rows:     rows,
+	})
+}
+
+// HandleDownloadQueryJobResults downloads the full results of a finished asynchronous query job.
+func (h *Handler) HandleDownloadQueryJobResults(ctx *gin.Context) {
+	job, ok := getQueryJob(ctx)
+	if !ok {
+		return
+	}
+
+	path, err := queryjobs.ResultPath(job)
+	if err != nil {
+		response.BadRequest(ctx, "Job results are not available", job.Status)
+		return
+	}
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.FileAttachment(path, job.ID+".ndjson")
+}
+
+// HandleCancelQueryJob cancels a queued or running asynchronous query job.
+func (h *Handler) HandleCancelQueryJob(ctx *gin.Context) {
+	job, ok := getQueryJob(ctx)
+	if !ok {
+		return
+	}
+
+	if err := queryjobs.CancelJob(job.ID, job.UserID); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Query job cancellation requested")
+}
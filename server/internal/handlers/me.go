@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/cprakhar/datawhiz/internal/database/users"
 	"github.com/cprakhar/datawhiz/utils/response"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -14,7 +13,7 @@ func (h *Handler) HandleMe(ctx *gin.Context) {
 	session := sessions.Default(ctx)
 	userID := session.Get("user_id")
 
-	userInfo, err := users.GetUserByID(h.Cfg.DBClient, userID.(string))
+	userInfo, err := h.Cfg.Users.GetByID(userID.(string))
 	if err != nil || userInfo == nil {
 		response.InternalError(ctx, err)
 		return
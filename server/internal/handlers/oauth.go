@@ -55,7 +55,7 @@ func (h *Handler) HandleOAuthCallback(ctx *gin.Context) {
 		return
 	}
 
-	exists, err := users.CheckUserExists(h.Cfg.DBClient, user.Email)
+	exists, err := users.CheckUserExists(h.Cfg.Users, user.Email)
 	if err != nil {
 		log.Println("Error checking if user exists:", err)
 		ctx.Redirect(http.StatusTemporaryRedirect, redirectURL+"&status=error")
@@ -70,7 +70,7 @@ func (h *Handler) HandleOAuthCallback(ctx *gin.Context) {
 			OAuthProvider: provider,
 			OAuthID: user.UserID,
 		}
-		createdUser, err := users.InsertOneUser(h.Cfg.DBClient, newUser)
+		createdUser, err := h.Cfg.Users.InsertOne(newUser)
 		if err != nil {
 			log.Println("Error creating new user:", err)
 			ctx.Redirect(http.StatusTemporaryRedirect, redirectURL+"&status=error")
@@ -99,7 +99,7 @@ func (h *Handler) HandleOAuthCallback(ctx *gin.Context) {
 		OAuthProvider: provider,
 		OAuthID: user.UserID,
 	}
-	updatedUser, err := users.UpsertOAuthUser(h.Cfg.DBClient, upsertUser)
+	updatedUser, err := h.Cfg.Users.UpsertOAuth(upsertUser)
 	if err != nil {
 		log.Println("Error upserting user:", err)
 		ctx.Redirect(http.StatusTemporaryRedirect, redirectURL+"&status=error")
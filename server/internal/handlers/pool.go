@@ -1,7 +1,8 @@
 package handlers
 
 import (
-	"github.com/cprakhar/datawhiz/internal/database/connections"
+	"net/http"
+
 	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
 	"github.com/cprakhar/datawhiz/utils/response"
 	"github.com/gin-contrib/sessions"
@@ -24,13 +25,13 @@ func (h *Handler) HandleActivateConnection(ctx *gin.Context) {
 		response.BadRequest(ctx, "Database type is required", nil)
 		return
 	}
-	err := poolmanager.ActivateConnection(h.Cfg, connID, dbType, userID.(string))
+	err := poolmanager.ActivateConnection(ctx.Request.Context(), h.Cfg, connID, dbType, userID.(string))
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 
-	err = connections.SetConnectionActive(h.Cfg.DBClient, connID, userID.(string), true)
+	err = h.Cfg.Connections.SetActive(connID, userID.(string), true)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -50,17 +51,35 @@ func (h *Handler) HandleDeactivateConnection(ctx *gin.Context) {
 		return
 	}
 
-	err := poolmanager.DeactivateConnection(connID)
+	err := poolmanager.DeactivateConnection(connID, userID.(string))
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 
-	err = connections.SetConnectionActive(h.Cfg.DBClient, connID, userID.(string), false)
+	err = h.Cfg.Connections.SetActive(connID, userID.(string), false)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 
 	response.OK(ctx, "Connection deactivated successfully")
-}
\ No newline at end of file
+}
+// HandleGetConnectionStats reports the statistics of the pool of an active connection.
+func (h *Handler) HandleGetConnectionStats(ctx *gin.Context) {
+	userID := sessions.Default(ctx).Get("user_id").(string)
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	stats, err := poolmanager.GetStats(connID, userID)
+	if err != nil {
+		response.NotFound(ctx, "Connection is not active")
+		return
+	}
+
+	response.JSON(ctx, http.StatusOK, "Connection statistics", stats)
+}
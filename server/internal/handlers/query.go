@@ -1,30 +1,72 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	queryhistory "github.com/cprakhar/datawhiz/internal/database/query_history"
 	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/executions"
 	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+	queryjobs "github.com/cprakhar/datawhiz/internal/query_jobs"
 	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type RequestExecuteQuery struct {
-	Query string `json:"query"`
+	Query          string `json:"query"`
 	GeneratedQuery string `json:"generated_query"`
+	ExecutionID    string `json:"execution_id"`
+	Timeout        int    `json:"timeout"` // seconds, overrides the connection timeout
+	Async          bool   `json:"async"`
+	Cache          bool   `json:"cache"`     // serve the result from the query cache and store it there
+	CacheTTL       int    `json:"cache_ttl"` // seconds the result stays cached, the server default when zero
 }
 
-type ResponseQueryResult struct {
-	Result interface{} `json:"result"`
-	ExecutedAt time.Time `json:"executed_at"`
-	Duration int64 `json:"duration"`
+// queryTimeout resolves the timeout for a query on the given pool. A per-request value
+// wins over the per-connection setting, which wins over the server default.
+func (h *Handler) queryTimeout(poolMgr *poolmanager.PoolManager, requestSeconds int) time.Duration {
+	timeout := h.Cfg.DBConfig.QueryTimeout
+	if poolMgr.QueryTimeout > 0 {
+		timeout = poolMgr.QueryTimeout
+	}
+	if requestSeconds > 0 {
+		timeout = time.Duration(requestSeconds) * time.Second
+	}
+	if maxTimeout := h.Cfg.DBConfig.MaxQueryTimeout; maxTimeout > 0 && timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	return timeout
+}
+
+// respondQueryError writes the response for a failed query, telling timeouts and cancellations apart from other errors.
+func respondQueryError(ctx *gin.Context, exec *executions.Execution, err error) {
+	switch {
+	case exec != nil && exec.Cancelled():
+		response.Error(ctx, http.StatusConflict, "Query cancelled", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		response.Error(ctx, http.StatusRequestTimeout, "Query timed out", err)
+	case ctx.Request.Context().Err() != nil:
+		// The client went away, there is nobody left to respond to.
+		ctx.Abort()
+	default:
+		response.InternalError(ctx, err)
+	}
 }
 
 // HandleExecuteQuery executes a SQL query on the specified connection and returns the results.
 func (h *Handler) HandleExecuteQuery(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
 
 	var req RequestExecuteQuery
 	if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -39,75 +81,359 @@ func (h *Handler) HandleExecuteQuery(ctx *gin.Context) {
 	}
 
 	dbName := ctx.Query("db_name")
-	poolMgr, err := poolmanager.GetPool(connID)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 	
+	h.executeQuery(ctx, &req, poolMgr, connID, userID, dbName)
+}
+
+// executeQuery runs the generated query of the request, or queues it as a job, and saves it to
+// the history. Arguments fill the placeholders of the query. When the request asks for it, a
+// read-only statement is served from the query cache and its result stored there.
+func (h *Handler) executeQuery(ctx *gin.Context, req *RequestExecuteQuery, poolMgr *poolmanager.PoolManager, connID, userID, dbName string, args ...interface{}) {
+	execID := req.ExecutionID
+	if execID == "" {
+		execID = uuid.NewString()
+	}
+
+	if req.Async {
+		h.submitQueryJob(ctx, req, poolMgr, execID, connID, userID, dbName, args)
+		return
+	}
+
+	cacheKey, cacheable := "", false
+	if querycache.Enabled() {
+		cacheKey, cacheable = querycache.Key(connID, dbName, poolMgr.DBType, req.GeneratedQuery, args)
+	}
+	if req.Cache && cacheable {
+		if entry, rows, ok := querycache.Get(cacheKey); ok {
+			h.serveCachedResult(ctx, req, poolMgr, entry, rows, execID, connID, userID)
+			return
+		}
+	}
+
+	execCtx, exec, err := executions.Start(ctx.Request.Context(), execID, connID, userID, h.queryTimeout(poolMgr, req.Timeout))
+	if err != nil {
+		response.BadRequest(ctx, "Invalid execution ID", err)
+		return
+	}
+	defer exec.Finish()
+
 	executedAt := time.Now()
-	results, err := dbdriver.RunQuery(poolMgr.Pool, poolMgr.DBType, dbName, req.GeneratedQuery)
+	rows, err := dbdriver.RunQuery(execCtx, poolMgr.Pool, poolMgr.DBType, dbName, req.GeneratedQuery, args...)
+	if err == nil {
+		rows, err = result.Peek(rows)
+	}
 	if err != nil {
-		response.InternalError(ctx, err)
+		respondQueryError(ctx, exec, err)
+		h.saveQueryHistory(req, poolMgr.DBType, connID, userID, executedAt, time.Since(executedAt).Milliseconds(), err, false)
 		return
 	}
-	if results == nil {
-		results = []interface{}{}
+	defer rows.Close()
+
+	header := result.Fields{"execution_id": execID, "executed_at": executedAt}
+	var recorder *querycache.Recorder
+	if req.Cache && cacheable {
+		recorder = querycache.Capture(rows)
+		rows = recorder
+		header["cached"] = false
+		ctx.Header("X-Cache", "MISS")
+	}
+
+	var duration int64
+	summary, err := streamResult(ctx, "Query executed successfully", rows, h.maxResultRows(ctx), header,
+		func() result.Fields {
+			duration = time.Since(executedAt).Milliseconds()
+			return result.Fields{"duration": duration}
+		},
+	)
+	if summary.Truncated {
+		// The remaining rows are not needed, stop the query instead of draining it.
+		exec.Interrupt()
 	}
-	endTime := time.Now()
-	duration := endTime.Sub(executedAt).Milliseconds()
+	if err != nil {
+		log.Println("Error streaming query result:", err)
+		h.saveQueryHistory(req, poolMgr.DBType, connID, userID, executedAt, time.Since(executedAt).Milliseconds(), err, false)
+		return
+	}
+	switch {
+	case recorder != nil:
+		querycache.Put(cacheKey, connID, recorder, time.Duration(req.CacheTTL)*time.Second)
+	case !cacheable:
+		// The statement may have changed data, the cached results of the connection are stale.
+		querycache.InvalidateConnection(connID)
+	}
+	h.saveQueryHistory(req, poolMgr.DBType, connID, userID, executedAt, duration, nil, false)
+}
+
+// saveQueryHistory records an execution of the request in the history of the user, with the
+// error it failed with, if any. Results served from the cache did not run on the database, so
+// they are left out of its statistics and throughput.
+func (h *Handler) saveQueryHistory(req *RequestExecuteQuery, dbType, connID, userID string, executedAt time.Time, duration int64, queryErr error, cached bool) {
+	history := &queryhistory.QueryHistory{
+		UserID:         userID,
+		ConnectionID:   connID,
+		Query:          req.Query,
+		GeneratedQuery: req.GeneratedQuery,
+		ExecutedAt:     executedAt,
+		Duration:       duration,
+		Success:        queryErr == nil,
+	}
+	if queryErr != nil {
+		history.Error = queryErr.Error()
+	}
+	record := queryhistory.RecordCacheHit
+	if !cached {
+		poolmanager.RecordQuery(connID, userID, time.Duration(duration)*time.Millisecond, queryErr != nil)
+		record = queryhistory.Record
+	}
+	if err := record(h.Cfg.History, history, dbType); err != nil {
+		log.Println("Error saving query history:", err)
+	}
+}
+
+// serveCachedResult responds with a result set from the query cache, saying how old it is.
+func (h *Handler) serveCachedResult(ctx *gin.Context, req *RequestExecuteQuery, poolMgr *poolmanager.PoolManager, entry *querycache.Entry, rows result.Rows, execID, connID, userID string) {
+	defer rows.Close()
+
+	executedAt := time.Now()
+	age := entry.Age()
+	ctx.Header("X-Cache", "HIT")
+	ctx.Header("Age", strconv.Itoa(int(age.Seconds())))
+	_, err := streamResult(ctx, "Query served from cache", rows, h.maxResultRows(ctx),
+		result.Fields{"execution_id": execID, "executed_at": executedAt, "cached": true, "cached_at": entry.CachedAt, "cache_age_ms": age.Milliseconds()},
+		func() result.Fields {
+			return result.Fields{"duration": time.Since(executedAt).Milliseconds()}
+		},
+	)
+	if err != nil {
+		log.Println("Error streaming cached query result:", err)
+	}
+	h.saveQueryHistory(req, poolMgr.DBType, connID, userID, executedAt, time.Since(executedAt).Milliseconds(), err, true)
+}
+
+// HandleClearQueryCache drops the cached results of a connection of the authenticated user.
+func (h *Handler) HandleClearQueryCache(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	connID := ctx.Param("id")
+	if _, err := h.Cfg.Connections.GetRecord(connID, userID); err != nil {
+		response.NotFound(ctx, "Connection not found")
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Query cache cleared", gin.H{"removed": querycache.InvalidateConnection(connID)})
+}
 
-	queryResult := &ResponseQueryResult{
-		Result: results,
-		ExecutedAt: executedAt,
-		Duration: duration,
+// submitQueryJob queues the query as an asynchronous job and responds with the job right away.
+func (h *Handler) submitQueryJob(ctx *gin.Context, req *RequestExecuteQuery, poolMgr *poolmanager.PoolManager, jobID, connID, userID, dbName string, args []interface{}) {
+	// Async jobs are meant for long statements, so they get the maximum timeout unless the request asks otherwise.
+	timeout := h.Cfg.DBConfig.MaxQueryTimeout
+	if req.Timeout > 0 || timeout <= 0 {
+		timeout = h.queryTimeout(poolMgr, req.Timeout)
 	}
 
-	err = queryhistory.SaveQueryHistory(h.Cfg.DBClient, &queryhistory.QueryHistory{
-		UserID: poolMgr.UserID,
-		ConnectionID: connID,
-		Query: req.Query,
+	err := queryjobs.Submit(&queryjobs.Job{
+		ID:             jobID,
+		ConnID:         connID,
+		UserID:         userID,
+		DBName:         dbName,
+		Query:          req.Query,
 		GeneratedQuery: req.GeneratedQuery,
-		ExecutedAt: executedAt,
-		Duration: duration,
+		Args:           args,
+		DBType:         poolMgr.DBType,
+		Timeout:        timeout,
 	})
 	if err != nil {
-		log.Println("Error saving query history:", err)
+		if errors.Is(err, queryjobs.ErrQueueFull) {
+			response.Error(ctx, http.StatusServiceUnavailable, "Query job queue is full", err)
+			return
+		}
+		response.BadRequest(ctx, "Failed to submit query job", err)
+		return
+	}
+
+	job, err := queryjobs.GetJob(jobID, userID)
+	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
+	response.JSON(ctx, http.StatusAccepted, "Query job submitted", job)
+}
+
+// HandleGetRunningQueries lists the queries currently running on a connection for the authenticated user.
+func (h *Handler) HandleGetRunningQueries(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
 
-	response.JSON(ctx, http.StatusOK, "Query executed successfully", queryResult)
+	running := executions.ListByConnection(connID, userID)
+	if running == nil {
+		running = []*executions.Execution{}
+	}
+	response.JSON(ctx, http.StatusOK, "Running queries", running)
 }
 
+// HandleCancelQuery cancels a running query by its execution ID.
+func (h *Handler) HandleCancelQuery(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	execID := ctx.Param("exec_id")
+	if execID == "" {
+		response.BadRequest(ctx, "Execution ID is required", nil)
+		return
+	}
+
+	if err := executions.Cancel(execID, userID); err != nil {
+		if errors.Is(err, executions.ErrNotFound) {
+			response.NotFound(ctx, "Execution not found")
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Query cancellation requested")
+}
+
+// HandleGetQueryHistory lists the query history of the authenticated user on a connection, latest
+// first, a page at a time. It can be searched and narrowed by time, duration and outcome.
 func (h *Handler) HandleGetQueryHistory(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
 	connID := ctx.Param("id")
 	if connID == "" {
 		response.BadRequest(ctx, "Connection ID is required", nil)
 		return
 	}
 
-	histories, err := queryhistory.GetQueryHistoryByConnectionID(h.Cfg.DBClient, connID)
+	filter := queryhistory.Filter{Search: strings.TrimSpace(ctx.Query("q")), Limit: 50}
+	if text := ctx.Query("limit"); text != "" {
+		limit, err := strconv.Atoi(text)
+		if err != nil || limit <= 0 || limit > 500 {
+			response.BadRequest(ctx, "Limit must be between 1 and 500", err)
+			return
+		}
+		filter.Limit = limit
+	}
+	if text := ctx.Query("offset"); text != "" {
+		offset, err := strconv.Atoi(text)
+		if err != nil || offset < 0 {
+			response.BadRequest(ctx, "Offset must be a non-negative number", err)
+			return
+		}
+		filter.Offset = offset
+	}
+	for name, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if text := ctx.Query(name); text != "" {
+			t, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				response.BadRequest(ctx, "Invalid "+name+" time, expected RFC 3339", err)
+				return
+			}
+			*target = &t
+		}
+	}
+	for name, target := range map[string]**int64{"min_duration": &filter.MinDuration, "max_duration": &filter.MaxDuration} {
+		if text := ctx.Query(name); text != "" {
+			duration, err := strconv.ParseInt(text, 10, 64)
+			if err != nil || duration < 0 {
+				response.BadRequest(ctx, "Invalid "+name+", expected milliseconds", err)
+				return
+			}
+			*target = &duration
+		}
+	}
+	if text := ctx.Query("success"); text != "" {
+		success, err := strconv.ParseBool(text)
+		if err != nil {
+			response.BadRequest(ctx, "Success must be true or false", err)
+			return
+		}
+		filter.Success = &success
+	}
+
+	page, err := h.Cfg.History.List(connID, userID, filter)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 
-	response.JSON(ctx, http.StatusOK, "Query history retrieved successfully", histories)
-}	
+	response.JSON(ctx, http.StatusOK, "Query history retrieved successfully", page)
+}
 
+// HandleDeleteQueryHistory deletes the query history of the authenticated user on a connection.
 func (h *Handler) HandleDeleteQueryHistory(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
 	connID := ctx.Param("id")
 	if connID == "" {
 		response.BadRequest(ctx, "Connection ID is required", nil)
 		return
 	}
 
-	err := queryhistory.DeleteQueryHistoryByConnectionID(h.Cfg.DBClient, connID)
+	err := h.Cfg.History.Delete(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 
 	response.JSON(ctx, http.StatusOK, "Query history deleted successfully", nil)
-}
\ No newline at end of file
+}
+
+// HandleGetQueryAnalytics lists the slowest and the most frequent statements the authenticated
+// user ran on a connection within a time window, the last 24 hours by default. Statements are
+// grouped by fingerprint and the window is widened to whole hours.
+func (h *Handler) HandleGetQueryAnalytics(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+
+	until := time.Now().UTC()
+	since := until.Add(-24 * time.Hour)
+	for name, target := range map[string]*time.Time{"since": &since, "until": &until} {
+		if text := ctx.Query(name); text != "" {
+			t, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				response.BadRequest(ctx, "Invalid "+name+" time, expected RFC 3339", err)
+				return
+			}
+			*target = t
+		}
+	}
+	if !since.Before(until) {
+		response.BadRequest(ctx, "Since must be before until", nil)
+		return
+	}
+	limit := 10
+	if text := ctx.Query("limit"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n <= 0 || n > 100 {
+			response.BadRequest(ctx, "Limit must be between 1 and 100", err)
+			return
+		}
+		limit = n
+	}
+
+	analytics, err := queryhistory.GetAnalytics(h.Cfg.History, connID, userID, since, until, limit)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Query analytics", analytics)
+}
@@ -0,0 +1,546 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	savedqueries "github.com/cprakhar/datawhiz/internal/database/saved_queries"
+	"github.com/cprakhar/datawhiz/internal/db_driver/params"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type RequestSavedQuery struct {
+	Name        string                 `json:"name" binding:"required"`
+	Description string                 `json:"description"`
+	Prompt      string                 `json:"prompt"`
+	Query       string                 `json:"query" binding:"required"`
+	DBType      string                 `json:"db_type" binding:"required"`
+	Params      map[string]interface{} `json:"params"`
+	Tags        []string               `json:"tags"`
+	FolderID    *string                `json:"folder_id"`
+	Version     int                    `json:"version"` // the version an edit starts from
+}
+
+type RequestRunSavedQuery struct {
+	Params      map[string]interface{} `json:"params"`
+	Version     int                    `json:"version"` // a past version to run, the latest when zero
+	ExecutionID string                 `json:"execution_id"`
+	Timeout     int                    `json:"timeout"`
+	Async       bool                   `json:"async"`
+	Cache       bool                   `json:"cache"`
+	CacheTTL    int                    `json:"cache_ttl"`
+}
+
+type RequestShareSavedQuery struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type RequestSavedQueryFolder struct {
+	Name     string  `json:"name" binding:"required"`
+	ParentID *string `json:"parent_id"`
+}
+
+// validateSavedQuery checks a saved query request and normalizes its tags, writing the error
+// response when it is invalid.
+func (h *Handler) validateSavedQuery(ctx *gin.Context, req *RequestSavedQuery, userID string) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		response.BadRequest(ctx, "Name is required", nil)
+		return false
+	}
+	switch req.DBType {
+	case "postgresql", "mysql", "sqlite":
+	default:
+		response.BadRequest(ctx, "Saved queries are written for postgresql, mysql or sqlite", nil)
+		return false
+	}
+	if err := params.Validate(req.Query, req.Params); err != nil {
+		response.BadRequest(ctx, "Invalid default parameters", err)
+		return false
+	}
+	if req.Params == nil {
+		req.Params = map[string]interface{}{}
+	}
+	tags := []string{}
+	for _, tag := range req.Tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	req.Tags = tags
+	if req.FolderID != nil {
+		if _, err := savedqueries.GetFolder(h.Cfg.DBClient, *req.FolderID, userID); err != nil {
+			response.BadRequest(ctx, "Folder not found", err)
+			return false
+		}
+	}
+	return true
+}
+
+// getSavedQuery loads the saved query from the route parameters and writes the error response if
+// the user cannot see it. Queries shared with the user can be read but only changed by their owner.
+func (h *Handler) getSavedQuery(ctx *gin.Context, owner bool) (*savedqueries.SavedQuery, bool) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	queryID := ctx.Param("query_id")
+	if queryID == "" {
+		response.BadRequest(ctx, "Saved query ID is required", nil)
+		return nil, false
+	}
+
+	query, err := savedqueries.GetSavedQuery(h.Cfg.DBClient, queryID)
+	if err != nil {
+		if errors.Is(err, savedqueries.ErrNotFound) {
+			response.NotFound(ctx, "Saved query not found")
+			return nil, false
+		}
+		response.InternalError(ctx, err)
+		return nil, false
+	}
+	if query.UserID == userID {
+		return query, true
+	}
+	if !owner {
+		allowed, err := savedqueries.CanRead(h.Cfg.DBClient, query, userID)
+		if err != nil {
+			response.InternalError(ctx, err)
+			return nil, false
+		}
+		if allowed {
+			return query, true
+		}
+	}
+	response.NotFound(ctx, "Saved query not found")
+	return nil, false
+}
+
+// HandleCreateSavedQuery saves a query to the library of the authenticated user.
+func (h *Handler) HandleCreateSavedQuery(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var req RequestSavedQuery
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	if !h.validateSavedQuery(ctx, &req, userID) {
+		return
+	}
+
+	query, err := savedqueries.InsertSavedQuery(h.Cfg.DBClient, &savedqueries.SavedQuery{
+		UserID:      userID,
+		FolderID:    req.FolderID,
+		Name:        req.Name,
+		Description: req.Description,
+		Prompt:      req.Prompt,
+		Query:       req.Query,
+		DBType:      req.DBType,
+		Params:      req.Params,
+		Tags:        req.Tags,
+	})
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusCreated, "Query saved", query)
+}
+
+// HandleGetSavedQueries lists the saved queries of the authenticated user, or the queries shared
+// with them when shared is set, filtered by folder_id ("root" for no folder), tag and search.
+func (h *Handler) HandleGetSavedQueries(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	filter := savedqueries.Filter{
+		FolderID: ctx.Query("folder_id"),
+		Tag:      strings.ToLower(strings.TrimSpace(ctx.Query("tag"))),
+		Search:   ctx.Query("search"),
+	}
+	shared, _ := strconv.ParseBool(ctx.Query("shared"))
+
+	var queries []savedqueries.SavedQuery
+	var err error
+	if shared {
+		queries, err = savedqueries.ListSharedQueries(h.Cfg.DBClient, userID, filter)
+	} else {
+		queries, err = savedqueries.ListSavedQueries(h.Cfg.DBClient, userID, filter)
+	}
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved queries", queries)
+}
+
+// HandleGetSavedQuery retrieves a saved query with the names of its parameters.
+func (h *Handler) HandleGetSavedQuery(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, false)
+	if !ok {
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved query", gin.H{"query": query, "parameters": params.Names(query.Query)})
+}
+
+// HandleUpdateSavedQuery edits a saved query, keeping the previous content as a version. The
+// request names the version it was made from, so that concurrent edits are not lost.
+func (h *Handler) HandleUpdateSavedQuery(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, true)
+	if !ok {
+		return
+	}
+
+	var req RequestSavedQuery
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	if req.Version == 0 {
+		req.Version = query.Version
+	}
+	if req.DBType != query.DBType {
+		response.BadRequest(ctx, "The engine of a saved query cannot change, save it as a new query", nil)
+		return
+	}
+	if !h.validateSavedQuery(ctx, &req, query.UserID) {
+		return
+	}
+
+	updated, err := savedqueries.UpdateSavedQuery(h.Cfg.DBClient, &savedqueries.SavedQuery{
+		ID:          query.ID,
+		UserID:      query.UserID,
+		FolderID:    req.FolderID,
+		Name:        req.Name,
+		Description: req.Description,
+		Prompt:      req.Prompt,
+		Query:       req.Query,
+		DBType:      query.DBType,
+		Params:      req.Params,
+		Tags:        req.Tags,
+		Version:     req.Version,
+	})
+	if err != nil {
+		if errors.Is(err, savedqueries.ErrVersionConflict) {
+			response.Error(ctx, http.StatusConflict, "Saved query was changed meanwhile", err)
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved query updated", updated)
+}
+
+// HandleDeleteSavedQuery deletes a saved query with its versions.
+func (h *Handler) HandleDeleteSavedQuery(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, true)
+	if !ok {
+		return
+	}
+
+	if err := savedqueries.DeleteSavedQuery(h.Cfg.DBClient, query.ID, query.UserID); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Saved query deleted")
+}
+
+// HandleGetSavedQueryVersions lists the versions of a saved query, latest first.
+func (h *Handler) HandleGetSavedQueryVersions(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, false)
+	if !ok {
+		return
+	}
+
+	versions, err := savedqueries.ListVersions(h.Cfg.DBClient, query.ID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved query versions", versions)
+}
+
+// HandleRestoreSavedQueryVersion makes the content of a past version the latest version again.
+func (h *Handler) HandleRestoreSavedQueryVersion(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, true)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(ctx.Param("version"))
+	if err != nil {
+		response.BadRequest(ctx, "Invalid version", err)
+		return
+	}
+
+	version, err := savedqueries.GetVersion(h.Cfg.DBClient, query.ID, number)
+	if err != nil {
+		if errors.Is(err, savedqueries.ErrNotFound) {
+			response.NotFound(ctx, "Version not found")
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	query.Name = version.Name
+	query.Description = version.Description
+	query.Prompt = version.Prompt
+	query.Query = version.Query
+	query.Params = version.Params
+	query.Tags = version.Tags
+
+	updated, err := savedqueries.UpdateSavedQuery(h.Cfg.DBClient, query)
+	if err != nil {
+		if errors.Is(err, savedqueries.ErrVersionConflict) {
+			response.Error(ctx, http.StatusConflict, "Saved query was changed meanwhile", err)
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved query version restored", updated)
+}
+
+// HandleExecuteSavedQuery runs a saved query on a connection of the engine it was written for.
+// Parameter values of the request override the saved defaults.
+func (h *Handler) HandleExecuteSavedQuery(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	query, ok := h.getSavedQuery(ctx, false)
+	if !ok {
+		return
+	}
+
+	var req RequestRunSavedQuery
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(ctx, "Invalid request data", err)
+			return
+		}
+	}
+
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+	poolMgr, err := poolmanager.GetPool(connID, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	if poolMgr.DBType != query.DBType {
+		response.BadRequest(ctx, "Saved query is written for "+query.DBType+", not "+poolMgr.DBType, nil)
+		return
+	}
+
+	sql, prompt, defaults := query.Query, query.Prompt, query.Params
+	if req.Version != 0 && req.Version != query.Version {
+		version, err := savedqueries.GetVersion(h.Cfg.DBClient, query.ID, req.Version)
+		if err != nil {
+			if errors.Is(err, savedqueries.ErrNotFound) {
+				response.NotFound(ctx, "Version not found")
+				return
+			}
+			response.InternalError(ctx, err)
+			return
+		}
+		sql, prompt, defaults = version.Query, version.Prompt, version.Params
+	}
+
+	bound, args, err := params.Bind(sql, query.DBType, defaults, req.Params)
+	if err != nil {
+		response.BadRequest(ctx, "Invalid parameters", err)
+		return
+	}
+	if prompt == "" {
+		prompt = query.Name
+	}
+
+	h.executeQuery(ctx, &RequestExecuteQuery{
+		Query:          prompt,
+		GeneratedQuery: bound,
+		ExecutionID:    req.ExecutionID,
+		Timeout:        req.Timeout,
+		Async:          req.Async,
+		Cache:          req.Cache,
+		CacheTTL:       req.CacheTTL,
+	}, poolMgr, connID, userID, ctx.Query("db_name"), args...)
+}
+
+// HandleGetSavedQueryShares lists the users a saved query is shared with.
+func (h *Handler) HandleGetSavedQueryShares(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, true)
+	if !ok {
+		return
+	}
+
+	shares, err := savedqueries.ListShares(h.Cfg.DBClient, query.ID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved query shares", shares)
+}
+
+// HandleShareSavedQuery shares a saved query with the user with the given email, who can then
+// view and run it. The response is the same whether or not the email belongs to a user, so that
+// it does not tell which emails are registered.
+func (h *Handler) HandleShareSavedQuery(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, true)
+	if !ok {
+		return
+	}
+
+	var req RequestShareSavedQuery
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	user, err := h.Cfg.Users.GetByEmail(strings.TrimSpace(req.Email))
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	if user != nil && user.ID != query.UserID {
+		share := &savedqueries.Share{QueryID: query.ID, UserID: user.ID}
+		if err := savedqueries.AddShare(h.Cfg.DBClient, share); err != nil {
+			response.InternalError(ctx, err)
+			return
+		}
+	}
+	response.OK(ctx, "Saved query shared")
+}
+
+// HandleUnshareSavedQuery stops sharing a saved query with a user.
+func (h *Handler) HandleUnshareSavedQuery(ctx *gin.Context) {
+	query, ok := h.getSavedQuery(ctx, true)
+	if !ok {
+		return
+	}
+
+	if err := savedqueries.RemoveShare(h.Cfg.DBClient, query.ID, ctx.Param("user_id")); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Saved query unshared")
+}
+
+// HandleGetSavedQueryFolders lists the folders of the authenticated user.
+func (h *Handler) HandleGetSavedQueryFolders(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	folders, err := savedqueries.ListFolders(h.Cfg.DBClient, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Saved query folders", folders)
+}
+
+// HandleCreateSavedQueryFolder creates a folder, inside another one when parent_id is set.
+func (h *Handler) HandleCreateSavedQueryFolder(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var req RequestSavedQueryFolder
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	if !h.validateFolderParent(ctx, userID, "", req.ParentID) {
+		return
+	}
+
+	folder, err := savedqueries.InsertFolder(h.Cfg.DBClient, &savedqueries.Folder{
+		UserID:   userID,
+		ParentID: req.ParentID,
+		Name:     strings.TrimSpace(req.Name),
+	})
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusCreated, "Folder created", folder)
+}
+
+// HandleUpdateSavedQueryFolder renames a folder or moves it under another one.
+func (h *Handler) HandleUpdateSavedQueryFolder(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	folder, err := savedqueries.GetFolder(h.Cfg.DBClient, ctx.Param("folder_id"), userID)
+	if err != nil {
+		response.NotFound(ctx, "Folder not found")
+		return
+	}
+	var req RequestSavedQueryFolder
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	if !h.validateFolderParent(ctx, userID, folder.ID, req.ParentID) {
+		return
+	}
+
+	folder.Name = strings.TrimSpace(req.Name)
+	folder.ParentID = req.ParentID
+	if err := savedqueries.UpdateFolder(h.Cfg.DBClient, folder); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Folder updated", folder)
+}
+
+// HandleDeleteSavedQueryFolder deletes a folder. Its queries and subfolders move up to its parent.
+func (h *Handler) HandleDeleteSavedQueryFolder(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	folder, err := savedqueries.GetFolder(h.Cfg.DBClient, ctx.Param("folder_id"), userID)
+	if err != nil {
+		response.NotFound(ctx, "Folder not found")
+		return
+	}
+	if err := savedqueries.DeleteFolder(h.Cfg.DBClient, folder); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Folder deleted")
+}
+
+// validateFolderParent checks that the parent of a folder belongs to the user and is not the
+// folder itself or one of its subfolders, writing the error response when it is not.
+func (h *Handler) validateFolderParent(ctx *gin.Context, userID, folderID string, parentID *string) bool {
+	if parentID == nil {
+		return true
+	}
+	folders, err := savedqueries.ListFolders(h.Cfg.DBClient, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return false
+	}
+	parents := make(map[string]*string, len(folders))
+	for _, folder := range folders {
+		parents[folder.ID] = folder.ParentID
+	}
+	if _, ok := parents[*parentID]; !ok {
+		response.BadRequest(ctx, "Parent folder not found", nil)
+		return false
+	}
+	// The walk is bounded so that a cycle left in the table cannot hang the request.
+	for i, id := 0, parentID; id != nil && i <= len(folders); i, id = i+1, parents[*id] {
+		if *id == folderID {
+			response.BadRequest(ctx, "A folder cannot be moved into itself", nil)
+			return false
+		}
+	}
+	return true
+}
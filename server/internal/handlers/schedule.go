@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	savedqueries "github.com/cprakhar/datawhiz/internal/database/saved_queries"
+	"github.com/cprakhar/datawhiz/internal/database/schedules"
+	"github.com/cprakhar/datawhiz/internal/db_driver/params"
+	"github.com/cprakhar/datawhiz/internal/scheduler"
+	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type RequestSchedule struct {
+	QueryID  string                 `json:"query_id" binding:"required"`
+	ConnID   string                 `json:"conn_id" binding:"required"`
+	DBName   string                 `json:"db_name"`
+	Name     string                 `json:"name" binding:"required"`
+	Cron     string                 `json:"cron" binding:"required"`
+	Timezone string                 `json:"timezone"` // UTC by default
+	Params   map[string]interface{} `json:"params"`
+	Sink     schedules.Sink         `json:"sink"`
+	CatchUp  schedules.CatchUp      `json:"catch_up"` // once by default
+	Enabled  *bool                  `json:"enabled"`  // true by default
+}
+
+// validateSchedule checks a schedule request and fills in its defaults, writing the error
+// response when it is invalid. It returns the first run time of the schedule.
+func (h *Handler) validateSchedule(ctx *gin.Context, req *RequestSchedule, userID string) (*time.Time, bool) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		response.BadRequest(ctx, "Name is required", nil)
+		return nil, false
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	nextRunAt, err := scheduler.NextRun(req.Cron, req.Timezone, time.Now())
+	if err != nil {
+		response.BadRequest(ctx, "Invalid schedule", err)
+		return nil, false
+	}
+	switch req.CatchUp {
+	case "":
+		req.CatchUp = schedules.CatchUpOnce
+	case schedules.CatchUpSkip, schedules.CatchUpOnce, schedules.CatchUpAll:
+	default:
+		response.BadRequest(ctx, "Catch up must be one of skip, once or all", nil)
+		return nil, false
+	}
+	if err := scheduler.ValidateSink(&req.Sink); err != nil {
+		response.BadRequest(ctx, "Invalid sink", err)
+		return nil, false
+	}
+	if req.Enabled == nil {
+		enabled := true
+		req.Enabled = &enabled
+	}
+
+	query, err := savedqueries.GetSavedQuery(h.Cfg.DBClient, req.QueryID)
+	if err != nil {
+		response.BadRequest(ctx, "Saved query not found", err)
+		return nil, false
+	}
+	allowed, err := savedqueries.CanRead(h.Cfg.DBClient, query, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return nil, false
+	}
+	if !allowed {
+		response.BadRequest(ctx, "Saved query not found", nil)
+		return nil, false
+	}
+	if err := params.Validate(query.Query, req.Params); err != nil {
+		response.BadRequest(ctx, "Invalid parameters", err)
+		return nil, false
+	}
+	if req.Params == nil {
+		req.Params = map[string]interface{}{}
+	}
+
+	conn, err := h.Cfg.Connections.GetRecord(req.ConnID, userID)
+	if err != nil {
+		response.BadRequest(ctx, "Connection not found", err)
+		return nil, false
+	}
+	if conn.DBType != query.DBType {
+		response.BadRequest(ctx, "Saved query is written for "+query.DBType+", not "+conn.DBType, nil)
+		return nil, false
+	}
+	return &nextRunAt, true
+}
+
+// getSchedule loads the schedule from the route parameters and writes the error response if it cannot be found.
+func (h *Handler) getSchedule(ctx *gin.Context) (*schedules.Schedule, bool) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	schedule, err := schedules.GetSchedule(h.Cfg.DBClient, ctx.Param("schedule_id"), userID)
+	if err != nil {
+		if errors.Is(err, schedules.ErrNotFound) {
+			response.NotFound(ctx, "Schedule not found")
+			return nil, false
+		}
+		response.InternalError(ctx, err)
+		return nil, false
+	}
+	return schedule, true
+}
+
+// HandleCreateSchedule schedules a saved query to run on a connection of the authenticated user.
+func (h *Handler) HandleCreateSchedule(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	var req RequestSchedule
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	nextRunAt, ok := h.validateSchedule(ctx, &req, userID)
+	if !ok {
+		return
+	}
+
+	schedule, err := schedules.InsertSchedule(h.Cfg.DBClient, &schedules.Schedule{
+		UserID:    userID,
+		QueryID:   req.QueryID,
+		ConnID:    req.ConnID,
+		DBName:    req.DBName,
+		Name:      req.Name,
+		Cron:      req.Cron,
+		Timezone:  req.Timezone,
+		Params:    req.Params,
+		Sink:      req.Sink,
+		CatchUp:   req.CatchUp,
+		Enabled:   *req.Enabled,
+		NextRunAt: nextRunAt,
+	})
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusCreated, "Schedule created", schedule)
+}
+
+// HandleGetSchedules lists the schedules of the authenticated user.
+func (h *Handler) HandleGetSchedules(ctx *gin.Context) {
+	session := sessions.Default(ctx)
+	userID := session.Get("user_id").(string)
+
+	list, err := schedules.ListSchedules(h.Cfg.DBClient, userID)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Schedules", list)
+}
+
+// HandleGetSchedule retrieves a schedule.
+func (h *Handler) HandleGetSchedule(ctx *gin.Context) {
+	schedule, ok := h.getSchedule(ctx)
+	if !ok {
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Schedule", schedule)
+}
+
+// HandleUpdateSchedule changes the settings of a schedule. The next run is computed again from
+// now, so runs missed while a schedule was disabled are not made up.
+func (h *Handler) HandleUpdateSchedule(ctx *gin.Context) {
+	schedule, ok := h.getSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	var req RequestSchedule
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	nextRunAt, ok := h.validateSchedule(ctx, &req, schedule.UserID)
+	if !ok {
+		return
+	}
+
+	updated, err := schedules.UpdateSchedule(h.Cfg.DBClient, &schedules.Schedule{
+		ID:        schedule.ID,
+		UserID:    schedule.UserID,
+		QueryID:   req.QueryID,
+		ConnID:    req.ConnID,
+		DBName:    req.DBName,
+		Name:      req.Name,
+		Cron:      req.Cron,
+		Timezone:  req.Timezone,
+		Params:    req.Params,
+		Sink:      req.Sink,
+		CatchUp:   req.CatchUp,
+		Enabled:   *req.Enabled,
+		NextRunAt: nextRunAt,
+	})
+	if err != nil {
+		if errors.Is(err, schedules.ErrNotFound) {
+			response.NotFound(ctx, "Schedule not found")
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Schedule updated", updated)
+}
+
+// HandleDeleteSchedule deletes a schedule with its runs.
+func (h *Handler) HandleDeleteSchedule(ctx *gin.Context) {
+	schedule, ok := h.getSchedule(ctx)
+	if !ok {
+		return
+	}
+	if err := schedules.DeleteSchedule(h.Cfg.DBClient, schedule.ID, schedule.UserID); err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.OK(ctx, "Schedule deleted")
+}
+
+// HandleRunSchedule starts a run of a schedule right away. The run continues in the background;
+// its ID is also its execution ID, so it can be cancelled like any other query.
+func (h *Handler) HandleRunSchedule(ctx *gin.Context) {
+	schedule, ok := h.getSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	run, err := scheduler.RunNow(schedule)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrAlreadyRunning) {
+			response.Error(ctx, http.StatusConflict, "Schedule is already running", err)
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusAccepted, "Schedule run started", run)
+}
+
+// HandleGetScheduleRuns lists the latest runs of a schedule without their rows.
+func (h *Handler) HandleGetScheduleRuns(ctx *gin.Context) {
+	schedule, ok := h.getSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if text := ctx.Query("limit"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n <= 0 || n > 500 {
+			response.BadRequest(ctx, "Limit must be between 1 and 500", err)
+			return
+		}
+		limit = n
+	}
+
+	runs, err := schedules.ListRuns(h.Cfg.DBClient, schedule.ID, schedule.UserID, limit)
+	if err != nil {
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Schedule runs", runs)
+}
+
+// HandleGetScheduleRun retrieves a run of a schedule with the rows it stored.
+func (h *Handler) HandleGetScheduleRun(ctx *gin.Context) {
+	schedule, ok := h.getSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	run, err := schedules.GetRun(h.Cfg.DBClient, ctx.Param("run_id"), schedule.ID, schedule.UserID)
+	if err != nil {
+		if errors.Is(err, schedules.ErrRunNotFound) {
+			response.NotFound(ctx, "Run not found")
+			return
+		}
+		response.InternalError(ctx, err)
+		return
+	}
+	response.JSON(ctx, http.StatusOK, "Schedule run", run)
+}
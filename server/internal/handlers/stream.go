@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/gin-gonic/gin"
+)
+
+// maxResultRows resolves the row cap for a result set. The max_rows parameter can only lower the server cap.
+func (h *Handler) maxResultRows(ctx *gin.Context) int64 {
+	maxRows := int64(h.Cfg.DBConfig.MaxResultRows)
+	if requested, err := strconv.ParseInt(ctx.Query("max_rows"), 10, 64); err == nil && requested > 0 {
+		if maxRows <= 0 || requested < maxRows {
+			maxRows = requested
+		}
+	}
+	return maxRows
+}
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON through the
+// format parameter or the Accept header.
+func wantsNDJSON(ctx *gin.Context) bool {
+	if format := ctx.Query("format"); format != "" {
+		return format == "ndjson"
+	}
+	return strings.Contains(ctx.GetHeader("Accept"), "application/x-ndjson")
+}
+
+// streamResult writes the rows to the response as they are read. By default the result is
+// written in chunks inside the standard response envelope; NDJSON clients get a header line,
+// one array per row and a trailer line instead. Errors that happen once streaming has started
+// are reported in the trailer since the status code has already been sent.
+func streamResult(ctx *gin.Context, message string, rows result.Rows, maxRows int64, header result.Fields, trailer func() result.Fields) (result.Summary, error) {
+	ctx.Status(http.StatusOK)
+
+	if wantsNDJSON(ctx) {
+		ctx.Header("Content-Type", "application/x-ndjson")
+		writer := result.NewNDJSONWriter(ctx.Writer)
+		writer.Header = header
+		writer.Trailer = trailer
+		return result.Copy(writer, rows, maxRows)
+	}
+
+	ctx.Header("Content-Type", "application/json; charset=utf-8")
+	encodedMessage, _ := json.Marshal(message)
+	ctx.Writer.WriteString(`{"message":` + string(encodedMessage) + `,"data":`)
+
+	writer := result.NewJSONWriter(ctx.Writer)
+	writer.Header = header
+	writer.Trailer = trailer
+	summary, err := result.Copy(writer, rows, maxRows)
+	if err != nil {
+		encodedErr, _ := json.Marshal(err.Error())
+		ctx.Writer.WriteString(`,"success":false,"error":` + string(encodedErr) + `}`)
+		return summary, err
+	}
+	ctx.Writer.WriteString(`,"success":true}`)
+	return summary, nil
+}
@@ -1,11 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 
 	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/records"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
 	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
 	"github.com/cprakhar/datawhiz/utils/response"
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,7 +25,8 @@ func (h *Handler) HandleGetTables(ctx *gin.Context) {
 		return
 	}
 
-	poolMgr, err := poolmanager.GetPool(connID)
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -25,7 +34,10 @@ func (h *Handler) HandleGetTables(ctx *gin.Context) {
 
 	dbName := ctx.Query("db_name")
 
-	tables, err := dbdriver.ExtractDBTables(poolMgr.Pool, poolMgr.DBType, dbName)
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.queryTimeout(poolMgr, 0))
+	defer cancel()
+
+	tables, err := dbdriver.ExtractDBTables(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -42,7 +54,8 @@ func (h* Handler) HandleGetTableSchema(ctx *gin.Context) {
 		return
 	}
 
-	poolMgr, err := poolmanager.GetPool(connID)
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -56,7 +69,10 @@ func (h* Handler) HandleGetTableSchema(ctx *gin.Context) {
 
     dbName := ctx.Query("db_name")
 
-	schema, err := dbdriver.GetTableSchema(poolMgr.Pool, poolMgr.DBType, dbName, tableName)
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.queryTimeout(poolMgr, 0))
+	defer cancel()
+
+	schema, err := dbdriver.GetTableSchema(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, tableName)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -73,7 +89,8 @@ func (h *Handler) HandleGetTableRecords(ctx *gin.Context) {
 		return
 	}
 
-	poolMgr, err := poolmanager.GetPool(connID)
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
@@ -86,12 +103,159 @@ func (h *Handler) HandleGetTableRecords(ctx *gin.Context) {
 	}
 
 	dbName := ctx.Query("db_name")
+	timeout, _ := strconv.Atoi(ctx.Query("timeout"))
+	maxRows := h.maxResultRows(ctx)
 
-	records, err := dbdriver.GetTableRecords(poolMgr.Pool, poolMgr.DBType, dbName, tableName)
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.queryTimeout(poolMgr, timeout))
+	defer cancel()
+
+	// Fetch one extra row so that a truncated table can be told apart from one that fits.
+	rows, err := dbdriver.GetTableRecords(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, tableName, maxRows+1)
+	if err == nil {
+		rows, err = result.Peek(rows)
+	}
+	if err != nil {
+		respondQueryError(ctx, nil, err)
+		return
+	}
+	defer rows.Close()
+
+	if _, err := streamResult(ctx, "Table records retrieved successfully", rows, maxRows, nil, nil); err != nil {
+		log.Println("Error streaming table records:", err)
+	}
+}
+
+// maxChangesPerBatch bounds the number of row edits applied in one transaction.
+const maxChangesPerBatch = 1000
+
+type RequestTableChanges struct {
+	Changes                []records.Change `json:"changes"`
+	AllowWithoutPrimaryKey bool             `json:"allow_without_primary_key"`
+}
+
+type RequestInsertRecords struct {
+	Rows []map[string]interface{} `json:"rows"`
+}
+
+type RequestUpdateRecords struct {
+	Rows []struct {
+		Key    map[string]interface{} `json:"key"`
+		Values map[string]interface{} `json:"values"`
+	} `json:"rows"`
+	AllowWithoutPrimaryKey bool `json:"allow_without_primary_key"`
+}
+
+type RequestDeleteRecords struct {
+	Keys                   []map[string]interface{} `json:"keys"`
+	AllowWithoutPrimaryKey bool                     `json:"allow_without_primary_key"`
+}
+
+// HandleInsertTableRecords inserts rows into a table.
+func (h *Handler) HandleInsertTableRecords(ctx *gin.Context) {
+	var req RequestInsertRecords
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	changes := make([]records.Change, len(req.Rows))
+	for i, row := range req.Rows {
+		changes[i] = records.Change{Op: records.OpInsert, Values: row}
+	}
+	h.applyTableChanges(ctx, changes, false)
+}
+
+// HandleUpdateTableRecords updates rows of a table identified by their primary key.
+func (h *Handler) HandleUpdateTableRecords(ctx *gin.Context) {
+	var req RequestUpdateRecords
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	changes := make([]records.Change, len(req.Rows))
+	for i, row := range req.Rows {
+		changes[i] = records.Change{Op: records.OpUpdate, Key: row.Key, Values: row.Values}
+	}
+	h.applyTableChanges(ctx, changes, req.AllowWithoutPrimaryKey)
+}
+
+// HandleDeleteTableRecords deletes rows of a table identified by their primary key.
+func (h *Handler) HandleDeleteTableRecords(ctx *gin.Context) {
+	var req RequestDeleteRecords
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	changes := make([]records.Change, len(req.Keys))
+	for i, key := range req.Keys {
+		changes[i] = records.Change{Op: records.OpDelete, Key: key}
+	}
+	h.applyTableChanges(ctx, changes, req.AllowWithoutPrimaryKey)
+}
+
+// HandleApplyTableChanges applies a mixed batch of inserts, updates and deletes in one transaction.
+func (h *Handler) HandleApplyTableChanges(ctx *gin.Context) {
+	var req RequestTableChanges
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(ctx, "Invalid request data", err)
+		return
+	}
+	h.applyTableChanges(ctx, req.Changes, req.AllowWithoutPrimaryKey)
+}
+
+// applyTableChanges validates and applies a batch of row edits, rolling it back if any of them fails.
+func (h *Handler) applyTableChanges(ctx *gin.Context, changes []records.Change, allowNoPK bool) {
+	connID := ctx.Param("id")
+	if connID == "" {
+		response.BadRequest(ctx, "Connection ID is required", nil)
+		return
+	}
+	tableName := ctx.Param("table_name")
+	if tableName == "" {
+		response.BadRequest(ctx, "Table name is required", nil)
+		return
+	}
+	if len(changes) == 0 {
+		response.BadRequest(ctx, "No changes given", nil)
+		return
+	}
+	if len(changes) > maxChangesPerBatch {
+		response.BadRequest(ctx, "Too many changes in one batch", "the limit is "+strconv.Itoa(maxChangesPerBatch))
+		return
+	}
+
+	userID := sessions.Default(ctx).Get("user_id").(string)
+	poolMgr, err := poolmanager.GetPool(connID, userID)
 	if err != nil {
 		response.InternalError(ctx, err)
 		return
 	}
 
-	response.JSON(ctx, http.StatusOK, "Table records retrieved successfully", records)
-}
\ No newline at end of file
+	dbName := ctx.Query("db_name")
+
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.queryTimeout(poolMgr, 0))
+	defer cancel()
+
+	summary, err := dbdriver.ApplyTableChanges(reqCtx, poolMgr.Pool, poolMgr.DBType, dbName, tableName, changes, allowNoPK)
+	if err == nil {
+		querycache.InvalidateConnection(connID)
+	}
+	if err != nil {
+		var validationErrs records.ValidationErrors
+		var changeErr *records.ChangeError
+		switch {
+		case errors.As(err, &validationErrs):
+			response.BadRequest(ctx, "Invalid changes", err)
+		case errors.Is(err, records.ErrNoPrimaryKey):
+			response.BadRequest(ctx, "Table has no primary key", err)
+		case errors.Is(err, records.ErrRowNotFound), errors.Is(err, records.ErrAmbiguousKey):
+			response.Error(ctx, http.StatusConflict, "Changes were rolled back", err)
+		case errors.As(err, &changeErr):
+			response.BadRequest(ctx, "Changes were rolled back", err)
+		default:
+			respondQueryError(ctx, nil, err)
+		}
+		return
+	}
+
+	response.JSON(ctx, http.StatusOK, "Changes applied successfully", summary)
+}
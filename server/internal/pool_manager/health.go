@@ -0,0 +1,198 @@
+package poolmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	HealthHealthy  = "healthy"  // the last ping succeeded in time
+	HealthDegraded = "degraded" // the last ping was slow, or failed fewer than downAfter times in a row
+	HealthDown     = "down"     // the pool failed downAfter pings in a row and is being rebuilt
+)
+
+const (
+	// downAfter is the number of failed pings in a row after which a pool is down.
+	downAfter = 3
+	// poolRebuildMaxBackoff caps the time between two attempts at rebuilding a pool.
+	poolRebuildMaxBackoff = 5 * time.Minute
+)
+
+// Health is the state of a pool as seen by the last health check.
+type Health struct {
+	State       string     `json:"state"`
+	LatencyMs   int64      `json:"latencyMs"`
+	CheckedAt   time.Time  `json:"checkedAt"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	Failures    int        `json:"failures,omitempty"` // failed pings or rebuilds in a row
+
+	nextRebuild time.Time
+}
+
+// GetHealth returns the health of the pool of a user for a connection, or nil if none is open.
+func GetHealth(connID, userID string) *Health {
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+
+	if pool, exists := poolMap[connID]; exists && pool.UserID == userID {
+		health := pool.health
+		return &health
+	}
+	return nil
+}
+
+// StartHealthChecker starts a background goroutine that pings every open pool on an interval and
+// rebuilds the pools that are down, backing off exponentially between attempts.
+func StartHealthChecker(cfg *config.Config) {
+	go func() {
+		ticker := time.NewTicker(cfg.Env.PoolHealthInterval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			CheckPools(cfg)
+		}
+	}()
+}
+
+// CheckPools checks the health of every open pool at once, and rebuilds the ones that are down
+// and due for another attempt.
+func CheckPools(cfg *config.Config) {
+	poolMutex.Lock()
+	pools := make([]*PoolManager, 0, len(poolMap))
+	for _, pool := range poolMap {
+		pools = append(pools, pool)
+	}
+	poolMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pool := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkPool(cfg, pool)
+		}()
+	}
+	wg.Wait()
+}
+
+func checkPool(cfg *config.Config, pool *PoolManager) {
+	poolMutex.Lock()
+	health := pool.health
+	poolMutex.Unlock()
+
+	if health.State == HealthDown {
+		if time.Now().Before(health.nextRebuild) {
+			return
+		}
+		rebuild(cfg, pool)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Env.PoolHealthTimeout)
+	start := time.Now()
+	err := ping(ctx, pool.Pool)
+	latency := time.Since(start)
+	cancel()
+
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+	health = pool.health
+	health.CheckedAt = time.Now()
+	health.LatencyMs = latency.Milliseconds()
+	switch {
+	case err != nil:
+		health.fail(err, cfg.Env.PoolHealthInterval)
+		if health.State == HealthDown {
+			log.Println("Connection pool", pool.connID, "is down:", err)
+		}
+	case latency > cfg.Env.PoolHealthSlowPing:
+		health.State = HealthDegraded
+		health.Failures = 0
+	default:
+		health.State = HealthHealthy
+		health.Failures = 0
+	}
+	pool.health = health
+}
+
+// fail records a failed ping or rebuild. A pool that goes down is first rebuilt after interval,
+// then after twice as long on every failure, up to poolRebuildMaxBackoff.
+func (h *Health) fail(err error, interval time.Duration) {
+	now := time.Now()
+	h.LastError = err.Error()
+	h.LastErrorAt = &now
+	h.Failures++
+	if h.Failures < downAfter {
+		h.State = HealthDegraded
+		return
+	}
+	h.State = HealthDown
+	backoff := interval
+	for range h.Failures - downAfter {
+		if backoff >= poolRebuildMaxBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	h.nextRebuild = now.Add(min(backoff, poolRebuildMaxBackoff))
+}
+
+// rebuild replaces a pool that is down with a new one from the stored connection, unless it has
+// been replaced or closed meanwhile.
+func rebuild(cfg *config.Config, pool *PoolManager) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Env.PoolHealthTimeout)
+	defer cancel()
+	fresh, err := dial(ctx, cfg, pool.connID, pool.DBType, pool.UserID)
+
+	poolMutex.Lock()
+	if poolMap[pool.connID] != pool {
+		poolMutex.Unlock()
+		if err == nil {
+			closePool(fresh)
+		}
+		return
+	}
+	if err != nil {
+		health := pool.health
+		health.CheckedAt = time.Now()
+		health.fail(err, cfg.Env.PoolHealthInterval)
+		pool.health = health
+		poolMutex.Unlock()
+		log.Println("Error rebuilding connection pool", pool.connID+":", err)
+		return
+	}
+	// Swap in place so that the pool keeps its idle TTL and its rank in the LRU list.
+	fresh.ExpiresAt = pool.ExpiresAt
+	fresh.LastUsedAt = pool.LastUsedAt
+	fresh.queries = pool.queries
+	fresh.elem = pool.elem
+	fresh.elem.Value = fresh
+	poolMap[pool.connID] = fresh
+	poolMutex.Unlock()
+
+	closePool(pool)
+	log.Println("Rebuilt connection pool", pool.connID)
+}
+
+// ping checks that a *pgxpool.Pool, *sql.DB or *mongo.Client reaches its database.
+func ping(ctx context.Context, pool interface{}) error {
+	switch p := pool.(type) {
+	case *pgxpool.Pool:
+		return p.Ping(ctx)
+	case *sql.DB:
+		return p.PingContext(ctx)
+	case *mongo.Client:
+		return p.Ping(ctx, nil)
+	default:
+		return errors.New("unsupported pool type")
+	}
+}
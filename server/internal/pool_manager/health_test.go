@@ -0,0 +1,59 @@
+package poolmanager
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestCheckPoolsRebuildsDownPool(t *testing.T) {
+	cfg, _ := newTestConfig(t, 0, 0)
+	cfg.Env.PoolHealthInterval = time.Minute
+	cfg.Env.PoolHealthTimeout = time.Second
+	cfg.Env.PoolHealthSlowPing = time.Second
+
+	if err := ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	old, err := GetPool("alice/1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Pool.(*sql.DB).Close()
+
+	for i, want := range []string{HealthDegraded, HealthDegraded, HealthDown} {
+		CheckPools(cfg)
+		health := GetHealth("alice/1", "alice")
+		if health.State != want || health.Failures != i+1 || health.LastError == "" {
+			t.Fatalf("after %d failed pings: %+v, want %s", i+1, health, want)
+		}
+	}
+
+	// Not due for a rebuild yet.
+	CheckPools(cfg)
+	if pool, _ := GetPool("alice/1", "alice"); pool != old {
+		t.Fatal("pool rebuilt before its backoff")
+	}
+
+	poolMutex.Lock()
+	old.health.nextRebuild = time.Now()
+	poolMutex.Unlock()
+	CheckPools(cfg)
+	pool, err := GetPool("alice/1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool == old {
+		t.Fatal("down pool was not rebuilt")
+	}
+	if err := pool.Pool.(*sql.DB).Ping(); err != nil {
+		t.Errorf("rebuilt pool: %v", err)
+	}
+	if health := GetHealth("alice/1", "alice"); health.State != HealthHealthy {
+		t.Errorf("rebuilt pool is %s", health.State)
+	}
+	if GetHealth("alice/1", "mallory") != nil {
+		t.Error("GetHealth returned the health of the pool of another user")
+	}
+}
@@ -1,6 +1,7 @@
 package poolmanager
 
 import (
+	   "container/list"
 	   "context"
 	   "database/sql"
 	   "errors"
@@ -10,161 +11,327 @@ import (
 
 	   "github.com/cprakhar/datawhiz/config"
 	   "github.com/cprakhar/datawhiz/internal/database/connections"
+	   "github.com/cprakhar/datawhiz/internal/database/schema"
 	   dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	   "github.com/cprakhar/datawhiz/internal/db_driver/nosql"
+	   sql_ "github.com/cprakhar/datawhiz/internal/db_driver/sql"
+	   "github.com/cprakhar/datawhiz/internal/db_driver/tlsconfig"
+	   "github.com/cprakhar/datawhiz/internal/db_driver/tunnel"
 	   "github.com/cprakhar/datawhiz/utils/secure"
 	   "github.com/jackc/pgx/v5/pgxpool"
-	   "github.com/supabase-community/supabase-go"
 	   "go.mongodb.org/mongo-driver/v2/mongo"
+	   "golang.org/x/sync/singleflight"
 )
 
 type PoolManager struct {
-	Pool      interface{} // This can be a *pgxpool.Pool, *sql.DB, *mongo.Client, etc.
-	ExpiresAt time.Time
-	UserID    string
-	DBType    string
+	Pool         interface{} // This can be a *pgxpool.Pool, *sql.DB, *mongo.Client, etc.
+	ExpiresAt    time.Time   // LastUsedAt plus the idle TTL
+	LastUsedAt   time.Time
+	UserID       string
+	DBType       string
+	QueryTimeout time.Duration // Per-connection query timeout, zero means the server default
+
+	health  Health // guarded by poolMutex
+	queries *throughput
+	tunnel  *tunnel.Tunnel // nil unless the connection goes through an SSH jump host
+	connID  string
+	elem    *list.Element // in poolLRU
 }
 
 
 var (
 	   poolMap   = make(map[string]*PoolManager) // key: connection ID
-	   poolMutex sync.RWMutex                    // Mutex to protect access to poolMap
+	   poolLRU   = list.New()                    // most recently used first
+	   poolMutex sync.Mutex                      // Mutex to protect access to poolMap and poolLRU
+	   poolCfg   *config.Config
+
+	   activations singleflight.Group // activations in progress, keyed by user and connection ID
 )
 
+// defaultIdleTTL is how long a pool stays open without being used when the cleanup routine
+// has not been started with a configuration.
+const defaultIdleTTL = time.Hour
+
+// activationTimeout bounds an activation, which outlives the request that started it when others
+// wait on it.
+const activationTimeout = 30 * time.Second
+
 // DeactivateAllUserPools deactivates all connection pools for a specific user.
 func DeactivateAllUserPools(userID string) {
 	poolMutex.Lock()
-	defer poolMutex.Unlock()
-	for connID, pool := range poolMap {
+	var closed []*PoolManager
+	for _, pool := range poolMap {
 		if pool.UserID == userID {
-			switch p := pool.Pool.(type) {
-			case *pgxpool.Pool:
-				p.Close()
-			case *sql.DB:
-				p.Close()
-			case *mongo.Client:
-				p.Disconnect(context.Background())
-			}
-			delete(poolMap, connID)
+			remove(pool)
+			closed = append(closed, pool)
 		}
 	}
+	poolMutex.Unlock()
+
+	for _, pool := range closed {
+		closePool(pool)
+	}
 }
 
-// GetPool retrieves the connection pool for the given connection ID.
-func GetPool(connID string) (*PoolManager, error) {
-	poolMutex.RLock()
-	defer poolMutex.RUnlock()
+// GetPool retrieves the connection pool of a user for the given connection ID. Using a pool extends its idle TTL.
+func GetPool(connID, userID string) (*PoolManager, error) {
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
 
-	if pool, exists := poolMap[connID]; exists && time.Now().Before(pool.ExpiresAt) {
+	if pool, exists := poolMap[connID]; exists && pool.UserID == userID && time.Now().Before(pool.ExpiresAt) {
+		touch(pool)
 		return pool, nil
 	}
 	return nil, errors.New("connection pool not found or expired")
 }
 
-// ActivateConnection activates a connection pool for the given connection ID.
-func ActivateConnection(cfg *config.Config, connID, dbType, userID string) error {
+// ActivateConnection activates a connection pool for the given connection ID. Concurrent
+// activations of a connection by its owner share a single dial. When the pools open exceed the
+// limits, those of the user and then those of anyone used least recently are closed.
+func ActivateConnection(ctx context.Context, cfg *config.Config, connID, dbType, userID string) error {
+	// Keyed by user too, so that the failed attempt of someone else does not fail the owner's.
+	_, err, _ := activations.Do(userID+"/"+connID, func() (interface{}, error) {
+		// The dial is shared, so the first caller going away must not fail those waiting on it.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), activationTimeout)
+		defer cancel()
+		return nil, activate(ctx, cfg, connID, dbType, userID)
+	})
+	return err
+}
+
+// activate opens the pool of a connection unless a live one is open, replacing and closing an expired one.
+func activate(ctx context.Context, cfg *config.Config, connID, dbType, userID string) error {
 	poolMutex.Lock()
 	pool, exists := poolMap[connID]
-	poolMutex.Unlock()
 	if exists && time.Now().Before(pool.ExpiresAt) {
+		defer poolMutex.Unlock()
+		if pool.UserID != userID {
+			return errors.New("connection not found")
+		}
+		touch(pool)
 		return nil
 	}
+	poolMutex.Unlock()
 
-	encryptedConnString, err := connections.GetConnectionStringByID(cfg.DBClient, connID, userID)
+	pool, err := dial(ctx, cfg, connID, dbType, userID)
 	if err != nil {
 		return err
 	}
-	connString, err := secure.Decrypt(encryptedConnString, cfg.Env.EncryptionKey)
+
+	poolMutex.Lock()
+	var replaced *PoolManager
+	if old, exists := poolMap[connID]; exists {
+		remove(old)
+		replaced = old
+	}
+	pool.elem = poolLRU.PushFront(pool)
+	poolMap[connID] = pool
+	touch(pool)
+	evicted := evict(userID, cfg.Env.PoolMaxPerUser, cfg.Env.PoolMaxOpen)
+	poolMutex.Unlock()
+
+	// Closing waits for the queries running on a pool, which may be another user's, so it does not
+	// hold up the activation.
+	if replaced != nil {
+		go closePool(replaced)
+	}
+	go release(cfg.Connections, evicted)
+	return nil
+}
+
+// dial opens a new pool for a connection from its stored record.
+func dial(ctx context.Context, cfg *config.Config, connID, dbType, userID string) (*PoolManager, error) {
+	conn, err := cfg.Connections.GetRecord(connID, userID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	connString, err := secure.Decrypt(conn.ConnString, cfg.Env.EncryptionKey)
+	if err != nil {
+		return nil, err
 	}
 
-	newPool, err := dbdriver.NewDBPool(cfg.DBConfig, connString, dbType)
+	var tlsSettings *schema.TLSSettings
+	if conn.TLS != nil {
+		if tlsSettings, err = tlsconfig.Decrypt(conn.TLS, cfg.Env.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// The tunnel lives as long as the pool, which reaches the database through it.
+	var sshTunnel *tunnel.Tunnel
+	if conn.SSHTunnel != nil {
+		settings, err := tunnel.Decrypt(conn.SSHTunnel, cfg.Env.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if tlsSettings, err = dbdriver.TunnelTLSSettings(tlsSettings, connString, dbType); err != nil {
+			return nil, err
+		}
+		sshTunnel, connString, err = dbdriver.OpenTunnel(ctx, settings, connString, dbType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newPool, err := dbdriver.NewDBPool(ctx, cfg.DBConfig, connString, dbType, conn.PoolSettings, tlsSettings)
 	if err != nil {
-		return err
+		if sshTunnel != nil {
+			sshTunnel.Close()
+		}
+		return nil, err
 	}
+	return &PoolManager{
+		Pool:         newPool,
+		UserID:       userID,
+		DBType:       dbType,
+		QueryTimeout: time.Duration(conn.QueryTimeout) * time.Second,
+		health:       Health{State: HealthHealthy, CheckedAt: time.Now()},
+		queries:      &throughput{},
+		tunnel:       sshTunnel,
+		connID:       connID,
+	}, nil
+}
+
+// StartCleanupRoutine starts a background goroutine to periodically clean up the pools that have been idle for too long.
+func StartCleanupRoutine(cfg *config.Config) {
 	poolMutex.Lock()
-	poolMap[connID] = &PoolManager{
-		Pool:      newPool,
-		ExpiresAt: time.Now().Add(1 * time.Hour),
-		UserID:    userID,
-		DBType:    dbType,
-	}
+	poolCfg = cfg
 	poolMutex.Unlock()
-	return nil
-}
 
-// StartCleanupRoutine starts a background goroutine to periodically clean up expired pools.
-func StartCleanupRoutine(interval time.Duration, client *supabase.Client) {
 	go func() {
-		ticker := time.NewTicker(interval)
+		ticker := time.NewTicker(cfg.Env.CleanupInterval)
 		defer ticker.Stop()
 		for {
 			<-ticker.C
-			CleanupPools(client)
+			CleanupPools(cfg.Connections)
 		}
 	}()
 }
 
-// DeactivateConnection deactivates the connection pool for the given connection ID.
-func DeactivateConnection(connID string) error {
+// DeactivateConnection deactivates the connection pool of a user for the given connection ID.
+func DeactivateConnection(connID, userID string) error {
 	poolMutex.Lock()
-	defer poolMutex.Unlock()
-	if pool, exists := poolMap[connID]; exists {
-		switch p := pool.Pool.(type) {
-		case *pgxpool.Pool:
-			p.Close()
-		case *sql.DB:
-			p.Close()
-		case *mongo.Client:
-			p.Disconnect(context.Background())
-		default:
-			return errors.New("unsupported pool type")
-		}
-		delete(poolMap, connID)
+	pool, exists := poolMap[connID]
+	exists = exists && pool.UserID == userID
+	if exists {
+		remove(pool)
 	}
+	poolMutex.Unlock()
 
+	if exists {
+		return closePool(pool)
+	}
 	return nil
 }
 
-// CleanupPools cleans up expired connection pools.
-func CleanupPools(client *supabase.Client) {
+// CleanupPools closes the pools whose idle TTL has passed and marks their connections inactive.
+func CleanupPools(repo connections.Repository) {
 	poolMutex.Lock()
-	defer poolMutex.Unlock()
-	for connID, pool := range poolMap {
-		if time.Now().After(pool.ExpiresAt) {
-			connections.SetConnectionActive(client, connID, pool.UserID, false)
-			switch p := pool.Pool.(type) {
-			case *pgxpool.Pool:
-				p.Close()
-			case *sql.DB:
-				p.Close()
-			case *mongo.Client:
-				p.Disconnect(context.Background())
-			}
-			delete(poolMap, connID)
+	var expired []*PoolManager
+	now := time.Now()
+	for _, pool := range poolMap {
+		if now.After(pool.ExpiresAt) {
+			remove(pool)
+			expired = append(expired, pool)
 		}
 	}
+	poolMutex.Unlock()
+
+	release(repo, expired)
 }
 
 // ShutdownAllPools closes all active connection pools and sets all connections to inactive.
-func ShutdownAllPools(client *supabase.Client) {
+func ShutdownAllPools(repo connections.Repository) {
 	poolMutex.Lock()
-	defer poolMutex.Unlock()
-	for connID, pool := range poolMap {
-		switch p := pool.Pool.(type) {
-		case *pgxpool.Pool:
-			p.Close()
-		case *sql.DB:
-			p.Close()
-		case *mongo.Client:
-			p.Disconnect(context.Background())
-		}
-		delete(poolMap, connID)
+	var closed []*PoolManager
+	for _, pool := range poolMap {
+		remove(pool)
+		closed = append(closed, pool)
 	}
+	poolMutex.Unlock()
 
-	err := connections.SetAllConnectionsInactive(client)
+	for _, pool := range closed {
+		closePool(pool)
+	}
+	err := repo.SetAllInactive()
 	if err != nil {
 		log.Println("Error setting all connections inactive:", err)
 		return
 	}
-}
\ No newline at end of file
+}
+
+// touch records a use of a pool: its idle TTL starts over and it becomes the most recently used.
+// The caller must hold poolMutex.
+func touch(pool *PoolManager) {
+	idleTTL := defaultIdleTTL
+	if poolCfg != nil && poolCfg.Env.PoolIdleTTL > 0 {
+		idleTTL = poolCfg.Env.PoolIdleTTL
+	}
+	pool.LastUsedAt = time.Now()
+	pool.ExpiresAt = pool.LastUsedAt.Add(idleTTL)
+	poolLRU.MoveToFront(pool.elem)
+}
+
+// remove takes a pool out of the manager without closing it. The caller must hold poolMutex.
+func remove(pool *PoolManager) {
+	poolLRU.Remove(pool.elem)
+	delete(poolMap, pool.connID)
+}
+
+// evict removes the least recently used pools of a user beyond maxPerUser, then the least
+// recently used pools of anyone beyond maxOpen, and returns them. The most recently used pool
+// is kept. A limit of zero or less means no limit. The caller must hold poolMutex.
+func evict(userID string, maxPerUser, maxOpen int) []*PoolManager {
+	var evicted []*PoolManager
+	if maxPerUser > 0 {
+		count := 0
+		for elem := poolLRU.Front(); elem != nil; {
+			next := elem.Next()
+			pool := elem.Value.(*PoolManager)
+			if pool.UserID == userID {
+				count++
+				if count > maxPerUser {
+					remove(pool)
+					evicted = append(evicted, pool)
+				}
+			}
+			elem = next
+		}
+	}
+	if maxOpen > 0 {
+		for poolLRU.Len() > max(maxOpen, 1) {
+			pool := poolLRU.Back().Value.(*PoolManager)
+			remove(pool)
+			evicted = append(evicted, pool)
+		}
+	}
+	return evicted
+}
+
+// release closes pools taken out of the manager and marks their connections inactive.
+func release(repo connections.Repository, pools []*PoolManager) {
+	for _, pool := range pools {
+		closePool(pool)
+		if err := repo.SetActive(pool.connID, pool.UserID, false); err != nil {
+			log.Println("Error marking connection", pool.connID, "inactive:", err)
+		}
+	}
+}
+
+// closePool closes a *pgxpool.Pool, *sql.DB or *mongo.Client, then its SSH tunnel if it has one.
+func closePool(pool *PoolManager) error {
+	if pool.tunnel != nil {
+		defer pool.tunnel.Close()
+	}
+	switch p := pool.Pool.(type) {
+	case *pgxpool.Pool:
+		sql_.ClosePostgresPool(p)
+	case *sql.DB:
+		p.Close()
+	case *mongo.Client:
+		nosql.CloseMongoDBPool(context.Background(), p)
+	default:
+		return errors.New("unsupported pool type")
+	}
+	return nil
+}
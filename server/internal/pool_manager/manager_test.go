@@ -0,0 +1,274 @@
+package poolmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	"github.com/cprakhar/datawhiz/internal/database/connections"
+	"github.com/cprakhar/datawhiz/internal/database/schema"
+	"github.com/cprakhar/datawhiz/utils/secure"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef"
+
+// fakeRepository serves SQLite connections owned by the user in their ID, such as "alice/1".
+type fakeRepository struct {
+	connections.Repository
+	dir      string
+	lookups  atomic.Int32
+	inactive sync.Map      // connection ID -> true
+	released chan struct{} // if set, marking a connection inactive waits until it is closed
+}
+
+func (r *fakeRepository) GetRecord(id, userID string) (*schema.Connection, error) {
+	r.lookups.Add(1)
+	if filepath.Dir(id) != userID {
+		return nil, errors.New("connection not found")
+	}
+	// Give concurrent activations time to pile up.
+	time.Sleep(20 * time.Millisecond)
+	connString, err := secure.Encrypt(filepath.Join(r.dir, filepath.Base(id)+".db"), testKey)
+	if err != nil {
+		return nil, err
+	}
+	return &schema.Connection{ID: id, UserID: userID, DBType: "sqlite", ConnString: connString}, nil
+}
+
+func (r *fakeRepository) SetActive(id, userID string, isActive bool) error {
+	if !isActive {
+		if r.released != nil {
+			<-r.released
+		}
+		r.inactive.Store(id, true)
+	}
+	return nil
+}
+
+func (r *fakeRepository) SetAllInactive() error {
+	return nil
+}
+
+func newTestConfig(t *testing.T, maxPerUser, maxOpen int) (*config.Config, *fakeRepository) {
+	t.Helper()
+	repo := &fakeRepository{dir: t.TempDir()}
+	cfg := &config.Config{
+		Env: &config.Env{
+			EncryptionKey:  testKey,
+			PoolIdleTTL:    time.Hour,
+			PoolMaxPerUser: maxPerUser,
+			PoolMaxOpen:    maxOpen,
+		},
+		Store:    &config.Store{Connections: repo},
+		DBConfig: &config.DBConfig{MaxOpenConns: 2, MaxIdleConns: 2},
+	}
+	t.Cleanup(func() { ShutdownAllPools(repo) })
+	return cfg, repo
+}
+
+func activateAll(t *testing.T, cfg *config.Config, connID, userID string, n int) []error {
+	t.Helper()
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = ActivateConnection(context.Background(), cfg, connID, "sqlite", userID)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// eventually reports whether cond holds within a few seconds, for what is done in the background.
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestActivateConnectionDialsOnce(t *testing.T) {
+	cfg, repo := newTestConfig(t, 0, 0)
+
+	for i, err := range activateAll(t, cfg, "alice/1", "alice", 32) {
+		if err != nil {
+			t.Fatalf("activation %d: %v", i, err)
+		}
+	}
+	if n := repo.lookups.Load(); n != 1 {
+		t.Errorf("connection looked up %d times, want 1", n)
+	}
+	if _, err := GetPool("alice/1", "alice"); err != nil {
+		t.Errorf("GetPool after activation: %v", err)
+	}
+}
+
+func TestActivationOutlivesFirstCaller(t *testing.T) {
+	cfg, _ := newTestConfig(t, 0, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() { first <- ActivateConnection(ctx, cfg, "alice/1", "sqlite", "alice") }()
+	// The second activation joins the dial of the first, whose client then goes away.
+	time.Sleep(5 * time.Millisecond)
+	second := make(chan error, 1)
+	go func() { second <- ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "alice") }()
+	cancel()
+
+	if err := <-second; err != nil {
+		t.Fatalf("waiting activation: %v", err)
+	}
+	<-first
+	if _, err := GetPool("alice/1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActivateConnectionClosesReplacedPool(t *testing.T) {
+	cfg, _ := newTestConfig(t, 0, 0)
+
+	if err := ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	poolMutex.Lock()
+	old := poolMap["alice/1"]
+	old.ExpiresAt = time.Now().Add(-time.Second)
+	poolMutex.Unlock()
+
+	for i, err := range activateAll(t, cfg, "alice/1", "alice", 8) {
+		if err != nil {
+			t.Fatalf("activation %d: %v", i, err)
+		}
+	}
+	pool, err := GetPool("alice/1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool == old {
+		t.Fatal("expired pool was not replaced")
+	}
+	if !eventually(func() bool { return old.Pool.(*sql.DB).Ping() != nil }) {
+		t.Error("replaced pool is still open")
+	}
+	if err := pool.Pool.(*sql.DB).Ping(); err != nil {
+		t.Errorf("new pool: %v", err)
+	}
+}
+
+func TestPoolOwnership(t *testing.T) {
+	cfg, _ := newTestConfig(t, 0, 0)
+
+	errs := make(chan error, 2)
+	go func() { errs <- ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "alice") }()
+	go func() { errs <- ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "mallory") }()
+	var failed int
+	for range 2 {
+		if <-errs != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("%d of the concurrent activations by the owner and another user failed, want 1", failed)
+	}
+
+	if _, err := GetPool("alice/1", "mallory"); err == nil {
+		t.Error("GetPool returned the pool of another user")
+	}
+	if err := ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "mallory"); err == nil {
+		t.Error("another user activated an open connection")
+	}
+	DeactivateConnection("alice/1", "mallory")
+	if _, err := GetPool("alice/1", "alice"); err != nil {
+		t.Errorf("GetPool by the owner: %v", err)
+	}
+}
+
+func TestEvictionMarksConnectionsInactive(t *testing.T) {
+	cfg, repo := newTestConfig(t, 2, 3)
+
+	for _, connID := range []string{"alice/1", "alice/2", "bob/1", "alice/3", "bob/2"} {
+		if err := ActivateConnection(context.Background(), cfg, connID, "sqlite", filepath.Dir(connID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// alice/1 goes over alice's cap, then alice/2, the least recently used, over the global one.
+	for _, connID := range []string{"alice/1", "alice/2"} {
+		if _, err := GetPool(connID, filepath.Dir(connID)); err == nil {
+			t.Errorf("%s is still open", connID)
+		}
+		if !eventually(func() bool { _, ok := repo.inactive.Load(connID); return ok }) {
+			t.Errorf("%s was not marked inactive", connID)
+		}
+	}
+	for _, connID := range []string{"alice/3", "bob/1", "bob/2"} {
+		if _, err := GetPool(connID, filepath.Dir(connID)); err != nil {
+			t.Errorf("%s: %v", connID, err)
+		}
+	}
+}
+
+func TestEvictionDoesNotWaitForRelease(t *testing.T) {
+	cfg, repo := newTestConfig(t, 1, 0)
+
+	if err := ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// A slow release stands for a pool that waits for the queries running on it to close.
+	repo.released = make(chan struct{})
+
+	done := make(chan error, 1)
+	go func() { done <- ActivateConnection(context.Background(), cfg, "alice/2", "sqlite", "alice") }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("activation waited for the evicted pool to be released")
+	}
+
+	close(repo.released)
+	if !eventually(func() bool { _, ok := repo.inactive.Load("alice/1"); return ok }) {
+		t.Error("evicted pool was not released")
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	cfg, repo := newTestConfig(t, 2, 4)
+
+	var wg sync.WaitGroup
+	for _, user := range []string{"alice", "bob", "carol"} {
+		for _, n := range []string{"1", "2", "3"} {
+			connID := user + "/" + n
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					ActivateConnection(context.Background(), cfg, connID, "sqlite", user)
+					if pool, err := GetPool(connID, user); err == nil {
+						pool.Pool.(*sql.DB).Ping()
+					}
+					CleanupPools(repo)
+				}
+				DeactivateConnection(connID, user)
+			}()
+		}
+	}
+	wg.Wait()
+
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+	if len(poolMap) != poolLRU.Len() {
+		t.Errorf("%d pools in the map but %d in the LRU list", len(poolMap), poolLRU.Len())
+	}
+}
@@ -0,0 +1,191 @@
+package poolmanager
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/nosql"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// throughputMinutes is how far back, in minutes, the queries run on a pool are counted.
+const throughputMinutes = 15
+
+// throughputWindows are the spans, in minutes, over which the throughput of a pool is reported.
+var throughputWindows = []int{1, 5, throughputMinutes}
+
+// Stats describes a pool, how much it is used and how long it stays open.
+type Stats struct {
+	DBType           string       `json:"dbType"`
+	Pool             interface{}  `json:"pool"` // PgxPoolStats, SQLPoolStats or nosql.MongoPoolStats
+	Health           Health       `json:"health"`
+	LastUsedAt       time.Time    `json:"lastUsedAt"`
+	ExpiresAt        time.Time    `json:"expiresAt"`
+	ExpiresInSeconds int64        `json:"expiresInSeconds"`
+	Throughput       []Throughput `json:"throughput"`
+}
+
+// PgxPoolStats is the Stat of a *pgxpool.Pool.
+type PgxPoolStats struct {
+	MaxConns                int32   `json:"maxConns"`
+	TotalConns              int32   `json:"totalConns"`
+	AcquiredConns           int32   `json:"acquiredConns"`
+	IdleConns               int32   `json:"idleConns"`
+	ConstructingConns       int32   `json:"constructingConns"`
+	AcquireCount            int64   `json:"acquireCount"`
+	EmptyAcquireCount       int64   `json:"emptyAcquireCount"`
+	CanceledAcquireCount    int64   `json:"canceledAcquireCount"`
+	AcquireDurationMs       float64 `json:"acquireDurationMs"`
+	EmptyAcquireWaitTimeMs  float64 `json:"emptyAcquireWaitTimeMs"`
+	NewConnsCount           int64   `json:"newConnsCount"`
+	MaxLifetimeDestroyCount int64   `json:"maxLifetimeDestroyCount"`
+	MaxIdleDestroyCount     int64   `json:"maxIdleDestroyCount"`
+}
+
+// SQLPoolStats is the Stats of a *sql.DB.
+type SQLPoolStats struct {
+	MaxOpenConnections int     `json:"maxOpenConnections"`
+	OpenConnections    int     `json:"openConnections"`
+	InUse              int     `json:"inUse"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"waitCount"`
+	WaitDurationMs     float64 `json:"waitDurationMs"`
+	MaxIdleClosed      int64   `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64   `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64   `json:"maxLifetimeClosed"`
+}
+
+// Throughput sums up the queries run on a pool over the last minutes.
+type Throughput struct {
+	Minutes          int     `json:"minutes"`
+	Queries          int64   `json:"queries"`
+	Failed           int64   `json:"failed"`
+	QueriesPerMinute float64 `json:"queriesPerMinute"`
+	AvgDurationMs    float64 `json:"avgDurationMs"`
+}
+
+// throughput counts the queries run on a pool in buckets of one minute.
+type throughput struct {
+	mu      sync.Mutex
+	buckets [throughputMinutes]struct {
+		minute     int64 // Unix minute the bucket counts
+		queries    int64
+		failed     int64
+		durationMs int64
+	}
+}
+
+func (t *throughput) add(at time.Time, duration time.Duration, failed bool) {
+	minute := at.Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := &t.buckets[minute%throughputMinutes]
+	if bucket.minute != minute {
+		bucket.minute, bucket.queries, bucket.failed, bucket.durationMs = minute, 0, 0, 0
+	}
+	bucket.queries++
+	if failed {
+		bucket.failed++
+	}
+	bucket.durationMs += duration.Milliseconds()
+}
+
+func (t *throughput) windows(now time.Time) []Throughput {
+	minute := now.Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	windows := make([]Throughput, 0, len(throughputWindows))
+	for _, minutes := range throughputWindows {
+		window := Throughput{Minutes: minutes}
+		var durationMs int64
+		for _, bucket := range t.buckets {
+			if bucket.minute > minute-int64(minutes) && bucket.minute <= minute {
+				window.Queries += bucket.queries
+				window.Failed += bucket.failed
+				durationMs += bucket.durationMs
+			}
+		}
+		window.QueriesPerMinute = float64(window.Queries) / float64(minutes)
+		if window.Queries > 0 {
+			window.AvgDurationMs = float64(durationMs) / float64(window.Queries)
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// RecordQuery counts a query run on the pool of a user for a connection towards its throughput.
+// It does nothing if the pool has been closed meanwhile.
+func RecordQuery(connID, userID string, duration time.Duration, failed bool) {
+	poolMutex.Lock()
+	pool, exists := poolMap[connID]
+	poolMutex.Unlock()
+
+	if exists && pool.UserID == userID {
+		pool.queries.add(time.Now(), duration, failed)
+	}
+}
+
+// GetStats returns the statistics of the pool of a user for a connection.
+func GetStats(connID, userID string) (*Stats, error) {
+	poolMutex.Lock()
+	pool, exists := poolMap[connID]
+	if !exists || pool.UserID != userID {
+		poolMutex.Unlock()
+		return nil, errors.New("connection pool not found or expired")
+	}
+	stats := &Stats{
+		DBType:     pool.DBType,
+		Health:     pool.health,
+		LastUsedAt: pool.LastUsedAt,
+		ExpiresAt:  pool.ExpiresAt,
+	}
+	poolMutex.Unlock()
+
+	now := time.Now()
+	stats.ExpiresInSeconds = max(int64(stats.ExpiresAt.Sub(now).Seconds()), 0)
+	stats.Throughput = pool.queries.windows(now)
+
+	switch p := pool.Pool.(type) {
+	case *pgxpool.Pool:
+		stat := p.Stat()
+		stats.Pool = PgxPoolStats{
+			MaxConns:                stat.MaxConns(),
+			TotalConns:              stat.TotalConns(),
+			AcquiredConns:           stat.AcquiredConns(),
+			IdleConns:               stat.IdleConns(),
+			ConstructingConns:       stat.ConstructingConns(),
+			AcquireCount:            stat.AcquireCount(),
+			EmptyAcquireCount:       stat.EmptyAcquireCount(),
+			CanceledAcquireCount:    stat.CanceledAcquireCount(),
+			AcquireDurationMs:       float64(stat.AcquireDuration()) / float64(time.Millisecond),
+			EmptyAcquireWaitTimeMs:  float64(stat.EmptyAcquireWaitTime()) / float64(time.Millisecond),
+			NewConnsCount:           stat.NewConnsCount(),
+			MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+			MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+		}
+	case *sql.DB:
+		stat := p.Stats()
+		stats.Pool = SQLPoolStats{
+			MaxOpenConnections: stat.MaxOpenConnections,
+			OpenConnections:    stat.OpenConnections,
+			InUse:              stat.InUse,
+			Idle:               stat.Idle,
+			WaitCount:          stat.WaitCount,
+			WaitDurationMs:     float64(stat.WaitDuration) / float64(time.Millisecond),
+			MaxIdleClosed:      stat.MaxIdleClosed,
+			MaxIdleTimeClosed:  stat.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stat.MaxLifetimeClosed,
+		}
+	case *mongo.Client:
+		if stat, ok := nosql.GetMongoPoolStats(p); ok {
+			stats.Pool = stat
+		}
+	}
+	return stats, nil
+}
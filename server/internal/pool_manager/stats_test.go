@@ -0,0 +1,51 @@
+package poolmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetStats(t *testing.T) {
+	cfg, _ := newTestConfig(t, 0, 0)
+
+	if err := ActivateConnection(context.Background(), cfg, "alice/1", "sqlite", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	RecordQuery("alice/1", "alice", 30*time.Millisecond, false)
+	RecordQuery("alice/1", "alice", 10*time.Millisecond, true)
+	RecordQuery("alice/1", "mallory", time.Second, false)
+
+	if _, err := GetStats("alice/1", "mallory"); err == nil {
+		t.Error("GetStats returned the pool of another user")
+	}
+	stats, err := GetStats("alice/1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats.Pool.(SQLPoolStats); !ok {
+		t.Errorf("pool statistics are %T, want SQLPoolStats", stats.Pool)
+	}
+	if stats.ExpiresInSeconds <= 0 || stats.ExpiresInSeconds > int64(time.Hour.Seconds()) {
+		t.Errorf("expires in %d seconds", stats.ExpiresInSeconds)
+	}
+	last := stats.Throughput[len(stats.Throughput)-1]
+	if last.Queries != 2 || last.Failed != 1 || last.AvgDurationMs != 20 {
+		t.Errorf("throughput over %d minutes: %+v", last.Minutes, last)
+	}
+}
+
+func TestThroughputForgetsOldMinutes(t *testing.T) {
+	var queries throughput
+	now := time.Now()
+	queries.add(now.Add(-20*time.Minute), time.Second, false)
+	queries.add(now.Add(-3*time.Minute), time.Second, false)
+	queries.add(now, time.Second, false)
+
+	for _, window := range queries.windows(now) {
+		want := map[int]int64{1: 1, 5: 2, 15: 2}[window.Minutes]
+		if window.Queries != want {
+			t.Errorf("%d queries over %d minutes, want %d", window.Queries, window.Minutes, want)
+		}
+	}
+}
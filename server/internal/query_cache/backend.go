@@ -0,0 +1,114 @@
+package querycache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+// memoryBackend keeps the rows in the entries themselves.
+type memoryBackend struct{}
+
+func (memoryBackend) store(entry *Entry, rows [][]interface{}) error {
+	entry.rows = rows
+	return nil
+}
+
+func (memoryBackend) open(entry *Entry) (result.Rows, error) {
+	return result.NewSliceRows(entry.Columns, entry.rows), nil
+}
+
+func (memoryBackend) remove(entry *Entry) {}
+
+// diskBackend writes the rows of each entry to its own file in the cache directory, one JSON
+// array per line.
+type diskBackend struct {
+	dir string
+}
+
+func (b diskBackend) store(entry *Entry, rows [][]interface{}) error {
+	file, err := os.CreateTemp(b.dir, entry.Key+".*.ndjson")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, row := range rows {
+		if err = encoder.Encode(row); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	entry.path = file.Name()
+	return nil
+}
+
+func (b diskBackend) open(entry *Entry) (result.Rows, error) {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &diskRows{file: file, scanner: scanner, columns: entry.Columns, remaining: entry.RowCount}, nil
+}
+
+func (b diskBackend) remove(entry *Entry) {
+	os.Remove(entry.path)
+}
+
+// diskRows reads the rows of a cached result set back from its file. Values come back as they
+// were encoded when the query ran, with binary columns decoded from base64.
+type diskRows struct {
+	file      *os.File
+	scanner   *bufio.Scanner
+	columns   []result.Column
+	remaining int64
+	err       error
+}
+
+func (r *diskRows) Columns() []result.Column { return r.columns }
+
+func (r *diskRows) Next() bool {
+	if r.remaining <= 0 || !r.scanner.Scan() {
+		r.err = r.scanner.Err()
+		return false
+	}
+	r.remaining--
+	return true
+}
+
+func (r *diskRows) Values() ([]interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(r.scanner.Bytes(), &raw); err != nil {
+		return nil, err
+	}
+	if len(raw) != len(r.columns) {
+		return nil, errors.New("cached row does not match the cached columns")
+	}
+	values := make([]interface{}, len(raw))
+	for i, value := range raw {
+		v, err := result.DecodeJSON(r.columns[i].Kind, value)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (r *diskRows) Err() error { return r.err }
+
+func (r *diskRows) Close() { r.file.Close() }
@@ -0,0 +1,280 @@
+package querycache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendDisk   = "disk"
+	BackendNone   = "none" // caching is turned off
+)
+
+// Entry describes a cached result set.
+type Entry struct {
+	Key       string          `json:"key"`
+	ConnID    string          `json:"conn_id"`
+	Columns   []result.Column `json:"columns"`
+	RowCount  int64           `json:"row_count"`
+	Size      int64           `json:"size"` // bytes of the rows encoded as JSON
+	CachedAt  time.Time       `json:"cached_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+
+	rows [][]interface{} // memory
+	path string          // disk
+}
+
+// Age is how long ago the result set was cached.
+func (e *Entry) Age() time.Duration {
+	return time.Since(e.CachedAt)
+}
+
+// backend keeps the rows of the entries.
+type backend interface {
+	store(entry *Entry, rows [][]interface{}) error
+	open(entry *Entry) (result.Rows, error)
+	remove(entry *Entry)
+}
+
+var (
+	cacheMap     = make(map[string]*list.Element) // key: cache key, value: *Entry
+	cacheLRU     = list.New()                     // most recently used first
+	cacheBytes   int64
+	cacheMutex   sync.Mutex // Mutex to protect access to cacheMap, cacheLRU and cacheBytes
+	cacheBackend backend
+	cacheEnv     *config.Env
+)
+
+// Init sets up the backend chosen by the configuration and starts the routine that drops
+// expired entries. Results cached on disk by a previous run are removed.
+func Init(cfg *config.Config) error {
+	cacheEnv = cfg.Env
+	switch cfg.Env.QueryCacheBackend {
+	case BackendMemory:
+		cacheBackend = memoryBackend{}
+	case BackendDisk:
+		if err := os.MkdirAll(cfg.Env.QueryCacheDir, 0o700); err != nil {
+			return err
+		}
+		stale, _ := filepath.Glob(filepath.Join(cfg.Env.QueryCacheDir, "*.ndjson"))
+		for _, path := range stale {
+			os.Remove(path)
+		}
+		cacheBackend = diskBackend{dir: cfg.Env.QueryCacheDir}
+	case BackendNone, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown query cache backend %q", cfg.Env.QueryCacheBackend)
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Env.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			Cleanup()
+		}
+	}()
+	return nil
+}
+
+// Enabled reports whether results can be cached.
+func Enabled() bool {
+	return cacheBackend != nil
+}
+
+// Key returns the cache key of a statement run on a database of a connection with the given
+// arguments, and whether the statement may be cached at all.
+func Key(connID, dbName, dbType, query string, args []interface{}) (string, bool) {
+	normalized, cacheable := Normalize(query, dbType)
+	if !cacheable {
+		return "", false
+	}
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.New()
+	for _, part := range []string{connID, dbName, normalized, string(encodedArgs)} {
+		// Each part is length-prefixed so that no two different tuples share an encoding.
+		fmt.Fprintf(sum, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), true
+}
+
+// Get returns the cached entry for a key with a cursor over its rows, if it has not expired.
+func Get(key string) (*Entry, result.Rows, bool) {
+	if !Enabled() {
+		return nil, nil, false
+	}
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	elem, ok := cacheMap[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := elem.Value.(*Entry)
+	if time.Now().After(entry.ExpiresAt) {
+		evict(elem)
+		return nil, nil, false
+	}
+	// The rows are opened while holding the lock, so an eviction cannot remove them first.
+	rows, err := cacheBackend.open(entry)
+	if err != nil {
+		evict(elem)
+		return nil, nil, false
+	}
+	cacheLRU.MoveToFront(elem)
+	snapshot := *entry
+	return &snapshot, rows, true
+}
+
+// Put caches the rows read through a recorder for ttl, or the default TTL when ttl is not
+// positive. Result sets that were not read to the end or that are too large are skipped.
+func Put(key, connID string, rec *Recorder, ttl time.Duration) {
+	if !Enabled() || !rec.complete || rec.overflow || rec.size > cacheEnv.QueryCacheMaxBytes {
+		return
+	}
+	if ttl <= 0 {
+		ttl = cacheEnv.QueryCacheTTL
+	}
+	if maxTTL := cacheEnv.QueryCacheMaxTTL; maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	now := time.Now()
+	entry := &Entry{
+		Key:       key,
+		ConnID:    connID,
+		Columns:   rec.Columns(),
+		RowCount:  int64(len(rec.rows)),
+		Size:      rec.size,
+		CachedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := cacheBackend.store(entry, rec.rows); err != nil {
+		return
+	}
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if elem, ok := cacheMap[key]; ok {
+		evict(elem)
+	}
+	cacheMap[key] = cacheLRU.PushFront(entry)
+	cacheBytes += entry.Size
+	for cacheLRU.Len() > 1 && (cacheBytes > cacheEnv.QueryCacheMaxBytes || cacheLRU.Len() > cacheEnv.QueryCacheMaxEntries) {
+		evict(cacheLRU.Back())
+	}
+}
+
+// InvalidateConnection drops the cached results of a connection and returns how many there were.
+func InvalidateConnection(connID string) int {
+	if !Enabled() {
+		return 0
+	}
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	var count int
+	for elem := cacheLRU.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*Entry).ConnID == connID {
+			evict(elem)
+			count++
+		}
+		elem = next
+	}
+	return count
+}
+
+// Cleanup drops the entries whose TTL has passed.
+func Cleanup() {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	now := time.Now()
+	for elem := cacheLRU.Front(); elem != nil; {
+		next := elem.Next()
+		if now.After(elem.Value.(*Entry).ExpiresAt) {
+			evict(elem)
+		}
+		elem = next
+	}
+}
+
+// evict drops an entry. The caller must hold cacheMutex.
+func evict(elem *list.Element) {
+	entry := cacheLRU.Remove(elem).(*Entry)
+	delete(cacheMap, entry.Key)
+	cacheBytes -= entry.Size
+	cacheBackend.remove(entry)
+}
+
+// Recorder wraps a result set to keep a copy of the rows read from it, so that they can be
+// cached once the result set has been written out.
+type Recorder struct {
+	result.Rows
+	rows     [][]interface{}
+	size     int64
+	complete bool // every row was read
+	overflow bool // the rows are larger than an entry may be
+}
+
+// Capture starts recording the rows read from a result set.
+func Capture(rows result.Rows) *Recorder {
+	return &Recorder{Rows: rows}
+}
+
+func (r *Recorder) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.complete = r.Rows.Err() == nil
+	return false
+}
+
+func (r *Recorder) Values() ([]interface{}, error) {
+	values, err := r.Rows.Values()
+	if err != nil || r.overflow {
+		return values, err
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		r.overflow = true
+		r.rows = nil
+		return values, nil
+	}
+	r.size += int64(len(encoded)) + 1
+	if r.size > cacheEnv.QueryCacheMaxEntryBytes {
+		r.overflow = true
+		r.rows = nil
+		return values, nil
+	}
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		if b, ok := value.([]byte); ok {
+			value = append([]byte(nil), b...)
+		}
+		row[i] = value
+	}
+	r.rows = append(r.rows, row)
+	return values, nil
+}
+
+// Cacheable reports whether the whole result set was read and is small enough to be cached.
+func (r *Recorder) Cacheable() bool {
+	return r.complete && !r.overflow
+}
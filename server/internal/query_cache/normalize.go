@@ -0,0 +1,58 @@
+package querycache
+
+import (
+	"strings"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/sqltext"
+)
+
+// writeWords are the words that make a statement unsafe to serve from cache, because it writes,
+// takes locks or advances a sequence. They are only looked for outside literals and comments.
+var writeWords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true, "upsert": true, "replace": true,
+	"into": true, "lock": true, "share": true, "nowait": true, "nextval": true, "setval": true,
+	"call": true, "exec": true, "execute": true, "copy": true, "load": true,
+}
+
+// readWords are the words a cacheable statement may start with.
+var readWords = map[string]bool{"select": true, "with": true, "table": true, "values": true}
+
+// Normalize rewrites a statement into a canonical form so that equivalent spellings share a
+// cache entry: comments are removed, runs of whitespace become a single space and trailing
+// semicolons are dropped. Literals and quoted identifiers are kept as they are. It also reports
+// whether the statement is a single read-only query that may be cached.
+func Normalize(query, dbType string) (string, bool) {
+	var sb strings.Builder
+	var words []string
+	separated := false // a semicolon ended the statement
+	multiple := false  // something follows the first statement
+
+	for _, token := range sqltext.Scan(query, dbType) {
+		if token.Kind == sqltext.Semicolon {
+			separated = sb.Len() > 0
+			continue
+		}
+		switch {
+		case separated && !multiple:
+			multiple = true
+			sb.WriteByte(';')
+		case token.Space && sb.Len() > 0:
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(token.Text)
+		if token.Kind == sqltext.Word {
+			words = append(words, token.Lower())
+		}
+	}
+
+	normalized := sb.String()
+	if multiple || len(words) == 0 || !readWords[words[0]] {
+		return normalized, false
+	}
+	for _, word := range words {
+		if writeWords[word] {
+			return normalized, false
+		}
+	}
+	return normalized, true
+}
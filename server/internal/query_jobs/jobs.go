@@ -0,0 +1,447 @@
+package queryjobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	queryhistory "github.com/cprakhar/datawhiz/internal/database/query_history"
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/executions"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+var (
+	ErrNotFound    = errors.New("job not found")
+	ErrQueueFull   = errors.New("job queue is full, try again later")
+	ErrNotFinished = errors.New("job has not finished successfully")
+)
+
+// Job is an asynchronous query execution whose results are spooled to local disk.
+type Job struct {
+	ID             string          `json:"id"`
+	ConnID         string          `json:"conn_id"`
+	UserID         string          `json:"-"`
+	DBName         string          `json:"db_name,omitempty"`
+	Query          string          `json:"query"`
+	GeneratedQuery string          `json:"generated_query"`
+	Args           []interface{}   `json:"-"` // values of the placeholders of the query
+	DBType         string          `json:"-"`
+	Timeout        time.Duration   `json:"-"`
+	Status         Status          `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	Columns        []result.Column `json:"columns,omitempty"`
+	RowCount       int64           `json:"row_count"`
+	Truncated      bool            `json:"truncated"`
+	CreatedAt      time.Time       `json:"created_at"`
+	StartedAt      *time.Time      `json:"started_at,omitempty"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty"`
+	ExpiresAt      *time.Time      `json:"expires_at,omitempty"`
+	Duration       int64           `json:"duration"`
+
+	resultPath      string
+	cancelRequested bool // set by CancelJob, guarded by jobMutex
+}
+
+var (
+	jobMap   = make(map[string]*Job) // key: user ID + "/" + job ID
+	jobMutex sync.RWMutex            // Mutex to protect access to jobMap and the jobs in it
+	jobQueue chan *Job
+	jobCfg   *config.Config
+)
+
+// jobKey scopes the client-supplied ID of a job to its user, as executions are.
+func jobKey(id, userID string) string {
+	return userID + "/" + id
+}
+
+// StartWorkers starts the bounded worker pool and the routine that expires finished jobs.
+func StartWorkers(cfg *config.Config) error {
+	if err := os.MkdirAll(cfg.Env.JobSpoolDir, 0o700); err != nil {
+		return err
+	}
+	// Spool files from a previous run are unreachable since jobs live in memory.
+	stale, _ := filepath.Glob(filepath.Join(cfg.Env.JobSpoolDir, "*.ndjson"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+
+	jobCfg = cfg
+	jobQueue = make(chan *Job, cfg.Env.JobQueueSize)
+	for range cfg.Env.JobWorkers {
+		go worker()
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Env.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			CleanupJobs()
+		}
+	}()
+	return nil
+}
+
+// Submit queues a job for execution.
+func Submit(job *Job) error {
+	job.Status = StatusQueued
+	job.CreatedAt = time.Now()
+	// The ID comes from the client, so it does not name the spool file.
+	job.resultPath = filepath.Join(jobCfg.Env.JobSpoolDir, uuid.NewString()+".ndjson")
+
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	key := jobKey(job.ID, job.UserID)
+	if _, exists := jobMap[key]; exists {
+		return errors.New("job ID already in use")
+	}
+
+	select {
+	case jobQueue <- job:
+		jobMap[key] = job
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// GetJob returns a snapshot of the job with the given ID owned by the given user.
+func GetJob(jobID, userID string) (*Job, error) {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	job, exists := jobMap[jobKey(jobID, userID)]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// ListJobs returns snapshots of the jobs for a connection owned by the given user.
+func ListJobs(connID, userID string) []Job {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	var result []Job
+	for _, job := range jobMap {
+		if job.ConnID == connID && job.UserID == userID {
+			result = append(result, *job)
+		}
+	}
+	return result
+}
+
+// CancelJob cancels a queued or running job.
+func CancelJob(jobID, userID string) error {
+	jobMutex.Lock()
+	job, exists := jobMap[jobKey(jobID, userID)]
+	if !exists {
+		jobMutex.Unlock()
+		return ErrNotFound
+	}
+	status := job.Status
+	switch status {
+	case StatusQueued:
+		finish(job, StatusCancelled, "")
+	case StatusRunning:
+		job.cancelRequested = true
+	}
+	jobMutex.Unlock()
+
+	if status == StatusRunning {
+		return cancelExecution(job)
+	}
+	return nil
+}
+
+// cancelExecution cancels the execution of a running job. A job that has not registered its
+// execution yet sees cancelRequested once it does, and one that has finished needs no cancelling.
+func cancelExecution(job *Job) error {
+	if err := executions.Cancel(job.ID, job.UserID); err != nil && !errors.Is(err, executions.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// ResultPath returns the spool file of a job that finished successfully.
+func ResultPath(job *Job) (string, error) {
+	if job.Status != StatusSucceeded {
+		return "", ErrNotFinished
+	}
+	return job.resultPath, nil
+}
+
+// ReadResultPage reads a page of rows from the spool file of a job that finished successfully.
+// Each row is returned as the raw JSON array it was spooled as.
+func ReadResultPage(job *Job, offset, limit int64) ([]json.RawMessage, error) {
+	path, err := ResultPath(job)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows := []json.RawMessage{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	// The first line is the header and the line after the last row is the trailer.
+	for line := int64(-1); scanner.Scan() && int64(len(rows)) < limit && line < job.RowCount; line++ {
+		if line < offset {
+			continue
+		}
+		rows = append(rows, json.RawMessage(append([]byte(nil), scanner.Bytes()...)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// OpenResult opens the spool file of a job that finished successfully as a result set, so
+// that it can be written out in another format. Values come back as they were encoded when
+// the job ran, with binary columns decoded from base64.
+func OpenResult(job *Job) (result.Rows, error) {
+	path, err := ResultPath(job)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &spooledRows{file: file, scanner: scanner, columns: job.Columns, remaining: job.RowCount}, nil
+}
+
+// spooledRows reads the rows of a spool file back. The header line is skipped and reading
+// stops before the trailer line.
+type spooledRows struct {
+	file      *os.File
+	scanner   *bufio.Scanner
+	columns   []result.Column
+	remaining int64
+	started   bool
+	err       error
+}
+
+func (r *spooledRows) Columns() []result.Column { return r.columns }
+
+func (r *spooledRows) Next() bool {
+	if !r.started {
+		r.started = true
+		if !r.scanner.Scan() {
+			r.err = r.scanner.Err()
+			return false
+		}
+	}
+	if r.remaining <= 0 || !r.scanner.Scan() {
+		r.err = r.scanner.Err()
+		return false
+	}
+	r.remaining--
+	return true
+}
+
+func (r *spooledRows) Values() ([]interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(r.scanner.Bytes(), &raw); err != nil {
+		return nil, err
+	}
+	if len(raw) != len(r.columns) {
+		return nil, errors.New("spooled row does not match the job columns")
+	}
+	values := make([]interface{}, len(raw))
+	for i, value := range raw {
+		v, err := result.DecodeJSON(r.columns[i].Kind, value)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (r *spooledRows) Err() error { return r.err }
+
+func (r *spooledRows) Close() { r.file.Close() }
+
+// CleanupJobs removes finished jobs and their spool files once their retention window has passed.
+func CleanupJobs() {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	for key, job := range jobMap {
+		if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+			os.Remove(job.resultPath)
+			delete(jobMap, key)
+		}
+	}
+}
+
+// ShutdownJobs cancels every queued and running job.
+func ShutdownJobs() {
+	jobMutex.Lock()
+	var running []*Job
+	for _, job := range jobMap {
+		switch job.Status {
+		case StatusQueued:
+			finish(job, StatusCancelled, "server shutting down")
+		case StatusRunning:
+			job.cancelRequested = true
+			running = append(running, job)
+		}
+	}
+	jobMutex.Unlock()
+
+	for _, job := range running {
+		cancelExecution(job)
+	}
+}
+
+func worker() {
+	for job := range jobQueue {
+		run(job)
+	}
+}
+
+// run executes a job and spools its results to disk. The job runs detached from
+// any HTTP request so it survives client disconnects.
+func run(job *Job) {
+	jobMutex.Lock()
+	if job.Status != StatusQueued {
+		jobMutex.Unlock()
+		return
+	}
+	startedAt := time.Now()
+	job.Status = StatusRunning
+	job.StartedAt = &startedAt
+	jobMutex.Unlock()
+
+	columns, summary, err := execute(job)
+
+	jobMutex.Lock()
+	job.Columns = columns
+	job.RowCount = summary.RowCount
+	job.Truncated = summary.Truncated
+	job.Duration = time.Since(startedAt).Milliseconds()
+	switch {
+	case err == nil:
+		finish(job, StatusSucceeded, "")
+	case errors.Is(err, context.Canceled):
+		finish(job, StatusCancelled, err.Error())
+	default:
+		finish(job, StatusFailed, err.Error())
+	}
+	history := &queryhistory.QueryHistory{
+		UserID:         job.UserID,
+		ConnectionID:   job.ConnID,
+		Query:          job.Query,
+		GeneratedQuery: job.GeneratedQuery,
+		ExecutedAt:     startedAt,
+		Duration:       job.Duration,
+		Success:        err == nil,
+		Error:          job.Error,
+	}
+	jobMutex.Unlock()
+
+	poolmanager.RecordQuery(history.ConnectionID, history.UserID, time.Duration(history.Duration)*time.Millisecond, err != nil)
+	if err := queryhistory.Record(jobCfg.History, history, job.DBType); err != nil {
+		log.Println("Error saving query history for job", job.ID+":", err)
+	}
+}
+
+func execute(job *Job) ([]result.Column, result.Summary, error) {
+	poolMgr, err := poolmanager.GetPool(job.ConnID, job.UserID)
+	if err != nil {
+		return nil, result.Summary{}, err
+	}
+
+	execCtx, exec, err := executions.Start(context.Background(), job.ID, job.ConnID, job.UserID, job.Timeout)
+	if err != nil {
+		return nil, result.Summary{}, err
+	}
+	defer exec.Finish()
+
+	// Checked once the execution is registered, so that a cancellation either is seen here or
+	// finds the execution to cancel.
+	jobMutex.RLock()
+	cancelRequested := job.cancelRequested
+	jobMutex.RUnlock()
+	if cancelRequested {
+		return nil, result.Summary{}, context.Canceled
+	}
+
+	rows, err := dbdriver.RunQuery(execCtx, poolMgr.Pool, poolMgr.DBType, job.DBName, job.GeneratedQuery, job.Args...)
+	if err != nil {
+		if exec.Cancelled() {
+			return nil, result.Summary{}, context.Canceled
+		}
+		return nil, result.Summary{}, err
+	}
+	defer rows.Close()
+
+	summary, err := spool(job.resultPath, rows, int64(jobCfg.DBConfig.MaxResultRows))
+	if summary.Truncated {
+		exec.Interrupt()
+	}
+	if err != nil && exec.Cancelled() {
+		err = context.Canceled
+	}
+	if _, cacheable := querycache.Normalize(job.GeneratedQuery, poolMgr.DBType); !cacheable {
+		// The statement may have changed data, the cached results of the connection are stale.
+		querycache.InvalidateConnection(job.ConnID)
+	}
+	return rows.Columns(), summary, err
+}
+
+// spool writes the rows to the given file in the NDJSON result format: a header line
+// with the columns, one array per row and a trailer line with the summary.
+func spool(path string, rows result.Rows, maxRows int64) (result.Summary, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return result.Summary{}, err
+	}
+	defer file.Close()
+
+	return result.Copy(result.NewNDJSONWriter(file), rows, maxRows)
+}
+
+// finish marks the job as done and starts its retention window. The caller must hold jobMutex.
+func finish(job *Job, status Status, errMsg string) {
+	finishedAt := time.Now()
+	expiresAt := finishedAt.Add(jobCfg.Env.JobRetention)
+	job.Status = status
+	job.Error = errMsg
+	job.FinishedAt = &finishedAt
+	job.ExpiresAt = &expiresAt
+	if status != StatusSucceeded {
+		os.Remove(job.resultPath)
+	}
+}
@@ -0,0 +1,60 @@
+package queryjobs
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/cprakhar/datawhiz/config"
+)
+
+// queueJobs points Submit at a spool directory and a queue that no worker reads.
+func queueJobs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	jobCfg = &config.Config{Env: &config.Env{JobSpoolDir: dir}}
+	jobQueue = make(chan *Job, 8)
+	t.Cleanup(func() {
+		jobMutex.Lock()
+		jobMap = make(map[string]*Job)
+		jobMutex.Unlock()
+		jobCfg, jobQueue = nil, nil
+	})
+	return dir
+}
+
+func TestSpoolPathIgnoresJobID(t *testing.T) {
+	dir := queueJobs(t)
+
+	for _, id := range []string{"../../etc/cron.d/job", "/tmp/job", `..\job`, "job"} {
+		job := &Job{ID: id, UserID: "user"}
+		if err := Submit(job); err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(job.resultPath) != dir {
+			t.Errorf("job %q spools to %s, outside %s", id, job.resultPath, dir)
+		}
+	}
+}
+
+func TestJobIDsAreScopedToUsers(t *testing.T) {
+	queueJobs(t)
+
+	if err := Submit(&Job{ID: "job", UserID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Submit(&Job{ID: "job", UserID: "bob"}); err != nil {
+		t.Fatalf("the ID of another user's job: %v", err)
+	}
+	if err := Submit(&Job{ID: "job", UserID: "alice"}); err == nil {
+		t.Fatal("reused the ID of a queued job")
+	}
+
+	job, err := GetJob("job", "bob")
+	if err != nil || job.UserID != "bob" {
+		t.Fatalf("GetJob: %+v, %v", job, err)
+	}
+	if _, err := GetJob("job", "carol"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetJob for another user: %v", err)
+	}
+}
@@ -62,15 +62,81 @@ func NewRouter(cfg *config.Config) *gin.Engine {
 	api.POST("/connections", middleware.RequireAuth(), h.HandleCreateConnection)
 	api.POST("/connections/:id/activate", middleware.RequireAuth(), h.HandleActivateConnection)
 	api.DELETE("/connections/:id/deactivate", middleware.RequireAuth(), h.HandleDeactivateConnection)
+	api.GET("/connections/:id/stats", middleware.RequireAuth(), h.HandleGetConnectionStats)
 	api.DELETE("/connections/:id", middleware.RequireAuth(), h.HandleDeleteConnection)
+	api.GET("/connections/:id/dump", middleware.RequireAuth(), h.HandleDumpDatabase)
+	api.POST("/connections/:id/restore", middleware.RequireAuth(), h.HandleRestoreDatabase)
 	
 	api.GET("/tables/:id", middleware.RequireAuth(), h.HandleGetTables)
 	api.GET("/tables/:id/:table_name/schema", middleware.RequireAuth(), h.HandleGetTableSchema)
 	api.GET("/tables/:id/:table_name/records", middleware.RequireAuth(), h.HandleGetTableRecords)
+	api.POST("/tables/:id/:table_name/records", middleware.RequireAuth(), h.HandleInsertTableRecords)
+	api.PATCH("/tables/:id/:table_name/records", middleware.RequireAuth(), h.HandleUpdateTableRecords)
+	api.DELETE("/tables/:id/:table_name/records", middleware.RequireAuth(), h.HandleDeleteTableRecords)
+	api.POST("/tables/:id/:table_name/records/batch", middleware.RequireAuth(), h.HandleApplyTableChanges)
+	api.GET("/tables/:id/:table_name/export", middleware.RequireAuth(), h.HandleExportTable)
+	api.POST("/tables/:id/:table_name/import", middleware.RequireAuth(), h.HandleImportTable)
+	api.POST("/tables/:id/:table_name/import/preview", middleware.RequireAuth(), h.HandlePreviewImport)
 
 	api.POST("/query/:id/generate", middleware.RequireAuth(), h.HandleGenerateQuery)
 	api.POST("/query/:id/execute", middleware.RequireAuth(), h.HandleExecuteQuery)
+	api.POST("/query/:id/explain", middleware.RequireAuth(), h.HandleExplainQuery)
+	api.POST("/query/:id/export", middleware.RequireAuth(), h.HandleExportQuery)
+	api.GET("/query/:id/executions", middleware.RequireAuth(), h.HandleGetRunningQueries)
+	api.DELETE("/query/:id/executions/:exec_id", middleware.RequireAuth(), h.HandleCancelQuery)
+	api.GET("/query/:id/jobs", middleware.RequireAuth(), h.HandleGetQueryJobs)
+	api.GET("/query/:id/jobs/:job_id", middleware.RequireAuth(), h.HandleGetQueryJob)
+	api.GET("/query/:id/jobs/:job_id/results", middleware.RequireAuth(), h.HandleGetQueryJobResults)
+	api.GET("/query/:id/jobs/:job_id/download", middleware.RequireAuth(), h.HandleDownloadQueryJobResults)
+	api.GET("/query/:id/jobs/:job_id/export", middleware.RequireAuth(), h.HandleExportQueryJobResults)
+	api.DELETE("/query/:id/jobs/:job_id", middleware.RequireAuth(), h.HandleCancelQueryJob)
+	api.DELETE("/query/:id/cache", middleware.RequireAuth(), h.HandleClearQueryCache)
 	api.GET("/query/history/:id", middleware.RequireAuth(), h.HandleGetQueryHistory)
 	api.DELETE("/query/history/:id", middleware.RequireAuth(), h.HandleDeleteQueryHistory)
+	api.GET("/query/history/:id/analytics", middleware.RequireAuth(), h.HandleGetQueryAnalytics)
+
+	api.POST("/copy-jobs", middleware.RequireAuth(), h.HandleSubmitCopyJob)
+	api.GET("/copy-jobs", middleware.RequireAuth(), h.HandleGetCopyJobs)
+	api.GET("/copy-jobs/:job_id", middleware.RequireAuth(), h.HandleGetCopyJob)
+	api.POST("/copy-jobs/:job_id/resume", middleware.RequireAuth(), h.HandleResumeCopyJob)
+	api.DELETE("/copy-jobs/:job_id", middleware.RequireAuth(), h.HandleCancelCopyJob)
+
+	// Saved queries, schedules and alerts are only kept in Supabase.
+	if cfg.DBClient != nil {
+		api.POST("/query/:id/saved/:query_id/execute", middleware.RequireAuth(), h.HandleExecuteSavedQuery)
+
+		api.GET("/saved-queries", middleware.RequireAuth(), h.HandleGetSavedQueries)
+		api.POST("/saved-queries", middleware.RequireAuth(), h.HandleCreateSavedQuery)
+		api.GET("/saved-queries/:query_id", middleware.RequireAuth(), h.HandleGetSavedQuery)
+		api.PUT("/saved-queries/:query_id", middleware.RequireAuth(), h.HandleUpdateSavedQuery)
+		api.DELETE("/saved-queries/:query_id", middleware.RequireAuth(), h.HandleDeleteSavedQuery)
+		api.GET("/saved-queries/:query_id/versions", middleware.RequireAuth(), h.HandleGetSavedQueryVersions)
+		api.POST("/saved-queries/:query_id/versions/:version/restore", middleware.RequireAuth(), h.HandleRestoreSavedQueryVersion)
+		api.GET("/saved-queries/:query_id/shares", middleware.RequireAuth(), h.HandleGetSavedQueryShares)
+		api.POST("/saved-queries/:query_id/shares", middleware.RequireAuth(), h.HandleShareSavedQuery)
+		api.DELETE("/saved-queries/:query_id/shares/:user_id", middleware.RequireAuth(), h.HandleUnshareSavedQuery)
+		api.GET("/saved-query-folders", middleware.RequireAuth(), h.HandleGetSavedQueryFolders)
+		api.POST("/saved-query-folders", middleware.RequireAuth(), h.HandleCreateSavedQueryFolder)
+		api.PUT("/saved-query-folders/:folder_id", middleware.RequireAuth(), h.HandleUpdateSavedQueryFolder)
+		api.DELETE("/saved-query-folders/:folder_id", middleware.RequireAuth(), h.HandleDeleteSavedQueryFolder)
+
+		api.GET("/schedules", middleware.RequireAuth(), h.HandleGetSchedules)
+		api.POST("/schedules", middleware.RequireAuth(), h.HandleCreateSchedule)
+		api.GET("/schedules/:schedule_id", middleware.RequireAuth(), h.HandleGetSchedule)
+		api.PUT("/schedules/:schedule_id", middleware.RequireAuth(), h.HandleUpdateSchedule)
+		api.DELETE("/schedules/:schedule_id", middleware.RequireAuth(), h.HandleDeleteSchedule)
+		api.POST("/schedules/:schedule_id/run", middleware.RequireAuth(), h.HandleRunSchedule)
+		api.GET("/schedules/:schedule_id/runs", middleware.RequireAuth(), h.HandleGetScheduleRuns)
+		api.GET("/schedules/:schedule_id/runs/:run_id", middleware.RequireAuth(), h.HandleGetScheduleRun)
+
+		api.GET("/alerts", middleware.RequireAuth(), h.HandleGetAlertRules)
+		api.POST("/alerts", middleware.RequireAuth(), h.HandleCreateAlertRule)
+		api.GET("/alerts/:alert_id", middleware.RequireAuth(), h.HandleGetAlertRule)
+		api.PUT("/alerts/:alert_id", middleware.RequireAuth(), h.HandleUpdateAlertRule)
+		api.DELETE("/alerts/:alert_id", middleware.RequireAuth(), h.HandleDeleteAlertRule)
+		api.POST("/alerts/:alert_id/check", middleware.RequireAuth(), h.HandleCheckAlertRule)
+		api.GET("/alerts/:alert_id/events", middleware.RequireAuth(), h.HandleGetAlertEvents)
+		api.GET("/alert-events", middleware.RequireAuth(), h.HandleGetAlertEvents)
+	}
 	return router
 }
\ No newline at end of file
@@ -0,0 +1,339 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/database/alerts"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAlertKeys  = 10000 // keys a new_rows rule remembers, the oldest are forgotten first
+	maxEventRows  = 100   // new rows kept in an event and sent to notifiers
+	alertClaimKey = "alert:"
+)
+
+var thresholdOperators = []string{">", ">=", "<", "<=", "=", "!="}
+
+// ValidateAlert checks the condition and notifiers of an alert rule and normalizes them.
+func ValidateAlert(condition *alerts.Condition, notifiers []alerts.Notifier) error {
+	switch condition.Type {
+	case alerts.ConditionThreshold:
+		if !slices.Contains(thresholdOperators, condition.Operator) {
+			return errors.New("threshold operator must be one of " + strings.Join(thresholdOperators, " "))
+		}
+	case alerts.ConditionRowCount, alerts.ConditionNewRows:
+	default:
+		return errors.New("condition type must be one of threshold, row_count_change or new_rows")
+	}
+
+	for i := range notifiers {
+		notifier := &notifiers[i]
+		switch notifier.Type {
+		case alerts.NotifierWebhook:
+			if err := validateWebhookURL(notifier.URL); err != nil {
+				return err
+			}
+		case alerts.NotifierEmail:
+			if len(notifier.To) == 0 {
+				return errors.New("email notifier needs at least one recipient")
+			}
+			for j, to := range notifier.To {
+				address, err := mail.ParseAddress(to)
+				if err != nil {
+					return fmt.Errorf("invalid recipient %q", to)
+				}
+				notifier.To[j] = address.Address
+			}
+		default:
+			return errors.New("notifier type must be webhook or email")
+		}
+	}
+	return nil
+}
+
+// CheckNow checks an alert rule right away and returns the events the check produced.
+func CheckNow(rule *alerts.Rule) ([]alerts.Event, error) {
+	if !claim(alertClaimKey + rule.ID) {
+		return nil, ErrAlreadyChecking
+	}
+	defer release(alertClaimKey + rule.ID)
+	return check(rule, time.Now()), nil
+}
+
+func checkAlerts(now time.Time) {
+	due, err := alerts.ListDueRules(schedCfg.DBClient, now)
+	if err != nil {
+		log.Println("Error listing due alert rules:", err)
+		return
+	}
+	for i := range due {
+		rule := &due[i]
+		if !claim(alertClaimKey + rule.ID) {
+			continue
+		}
+		go func() {
+			defer release(alertClaimKey + rule.ID)
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-schedCtx.Done():
+				return
+			}
+			check(rule, now)
+		}()
+	}
+}
+
+// check runs the query of a rule, records the events its result produces and notifies about them.
+func check(rule *alerts.Rule, now time.Time) []alerts.Event {
+	columns, data, summary, err := collect(rule.ConnID, rule.UserID, rule.DBName, uuid.NewString(), "", rule.Query, nil, nil, nil)
+	events := evaluate(rule, columns, data, summary, err)
+
+	for i := range events {
+		event := &events[i]
+		if len(rule.Notifiers) > 0 && (event.Kind != alerts.EventResolved || rule.NotifyResolved) {
+			if err := notify(rule, event); err != nil {
+				event.NotifyError = err.Error()
+			} else {
+				event.Notified = true
+			}
+		}
+		if err := alerts.InsertEvent(schedCfg.DBClient, event); err != nil {
+			log.Println("Error saving event of alert rule", rule.ID+":", err)
+		}
+	}
+
+	checkedAt := now.UTC()
+	nextCheckAt := checkedAt.Add(time.Duration(max(rule.IntervalMinutes, 1)) * time.Minute)
+	rule.LastCheckedAt = &checkedAt
+	rule.NextCheckAt = &nextCheckAt
+	if slices.ContainsFunc(events, func(e alerts.Event) bool { return e.Kind != alerts.EventResolved }) {
+		rule.LastTriggeredAt = &checkedAt
+	}
+	if err := alerts.SaveState(schedCfg.DBClient, rule); err != nil {
+		log.Println("Error saving state of alert rule", rule.ID+":", err)
+	}
+	return events
+}
+
+// evaluate compares the result of a check with the state of the rule, updates the state and
+// returns the events to record. Only changes produce events: a threshold that stays crossed or a
+// query that keeps failing is reported once.
+func evaluate(rule *alerts.Rule, columns []result.Column, rows [][]interface{}, summary result.Summary, queryErr error) []alerts.Event {
+	state := &rule.State
+	previous := state.Status
+	event := func(kind alerts.EventKind, message string) alerts.Event {
+		rowCount := summary.RowCount
+		return alerts.Event{RuleID: rule.ID, UserID: rule.UserID, Kind: kind, Message: message, Value: state.Value, RowCount: &rowCount}
+	}
+	fail := func(err error) []alerts.Event {
+		state.Status = alerts.StatusError
+		state.Error = err.Error()
+		if previous == alerts.StatusError {
+			return nil
+		}
+		return []alerts.Event{{RuleID: rule.ID, UserID: rule.UserID, Kind: alerts.EventError, Message: err.Error()}}
+	}
+	if queryErr != nil {
+		return fail(queryErr)
+	}
+
+	// The first successful check only records a baseline to compare later checks with.
+	baseline := state.RowCount == nil
+	var events []alerts.Event
+	switch rule.Condition.Type {
+	case alerts.ConditionThreshold:
+		index, err := columnIndex(columns, rule.Condition.Column)
+		if err != nil {
+			return fail(err)
+		}
+		var current *float64
+		if len(rows) > 0 {
+			value, ok := numericValue(rows[0][index])
+			if !ok && rows[0][index] != nil {
+				return fail(fmt.Errorf("column %s is not numeric", columns[index].Name))
+			}
+			if ok {
+				current = &value
+			}
+		}
+		// A failing query keeps the last value, so recovering from an error does not report a
+		// crossing that was already reported.
+		wasFiring := previous == alerts.StatusFiring ||
+			previous == alerts.StatusError && state.Value != nil && compare(*state.Value, rule.Condition.Operator, rule.Condition.Value)
+		firing := current != nil && compare(*current, rule.Condition.Operator, rule.Condition.Value)
+		state.Value = current
+		state.Status = alerts.StatusOK
+		if firing {
+			state.Status = alerts.StatusFiring
+		}
+		switch {
+		case firing && !wasFiring:
+			events = append(events, event(alerts.EventTriggered, fmt.Sprintf("%s is %s %s", formatNumber(state.Value), rule.Condition.Operator, formatNumber(&rule.Condition.Value))))
+		case !firing && wasFiring:
+			events = append(events, event(alerts.EventResolved, fmt.Sprintf("%s is no longer %s %s", formatNumber(state.Value), rule.Condition.Operator, formatNumber(&rule.Condition.Value))))
+		}
+
+	case alerts.ConditionRowCount:
+		state.Status = alerts.StatusOK
+		if !baseline && *state.RowCount != summary.RowCount {
+			events = append(events, event(alerts.EventChanged, fmt.Sprintf("row count changed from %d to %d", *state.RowCount, summary.RowCount)))
+		}
+
+	case alerts.ConditionNewRows:
+		index, err := columnIndex(columns, rule.Condition.Key)
+		if err != nil {
+			return fail(err)
+		}
+		seen := make(map[string]bool, len(state.Keys))
+		for _, key := range state.Keys {
+			seen[key] = true
+		}
+		var added [][]interface{}
+		var count int
+		for _, row := range rows {
+			key := keyOf(row[index])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			state.Keys = append(state.Keys, key)
+			count++
+			if len(added) < maxEventRows {
+				added = append(added, row)
+			}
+		}
+		if len(state.Keys) > maxAlertKeys {
+			state.Keys = slices.Clone(state.Keys[len(state.Keys)-maxAlertKeys:])
+		}
+		state.Status = alerts.StatusOK
+		if !baseline && count > 0 {
+			e := event(alerts.EventNewRows, fmt.Sprintf("%d new rows", count))
+			e.Rows = added
+			events = append(events, e)
+		}
+	}
+
+	rowCount := summary.RowCount
+	state.RowCount = &rowCount
+	state.Error = ""
+	return events
+}
+
+// columnIndex finds a column of the result by name, the first column when name is empty.
+func columnIndex(columns []result.Column, name string) (int, error) {
+	if len(columns) == 0 {
+		return 0, errors.New("query returns no columns")
+	}
+	if name == "" {
+		return 0, nil
+	}
+	for i, col := range columns {
+		if col.Name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("query returns no column %s", name)
+}
+
+// numericValue reads a number from a result value. Decimals come as strings to keep their precision.
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func compare(value float64, operator string, limit float64) bool {
+	switch operator {
+	case ">":
+		return value > limit
+	case ">=":
+		return value >= limit
+	case "<":
+		return value < limit
+	case "<=":
+		return value <= limit
+	case "=":
+		return value == limit
+	case "!=":
+		return value != limit
+	}
+	return false
+}
+
+func formatNumber(value *float64) string {
+	if value == nil {
+		return "null"
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+// keyOf turns the key of a row into the string remembered in the state of a rule.
+func keyOf(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// notify sends an event to every notifier of its rule.
+func notify(rule *alerts.Rule, event *alerts.Event) error {
+	ctx, cancel := context.WithTimeout(schedCtx, time.Minute)
+	defer cancel()
+
+	var errs []error
+	for _, notifier := range rule.Notifiers {
+		var err error
+		switch notifier.Type {
+		case alerts.NotifierWebhook:
+			err = postJSON(ctx, notifier.URL, notifier.Headers, struct {
+				Rule string `json:"rule"`
+				*alerts.Event
+			}{rule.Name, event})
+		case alerts.NotifierEmail:
+			subject := fmt.Sprintf("Alert %s: %s", rule.Name, event.Kind)
+			text := fmt.Sprintf("Alert: %s\r\nEvent: %s\r\n%s\r\n", rule.Name, event.Kind, event.Message)
+			for _, row := range event.Rows {
+				line, _ := json.Marshal(row)
+				text += string(line) + "\r\n"
+			}
+			err = sendMail(schedCfg.Env.SMTPAddr, schedCfg.Env.SMTPFrom, notifier.To, subject, text, nil)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", notifier.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
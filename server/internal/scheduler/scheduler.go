@@ -0,0 +1,408 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	savedqueries "github.com/cprakhar/datawhiz/internal/database/saved_queries"
+	"github.com/cprakhar/datawhiz/internal/database/schedules"
+	dbdriver "github.com/cprakhar/datawhiz/internal/db_driver"
+	"github.com/cprakhar/datawhiz/internal/db_driver/params"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/executions"
+	poolmanager "github.com/cprakhar/datawhiz/internal/pool_manager"
+	querycache "github.com/cprakhar/datawhiz/internal/query_cache"
+)
+
+var (
+	ErrAlreadyRunning  = errors.New("schedule is already running")
+	ErrAlreadyChecking = errors.New("alert rule is already being checked")
+	ErrNeverRuns       = errors.New("cron expression never matches")
+)
+
+var (
+	running      = make(map[string]bool) // key: schedule ID, or alert rule ID prefixed with "alert:"
+	runningMutex sync.Mutex              // Mutex to protect access to running
+	slots        chan struct{}           // bounds the runs executing at once
+	schedCfg     *config.Config
+	schedCtx     context.Context
+	stopSched    context.CancelFunc
+)
+
+// NextRun returns the first time after t that a cron expression matches in a time zone.
+func NextRun(spec, timezone string, t time.Time) (time.Time, error) {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown time zone %q", timezone)
+	}
+	next := cron.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, ErrNeverRuns
+	}
+	return next.UTC(), nil
+}
+
+// Start starts the routine that runs due schedules and checks due alert rules. Runs left unfinished by a previous process
+// are marked as failed; the runs missed while the server was down follow the catch-up policy of
+// their schedule on the first tick. Schedules and alert rules are kept in Supabase, so nothing
+// is started without it.
+func Start(cfg *config.Config) error {
+	if cfg.DBClient == nil {
+		log.Println("Supabase is not configured, schedules and alerts are disabled")
+		return nil
+	}
+	if err := os.MkdirAll(cfg.Env.ScheduleDropDir, 0o700); err != nil {
+		return err
+	}
+	if err := schedules.FailInterruptedRuns(cfg.DBClient); err != nil {
+		log.Println("Error failing interrupted schedule runs:", err)
+	}
+
+	schedCfg = cfg
+	slots = make(chan struct{}, max(cfg.Env.ScheduleWorkers, 1))
+	schedCtx, stopSched = context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(cfg.Env.SchedulerInterval)
+		defer ticker.Stop()
+		for {
+			tick(time.Now())
+			select {
+			case <-schedCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the scheduler and cancels the runs and checks in progress.
+func Shutdown() {
+	if stopSched != nil {
+		stopSched()
+	}
+}
+
+// RunNow starts a run of a schedule outside of its cron expression and returns it.
+func RunNow(schedule *schedules.Schedule) (*schedules.Run, error) {
+	if !claim(schedule.ID) {
+		return nil, ErrAlreadyRunning
+	}
+	run, err := startRun(schedule, time.Now(), schedules.TriggerManual)
+	if err != nil {
+		release(schedule.ID)
+		return nil, err
+	}
+	go func() {
+		defer release(schedule.ID)
+		perform(schedule, run)
+	}()
+	return run, nil
+}
+
+func claim(key string) bool {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+	if running[key] {
+		return false
+	}
+	running[key] = true
+	return true
+}
+
+func release(key string) {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+	delete(running, key)
+}
+
+func tick(now time.Time) {
+	dispatchSchedules(now)
+	checkAlerts(now)
+}
+
+func dispatchSchedules(now time.Time) {
+	due, err := schedules.ListDueSchedules(schedCfg.DBClient, now)
+	if err != nil {
+		log.Println("Error listing due schedules:", err)
+		return
+	}
+	for i := range due {
+		schedule := &due[i]
+		// A schedule still running from an earlier tick is picked up again once it is done.
+		if !claim(schedule.ID) {
+			continue
+		}
+		go func() {
+			defer release(schedule.ID)
+			dispatch(schedule, now)
+		}()
+	}
+}
+
+// dispatch runs the due times of a schedule up to now. Times more than a tick late were missed,
+// typically while the server was down, and are handled by the catch-up policy.
+func dispatch(schedule *schedules.Schedule, now time.Time) {
+	cron, err := ParseCron(schedule.Cron)
+	if err != nil {
+		log.Println("Error parsing cron expression of schedule", schedule.ID+":", err)
+		schedules.SetRunTimes(schedCfg.DBClient, schedule.ID, schedule.LastRunAt, nil)
+		return
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	limit := max(schedCfg.Env.ScheduleCatchUpMax, 1)
+	times := []time.Time{*schedule.NextRunAt}
+	for {
+		next := cron.Next(times[len(times)-1].In(loc))
+		if next.IsZero() || next.After(now) {
+			break
+		}
+		times = append(times, next)
+		if len(times) > limit+1 {
+			times = times[1:]
+		}
+	}
+
+	var onTime *time.Time
+	grace := schedCfg.Env.SchedulerInterval + time.Minute
+	if last := times[len(times)-1]; now.Sub(last) <= grace {
+		onTime = &last
+		times = times[:len(times)-1]
+	}
+	missed := times
+
+	// The next run is recorded first so that a slow run is not picked up again by the next tick.
+	var nextRunAt *time.Time
+	if next := cron.Next(now.In(loc)); !next.IsZero() {
+		next = next.UTC()
+		nextRunAt = &next
+	}
+	lastRunAt := now.UTC()
+	if err := schedules.SetRunTimes(schedCfg.DBClient, schedule.ID, &lastRunAt, nextRunAt); err != nil {
+		log.Println("Error updating run times of schedule", schedule.ID+":", err)
+		return
+	}
+
+	if len(missed) > 0 {
+		switch schedule.CatchUp {
+		case schedules.CatchUpAll:
+			for _, scheduledAt := range missed {
+				execute(schedule, scheduledAt, schedules.TriggerCatchUp)
+			}
+		case schedules.CatchUpOnce:
+			if onTime == nil {
+				execute(schedule, missed[len(missed)-1], schedules.TriggerCatchUp)
+			}
+		default:
+			skip(schedule, missed)
+		}
+	}
+	if onTime != nil {
+		execute(schedule, *onTime, schedules.TriggerSchedule)
+	}
+}
+
+// skip records the missed runs of a schedule as a single skipped run.
+func skip(schedule *schedules.Schedule, missed []time.Time) {
+	now := time.Now()
+	run := &schedules.Run{
+		ScheduleID:  schedule.ID,
+		UserID:      schedule.UserID,
+		Trigger:     schedules.TriggerCatchUp,
+		Status:      schedules.RunSkipped,
+		Error:       fmt.Sprintf("%d missed runs skipped, the first was due at %s", len(missed), missed[0].UTC().Format(time.RFC3339)),
+		ScheduledAt: missed[len(missed)-1],
+		FinishedAt:  &now,
+	}
+	if _, err := schedules.InsertRun(schedCfg.DBClient, run); err != nil {
+		log.Println("Error recording skipped runs of schedule", schedule.ID+":", err)
+	}
+}
+
+// execute runs a schedule for one of its times and waits for the run to finish.
+func execute(schedule *schedules.Schedule, scheduledAt time.Time, trigger schedules.Trigger) {
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-schedCtx.Done():
+		return
+	}
+	run, err := startRun(schedule, scheduledAt, trigger)
+	if err != nil {
+		log.Println("Error starting a run of schedule", schedule.ID+":", err)
+		return
+	}
+	perform(schedule, run)
+}
+
+func startRun(schedule *schedules.Schedule, scheduledAt time.Time, trigger schedules.Trigger) (*schedules.Run, error) {
+	startedAt := time.Now()
+	return schedules.InsertRun(schedCfg.DBClient, &schedules.Run{
+		ScheduleID:  schedule.ID,
+		UserID:      schedule.UserID,
+		Trigger:     trigger,
+		Status:      schedules.RunRunning,
+		ScheduledAt: scheduledAt.UTC(),
+		StartedAt:   &startedAt,
+	})
+}
+
+// perform runs the query of a started run, delivers its output to the sink of the schedule and
+// stores the outcome.
+func perform(schedule *schedules.Schedule, run *schedules.Run) {
+	startedAt := time.Now()
+	err := runQuery(schedule, run)
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Duration = finishedAt.Sub(startedAt).Milliseconds()
+	run.Status = schedules.RunSucceeded
+	if err != nil {
+		run.Status = schedules.RunFailed
+		run.Error = err.Error()
+	}
+
+	if schedule.Sink.Type != "" && schedule.Sink.Type != schedules.SinkNone {
+		ctx, cancel := context.WithTimeout(schedCtx, time.Minute)
+		if err := deliver(ctx, schedCfg, schedule, run); err != nil {
+			run.DeliveryError = err.Error()
+		} else {
+			run.Delivered = true
+		}
+		cancel()
+	}
+
+	if err := schedules.UpdateRun(schedCfg.DBClient, run); err != nil {
+		log.Println("Error saving run", run.ID, "of schedule", schedule.ID+":", err)
+	}
+}
+
+func runQuery(schedule *schedules.Schedule, run *schedules.Run) error {
+	query, err := savedqueries.GetSavedQuery(schedCfg.DBClient, schedule.QueryID)
+	if err != nil {
+		return err
+	}
+	allowed, err := savedqueries.CanRead(schedCfg.DBClient, query, schedule.UserID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return savedqueries.ErrNotFound
+	}
+
+	columns, data, summary, err := collect(schedule.ConnID, schedule.UserID, schedule.DBName, run.ID, query.DBType, query.Query, query.Params, schedule.Params, func(bound string) {
+		run.Query = bound
+	})
+	if err != nil {
+		return err
+	}
+	run.Columns = columns
+	run.Rows = data
+	run.RowCount = summary.RowCount
+	run.Truncated = summary.Truncated
+	return nil
+}
+
+// collect runs a query with parameters on a connection of a user, activating it if needed, and
+// reads at most ScheduleMaxRows rows. The query is registered as an execution under execID so
+// that it can be cancelled like any other query. bound receives the query as it is sent.
+func collect(connID, userID, dbName, execID, dbType, query string, defaults, values map[string]interface{}, bound func(string)) ([]result.Column, [][]interface{}, result.Summary, error) {
+	var summary result.Summary
+	poolMgr, err := activate(connID, userID)
+	if err != nil {
+		return nil, nil, summary, err
+	}
+	if dbType != "" && poolMgr.DBType != dbType {
+		return nil, nil, summary, fmt.Errorf("query is written for %s, not %s", dbType, poolMgr.DBType)
+	}
+	sql, args, err := params.Bind(query, poolMgr.DBType, defaults, values)
+	if err != nil {
+		return nil, nil, summary, err
+	}
+	if bound != nil {
+		bound(sql)
+	}
+
+	timeout := poolMgr.QueryTimeout
+	if timeout <= 0 {
+		timeout = schedCfg.DBConfig.QueryTimeout
+	}
+	execCtx, exec, err := executions.Start(schedCtx, execID, connID, userID, timeout)
+	if err != nil {
+		return nil, nil, summary, err
+	}
+	defer exec.Finish()
+
+	rows, err := dbdriver.RunQuery(execCtx, poolMgr.Pool, poolMgr.DBType, dbName, sql, args...)
+	if err != nil {
+		if exec.Cancelled() {
+			return nil, nil, summary, context.Canceled
+		}
+		return nil, nil, summary, err
+	}
+	columns := rows.Columns()
+	data, summary, err := read(exec, rows, int64(schedCfg.Env.ScheduleMaxRows))
+	if _, cacheable := querycache.Normalize(sql, poolMgr.DBType); !cacheable {
+		// The statement may have changed data, the cached results of the connection are stale.
+		querycache.InvalidateConnection(connID)
+	}
+	if err != nil {
+		if exec.Cancelled() {
+			return nil, nil, summary, context.Canceled
+		}
+		return nil, nil, summary, err
+	}
+	if data == nil {
+		data = [][]interface{}{}
+	}
+	return columns, data, summary, nil
+}
+
+// read collects at most maxRows rows of a running query. When the cap is hit, the query is
+// interrupted before its cursor is closed, since closing it would read the remaining rows.
+func read(exec *executions.Execution, rows result.Rows, maxRows int64) ([][]interface{}, result.Summary, error) {
+	defer rows.Close()
+	data, summary, err := result.Collect(rows, maxRows)
+	if summary.Truncated {
+		exec.Interrupt()
+	}
+	return data, summary, err
+}
+
+// activate returns the pool of a connection of a user, activating it when it is not active.
+func activate(connID, userID string) (*poolmanager.PoolManager, error) {
+	if poolMgr, err := poolmanager.GetPool(connID, userID); err == nil {
+		if poolMgr.UserID != userID {
+			return nil, errors.New("connection not found")
+		}
+		return poolMgr, nil
+	}
+
+	conn, err := schedCfg.Connections.GetRecord(connID, userID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(schedCtx, 30*time.Second)
+	defer cancel()
+	if err := poolmanager.ActivateConnection(ctx, schedCfg, connID, conn.DBType, userID); err != nil {
+		return nil, err
+	}
+	if err := schedCfg.Connections.SetActive(connID, userID, true); err != nil {
+		log.Println("Error marking connection", connID, "active:", err)
+	}
+	return poolmanager.GetPool(connID, userID)
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+	"github.com/cprakhar/datawhiz/internal/executions"
+)
+
+// endlessRows yields rows until the query is stopped on the server. Like a driver, Close reads
+// the remaining rows, so it only returns once stopped is closed.
+type endlessRows struct {
+	stopped chan struct{}
+	drained bool
+}
+
+func (r *endlessRows) Columns() []result.Column       { return []result.Column{{Name: "n"}} }
+func (r *endlessRows) Next() bool                     { return true }
+func (r *endlessRows) Values() ([]interface{}, error) { return []interface{}{1}, nil }
+func (r *endlessRows) Err() error                     { return nil }
+
+func (r *endlessRows) Close() {
+	select {
+	case <-r.stopped:
+		r.drained = true
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestReadInterruptsAtCap(t *testing.T) {
+	_, exec, err := executions.Start(context.Background(), "run", "conn", "user", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exec.Finish()
+
+	rows := &endlessRows{stopped: make(chan struct{})}
+	exec.SetCanceller(func(context.Context) error {
+		close(rows.stopped)
+		return nil
+	})
+
+	data, summary, err := read(exec, rows, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3 || !summary.Truncated {
+		t.Fatalf("read %d rows, truncated %v", len(data), summary.Truncated)
+	}
+	if !rows.drained {
+		t.Fatal("the query was not stopped before its cursor was closed")
+	}
+	if exec.Cancelled() {
+		t.Fatal("reaching the cap cancelled the run")
+	}
+}
@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cprakhar/datawhiz/config"
+	"github.com/cprakhar/datawhiz/internal/database/schedules"
+	"github.com/cprakhar/datawhiz/internal/db_driver/export"
+	"github.com/cprakhar/datawhiz/internal/db_driver/result"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// ValidateSink checks the settings of a sink and fills in its defaults.
+func ValidateSink(sink *schedules.Sink) error {
+	if sink.Type == "" {
+		sink.Type = schedules.SinkNone
+	}
+	if sink.Type == schedules.SinkFile || sink.Type == schedules.SinkEmail {
+		format, err := export.ParseFormat(sink.Format, "")
+		if err != nil {
+			return err
+		}
+		sink.Format = string(format)
+	}
+
+	switch sink.Type {
+	case schedules.SinkNone:
+	case schedules.SinkWebhook:
+		if err := validateWebhookURL(sink.URL); err != nil {
+			return err
+		}
+	case schedules.SinkFile:
+		if sink.Path != "" && !filepath.IsLocal(sink.Path) {
+			return errors.New("file path must be a relative path inside the drop directory")
+		}
+	case schedules.SinkEmail:
+		if len(sink.To) == 0 {
+			return errors.New("email sink needs at least one recipient")
+		}
+		for i, to := range sink.To {
+			address, err := mail.ParseAddress(to)
+			if err != nil {
+				return fmt.Errorf("invalid recipient %q", to)
+			}
+			sink.To[i] = address.Address
+		}
+	default:
+		return errors.New("sink type must be one of none, webhook, file or email")
+	}
+	return nil
+}
+
+// deliver sends the output of a run to the sink of its schedule. Failed runs are reported to
+// webhooks and by email; file drops only receive results.
+func deliver(ctx context.Context, cfg *config.Config, schedule *schedules.Schedule, run *schedules.Run) error {
+	switch schedule.Sink.Type {
+	case schedules.SinkWebhook:
+		return deliverWebhook(ctx, schedule, run)
+	case schedules.SinkFile:
+		if run.Status != schedules.RunSucceeded {
+			return nil
+		}
+		return deliverFile(cfg.Env.ScheduleDropDir, schedule, run)
+	case schedules.SinkEmail:
+		return deliverEmail(cfg.Env.SMTPAddr, cfg.Env.SMTPFrom, schedule, run)
+	}
+	return nil
+}
+
+func deliverWebhook(ctx context.Context, schedule *schedules.Schedule, run *schedules.Run) error {
+	return postJSON(ctx, schedule.Sink.URL, schedule.Sink.Headers, struct {
+		Schedule string `json:"schedule"`
+		*schedules.Run
+	}{schedule.Name, run})
+}
+
+// postJSON posts a payload to a webhook and fails unless it responds with a 2xx status.
+func postJSON(ctx context.Context, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// outputName is the file name of the output of a run, unique per schedule and scheduled time.
+func outputName(schedule *schedules.Schedule, run *schedules.Run) string {
+	name := strings.Trim(unsafeNameChars.ReplaceAllString(schedule.Name, "_"), "_")
+	if name == "" {
+		name = "schedule"
+	}
+	return name + "_" + run.ScheduledAt.UTC().Format("20060102T150405Z") + export.Format(schedule.Sink.Format).Extension()
+}
+
+func writeOutput(w io.Writer, format string, run *schedules.Run) error {
+	writer, err := export.NewWriter(w, export.Format(format), export.DefaultOptions())
+	if err != nil {
+		return err
+	}
+	_, err = result.Copy(writer, result.NewSliceRows(run.Columns, run.Rows), 0)
+	return err
+}
+
+// deliverFile writes the results to the drop directory of the owner. The file appears under its
+// final name only once it is complete, so that whatever watches the directory never reads a
+// partial file.
+func deliverFile(dropDir string, schedule *schedules.Schedule, run *schedules.Run) error {
+	dir := filepath.Join(dropDir, schedule.UserID, schedule.Sink.Path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	name := outputName(schedule, run)
+	file, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := writeOutput(file, schedule.Sink.Format, run); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(dir, name))
+}
+
+// deliverEmail sends a summary of the run through the mail relay, with the results attached.
+func deliverEmail(addr, from string, schedule *schedules.Schedule, run *schedules.Run) error {
+	subject := schedule.Sink.Subject
+	if subject == "" {
+		subject = fmt.Sprintf("%s: run %s", schedule.Name, run.Status)
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Schedule: %s\r\nScheduled at: %s\r\nStatus: %s\r\n", schedule.Name, run.ScheduledAt.UTC().Format(time.RFC3339), run.Status)
+	if run.Status != schedules.RunSucceeded {
+		fmt.Fprintf(&text, "Error: %s\r\n", run.Error)
+		return sendMail(addr, from, schedule.Sink.To, subject, text.String(), nil)
+	}
+	fmt.Fprintf(&text, "Rows: %d", run.RowCount)
+	if run.Truncated {
+		fmt.Fprint(&text, " (truncated)")
+	}
+	fmt.Fprint(&text, "\r\n")
+
+	var output bytes.Buffer
+	if err := writeOutput(&output, schedule.Sink.Format, run); err != nil {
+		return err
+	}
+	return sendMail(addr, from, schedule.Sink.To, subject, text.String(), &attachment{
+		name:        outputName(schedule, run),
+		contentType: export.Format(schedule.Sink.Format).ContentType(),
+		data:        output.Bytes(),
+	})
+}
+
+type attachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// sendMail sends a plain text message, optionally with an attachment, through the mail relay.
+func sendMail(addr, from string, to []string, subject, text string, file *attachment) error {
+	var msg bytes.Buffer
+	parts := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", parts.Boundary())
+
+	part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(part, text)
+
+	if file != nil {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {file.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": file.name})},
+		})
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(file.data)
+		for len(encoded) > 76 {
+			fmt.Fprint(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		fmt.Fprint(part, encoded+"\r\n")
+	}
+	if err := parts.Close(); err != nil {
+		return err
+	}
+
+	// The relay is local, so the message is handed over without authentication.
+	return smtp.SendMail(addr, nil, from, to, msg.Bytes())
+}
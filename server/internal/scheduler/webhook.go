@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// webhookTimeout bounds a webhook delivery, redirects included.
+const webhookTimeout = 30 * time.Second
+
+var errInternalWebhook = errors.New("webhook URL must not point to a loopback, link-local or private address")
+
+// webhookClient posts to webhooks. Users choose webhook URLs, so it only connects to public
+// addresses: the check runs on the address being dialed, after name resolution and on every
+// redirect, so a name that resolves to an internal address is refused as well.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		// A proxy from the environment would be dialed instead of the webhook.
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				addr, err := netip.ParseAddr(host)
+				if err != nil || internalAddr(addr) {
+					return errInternalWebhook
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: webhookTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// internalAddr reports whether an address belongs to the server or its network rather than the
+// internet, such as 127.0.0.1, 10.0.0.0/8 or the 169.254.169.254 metadata endpoint of clouds.
+func internalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified()
+}
+
+// validateWebhookURL checks that a webhook URL is an http or https URL, and refuses hosts that
+// are internal on their face. Names are checked again when they are resolved for a delivery.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook URL must be an http or https URL")
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errInternalWebhook
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && internalAddr(addr) {
+		return errInternalWebhook
+	}
+	return nil
+}